@@ -0,0 +1,209 @@
+package quayd
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// AdminAuth returns an http.Handler that only passes requests authenticated
+// with AdminToken to h, either as a bearer token or as the `token` query
+// parameter, since browsers can't set headers on WebSocket requests. The
+// admin endpoints are disabled when AdminToken is empty.
+func (q *Quayd) AdminAuth(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if q.AdminToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" {
+			token = r.URL.Query().Get("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(q.AdminToken)) != 1 {
+			http.Error(w, "Unauthorized", 401)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// TimelineHandler is an http.Handler that returns the processing timeline for
+// a delivery.
+type TimelineHandler struct {
+	*Quayd
+}
+
+func (h *TimelineHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	tl, ok := h.Timelines.Get(id)
+	if !ok {
+		http.Error(w, "Delivery not found: "+id, 404)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tl)
+}
+
+// CostsHandler is an http.Handler that returns the build cost attribution
+// summary.
+type CostsHandler struct {
+	*Quayd
+}
+
+func (h *CostsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	summary := h.Costs.Summary()
+	if summary == nil {
+		summary = []*CostSummary{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+// ReplayHandler is an http.Handler that reprocesses a recorded delivery, so
+// that failed deliveries can be recovered without Quay resending them.
+type ReplayHandler struct {
+	*Quayd
+}
+
+func (h *ReplayHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	err := h.Replay(r.Context(), id)
+	if err == ErrDeliveryNotFound {
+		http.Error(w, "Delivery not found: "+id, 404)
+		return
+	}
+	if err != nil {
+		h.errorResponse(w, r, err)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+// DefaultDeliveriesPerPage is the default page size of DeliveriesHandler.
+const DefaultDeliveriesPerPage = 50
+
+// maxDeliveriesPerPage caps the per_page query parameter of
+// DeliveriesHandler.
+const maxDeliveriesPerPage = 500
+
+// DeliveryView is a delivery as listed by DeliveriesHandler, with the build
+// event parsed from its payload.
+type DeliveryView struct {
+	ID          string          `json:"id"`
+	Status      string          `json:"status"`
+	State       string          `json:"state"`
+	ReceivedAt  time.Time       `json:"received_at"`
+	ProcessedAt *time.Time      `json:"processed_at,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	Ignored     string          `json:"ignored,omitempty"`
+	Event       *BuildEvent     `json:"event,omitempty"`
+	ParseError  string          `json:"parse_error,omitempty"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+}
+
+// DeliveriesPage is a page of deliveries, newest first.
+type DeliveriesPage struct {
+	Deliveries []*DeliveryView `json:"deliveries"`
+	Total      int             `json:"total"`
+	Page       int             `json:"page"`
+	PerPage    int             `json:"per_page"`
+	NextPage   int             `json:"next_page,omitempty"`
+}
+
+// DeliveriesHandler is an http.Handler that lists the recent deliveries,
+// paginated with the `page` and `per_page` query parameters.
+type DeliveriesHandler struct {
+	*Quayd
+}
+
+func (h *DeliveriesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	lister, ok := h.Deliveries.(DeliveryLister)
+	if !ok {
+		http.Error(w, "Deliveries are not recorded", 404)
+		return
+	}
+
+	query := r.URL.Query()
+	page, err := queryInt(query.Get("page"), 1)
+	if err != nil || page < 1 {
+		http.Error(w, "Invalid page: "+query.Get("page"), 400)
+		return
+	}
+	perPage, err := queryInt(query.Get("per_page"), DefaultDeliveriesPerPage)
+	if err != nil || perPage < 1 {
+		http.Error(w, "Invalid per_page: "+query.Get("per_page"), 400)
+		return
+	}
+	if perPage > maxDeliveriesPerPage {
+		perPage = maxDeliveriesPerPage
+	}
+
+	deliveries, total, err := lister.List((page-1)*perPage, perPage)
+	if err != nil {
+		h.errorResponse(w, r, err)
+		return
+	}
+
+	resp := &DeliveriesPage{
+		Deliveries: []*DeliveryView{},
+		Total:      total,
+		Page:       page,
+		PerPage:    perPage,
+	}
+	for _, d := range deliveries {
+		resp.Deliveries = append(resp.Deliveries, h.deliveryView(d))
+	}
+	if page*perPage < total {
+		resp.NextPage = page + 1
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// deliveryView parses the delivery's payload into the build event that it
+// would be processed as.
+func (q *Quayd) deliveryView(d *Delivery) *DeliveryView {
+	v := &DeliveryView{
+		ID:         d.ID,
+		Status:     d.Status,
+		State:      d.State(),
+		ReceivedAt: d.ReceivedAt,
+		Error:      d.Error,
+		Ignored:    d.Ignored,
+	}
+	if !d.ProcessedAt.IsZero() {
+		v.ProcessedAt = &d.ProcessedAt
+	}
+	if json.Valid(d.Payload) {
+		v.Payload = json.RawMessage(d.Payload)
+	}
+
+	e, err := newBuildEvent(d.ID, d.Status, d.Payload, q.Quay.Triggered)
+	if err != nil {
+		v.ParseError = err.Error()
+	} else {
+		v.Event = e
+	}
+	return v
+}
+
+func queryInt(s string, def int) (int, error) {
+	if s == "" {
+		return def, nil
+	}
+	return strconv.Atoi(s)
+}
@@ -0,0 +1,254 @@
+package quayd
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// testAdminToken is the AdminToken of the Quayds that tests make admin
+// requests to.
+const testAdminToken = "secret"
+
+// newAdminRequest returns a request authenticated with testAdminToken.
+func newAdminRequest(method, urlStr string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, urlStr, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	return req, nil
+}
+
+func TestAdminAuth(t *testing.T) {
+	s := NewServer(&Quayd{AdminToken: testAdminToken, Deliveries: &MemoryDeliveryStore{}})
+
+	for _, token := range []string{"", "wrong"} {
+		resp := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/admin/replay/foo", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		s.ServeHTTP(resp, req)
+
+		if got, want := resp.Code, 401; got != want {
+			t.Fatalf("Status with token %q => %d; want %d", token, got, want)
+		}
+	}
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/admin/replay/foo?token="+testAdminToken, nil)
+	s.ServeHTTP(resp, req)
+	if got, want := resp.Code, 404; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+}
+
+func TestAdminAuth_Disabled(t *testing.T) {
+	s := NewServer(&Quayd{Deliveries: &MemoryDeliveryStore{}})
+
+	resp := httptest.NewRecorder()
+	req, _ := newAdminRequest("POST", "/admin/replay/foo", nil)
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 404; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+}
+
+func TestTimelineHandler(t *testing.T) {
+	q := &Quayd{AdminToken: testAdminToken, StatusesRepository: &statusesRepository{}, Timelines: &Timelines{}}
+	s := NewServer(q)
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/quay/success", loadFixture("pending_build", t))
+	s.ServeHTTP(resp, req)
+
+	id := resp.Header().Get("X-Delivery-ID")
+	if id == "" {
+		t.Fatal("Expected a delivery id")
+	}
+
+	resp = httptest.NewRecorder()
+	req, _ = newAdminRequest("GET", "/admin/deliveries/"+id+"/timeline", nil)
+	s.ServeHTTP(resp, req)
+
+	var tl Timeline
+	if err := json.NewDecoder(resp.Body).Decode(&tl); err != nil {
+		t.Fatal(err)
+	}
+
+	var steps []string
+	for _, step := range tl.Steps {
+		steps = append(steps, step.Name)
+	}
+
+	want := []string{"received", "parsed", "tagged", "resolved", "status-created"}
+	if len(steps) != len(want) {
+		t.Fatalf("Steps => %v; want %v", steps, want)
+	}
+	for i := range want {
+		if steps[i] != want[i] {
+			t.Fatalf("Steps => %v; want %v", steps, want)
+		}
+	}
+}
+
+func TestTimelineHandler_NotFound(t *testing.T) {
+	s := NewServer(&Quayd{AdminToken: testAdminToken, Timelines: &Timelines{}})
+
+	resp := httptest.NewRecorder()
+	req, _ := newAdminRequest("GET", "/admin/deliveries/foo/timeline", nil)
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 404; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+}
+
+func TestReplayHandler(t *testing.T) {
+	r := &downStatusesRepository{down: true}
+	deliveries := &MemoryDeliveryStore{}
+	s := NewServer(&Quayd{AdminToken: testAdminToken, StatusesRepository: r, Deliveries: deliveries})
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/quay/pending", loadFixture("pending_build", t))
+	s.ServeHTTP(resp, req)
+
+	id := resp.Header().Get("X-Delivery-ID")
+	if d, err := deliveries.Find(id); err != nil || !d.Failed() {
+		t.Fatalf("Expected delivery %s to have failed", id)
+	}
+
+	r.down = false
+
+	resp = httptest.NewRecorder()
+	req, _ = newAdminRequest("POST", "/admin/replay/"+id, nil)
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 204; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+
+	if got, want := len(r.statuses), 1; got != want {
+		t.Fatalf("Statuses => %d; want %d", got, want)
+	}
+
+	if d, _ := deliveries.Find(id); d.Failed() {
+		t.Fatalf("Expected delivery to have succeeded, got %s", d.Error)
+	}
+}
+
+func TestReplayHandler_NotFound(t *testing.T) {
+	s := NewServer(&Quayd{AdminToken: testAdminToken, Deliveries: &MemoryDeliveryStore{}})
+
+	resp := httptest.NewRecorder()
+	req, _ := newAdminRequest("POST", "/admin/replay/foo", nil)
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 404; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+}
+
+func TestDeliveriesHandler(t *testing.T) {
+	deliveries := &MemoryDeliveryStore{}
+	s := NewServer(&Quayd{AdminToken: testAdminToken, StatusesRepository: &downStatusesRepository{down: true}, Deliveries: deliveries})
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		resp := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/quay/pending", loadFixture("pending_build", t))
+		s.ServeHTTP(resp, req)
+		ids = append(ids, resp.Header().Get("X-Delivery-ID"))
+	}
+
+	resp := httptest.NewRecorder()
+	req, _ := newAdminRequest("GET", "/admin/deliveries?per_page=2", nil)
+	s.ServeHTTP(resp, req)
+
+	var page DeliveriesPage
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := page.Total, 3; got != want {
+		t.Fatalf("Total => %d; want %d", got, want)
+	}
+	if got, want := page.NextPage, 2; got != want {
+		t.Fatalf("NextPage => %d; want %d", got, want)
+	}
+	if got, want := len(page.Deliveries), 2; got != want {
+		t.Fatalf("Deliveries => %d; want %d", got, want)
+	}
+
+	d := page.Deliveries[0]
+	if got, want := d.ID, ids[2]; got != want {
+		t.Fatalf("ID => %s; want %s", got, want)
+	}
+	if got, want := d.State, "failed"; got != want {
+		t.Fatalf("State => %s; want %s", got, want)
+	}
+	if d.Error == "" {
+		t.Fatal("Expected an error")
+	}
+	if d.Event == nil || d.Event.Repo != "ejholmes/docker-statsd" {
+		t.Fatalf("Event => %+v; want the parsed build event", d.Event)
+	}
+
+	resp = httptest.NewRecorder()
+	req, _ = newAdminRequest("GET", "/admin/deliveries?per_page=2&page=2", nil)
+	s.ServeHTTP(resp, req)
+
+	page = DeliveriesPage{}
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(page.Deliveries), 1; got != want {
+		t.Fatalf("Deliveries => %d; want %d", got, want)
+	}
+	if got, want := page.Deliveries[0].ID, ids[0]; got != want {
+		t.Fatalf("ID => %s; want %s", got, want)
+	}
+	if page.NextPage != 0 {
+		t.Fatalf("NextPage => %d; want none", page.NextPage)
+	}
+}
+
+func TestDeliveriesHandler_InvalidPage(t *testing.T) {
+	s := NewServer(&Quayd{AdminToken: testAdminToken, Deliveries: &MemoryDeliveryStore{}})
+
+	resp := httptest.NewRecorder()
+	req, _ := newAdminRequest("GET", "/admin/deliveries?page=0", nil)
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 400; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+}
+
+func TestDeliveriesHandler_NotRecorded(t *testing.T) {
+	s := NewServer(&Quayd{AdminToken: testAdminToken})
+
+	resp := httptest.NewRecorder()
+	req, _ := newAdminRequest("GET", "/admin/deliveries", nil)
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 404; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+}
+
+func TestDeliveriesHandler_Unauthorized(t *testing.T) {
+	s := NewServer(&Quayd{AdminToken: testAdminToken, Deliveries: &MemoryDeliveryStore{}})
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/deliveries", nil)
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 401; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+}
@@ -0,0 +1,103 @@
+package quayd
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// AdminMessage is a message sent from the dashboard over the admin socket.
+type AdminMessage struct {
+	// Op is the operation to perform: "tail" follows the log lines for a
+	// delivery, and "replay" reprocesses it.
+	Op string `json:"op"`
+
+	// ID is the delivery id.
+	ID string `json:"id"`
+}
+
+// AdminReply is a message sent to the dashboard over the admin socket.
+type AdminReply struct {
+	// Type is "log" for a tailed log line, "replayed" once a replay
+	// completes, or "error".
+	Type  string   `json:"type"`
+	ID    string   `json:"id,omitempty"`
+	Line  *LogLine `json:"line,omitempty"`
+	Error string   `json:"error,omitempty"`
+}
+
+// AdminSocketHandler is an http.Handler that upgrades to a WebSocket, over
+// which operators can tail the processing logs of a delivery and trigger
+// replays. Clients authenticate with AdminAuth.
+type AdminSocketHandler struct {
+	*Quayd
+}
+
+func (h *AdminSocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	// Close the socket when the server shuts down, which unblocks the
+	// read below.
+	go func() {
+		select {
+		case <-done:
+		case <-closing(r.Context()):
+			conn.Close()
+		}
+	}()
+
+	reply := func(msg *AdminReply) {
+		raw, _ := json.Marshal(msg)
+		conn.WriteMessage(raw)
+	}
+
+	for {
+		raw, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var msg AdminMessage
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			reply(&AdminReply{Type: "error", Error: err.Error()})
+			continue
+		}
+
+		switch msg.Op {
+		case "tail":
+			if h.Tail == nil {
+				reply(&AdminReply{Type: "error", ID: msg.ID, Error: "log tailing is not enabled"})
+				continue
+			}
+
+			lines, unsubscribe := h.Tail.Subscribe(msg.ID)
+			go func() {
+				defer unsubscribe()
+				for {
+					select {
+					case <-done:
+						return
+					case line := <-lines:
+						reply(&AdminReply{Type: "log", ID: line.RequestID, Line: line})
+					}
+				}
+			}()
+		case "replay":
+			go func(id string) {
+				if err := h.Replay(r.Context(), id); err != nil {
+					reply(&AdminReply{Type: "error", ID: id, Error: err.Error()})
+					return
+				}
+				reply(&AdminReply{Type: "replayed", ID: id})
+			}(msg.ID)
+		default:
+			reply(&AdminReply{Type: "error", ID: msg.ID, Error: "unknown op: " + msg.Op})
+		}
+	}
+}
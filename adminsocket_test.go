@@ -0,0 +1,166 @@
+package quayd
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminSocket(t *testing.T) {
+	q := &Quayd{
+		StatusesRepository: &statusesRepository{},
+		Deliveries:         &MemoryDeliveryStore{},
+		Logger:             &JSONLogger{Writer: ioutil.Discard},
+		Tail:               &LogTail{},
+		AdminToken:         "secret",
+	}
+	s := httptest.NewServer(NewServer(q))
+	defer s.Close()
+
+	resp, err := http.Post(s.URL+"/quay/pending", "application/json", loadFixture("pending_build", t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	id := resp.Header.Get("X-Delivery-ID")
+
+	c := dialTestWebSocket(t, s.URL, "secret")
+	defer c.Close()
+
+	c.write(t, `{"op":"tail","id":"`+id+`"}`)
+	c.write(t, `{"op":"replay","id":"`+id+`"}`)
+
+	var (
+		logs     int
+		replayed bool
+	)
+	for logs == 0 || !replayed {
+		var reply AdminReply
+		if err := json.Unmarshal(c.read(t), &reply); err != nil {
+			t.Fatal(err)
+		}
+
+		switch reply.Type {
+		case "log":
+			if reply.ID != id {
+				t.Fatalf("ID => %s; want %s", reply.ID, id)
+			}
+			logs++
+		case "replayed":
+			replayed = true
+		default:
+			t.Fatalf("Unexpected reply: %+v", reply)
+		}
+	}
+}
+
+func TestAdminSocket_Unauthorized(t *testing.T) {
+	s := NewServer(&Quayd{AdminToken: "secret"})
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/socket?token=wrong", nil)
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 401; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+}
+
+func TestAdminSocket_Disabled(t *testing.T) {
+	s := NewServer(&Quayd{})
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/socket", nil)
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 404; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+}
+
+// testWebSocket is a minimal WebSocket client.
+type testWebSocket struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func dialTestWebSocket(t testing.TB, url, token string) *testWebSocket {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	io.WriteString(conn, "GET /admin/socket HTTP/1.1\r\n"+
+		"Host: localhost\r\n"+
+		"Authorization: Bearer "+token+"\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := resp.StatusCode, 101; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+
+	if got, want := resp.Header.Get("Sec-WebSocket-Accept"), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="; got != want {
+		t.Fatalf("Sec-WebSocket-Accept => %s; want %s", got, want)
+	}
+
+	return &testWebSocket{Conn: conn, r: r}
+}
+
+func (c *testWebSocket) write(t testing.TB, msg string) {
+	var mask [4]byte
+	rand.Read(mask[:])
+
+	frame := []byte{0x80 | wsText, 0x80 | byte(len(msg))}
+	frame = append(frame, mask[:]...)
+	for i := 0; i < len(msg); i++ {
+		frame = append(frame, msg[i]^mask[i%4])
+	}
+
+	if _, err := c.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *testWebSocket) read(t testing.TB) []byte {
+	var header [2]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		t.Fatal(err)
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(c.r, ext[:])
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(c.r, ext[:])
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
@@ -0,0 +1,125 @@
+package quayd
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// IPAllowlist only allows requests from the networks in Allow, so that an
+// internet facing quayd only accepts Quay webhooks from Quay's published IP
+// ranges. A nil *IPAllowlist allows everything.
+type IPAllowlist struct {
+	Allow []*net.IPNet
+
+	// TrustedProxies are the networks of reverse proxies whose
+	// X-Forwarded-For header is trusted. The client is the last address in
+	// X-Forwarded-For that isn't a trusted proxy. Without trusted proxies,
+	// the header is ignored, since anyone could set it.
+	TrustedProxies []*net.IPNet
+}
+
+// ParseIPAllowlist returns an IPAllowlist for CIDRs (or single IPs), like
+// "10.0.0.0/8" or "192.0.2.1".
+func ParseIPAllowlist(allow, trustedProxies []string) (*IPAllowlist, error) {
+	l := &IPAllowlist{}
+	var err error
+	if l.Allow, err = parseCIDRs(allow); err != nil {
+		return nil, err
+	}
+	if l.TrustedProxies, err = parseCIDRs(trustedProxies); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Allows returns true if the request comes from an allowed network.
+func (l *IPAllowlist) Allows(r *http.Request) bool {
+	if l == nil {
+		return true
+	}
+
+	ip := l.ClientIP(r)
+	return ip != nil && containsIP(l.Allow, ip)
+}
+
+// ClientIP returns the IP address that the request came from, taking trusted
+// proxies into account.
+func (l *IPAllowlist) ClientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !containsIP(l.TrustedProxies, ip) {
+		return ip
+	}
+
+	// Walk back through the proxies that the request was forwarded
+	// through, until one isn't trusted.
+	header := strings.Join(r.Header.Values("X-Forwarded-For"), ",")
+	if header == "" {
+		return ip
+	}
+	forwarded := strings.Split(header, ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			return nil
+		}
+		ip = hop
+		if !containsIP(l.TrustedProxies, ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// Handler returns an http.Handler that responds with a 403 to requests that
+// aren't allowed, and passes the rest to h.
+func (l *IPAllowlist) Handler(h http.Handler) http.Handler {
+	if l == nil {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.Allows(r) {
+			http.Error(w, "Forbidden", 403)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, c := range cidrs {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		if !strings.Contains(c, "/") {
+			if ip := net.ParseIP(c); ip != nil && ip.To4() != nil {
+				c += "/32"
+			} else {
+				c += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %v", c, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package quayd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPAllowlist(t *testing.T) {
+	l, err := ParseIPAllowlist([]string{"23.20.0.0/14", "192.0.2.1"}, []string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		remoteAddr string
+		forwarded  string
+		allowed    bool
+	}{
+		{"23.21.1.2:1234", "", true},
+		{"192.0.2.1:1234", "", true},
+		{"198.51.100.1:1234", "", false},
+
+		// X-Forwarded-For is only trusted from trusted proxies.
+		{"198.51.100.1:1234", "23.21.1.2", false},
+		{"10.0.0.1:1234", "23.21.1.2", true},
+		{"10.0.0.1:1234", "23.21.1.2, 10.0.0.2", true},
+		{"10.0.0.1:1234", "198.51.100.1", false},
+
+		// A spoofed address before an untrusted hop is ignored.
+		{"10.0.0.1:1234", "23.21.1.2, 198.51.100.1", false},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("POST", "/quay/success", nil)
+		req.RemoteAddr = tt.remoteAddr
+		if tt.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+
+		if got := l.Allows(req); got != tt.allowed {
+			t.Fatalf("Allows(%s, %q) => %v; want %v", tt.remoteAddr, tt.forwarded, got, tt.allowed)
+		}
+	}
+
+	if _, err := ParseIPAllowlist([]string{"not-a-network"}, nil); err == nil {
+		t.Fatal("Expected an error")
+	}
+}
+
+func TestWebhook_IPAllowlist(t *testing.T) {
+	l, _ := ParseIPAllowlist([]string{"23.20.0.0/14"}, nil)
+	r := &statusesRepository{}
+	s := NewServer(&Quayd{StatusesRepository: r, IPAllowlist: l})
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/quay/pending", loadFixture("pending_build", t))
+	req.RemoteAddr = "198.51.100.1:1234"
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 403; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+	if len(r.statuses) != 0 {
+		t.Fatal("Expected no commit status")
+	}
+}
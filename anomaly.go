@@ -0,0 +1,110 @@
+package quayd
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Defaults for DurationMonitor.
+const (
+	DefaultDurationWindow     = 20
+	DefaultDurationDeviations = 3
+	DefaultDurationMinSamples = 5
+)
+
+// DurationMonitor keeps a rolling baseline of build durations for each repo
+// and flags builds that take much longer than usual, which catches Dockerfile
+// performance regressions early. A nil *DurationMonitor flags nothing.
+type DurationMonitor struct {
+	// Window is the number of recent builds that make up the baseline.
+	Window int
+
+	// Deviations is the number of standard deviations above the mean that
+	// a build must take to be flagged.
+	Deviations float64
+
+	// MinSamples is the number of builds required before any are flagged.
+	MinSamples int
+
+	mu        sync.Mutex
+	durations map[string][]time.Duration
+}
+
+// Observe records the duration of a finished build and returns a warning if
+// it was anomalous, or an empty string otherwise.
+func (m *DurationMonitor) Observe(e *BuildEvent) string {
+	if m == nil || e.State == "pending" || e.StartedAt.IsZero() || e.CompletedAt.IsZero() {
+		return ""
+	}
+
+	took := e.CompletedAt.Sub(e.StartedAt)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.durations == nil {
+		m.durations = make(map[string][]time.Duration)
+	}
+
+	baseline := m.durations[e.Repo]
+	m.durations[e.Repo] = append(baseline, took)
+	if n := len(m.durations[e.Repo]); n > m.window() {
+		m.durations[e.Repo] = m.durations[e.Repo][n-m.window():]
+	}
+
+	if len(baseline) < m.minSamples() {
+		return ""
+	}
+
+	mean, stddev := meanStddev(baseline)
+	if float64(took) <= mean+m.deviations()*stddev {
+		return ""
+	}
+
+	return fmt.Sprintf("build took %s, usual %s", round(took), round(time.Duration(mean)))
+}
+
+func (m *DurationMonitor) window() int {
+	if m.Window == 0 {
+		return DefaultDurationWindow
+	}
+	return m.Window
+}
+
+func (m *DurationMonitor) deviations() float64 {
+	if m.Deviations == 0 {
+		return DefaultDurationDeviations
+	}
+	return m.Deviations
+}
+
+func (m *DurationMonitor) minSamples() int {
+	if m.MinSamples == 0 {
+		return DefaultDurationMinSamples
+	}
+	return m.MinSamples
+}
+
+func meanStddev(durations []time.Duration) (mean, stddev float64) {
+	for _, d := range durations {
+		mean += float64(d)
+	}
+	mean /= float64(len(durations))
+
+	for _, d := range durations {
+		stddev += math.Pow(float64(d)-mean, 2)
+	}
+	stddev = math.Sqrt(stddev / float64(len(durations)))
+
+	return mean, stddev
+}
+
+// round rounds d to the nearest minute, or second for short durations.
+func round(d time.Duration) time.Duration {
+	if d < time.Minute {
+		return d / time.Second * time.Second
+	}
+	return (d + time.Minute/2) / time.Minute * time.Minute
+}
@@ -0,0 +1,29 @@
+package quayd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDurationMonitor(t *testing.T) {
+	m := &DurationMonitor{}
+	start := time.Unix(1420070400, 0)
+
+	build := func(d time.Duration) *BuildEvent {
+		return &BuildEvent{Repo: "remind101/r101-api", State: "success", StartedAt: start, CompletedAt: start.Add(d)}
+	}
+
+	for _, d := range []time.Duration{6, 5, 7, 6, 6} {
+		if w := m.Observe(build(d * time.Minute)); w != "" {
+			t.Fatalf("Unexpected warning: %s", w)
+		}
+	}
+
+	if got, want := m.Observe(build(7*time.Minute)), ""; got != want {
+		t.Fatalf("Warning => %q; want %q", got, want)
+	}
+
+	if got, want := m.Observe(build(28*time.Minute)), "build took 28m0s, usual 6m0s"; got != want {
+		t.Fatalf("Warning => %q; want %q", got, want)
+	}
+}
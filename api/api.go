@@ -0,0 +1,213 @@
+// Package api contains the core types and interfaces of quayd, without any of
+// their implementations. Embedders can depend on it to implement backends
+// without pulling in the GitHub, OAuth and registry clients.
+package api
+
+import (
+	"context"
+	"time"
+)
+
+// BuildEvent represents a build notification from Quay.
+type BuildEvent struct {
+	// A unique identifier for the delivery of this event.
+	ID string `json:"id"`
+
+	// The repository, in the form `owner/repo`.
+	Repo string `json:"repo"`
+
+	// The git ref that was built.
+	Ref string `json:"ref"`
+
+	// A URL to the build.
+	URL string `json:"url"`
+
+	// The Quay build id, if known.
+	BuildID string `json:"build_id,omitempty"`
+
+	// The attempt number of the build of this commit, if known. Retried
+	// builds have the same ref, but a new build id.
+	Attempt int `json:"attempt,omitempty"`
+
+	// The state of the build (pending, success, failure).
+	State string `json:"state"`
+
+	// Cancelled is true when the build was cancelled, rather than failing.
+	Cancelled bool `json:"cancelled,omitempty"`
+
+	// The error that Quay reported for a failed build, if any.
+	Error string `json:"error,omitempty"`
+
+	// The docker tags that the build was pushed with.
+	Tags []string `json:"tags"`
+
+	// The media type of the pushed artifact, used to pick a route.
+	MediaType string `json:"media_type,omitempty"`
+
+	// The time that the build started, if known.
+	StartedAt time.Time `json:"started_at"`
+
+	// The time that the build completed, if known.
+	CompletedAt time.Time `json:"completed_at"`
+
+	// The time that quayd received the webhook, if known.
+	ReceivedAt time.Time `json:"received_at"`
+
+	// Other commits that are part of the push which triggered the build.
+	Commits []string `json:"commits,omitempty"`
+
+	// The commit status context to use, overriding the configured one. Set
+	// from the `context` query parameter of the webhook URL, so monorepos
+	// with multiple Quay builds can have distinct statuses.
+	Context string `json:"context,omitempty"`
+
+	// The branch that was pushed, and the repo's default branch, if known.
+	Branch        string `json:"branch,omitempty"`
+	DefaultBranch string `json:"default_branch,omitempty"`
+
+	// The git tag that was pushed, if the build was triggered by a tag.
+	GitTag string `json:"git_tag,omitempty"`
+
+	// The registry that the image was pushed to. Defaults to the registry
+	// that quayd tags images in, which is the only registry that images
+	// are retagged in.
+	Registry string `json:"registry,omitempty"`
+
+	// The URL of the git remote that the build was triggered from, for
+	// builds from custom git remotes rather than GitHub. The repo and ref
+	// are resolved from it and the commit sha by a RefResolver.
+	GitURL string `json:"git_url,omitempty"`
+
+	// The optional steps that were skipped because handling the event
+	// went over its latency budget.
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+// Status represents a GitHub Commit Status.
+type Status struct {
+	Repo        string `json:"repo"`
+	Ref         string `json:"ref"`
+	State       string `json:"state"`
+	Context     string `json:"context"`
+	TargetURL   string `json:"target_url"`
+	Description string `json:"description"`
+
+	// Image is the docker image that was tagged for this status, if any.
+	Image *Image `json:"image,omitempty"`
+
+	// Attempt is the attempt number of the build, when it's been retried.
+	Attempt int `json:"attempt,omitempty"`
+
+	// Branch is the branch that was built, if known.
+	Branch string `json:"branch,omitempty"`
+
+	// Cancelled is true for the status of a cancelled build, so that
+	// backends with a cancelled state can report it as one.
+	Cancelled bool `json:"cancelled,omitempty"`
+}
+
+// Image represents a docker image that quayd tagged.
+type Image struct {
+	// The registry host that the image lives in.
+	Registry string `json:"registry"`
+
+	// The repository, in the form `owner/repo`.
+	Repo string `json:"repo"`
+
+	// The image id.
+	ID string `json:"id,omitempty"`
+
+	// The immutable digest of the image, if known.
+	Digest string `json:"digest,omitempty"`
+
+	// The tags that point at the image.
+	Tags []string `json:"tags"`
+
+	// Platforms are the platform specific images, when the image is a
+	// multi-arch manifest list.
+	Platforms []*Platform `json:"platforms,omitempty"`
+}
+
+// Platform is the image of one platform of a multi-arch manifest list.
+type Platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+
+	// Digest is the digest of the platform's manifest.
+	Digest string `json:"digest"`
+
+	// Tag is the tag that was applied to the platform's manifest, if any.
+	Tag string `json:"tag,omitempty"`
+}
+
+// Name returns the fully qualified name of the image, without a tag.
+func (i *Image) Name() string {
+	return i.Registry + "/" + i.Repo
+}
+
+// StatusesRepository is an interface that can be implemented for creating
+// Commit Statuses.
+type StatusesRepository interface {
+	// Create creates a GitHub Commit Status.
+	Create(context.Context, *Status) error
+}
+
+// CommitResolver is an interface for resolving a short sha to a full 40
+// character sha.
+type CommitResolver interface {
+	// Resolve resolves the short sha to a full 40 character sha.
+	Resolve(ctx context.Context, repo, short string) (string, error)
+}
+
+// TagResolver resolves a docker tag to an image id, or to a manifest digest
+// for v2 registries.
+type TagResolver interface {
+	Resolve(ctx context.Context, repo, tag string) (string, error)
+}
+
+// Tagger is an interface for tagging a docker image with a tag.
+type Tagger interface {
+	// Tag tags the imageID with the given tag.
+	Tag(ctx context.Context, repo, imageID, tag string) error
+}
+
+// Untagger is implemented by Taggers that can also remove tags.
+type Untagger interface {
+	// Untag removes the tag from repo.
+	Untag(ctx context.Context, repo, tag string) error
+}
+
+// TagEvent describes tags that quayd applied to an image.
+type TagEvent struct {
+	Repo    string   `json:"repo"`
+	Sha     string   `json:"sha"`
+	ImageID string   `json:"image_id"`
+	Tags    []string `json:"tags"`
+}
+
+// TagHook is an interface that is notified after quayd applies tags to an
+// image.
+type TagHook interface {
+	// TagsApplied is called after the tags have been applied.
+	TagsApplied(context.Context, *TagEvent) error
+}
+
+// Handler is an interface for handling build events.
+type Handler interface {
+	Handle(context.Context, *BuildEvent) error
+}
+
+// Logger is an interface for structured logging.
+type Logger interface {
+	// Log logs msg with alternating key/value pairs. The request id in ctx,
+	// if any, is included.
+	Log(ctx context.Context, msg string, keyvals ...interface{})
+}
+
+// Notifier is an interface that is notified of each commit status that
+// quayd creates, for sending chat notifications and the like.
+type Notifier interface {
+	// Notify is called after the status for the build event is created.
+	Notify(ctx context.Context, e *BuildEvent, status *Status) error
+}
@@ -0,0 +1,11 @@
+package api
+
+import "testing"
+
+func TestImage_Name(t *testing.T) {
+	i := &Image{Registry: "quay.io", Repo: "remind101/acme-inc"}
+
+	if got, want := i.Name(), "quay.io/remind101/acme-inc"; got != want {
+		t.Fatalf("Name => %s; want %s", got, want)
+	}
+}
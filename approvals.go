@@ -0,0 +1,515 @@
+package quayd
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ejholmes/go-github/github"
+)
+
+// DefaultApprovalTags are the tags that require approval when
+// Approvals.Tags is empty.
+var DefaultApprovalTags = []string{"production"}
+
+// DefaultApprovalPath is the directory of the deploys repo that promotion
+// pull requests write to when Approvals.Path is empty.
+const DefaultApprovalPath = "promotions"
+
+// Promotion is a request to tag an image, which is applied once the pull
+// request opened for it is approved or merged.
+type Promotion struct {
+	Repo        string    `json:"repo"`
+	Ref         string    `json:"ref"`
+	Sha         string    `json:"sha"`
+	ImageID     string    `json:"image_id"`
+	Tag         string    `json:"tag"`
+	Number      int       `json:"number"`
+	URL         string    `json:"url"`
+	RequestedAt time.Time `json:"requested_at"`
+}
+
+// PromotionPendingError is returned by Promote when the promotion requires
+// approval, and a pull request was opened for it instead.
+type PromotionPendingError struct {
+	Promotion *Promotion
+}
+
+func (e *PromotionPendingError) Error() string {
+	p := e.Promotion
+	return fmt.Sprintf("promotion of %s@%s to %s awaits approval: %s", p.Repo, p.ImageID, p.Tag, p.URL)
+}
+
+// ApprovalPullRequest is a pull request that writes the candidate digest of a
+// promotion to a file in the deploys repo.
+type ApprovalPullRequest struct {
+	Repo    string
+	Base    string
+	Branch  string
+	Title   string
+	Body    string
+	Path    string
+	Content []byte
+}
+
+// ApprovalState is the state of a promotion pull request, as reported by
+// GitHub.
+type ApprovalState struct {
+	// Approved is true if the pull request has an approving review, and
+	// no outstanding request for changes.
+	Approved bool
+
+	// Merged and Closed are true if the pull request was merged or
+	// closed.
+	Merged bool
+	Closed bool
+}
+
+// ApprovalService is an interface for opening promotion pull requests, and
+// checking on them. GitHubApprovalService implements it.
+type ApprovalService interface {
+	// OpenPullRequest opens the pull request and returns its number and
+	// URL.
+	OpenPullRequest(ctx context.Context, pr *ApprovalPullRequest) (number int, url string, err error)
+
+	// PullRequestState returns the state of the pull request.
+	PullRequestState(ctx context.Context, repo string, number int) (*ApprovalState, error)
+}
+
+// Approvals gates promotions to some tags (e.g. "production") behind pull
+// request reviews. Instead of tagging the image, Promote opens a pull request
+// with the candidate digest in a designated deploys repo, and the tag is
+// applied when a `pull_request_review` webhook approves it, or a
+// `pull_request` webhook reports it merged. The webhooks must be signed with
+// the GitHub webhook secret, and the pull request's state is checked with
+// GitHub before the tag is applied. Who can approve is up to the deploys
+// repo's branch protection. Pending promotions are kept in memory,
+// so pull requests opened before a restart need to be promoted again. A nil
+// *Approvals requires no approvals.
+type Approvals struct {
+	// Repo is the deploys repo, as `owner/repo`.
+	Repo string `json:"repo"`
+
+	// Base is the branch that pull requests are opened against. Defaults
+	// to master.
+	Base string `json:"base,omitempty"`
+
+	// Tags are the tags whose promotions require approval. Defaults to
+	// DefaultApprovalTags.
+	Tags []string `json:"tags,omitempty"`
+
+	// Path is the directory that promotion files are written to. Defaults
+	// to DefaultApprovalPath.
+	Path string `json:"path,omitempty"`
+
+	// RequireMerge, if true, only applies promotions when their pull
+	// request is merged, rather than when it's approved.
+	RequireMerge bool `json:"require_merge,omitempty"`
+
+	Service ApprovalService `json:"-"`
+
+	mu      sync.Mutex
+	pending map[int]*Promotion
+}
+
+// Required returns true if promotions to tag require approval.
+func (a *Approvals) Required(tag string) bool {
+	if a == nil {
+		return false
+	}
+
+	tags := a.Tags
+	if len(tags) == 0 {
+		tags = DefaultApprovalTags
+	}
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Request opens a pull request for the promotion, and keeps it pending until
+// the pull request is approved or merged.
+func (a *Approvals) Request(ctx context.Context, p *Promotion) error {
+	number, url, err := a.Service.OpenPullRequest(ctx, a.pullRequest(p, time.Now()))
+	if err != nil {
+		return err
+	}
+	p.Number, p.URL = number, url
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.pending == nil {
+		a.pending = make(map[int]*Promotion)
+	}
+	a.pending[number] = p
+	return nil
+}
+
+// Pending returns the promotions awaiting approval, oldest first.
+func (a *Approvals) Pending() []*Promotion {
+	promotions := []*Promotion{}
+	if a == nil {
+		return promotions
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, p := range a.pending {
+		promotions = append(promotions, p)
+	}
+	sort.Slice(promotions, func(i, j int) bool { return promotions[i].Number < promotions[j].Number })
+	return promotions
+}
+
+// get returns the pending promotion of the pull request.
+func (a *Approvals) get(number int) *Promotion {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.pending[number]
+}
+
+// take removes and returns the pending promotion of the pull request.
+func (a *Approvals) take(number int) *Promotion {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	p := a.pending[number]
+	delete(a.pending, number)
+	return p
+}
+
+// restore puts back a promotion that couldn't be applied, so that a later
+// webhook for the pull request can retry it.
+func (a *Approvals) restore(p *Promotion) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.pending == nil {
+		a.pending = make(map[int]*Promotion)
+	}
+	a.pending[p.Number] = p
+}
+
+func (a *Approvals) pullRequest(p *Promotion, now time.Time) *ApprovalPullRequest {
+	dir := a.Path
+	if dir == "" {
+		dir = DefaultApprovalPath
+	}
+	base := a.Base
+	if base == "" {
+		base = "master"
+	}
+
+	image := DefaultRegistry + "/" + p.Repo
+	return &ApprovalPullRequest{
+		Repo:   a.Repo,
+		Base:   base,
+		Branch: fmt.Sprintf("quayd/promote-%s-%s-%d", strings.Replace(p.Repo, "/", "-", -1), p.Tag, now.Unix()),
+		Title:  fmt.Sprintf("Promote %s@%s to %s", image, shortSha(p.Sha), p.Tag),
+		Body: fmt.Sprintf("This pull request was opened by quayd to promote an image. "+
+			"Approving or merging it tags the image with `%s`.\n\n"+
+			"| Image | Commit | Digest |\n| --- | --- | --- |\n| `%s` | `%s` | `%s` |\n",
+			p.Tag, image, p.Sha, p.ImageID),
+		Path:    path.Join(dir, p.Repo, p.Tag),
+		Content: []byte(image + "@" + p.ImageID + "\n"),
+	}
+}
+
+// requestApproval opens a pull request for the promotion, unless quayd is
+// read-only.
+func (q *Quayd) requestApproval(ctx context.Context, p *Promotion) error {
+	if q.ReadOnly {
+		q.logger().Log(ctx, "approval request skipped (read-only)", "repo", p.Repo, "tag", p.Tag)
+		return nil
+	}
+
+	return q.Approvals.Request(ctx, p)
+}
+
+// applyPromotion tags the image of an approved promotion. The image is
+// checked for quarantine again, since it may have been quarantined while the
+// pull request was open.
+func (q *Quayd) applyPromotion(ctx context.Context, p *Promotion) (*Image, error) {
+	q = q.forTenant(p.Repo)
+
+	if q.Quarantines.Quarantined(p.Repo, p.ImageID) {
+		return nil, fmt.Errorf("%s@%s is quarantined", p.Repo, p.ImageID)
+	}
+
+	if err := q.tagger().Tag(ctx, p.Repo, p.ImageID, p.Tag); err != nil {
+		return nil, err
+	}
+	q.logger().Log(ctx, "promotion applied", "repo", p.Repo, "image", p.ImageID, "tag", p.Tag, "pull", p.Number)
+	q.bake(ctx, p.Repo, p.Sha, p.ImageID, p.Tag)
+
+	return &Image{
+		Registry: DefaultRegistry,
+		Repo:     p.Repo,
+		ID:       p.ImageID,
+		Tags:     []string{p.Sha, p.Tag},
+	}, nil
+}
+
+// GitHubPullRequestEvent is the payload of a GitHub `pull_request` or
+// `pull_request_review` webhook.
+type GitHubPullRequestEvent struct {
+	Action      string `json:"action"`
+	PullRequest struct {
+		Number int  `json:"number"`
+		Merged bool `json:"merged"`
+	} `json:"pull_request"`
+	Review struct {
+		State string `json:"state"`
+		User  struct {
+			Login string `json:"login"`
+		} `json:"user"`
+	} `json:"review"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+// approval handles reviews and merges of promotion pull requests in the
+// deploys repo. Closing a pull request without merging it cancels its
+// promotion. Since approving a promotion tags the image, the webhook must be
+// signed, and the payload is only taken as a hint to check the pull request's
+// state with GitHub.
+func (wh *GitHubWebhook) approval(w http.ResponseWriter, r *http.Request, event string, body []byte) {
+	if _, ok := wh.WebhookValidators["github"]; !ok {
+		http.Error(w, "approvals require a GitHub webhook secret", 401)
+		return
+	}
+
+	var e GitHubPullRequestEvent
+	if err := json.Unmarshal(body, &e); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	if e.Repository.FullName != wh.Approvals.Repo {
+		w.WriteHeader(204)
+		return
+	}
+
+	reviewed := event == "pull_request_review" && e.Action == "submitted"
+	closed := event == "pull_request" && e.Action == "closed"
+	if (!reviewed && !closed) || wh.Approvals.get(e.PullRequest.Number) == nil {
+		w.WriteHeader(204)
+		return
+	}
+
+	state, err := wh.Approvals.Service.PullRequestState(r.Context(), wh.Approvals.Repo, e.PullRequest.Number)
+	if err != nil {
+		wh.errorResponse(w, r, err)
+		return
+	}
+
+	approved := state.Merged || (state.Approved && !wh.Approvals.RequireMerge)
+	cancelled := state.Closed && !state.Merged
+	if !approved && !cancelled {
+		w.WriteHeader(204)
+		return
+	}
+
+	p := wh.Approvals.take(e.PullRequest.Number)
+	if p == nil {
+		w.WriteHeader(204)
+		return
+	}
+
+	if cancelled {
+		wh.logger().Log(r.Context(), "promotion cancelled", "repo", p.Repo, "tag", p.Tag, "pull", p.Number)
+		w.WriteHeader(204)
+		return
+	}
+
+	image, err := wh.applyPromotion(r.Context(), p)
+	if err != nil {
+		wh.Approvals.restore(p)
+		wh.errorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(image)
+}
+
+// PromotionsHandler is an http.Handler that promotes the image of a ref on
+// POST, and lists the promotions awaiting approval on GET. Promotions that
+// require approval respond with a 202 and the pending promotion.
+type PromotionsHandler struct {
+	*Quayd
+}
+
+// PromotionForm is the payload of a request to PromotionsHandler.
+type PromotionForm struct {
+	Repo string `json:"repo"`
+	Ref  string `json:"ref"`
+	Tag  string `json:"tag"`
+}
+
+func (h *PromotionsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.Approvals.Pending())
+		return
+	}
+
+	var form PromotionForm
+	if err := json.NewDecoder(r.Body).Decode(&form); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	if form.Repo == "" || form.Ref == "" || form.Tag == "" {
+		http.Error(w, "repo, ref and tag are required", 400)
+		return
+	}
+
+	image, err := h.Promote(r.Context(), form.Repo, form.Ref, form.Tag)
+	if perr, ok := err.(*PromotionPendingError); ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(202)
+		json.NewEncoder(w).Encode(perr.Promotion)
+		return
+	}
+	if err != nil {
+		h.errorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(image)
+}
+
+// GitHubApprovalService is an implementation of the ApprovalService
+// interface backed by the GitHub API.
+type GitHubApprovalService struct {
+	Client interface {
+		NewRequest(method, urlStr string, body interface{}) (*http.Request, error)
+		Do(req *http.Request, v interface{}) (*github.Response, error)
+	}
+}
+
+// OpenPullRequest implements ApprovalService OpenPullRequest.
+func (s *GitHubApprovalService) OpenPullRequest(ctx context.Context, pr *ApprovalPullRequest) (int, string, error) {
+	var ref struct {
+		Object struct {
+			Sha string `json:"sha"`
+		} `json:"object"`
+	}
+	if err := s.do(ctx, "GET", fmt.Sprintf("repos/%s/git/ref/heads/%s", pr.Repo, pr.Base), nil, &ref); err != nil {
+		return 0, "", err
+	}
+
+	if err := s.do(ctx, "POST", fmt.Sprintf("repos/%s/git/refs", pr.Repo), map[string]string{
+		"ref": "refs/heads/" + pr.Branch,
+		"sha": ref.Object.Sha,
+	}, nil); err != nil {
+		return 0, "", err
+	}
+
+	// Updating a file that already exists, from an earlier promotion,
+	// requires its blob sha.
+	contentsURL := fmt.Sprintf("repos/%s/contents/%s", pr.Repo, pr.Path)
+	var existing struct {
+		Sha string `json:"sha"`
+	}
+	err := s.do(ctx, "GET", contentsURL+"?ref="+url.QueryEscape(pr.Branch), nil, &existing)
+	if err != nil && statusCode(err) != 404 {
+		return 0, "", err
+	}
+
+	update := map[string]string{
+		"message": pr.Title,
+		"content": base64.StdEncoding.EncodeToString(pr.Content),
+		"branch":  pr.Branch,
+	}
+	if existing.Sha != "" {
+		update["sha"] = existing.Sha
+	}
+	if err := s.do(ctx, "PUT", contentsURL, update, nil); err != nil {
+		return 0, "", err
+	}
+
+	var created struct {
+		Number  int    `json:"number"`
+		HTMLURL string `json:"html_url"`
+	}
+	err = s.do(ctx, "POST", fmt.Sprintf("repos/%s/pulls", pr.Repo), map[string]string{
+		"title": pr.Title,
+		"head":  pr.Branch,
+		"base":  pr.Base,
+		"body":  pr.Body,
+	}, &created)
+	return created.Number, created.HTMLURL, err
+}
+
+// PullRequestState implements ApprovalService PullRequestState. The latest
+// review of each reviewer counts, so a request for changes overrides an
+// earlier approval by the same reviewer.
+func (s *GitHubApprovalService) PullRequestState(ctx context.Context, repo string, number int) (*ApprovalState, error) {
+	var pull struct {
+		State  string `json:"state"`
+		Merged bool   `json:"merged"`
+	}
+	if err := s.do(ctx, "GET", fmt.Sprintf("repos/%s/pulls/%d", repo, number), nil, &pull); err != nil {
+		return nil, err
+	}
+
+	var reviews []struct {
+		State string `json:"state"`
+		User  struct {
+			Login string `json:"login"`
+		} `json:"user"`
+	}
+	if err := s.do(ctx, "GET", fmt.Sprintf("repos/%s/pulls/%d/reviews?per_page=100", repo, number), nil, &reviews); err != nil {
+		return nil, err
+	}
+
+	latest := make(map[string]string)
+	for _, r := range reviews {
+		// Comments don't change a reviewer's verdict.
+		if r.State == "COMMENTED" || r.State == "PENDING" {
+			continue
+		}
+		latest[r.User.Login] = r.State
+	}
+
+	state := &ApprovalState{Merged: pull.Merged, Closed: pull.State == "closed"}
+	for _, verdict := range latest {
+		switch verdict {
+		case "APPROVED":
+			state.Approved = true
+		case "CHANGES_REQUESTED":
+			state.Approved = false
+			return state, nil
+		}
+	}
+	return state, nil
+}
+
+func (s *GitHubApprovalService) do(ctx context.Context, method, urlStr string, body, v interface{}) error {
+	req, err := s.Client.NewRequest(method, urlStr, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	_, err = s.Client.Do(req.WithContext(ctx), v)
+	return err
+}
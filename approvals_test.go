@@ -0,0 +1,253 @@
+package quayd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// approvalService is a fake ApprovalService that records the pull requests
+// that it opens. Pull requests are open and unreviewed until their state is
+// set.
+type approvalService struct {
+	pulls  []*ApprovalPullRequest
+	states map[int]*ApprovalState
+}
+
+func (s *approvalService) PullRequestState(ctx context.Context, repo string, number int) (*ApprovalState, error) {
+	if state, ok := s.states[number]; ok {
+		return state, nil
+	}
+	return &ApprovalState{}, nil
+}
+
+func (s *approvalService) set(number int, state *ApprovalState) {
+	if s.states == nil {
+		s.states = make(map[int]*ApprovalState)
+	}
+	s.states[number] = state
+}
+
+func (s *approvalService) OpenPullRequest(ctx context.Context, pr *ApprovalPullRequest) (int, string, error) {
+	s.pulls = append(s.pulls, pr)
+	n := len(s.pulls)
+	return n, fmt.Sprintf("https://github.com/remind101/deploys/pull/%d", n), nil
+}
+
+func newApprovalsServer(approvals *Approvals) (*MemoryRegistry, *Server) {
+	registry := &MemoryRegistry{}
+	registry.Seed("remind101/acme-inc", "long-f1fb3b0")
+	q := &Quayd{
+		AdminToken:        testAdminToken,
+		Tagger:            registry,
+		TagResolver:       registry,
+		Approvals:         approvals,
+		WebhookValidators: WebhookValidators{"github": &HMACValidator{Secret: "secret", Header: GitHubSignatureHeader}},
+	}
+	return registry, NewServer(q)
+}
+
+func promote(s *Server, tag string) *httptest.ResponseRecorder {
+	resp := httptest.NewRecorder()
+	req, _ := newAdminRequest("POST", "/admin/promotions", strings.NewReader(`{"repo":"remind101/acme-inc","ref":"f1fb3b0","tag":"`+tag+`"}`))
+	s.ServeHTTP(resp, req)
+	return resp
+}
+
+func pullRequestWebhook(s *Server, event, body string) *httptest.ResponseRecorder {
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/github", bytes.NewBufferString(body))
+	req.Header.Set("X-GitHub-Event", event)
+	req.Header.Set(GitHubSignatureHeader, Sign([]byte("secret"), []byte(body)))
+	s.ServeHTTP(resp, req)
+	return resp
+}
+
+func TestApprovals_Review(t *testing.T) {
+	service := &approvalService{}
+	approvals := &Approvals{Repo: "remind101/deploys", Service: service}
+	registry, s := newApprovalsServer(approvals)
+
+	resp := promote(s, "production")
+	if got, want := resp.Code, 202; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+
+	var p Promotion
+	if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	built, _ := registry.Resolve(context.Background(), "remind101/acme-inc", "long-f1fb3b0")
+	if p.Number != 1 || p.ImageID != built {
+		t.Fatalf("Promotion => %+v", p)
+	}
+
+	if got, want := len(service.pulls), 1; got != want {
+		t.Fatalf("Pull requests => %d; want %d", got, want)
+	}
+	pr := service.pulls[0]
+	if got, want := pr.Path, "promotions/remind101/acme-inc/production"; got != want {
+		t.Fatalf("Path => %s; want %s", got, want)
+	}
+	if got, want := string(pr.Content), "quay.io/remind101/acme-inc@"+built+"\n"; got != want {
+		t.Fatalf("Content => %q; want %q", got, want)
+	}
+
+	if production, _ := registry.Resolve(context.Background(), "remind101/acme-inc", "production"); production != "" {
+		t.Fatal("Expected the image not to be tagged before approval")
+	}
+
+	// Reviews don't apply the promotion until GitHub reports it approved.
+	pullRequestWebhook(s, "pull_request_review", `{"action":"submitted","review":{"state":"approved"},"pull_request":{"number":1},"repository":{"full_name":"remind101/deploys"}}`)
+	if production, _ := registry.Resolve(context.Background(), "remind101/acme-inc", "production"); production != "" {
+		t.Fatal("Expected a forged approval not to tag the image")
+	}
+
+	// Comments and reviews in other repos don't apply the promotion.
+	pullRequestWebhook(s, "pull_request_review", `{"action":"submitted","review":{"state":"commented"},"pull_request":{"number":1},"repository":{"full_name":"remind101/deploys"}}`)
+	pullRequestWebhook(s, "pull_request_review", `{"action":"submitted","review":{"state":"approved"},"pull_request":{"number":1},"repository":{"full_name":"remind101/acme-inc"}}`)
+	if production, _ := registry.Resolve(context.Background(), "remind101/acme-inc", "production"); production != "" {
+		t.Fatal("Expected the image not to be tagged")
+	}
+
+	service.set(1, &ApprovalState{Approved: true})
+	resp = pullRequestWebhook(s, "pull_request_review", `{"action":"submitted","review":{"state":"approved"},"pull_request":{"number":1},"repository":{"full_name":"remind101/deploys"}}`)
+	if got, want := resp.Code, 200; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+	if production, _ := registry.Resolve(context.Background(), "remind101/acme-inc", "production"); production != built {
+		t.Fatal("Expected the image to be tagged production")
+	}
+
+	if pending := approvals.Pending(); len(pending) != 0 {
+		t.Fatalf("Pending => %v; want none", pending)
+	}
+}
+
+func TestApprovals_RequireMerge(t *testing.T) {
+	service := &approvalService{}
+	registry, s := newApprovalsServer(&Approvals{Repo: "remind101/deploys", RequireMerge: true, Service: service})
+	promote(s, "production")
+
+	service.set(1, &ApprovalState{Approved: true})
+	pullRequestWebhook(s, "pull_request_review", `{"action":"submitted","review":{"state":"approved"},"pull_request":{"number":1},"repository":{"full_name":"remind101/deploys"}}`)
+	if production, _ := registry.Resolve(context.Background(), "remind101/acme-inc", "production"); production != "" {
+		t.Fatal("Expected the image not to be tagged before the merge")
+	}
+
+	service.set(1, &ApprovalState{Approved: true, Merged: true, Closed: true})
+	pullRequestWebhook(s, "pull_request", `{"action":"closed","pull_request":{"number":1,"merged":true},"repository":{"full_name":"remind101/deploys"}}`)
+	if production, _ := registry.Resolve(context.Background(), "remind101/acme-inc", "production"); production == "" {
+		t.Fatal("Expected the image to be tagged production")
+	}
+}
+
+func TestApprovals_Closed(t *testing.T) {
+	service := &approvalService{}
+	registry, s := newApprovalsServer(&Approvals{Repo: "remind101/deploys", Service: service})
+	promote(s, "production")
+
+	service.set(1, &ApprovalState{Closed: true})
+	pullRequestWebhook(s, "pull_request", `{"action":"closed","pull_request":{"number":1,"merged":false},"repository":{"full_name":"remind101/deploys"}}`)
+	service.set(1, &ApprovalState{Approved: true, Closed: true})
+	pullRequestWebhook(s, "pull_request", `{"action":"closed","pull_request":{"number":1,"merged":false},"repository":{"full_name":"remind101/deploys"}}`)
+	pullRequestWebhook(s, "pull_request_review", `{"action":"submitted","review":{"state":"approved"},"pull_request":{"number":1},"repository":{"full_name":"remind101/deploys"}}`)
+
+	if production, _ := registry.Resolve(context.Background(), "remind101/acme-inc", "production"); production != "" {
+		t.Fatal("Expected a closed pull request to cancel the promotion")
+	}
+}
+
+func TestApprovals_NotRequired(t *testing.T) {
+	service := &approvalService{}
+	registry, s := newApprovalsServer(&Approvals{Repo: "remind101/deploys", Service: service})
+
+	if got, want := promote(s, "staging").Code, 200; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+	if len(service.pulls) != 0 {
+		t.Fatal("Expected no pull request for a tag that doesn't require approval")
+	}
+	if staging, _ := registry.Resolve(context.Background(), "remind101/acme-inc", "staging"); staging == "" {
+		t.Fatal("Expected the image to be tagged staging")
+	}
+}
+
+func TestApprovals_Unsigned(t *testing.T) {
+	service := &approvalService{}
+	registry, s := newApprovalsServer(&Approvals{Repo: "remind101/deploys", Service: service})
+	promote(s, "production")
+	service.set(1, &ApprovalState{Approved: true})
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/github", strings.NewReader(`{"action":"submitted","review":{"state":"approved"},"pull_request":{"number":1},"repository":{"full_name":"remind101/deploys"}}`))
+	req.Header.Set("X-GitHub-Event", "pull_request_review")
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 401; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+	if production, _ := registry.Resolve(context.Background(), "remind101/acme-inc", "production"); production != "" {
+		t.Fatal("Expected an unsigned approval not to tag the image")
+	}
+}
+
+func TestApprovals_ReadOnly(t *testing.T) {
+	service := &approvalService{}
+	registry, s := newApprovalsServer(&Approvals{Repo: "remind101/deploys", Service: service})
+	s.Quayd().ReadOnly = true
+
+	if got, want := promote(s, "production").Code, 202; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+	if len(service.pulls) != 0 {
+		t.Fatal("Expected a read-only instance not to open pull requests")
+	}
+	if production, _ := registry.Resolve(context.Background(), "remind101/acme-inc", "production"); production != "" {
+		t.Fatal("Expected the image not to be tagged")
+	}
+}
+
+func TestGitHubApprovalService_PullRequestState(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/remind101/deploys/pulls/1":
+			w.Write([]byte(`{"state":"open","merged":false}`))
+		case "/repos/remind101/deploys/pulls/1/reviews":
+			w.Write([]byte(`[{"state":"APPROVED","user":{"login":"a"}},{"state":"CHANGES_REQUESTED","user":{"login":"b"}},{"state":"APPROVED","user":{"login":"b"}},{"state":"COMMENTED","user":{"login":"a"}}]`))
+		case "/repos/remind101/deploys/pulls/2":
+			w.Write([]byte(`{"state":"open","merged":false}`))
+		case "/repos/remind101/deploys/pulls/2/reviews":
+			w.Write([]byte(`[{"state":"APPROVED","user":{"login":"a"}},{"state":"CHANGES_REQUESTED","user":{"login":"b"}}]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer s.Close()
+
+	g := NewGitHubClient("")
+	g.BaseURL, _ = url.Parse(s.URL + "/")
+	service := &GitHubApprovalService{Client: g}
+
+	state, err := service.PullRequestState(context.Background(), "remind101/deploys", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.Approved || state.Merged || state.Closed {
+		t.Fatalf("State => %+v; want approved", state)
+	}
+
+	state, err = service.PullRequestState(context.Background(), "remind101/deploys", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Approved {
+		t.Fatalf("State => %+v; want changes requested", state)
+	}
+}
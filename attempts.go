@@ -0,0 +1,127 @@
+package quayd
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// DefaultMaxAttemptCommits is the default number of commits that Attempts
+// keeps the history of.
+const DefaultMaxAttemptCommits = 1000
+
+// Attempt is a single Quay build of a commit.
+type Attempt struct {
+	// Number is the 1 based attempt number.
+	Number int `json:"number"`
+
+	// BuildID is the Quay build id.
+	BuildID string `json:"build_id"`
+
+	// State is the latest state of the build.
+	State string `json:"state"`
+
+	// Started is when quayd first saw the build.
+	Started time.Time `json:"started"`
+
+	// Updated is when quayd last saw the build.
+	Updated time.Time `json:"updated"`
+}
+
+// Attempts tracks the Quay builds of each commit, so that a retried build
+// (the same commit, with a new build id) is reported as a new attempt of the
+// same check rather than a duplicate. A nil *Attempts records nothing.
+type Attempts struct {
+	// Max is the number of commits to keep. Defaults to
+	// DefaultMaxAttemptCommits.
+	Max int
+
+	mu      sync.Mutex
+	order   []string
+	commits map[string][]*Attempt
+}
+
+// Record records the build event, and returns its attempt number, or 0 if
+// the build id isn't known.
+func (a *Attempts) Record(e *BuildEvent) int {
+	if a == nil || e.BuildID == "" {
+		return 0
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.commits == nil {
+		a.commits = make(map[string][]*Attempt)
+	}
+
+	now := time.Now()
+	key := attemptKey(e.Repo, e.Ref)
+	attempts, ok := a.commits[key]
+	if !ok {
+		a.order = append(a.order, key)
+	}
+
+	for _, attempt := range attempts {
+		if attempt.BuildID == e.BuildID {
+			attempt.State, attempt.Updated = e.State, now
+			return attempt.Number
+		}
+	}
+
+	attempt := &Attempt{Number: len(attempts) + 1, BuildID: e.BuildID, State: e.State, Started: now, Updated: now}
+	a.commits[key] = append(attempts, attempt)
+
+	max := a.Max
+	if max == 0 {
+		max = DefaultMaxAttemptCommits
+	}
+	for len(a.order) > max {
+		delete(a.commits, a.order[0])
+		a.order = a.order[1:]
+	}
+
+	return attempt.Number
+}
+
+// History returns a copy of the attempts to build the ref of the repo.
+func (a *Attempts) History(repo, ref string) []*Attempt {
+	if a == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var history []*Attempt
+	for _, attempt := range a.commits[attemptKey(repo, ref)] {
+		copy := *attempt
+		history = append(history, &copy)
+	}
+	return history
+}
+
+func attemptKey(repo, ref string) string {
+	return repo + "@" + ref
+}
+
+// AttemptsHandler is an http.Handler that returns the build attempts for a
+// commit.
+type AttemptsHandler struct {
+	*Quayd
+}
+
+func (h *AttemptsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	history := h.Attempts.History(vars["namespace"]+"/"+vars["name"], vars["ref"])
+	if history == nil {
+		history = []*Attempt{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
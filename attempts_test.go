@@ -0,0 +1,77 @@
+package quayd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAttempts(t *testing.T) {
+	a := &Attempts{}
+
+	tests := []struct {
+		buildID string
+		state   string
+		want    int
+	}{
+		{"1", "pending", 1},
+		{"1", "failure", 1},
+		{"2", "pending", 2},
+		{"2", "success", 2},
+	}
+
+	for _, tt := range tests {
+		if got := a.Record(&BuildEvent{Repo: "remind101/acme-inc", Ref: "abcd", BuildID: tt.buildID, State: tt.state}); got != tt.want {
+			t.Fatalf("Attempt => %d; want %d", got, tt.want)
+		}
+	}
+
+	history := a.History("remind101/acme-inc", "abcd")
+	if got, want := len(history), 2; got != want {
+		t.Fatalf("Attempts => %d; want %d", got, want)
+	}
+
+	if history[0].State != "failure" || history[1].State != "success" {
+		t.Fatalf("Unexpected history: %+v %+v", history[0], history[1])
+	}
+}
+
+func TestQuayd_RetriedBuild(t *testing.T) {
+	r := &statusesRepository{}
+	q := &Quayd{StatusesRepository: r, Attempts: &Attempts{}}
+
+	for _, buildID := range []string{"1", "2"} {
+		if err := q.Handle(context.Background(), &BuildEvent{Repo: "remind101/acme-inc", Ref: "abcd", BuildID: buildID, State: "pending"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	st := r.statuses[1]
+	if got, want := st.Attempt, 2; got != want {
+		t.Fatalf("Attempt => %d; want %d", got, want)
+	}
+
+	if got, want := st.Description, "The Docker image is building (attempt 2)"; got != want {
+		t.Fatalf("Description => %s; want %s", got, want)
+	}
+}
+
+func TestAttemptsHandler(t *testing.T) {
+	a := &Attempts{}
+	a.Record(&BuildEvent{Repo: "remind101/acme-inc", Ref: "abcd", BuildID: "1", State: "failure"})
+	s := NewServer(&Quayd{AdminToken: testAdminToken, Attempts: a})
+
+	resp := httptest.NewRecorder()
+	req, _ := newAdminRequest("GET", "/admin/attempts/remind101/acme-inc/abcd", nil)
+	s.ServeHTTP(resp, req)
+
+	var history []*Attempt
+	if err := json.NewDecoder(resp.Body).Decode(&history); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(history) != 1 || history[0].BuildID != "1" {
+		t.Fatalf("Unexpected history: %v", history)
+	}
+}
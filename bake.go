@@ -0,0 +1,443 @@
+package quayd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BakeContext is the commit status context of auto-promotion announcements.
+const BakeContext = "quayd/auto-promote"
+
+// BakeRule automatically promotes an image from one tag to another once it
+// has baked in the first, e.g. to production 24 hours after it was promoted
+// to staging.
+type BakeRule struct {
+	// Repo is a pattern, as used by path.Match, for the Quay repos that
+	// the rule applies to. Defaults to every repo.
+	Repo string
+
+	// From is the tag that starts the bake, and To is the tag that the
+	// image is promoted to when it's done.
+	From, To string
+
+	// After is how long the image bakes for.
+	After time.Duration
+
+	// Notice is how long before the promotion it's announced. Zero
+	// announces it when the bake starts.
+	Notice time.Duration
+}
+
+// Matches reports whether the rule applies to promotions of repo to tag.
+func (r *BakeRule) Matches(repo, tag string) bool {
+	if r.From != tag {
+		return false
+	}
+	if r.Repo == "" {
+		return true
+	}
+
+	ok, _ := path.Match(r.Repo, repo)
+	return ok
+}
+
+// ParseBakeRules parses comma separated bake rules like
+// `staging=production:24h`, optionally limited to some repos, like
+// `remind101/*:staging=production:24h`.
+func ParseBakeRules(spec string) ([]*BakeRule, error) {
+	var rules []*BakeRule
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		parts := strings.Split(s, ":")
+		r := &BakeRule{}
+		switch len(parts) {
+		case 2:
+		case 3:
+			r.Repo, parts = parts[0], parts[1:]
+		default:
+			return nil, fmt.Errorf("invalid bake rule %q: expected [repo:]from=to:duration", s)
+		}
+
+		tags := strings.SplitN(parts[0], "=", 2)
+		if len(tags) != 2 || tags[0] == "" || tags[1] == "" {
+			return nil, fmt.Errorf("invalid bake rule %q: expected [repo:]from=to:duration", s)
+		}
+		r.From, r.To = tags[0], tags[1]
+
+		after, err := time.ParseDuration(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid bake rule %q: %v", s, err)
+		}
+		r.After = after
+
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// Bake is an image that's baking before it's automatically promoted.
+type Bake struct {
+	Repo      string    `json:"repo"`
+	Sha       string    `json:"sha"`
+	ImageID   string    `json:"image_id"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	StartedAt time.Time `json:"started_at"`
+	DueAt     time.Time `json:"due_at"`
+	Announced bool      `json:"announced"`
+
+	// AnnounceAt is when the promotion is announced.
+	AnnounceAt time.Time `json:"announce_at"`
+
+	// Attempts is the number of failed attempts to promote the image.
+	// Failed promotions are retried at the next check.
+	Attempts  int    `json:"attempts,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// errBakeQuarantined is returned when the image of a bake is quarantined,
+// which cancels the bake rather than retrying it.
+type errBakeQuarantined struct {
+	bake *Bake
+}
+
+func (e *errBakeQuarantined) Error() string {
+	return fmt.Sprintf("%s@%s is quarantined", e.bake.Repo, e.bake.ImageID)
+}
+
+// AutoPromotions automatically promotes images that have baked in a tag for
+// long enough, as long as no failed build of their commit and no quarantine
+// was recorded in the meantime. Only the latest image promoted to a tag bakes,
+// so promoting a newer image restarts the clock. Bakes are checked by
+// RunAutoPromotions. A nil *AutoPromotions promotes nothing.
+type AutoPromotions struct {
+	Rules []*BakeRule
+
+	// Path, if set, is the file that bakes are saved to, so that they
+	// survive restarts. See Load and Save.
+	Path string
+
+	mu    sync.Mutex
+	bakes map[string]*Bake
+}
+
+// Load restores the bakes saved to Path. A missing file isn't an error.
+func (a *AutoPromotions) Load() error {
+	if a == nil || a.Path == "" {
+		return nil
+	}
+
+	raw, err := ioutil.ReadFile(a.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var bakes []*Bake
+	if err := json.Unmarshal(raw, &bakes); err != nil {
+		return fmt.Errorf("%s: %v", a.Path, err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.bakes = make(map[string]*Bake)
+	for _, b := range bakes {
+		a.bakes[b.Repo+"@"+b.To] = b
+	}
+	return nil
+}
+
+// Save writes the bakes to Path.
+func (a *AutoPromotions) Save() error {
+	if a == nil || a.Path == "" {
+		return nil
+	}
+
+	raw, err := json.Marshal(a.List())
+	if err != nil {
+		return err
+	}
+
+	tmp := a.Path + ".tmp"
+	if err := ioutil.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.Path)
+}
+
+// Start starts a bake of the image for each rule that applies to promotions
+// of repo to tag, replacing the bakes of earlier images.
+func (a *AutoPromotions) Start(repo, sha, imageID, tag string, now time.Time) []*Bake {
+	if a == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var started []*Bake
+	for _, r := range a.Rules {
+		if !r.Matches(repo, tag) {
+			continue
+		}
+
+		b := &Bake{
+			Repo:      repo,
+			Sha:       sha,
+			ImageID:   imageID,
+			From:      r.From,
+			To:        r.To,
+			StartedAt: now,
+			DueAt:     now.Add(r.After),
+		}
+		b.AnnounceAt = now
+		if r.Notice > 0 && r.Notice < r.After {
+			b.AnnounceAt = b.DueAt.Add(-r.Notice)
+		}
+
+		if a.bakes == nil {
+			a.bakes = make(map[string]*Bake)
+		}
+		a.bakes[repo+"@"+r.To] = b
+		started = append(started, b)
+	}
+	return started
+}
+
+// Fail cancels the bakes of images built from sha (or a prefix of it), and
+// returns them.
+func (a *AutoPromotions) Fail(repo, sha string) []*Bake {
+	if a == nil || sha == "" {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var cancelled []*Bake
+	for key, b := range a.bakes {
+		if b.Repo == repo && strings.HasPrefix(b.Sha, sha) {
+			delete(a.bakes, key)
+			cancelled = append(cancelled, b)
+		}
+	}
+	return cancelled
+}
+
+// List returns the images that are baking, soonest first.
+func (a *AutoPromotions) List() []*Bake {
+	bakes := []*Bake{}
+	if a == nil {
+		return bakes
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, b := range a.bakes {
+		c := *b
+		bakes = append(bakes, &c)
+	}
+	sort.Slice(bakes, func(i, j int) bool { return bakes[i].DueAt.Before(bakes[j].DueAt) })
+	return bakes
+}
+
+// due returns the bakes that should be announced, and the bakes that are done.
+// Bakes that are done are removed by finish once they're promoted.
+func (a *AutoPromotions) due(now time.Time) (announce, done []*Bake) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var keys []string
+	for key := range a.bakes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		b := a.bakes[key]
+		if !now.Before(b.DueAt) {
+			done = append(done, b)
+			continue
+		}
+		if !b.Announced && !now.Before(b.AnnounceAt) {
+			b.Announced = true
+			announce = append(announce, b)
+		}
+	}
+	return announce, done
+}
+
+// finish removes a bake that's done, unless it was replaced in the meantime.
+func (a *AutoPromotions) finish(b *Bake) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key := b.Repo + "@" + b.To
+	if a.bakes[key] == b {
+		delete(a.bakes, key)
+	}
+}
+
+// retry records a failed attempt to promote a bake that's done, and returns
+// the number of attempts.
+func (a *AutoPromotions) retry(b *Bake, err error) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	b.Attempts++
+	b.LastError = err.Error()
+	return b.Attempts
+}
+
+// RunAutoPromotions checks the bakes every interval until stop is closed.
+// The Quayd is looked up on every check, so that reloads are picked up.
+func RunAutoPromotions(quayd func() *Quayd, interval time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			quayd().CheckAutoPromotions(context.Background(), time.Now())
+		case <-stop:
+			return
+		}
+	}
+}
+
+// CheckAutoPromotions announces upcoming promotions, and promotes the images
+// that are done baking. Promotions that fail are retried at the next check,
+// unless the image was quarantined.
+func (q *Quayd) CheckAutoPromotions(ctx context.Context, now time.Time) {
+	if q.AutoPromotions == nil {
+		return
+	}
+
+	// Bakes are left for a writable instance to promote.
+	if q.ReadOnly {
+		return
+	}
+
+	announce, done := q.AutoPromotions.due(now)
+	for _, b := range announce {
+		q.announceBake(ctx, b, "pending", fmt.Sprintf("Promoting to %s at %s", b.To, b.DueAt.UTC().Format("2006-01-02 15:04 MST")))
+	}
+
+	for _, b := range done {
+		err := q.autoPromote(ctx, b)
+		switch err := err.(type) {
+		case nil:
+			q.AutoPromotions.finish(b)
+			q.announceBake(ctx, b, "success", fmt.Sprintf("Promoted to %s after %s in %s", b.To, b.DueAt.Sub(b.StartedAt), b.From))
+		case *PromotionPendingError:
+			q.AutoPromotions.finish(b)
+			q.announceBake(ctx, b, "pending", fmt.Sprintf("Promotion to %s awaits approval: %s", b.To, err.Promotion.URL))
+		case *errBakeQuarantined:
+			q.AutoPromotions.finish(b)
+			q.logger().Log(ctx, "auto-promotion cancelled", "repo", b.Repo, "image", b.ImageID, "tag", b.To, "error", err)
+			q.announceBake(ctx, b, "error", truncateDescription(fmt.Sprintf("Promotion to %s failed: %s", b.To, err)))
+		default:
+			attempts := q.AutoPromotions.retry(b, err)
+			q.logger().Log(ctx, "auto-promotion failed", "repo", b.Repo, "image", b.ImageID, "tag", b.To, "attempts", attempts, "error", err)
+			// Only the first failure is announced, since it's retried
+			// at every check.
+			if attempts == 1 {
+				q.announceBake(ctx, b, "error", truncateDescription(fmt.Sprintf("Promotion to %s failed, retrying: %s", b.To, err)))
+			}
+		}
+	}
+	q.saveBakes(ctx)
+}
+
+// saveBakes saves the bakes, logging failures, since they're kept in memory
+// either way.
+func (q *Quayd) saveBakes(ctx context.Context) {
+	if err := q.AutoPromotions.Save(); err != nil {
+		q.logger().Log(ctx, "saving bakes failed", "error", err)
+	}
+}
+
+// autoPromote tags the image of a bake that's done, or requests approval if
+// the tag requires it.
+func (q *Quayd) autoPromote(ctx context.Context, b *Bake) error {
+	q = q.forTenant(b.Repo)
+
+	if q.Quarantines.Quarantined(b.Repo, b.ImageID) {
+		return &errBakeQuarantined{bake: b}
+	}
+
+	if q.Approvals.Required(b.To) {
+		p := &Promotion{Repo: b.Repo, Ref: b.Sha, Sha: b.Sha, ImageID: b.ImageID, Tag: b.To, RequestedAt: time.Now()}
+		if err := q.requestApproval(ctx, p); err != nil {
+			return err
+		}
+		return &PromotionPendingError{Promotion: p}
+	}
+
+	if err := q.tagger().Tag(ctx, b.Repo, b.ImageID, b.To); err != nil {
+		return err
+	}
+	q.logger().Log(ctx, "image auto-promoted", "repo", b.Repo, "image", b.ImageID, "tag", b.To)
+	q.bake(ctx, b.Repo, b.Sha, b.ImageID, b.To)
+	return nil
+}
+
+// bake starts baking an image that was promoted to tag.
+func (q *Quayd) bake(ctx context.Context, repo, sha, imageID, tag string) {
+	started := q.AutoPromotions.Start(repo, sha, imageID, tag, time.Now())
+	for _, b := range started {
+		q.logger().Log(ctx, "image baking", "repo", repo, "image", imageID, "tag", b.To, "due", b.DueAt)
+	}
+	if len(started) > 0 {
+		q.saveBakes(ctx)
+	}
+}
+
+// bakeFailed cancels the bakes of images built from the commit of a failed
+// build.
+func (q *Quayd) bakeFailed(ctx context.Context, e *BuildEvent) {
+	if q.AutoPromotions == nil {
+		return
+	}
+
+	m, err := q.mapState(e.State)
+	if err != nil || (m.State != "failure" && m.State != "error") {
+		return
+	}
+
+	cancelled := q.AutoPromotions.Fail(e.Repo, e.Ref)
+	for _, b := range cancelled {
+		q.announceBake(ctx, b, "error", fmt.Sprintf("Promotion to %s cancelled: a build of this commit failed", b.To))
+	}
+	if len(cancelled) > 0 {
+		q.saveBakes(ctx)
+	}
+}
+
+// announceBake announces a bake through the Notifiers.
+func (q *Quayd) announceBake(ctx context.Context, b *Bake, state, description string) {
+	e := &BuildEvent{Repo: b.Repo, Ref: b.Sha, State: state, Context: BakeContext}
+	q.notify(ctx, e, &Status{
+		Repo:        b.Repo,
+		Ref:         b.Sha,
+		State:       state,
+		Description: description,
+		Context:     BakeContext,
+	}, q.Notifiers)
+}
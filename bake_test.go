@@ -0,0 +1,209 @@
+package quayd
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseBakeRules(t *testing.T) {
+	rules, err := ParseBakeRules("staging=production:24h, remind101/*:canary=production:30m")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(rules), 2; got != want {
+		t.Fatalf("Rules => %d; want %d", got, want)
+	}
+	if r := rules[0]; r.Repo != "" || r.From != "staging" || r.To != "production" || r.After != 24*time.Hour {
+		t.Fatalf("Rule => %+v", r)
+	}
+	if r := rules[1]; r.Repo != "remind101/*" || r.From != "canary" || r.After != 30*time.Minute {
+		t.Fatalf("Rule => %+v", r)
+	}
+
+	for _, spec := range []string{"staging:24h", "staging=production:forever", "a:b:c=d:1h"} {
+		if _, err := ParseBakeRules(spec); err == nil {
+			t.Fatalf("Expected %q to be invalid", spec)
+		}
+	}
+}
+
+func newBakeQuayd() (*Quayd, *MemoryRegistry, *notifier) {
+	registry := &MemoryRegistry{}
+	registry.Seed("remind101/acme-inc", "long-f1fb3b0")
+	n := &notifier{}
+	q := &Quayd{
+		StatusesRepository: &statusesRepository{},
+		Tagger:             registry,
+		TagResolver:        registry,
+		Quarantines:        &Quarantines{},
+		Notifiers:          []Notifier{n},
+		AutoPromotions: &AutoPromotions{Rules: []*BakeRule{
+			{From: "staging", To: "production", After: 24 * time.Hour, Notice: time.Hour},
+		}},
+	}
+	return q, registry, n
+}
+
+func TestAutoPromotions(t *testing.T) {
+	q, registry, n := newBakeQuayd()
+	ctx := context.Background()
+
+	if _, err := q.Promote(ctx, "remind101/acme-inc", "f1fb3b0", "staging"); err != nil {
+		t.Fatal(err)
+	}
+
+	bakes := q.AutoPromotions.List()
+	if got, want := len(bakes), 1; got != want {
+		t.Fatalf("Bakes => %d; want %d", got, want)
+	}
+	due := bakes[0].DueAt
+
+	q.CheckAutoPromotions(ctx, due.Add(-2*time.Hour))
+	if got, want := len(n.statuses), 0; got != want {
+		t.Fatalf("Announcements => %d; want %d", got, want)
+	}
+
+	q.CheckAutoPromotions(ctx, due.Add(-time.Hour))
+	if got, want := len(n.statuses), 1; got != want {
+		t.Fatalf("Announcements => %d; want %d", got, want)
+	}
+	if s := n.statuses[0]; s.State != "pending" || s.Context != BakeContext || !strings.HasPrefix(s.Description, "Promoting to production at") {
+		t.Fatalf("Announcement => %+v", s)
+	}
+
+	if production, _ := registry.Resolve(ctx, "remind101/acme-inc", "production"); production != "" {
+		t.Fatal("Expected the image not to be promoted before it's done baking")
+	}
+
+	q.CheckAutoPromotions(ctx, due)
+	built, _ := registry.Resolve(ctx, "remind101/acme-inc", "long-f1fb3b0")
+	if production, _ := registry.Resolve(ctx, "remind101/acme-inc", "production"); production != built {
+		t.Fatal("Expected the image to be promoted to production")
+	}
+	if got, want := len(n.statuses), 2; got != want {
+		t.Fatalf("Announcements => %d; want %d", got, want)
+	}
+	if s := n.statuses[1]; s.State != "success" || s.Description != "Promoted to production after 24h0m0s in staging" {
+		t.Fatalf("Announcement => %+v", s)
+	}
+
+	if bakes := q.AutoPromotions.List(); len(bakes) != 0 {
+		t.Fatalf("Bakes => %v; want none", bakes)
+	}
+}
+
+func TestAutoPromotions_Failure(t *testing.T) {
+	q, registry, n := newBakeQuayd()
+	ctx := context.Background()
+
+	if _, err := q.Promote(ctx, "remind101/acme-inc", "f1fb3b0", "staging"); err != nil {
+		t.Fatal(err)
+	}
+	due := q.AutoPromotions.List()[0].DueAt
+
+	q.Handle(ctx, &BuildEvent{Repo: "remind101/acme-inc", Ref: "long-f1fb3b0", State: "failure"})
+
+	q.CheckAutoPromotions(ctx, due)
+	if production, _ := registry.Resolve(ctx, "remind101/acme-inc", "production"); production != "" {
+		t.Fatal("Expected a failed build to cancel the promotion")
+	}
+
+	var cancelled bool
+	for _, s := range n.statuses {
+		cancelled = cancelled || (s.Context == BakeContext && strings.Contains(s.Description, "cancelled"))
+	}
+	if !cancelled {
+		t.Fatal("Expected the cancellation to be announced")
+	}
+}
+
+func TestAutoPromotions_Retry(t *testing.T) {
+	q, _, n := newBakeQuayd()
+	ctx := context.Background()
+
+	if _, err := q.Promote(ctx, "remind101/acme-inc", "f1fb3b0", "staging"); err != nil {
+		t.Fatal(err)
+	}
+	due := q.AutoPromotions.List()[0].DueAt
+
+	tagger := &flakyTagger{err: errors.New("registry unavailable"), n: 2}
+	q.Tagger = tagger
+	q.CheckAutoPromotions(ctx, due)
+
+	bakes := q.AutoPromotions.List()
+	if len(bakes) != 1 || bakes[0].Attempts != 1 || bakes[0].LastError != "registry unavailable" {
+		t.Fatalf("Bakes => %+v; want the failed bake to be kept", bakes)
+	}
+	if s := n.statuses[len(n.statuses)-1]; s.State != "error" {
+		t.Fatalf("Announcement => %+v; want an error", s)
+	}
+
+	q.CheckAutoPromotions(ctx, due.Add(time.Minute))
+	if got, want := tagger.calls, 2; got != want {
+		t.Fatalf("Tag calls => %d; want %d", got, want)
+	}
+	if bakes := q.AutoPromotions.List(); len(bakes) != 0 {
+		t.Fatalf("Bakes => %v; want none", bakes)
+	}
+	if s := n.statuses[len(n.statuses)-1]; s.State != "success" {
+		t.Fatalf("Announcement => %+v; want a success", s)
+	}
+}
+
+func TestAutoPromotions_Save(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bakes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bakes.json")
+
+	q, _, _ := newBakeQuayd()
+	q.AutoPromotions.Path = path
+	if _, err := q.Promote(context.Background(), "remind101/acme-inc", "f1fb3b0", "staging"); err != nil {
+		t.Fatal(err)
+	}
+
+	a := &AutoPromotions{Path: path}
+	if err := a.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := a.List(), q.AutoPromotions.List(); len(got) != 1 || !got[0].DueAt.Equal(want[0].DueAt) || got[0].ImageID != want[0].ImageID {
+		t.Fatalf("Loaded => %+v; want %+v", got, want)
+	}
+
+	if err := (&AutoPromotions{Path: filepath.Join(dir, "missing.json")}).Load(); err != nil {
+		t.Fatalf("Expected a missing file to be ignored, got %v", err)
+	}
+}
+
+func TestAutoPromotions_Quarantined(t *testing.T) {
+	q, registry, n := newBakeQuayd()
+	ctx := context.Background()
+
+	image, err := q.Promote(ctx, "remind101/acme-inc", "f1fb3b0", "staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	due := q.AutoPromotions.List()[0].DueAt
+
+	q.Quarantines.add(&QuarantinedImage{Repo: "remind101/acme-inc", Digest: image.ID})
+
+	q.CheckAutoPromotions(ctx, due)
+	if production, _ := registry.Resolve(ctx, "remind101/acme-inc", "production"); production != "" {
+		t.Fatal("Expected a quarantined image not to be promoted")
+	}
+	if s := n.statuses[len(n.statuses)-1]; s.State != "error" {
+		t.Fatalf("Announcement => %+v; want an error", s)
+	}
+	if bakes := q.AutoPromotions.List(); len(bakes) != 0 {
+		t.Fatalf("Bakes => %v; want the quarantined bake to be cancelled", bakes)
+	}
+}
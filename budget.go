@@ -0,0 +1,52 @@
+package quayd
+
+import (
+	"context"
+	"time"
+)
+
+// The optional steps of handling a build event, which are skipped when the
+// event is over its latency budget.
+const (
+	StepDirectives   = "directives"
+	StepPullAccess   = "pull-access"
+	StepNotify       = "notify"
+	StepDeploy       = "deploy"
+	StepGitOps       = "gitops"
+	StepReleaseAsset = "release-asset"
+)
+
+// latencyBudget tracks how much of Quayd.LatencyBudget a build event has
+// used. A nil *latencyBudget never skips anything.
+type latencyBudget struct {
+	q        *Quayd
+	e        *BuildEvent
+	deadline time.Time
+}
+
+// budget returns the latency budget of the event, which starts when the
+// webhook was received, or now if that isn't known.
+func (q *Quayd) budget(e *BuildEvent) *latencyBudget {
+	if q.LatencyBudget <= 0 {
+		return nil
+	}
+
+	start := e.ReceivedAt
+	if start.IsZero() {
+		start = time.Now()
+	}
+	return &latencyBudget{q: q, e: e, deadline: start.Add(q.LatencyBudget)}
+}
+
+// skip returns true if the optional step should be skipped because the
+// budget has been used up, and records the skipped step on the event.
+func (b *latencyBudget) skip(ctx context.Context, step string) bool {
+	if b == nil || time.Now().Before(b.deadline) {
+		return false
+	}
+
+	b.e.Skipped = append(b.e.Skipped, step)
+	b.q.Timelines.Record(b.e.ID, "skipped-"+step, time.Now(), nil)
+	b.q.logger().Log(ctx, "step skipped (over latency budget)", "repo", b.e.Repo, "ref", b.e.Ref, "step", step)
+	return true
+}
@@ -0,0 +1,45 @@
+package quayd
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHandle_LatencyBudget(t *testing.T) {
+	r := &statusesRepository{}
+	n := &notifier{}
+	q := &Quayd{
+		StatusesRepository: r,
+		Notifiers:          []Notifier{n},
+		Directives:         &Directives{Messages: commitMessages{"abcd": "Update README [skip image]"}},
+		LatencyBudget:      time.Second,
+	}
+	ctx := context.Background()
+
+	// Within the budget, every step runs.
+	e := &BuildEvent{Repo: "remind101/acme-inc", Ref: "efgh", State: "failure", ReceivedAt: time.Now()}
+	if err := q.Handle(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+	if len(n.statuses) != 1 || len(e.Skipped) != 0 {
+		t.Fatalf("Expected a notification and no skipped steps, got %v", e.Skipped)
+	}
+
+	// Over the budget, the status is still posted, but the optional steps
+	// are skipped.
+	e = &BuildEvent{Repo: "remind101/acme-inc", Ref: "abcd", State: "failure", ReceivedAt: time.Now().Add(-time.Minute)}
+	if err := q.Handle(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(r.statuses), 2; got != want {
+		t.Fatalf("Statuses => %d; want %d", got, want)
+	}
+	if got, want := len(n.statuses), 1; got != want {
+		t.Fatalf("Notifications => %d; want %d", got, want)
+	}
+	if got, want := e.Skipped, []string{StepDirectives, StepNotify}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Skipped => %v; want %v", got, want)
+	}
+}
@@ -0,0 +1,42 @@
+package quayd
+
+import (
+	"context"
+	"net/url"
+)
+
+// DefaultBuildURLResolver is the BuildURLResolver used when a Quayd instance
+// doesn't have one.
+var DefaultBuildURLResolver BuildURLResolver = &QuayBuildURLResolver{}
+
+// BuildURLResolver resolves the URL of the page for a build, which is used as
+// the TargetURL of its commit status.
+type BuildURLResolver interface {
+	Resolve(ctx context.Context, e *BuildEvent) (string, error)
+}
+
+// QuayBuildURLResolver is a BuildURLResolver that links to the build logs on
+// Quay. Builds without a build id link to the URL from the webhook.
+type QuayBuildURLResolver struct {
+	// Host is the Quay host. Defaults to DefaultRegistry.
+	Host string
+}
+
+// Resolve implements BuildURLResolver Resolve.
+func (r *QuayBuildURLResolver) Resolve(ctx context.Context, e *BuildEvent) (string, error) {
+	if e.BuildID == "" || (e.Registry != "" && e.Registry != DefaultRegistry) {
+		return e.URL, nil
+	}
+
+	host := r.Host
+	if host == "" {
+		host = DefaultRegistry
+	}
+
+	u := &url.URL{
+		Scheme: "https",
+		Host:   host,
+		Path:   "/repository/" + e.Repo + "/build/" + e.BuildID,
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,30 @@
+package quayd
+
+import (
+	"context"
+	"testing"
+)
+
+func TestQuayBuildURLResolver(t *testing.T) {
+	r := &QuayBuildURLResolver{}
+
+	tests := []struct {
+		event *BuildEvent
+		want  string
+	}{
+		{&BuildEvent{Repo: "remind101/acme-inc", BuildID: "1234", URL: "https://quay.io/repository/remind101/acme-inc"}, "https://quay.io/repository/remind101/acme-inc/build/1234"},
+		{&BuildEvent{Repo: "remind101/acme-inc", URL: "https://quay.io/repository/remind101/acme-inc"}, "https://quay.io/repository/remind101/acme-inc"},
+		{&BuildEvent{Repo: "remind101/acme-inc", BuildID: "1234", Registry: DockerHubRegistry, URL: "https://hub.docker.com/r/remind101/acme-inc"}, "https://hub.docker.com/r/remind101/acme-inc"},
+	}
+
+	for _, tt := range tests {
+		got, err := r.Resolve(context.Background(), tt.event)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != tt.want {
+			t.Fatalf("Resolve => %s; want %s", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package quayd
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// BundleConfigFile is the name of the config file within a bundle.
+const BundleConfigFile = "quayd.json"
+
+var (
+	// ErrInvalidSignature is returned when a bundle's signature doesn't
+	// verify against the public key.
+	ErrInvalidSignature = errors.New("config bundle signature is invalid")
+
+	// ErrNoBundleConfig is returned when a bundle doesn't contain a
+	// config file.
+	ErrNoBundleConfig = errors.New("config bundle does not contain " + BundleConfigFile)
+)
+
+// LoadBundle verifies that sig is a valid ed25519 signature of the tar
+// archive read from r, using the public key, then decodes the Config from the
+// quayd.json file within the archive. This allows fleets of quayd instances to
+// pull trusted configuration from untrusted storage.
+func LoadBundle(r io.Reader, sig []byte, key ed25519.PublicKey) (*Config, error) {
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifySignature(raw, sig, key); err != nil {
+		return nil, err
+	}
+
+	tr := tar.NewReader(bytes.NewReader(raw))
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil, ErrNoBundleConfig
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if h.Name == BundleConfigFile {
+			return DecodeConfig(tr)
+		}
+	}
+}
+
+// verifySignature returns ErrInvalidSignature unless sig is a valid ed25519
+// signature of raw, using the public key.
+func verifySignature(raw, sig []byte, key ed25519.PublicKey) error {
+	if len(key) != ed25519.PublicKeySize || !ed25519.Verify(key, raw, sig) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package quayd
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/ed25519"
+	"testing"
+)
+
+func TestLoadBundle(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	bundle := newBundle(t, map[string]string{
+		BundleConfigFile: `{"github_token":"1234","all_commits":true}`,
+	})
+
+	c, err := LoadBundle(bytes.NewReader(bundle), ed25519.Sign(priv, bundle), pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := c.GitHubToken, "1234"; got != want {
+		t.Fatalf("GitHubToken => %s; want %s", got, want)
+	}
+
+	if !c.AllCommits {
+		t.Fatal("Expected AllCommits to be true")
+	}
+}
+
+func TestLoadBundle_InvalidSignature(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	bundle := newBundle(t, map[string]string{BundleConfigFile: `{}`})
+	sig := ed25519.Sign(priv, bundle)
+
+	tampered := newBundle(t, map[string]string{BundleConfigFile: `{"github_token":"evil"}`})
+	if _, err := LoadBundle(bytes.NewReader(tampered), sig, pub); err != ErrInvalidSignature {
+		t.Fatalf("err => %v; want %v", err, ErrInvalidSignature)
+	}
+}
+
+func TestLoadBundle_NoConfig(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	bundle := newBundle(t, map[string]string{"README": "hello"})
+
+	if _, err := LoadBundle(bytes.NewReader(bundle), ed25519.Sign(priv, bundle), pub); err != ErrNoBundleConfig {
+		t.Fatalf("err => %v; want %v", err, ErrNoBundleConfig)
+	}
+}
+
+func newBundle(t testing.TB, files map[string]string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, body := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(body))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(body))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
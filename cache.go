@@ -0,0 +1,367 @@
+package quayd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache is an interface for caching lookups that are expensive, like GitHub
+// API reads.
+type Cache interface {
+	// Get returns the cached value for key, and whether it was found.
+	Get(key string) (string, bool, error)
+
+	// Set caches value for key, expiring it after ttl.
+	Set(key, value string, ttl time.Duration) error
+
+	// Add caches value for key, expiring it after ttl, only if key isn't
+	// already cached. It returns whether the value was added.
+	Add(key, value string, ttl time.Duration) (bool, error)
+
+	// Delete removes key from the cache.
+	Delete(key string) error
+}
+
+// shaPrefix matches refs that look like (short) git shas.
+var shaPrefix = regexp.MustCompile(`^[0-9a-f]{4,40}$`)
+
+// CachedCommitResolver is a CommitResolver that caches the resolved shas in a
+// Cache. Only refs that look like shas, and resolve to a sha that they're a
+// prefix of, are cached, since branches and tags move.
+type CachedCommitResolver struct {
+	CommitResolver
+	Cache Cache
+
+	// TTL is how long resolved shas are cached for. Since a short sha can
+	// only become ambiguous, never point somewhere else, this can be long.
+	TTL time.Duration
+}
+
+// Resolve implements CommitResolver Resolve.
+func (cr *CachedCommitResolver) Resolve(ctx context.Context, repo, short string) (string, error) {
+	if !shaPrefix.MatchString(short) {
+		return cr.CommitResolver.Resolve(ctx, repo, short)
+	}
+
+	key := "sha:" + repo + ":" + short
+
+	if sha, ok, err := cr.Cache.Get(key); err == nil && ok {
+		return sha, nil
+	}
+
+	sha, err := cr.CommitResolver.Resolve(ctx, repo, short)
+	if err != nil {
+		return "", err
+	}
+
+	// A branch or tag that happens to look like a sha can move.
+	if !strings.HasPrefix(sha, short) {
+		return sha, nil
+	}
+
+	// A cache failure shouldn't fail the resolution.
+	cr.Cache.Set(key, sha, cr.TTL)
+
+	return sha, nil
+}
+
+// CachedRepoMapper is a RepoMapper that caches the mapped repos in a Cache,
+// for mappers that look repos up remotely. Repos that fail to map aren't
+// cached.
+type CachedRepoMapper struct {
+	RepoMapper
+	Cache Cache
+
+	// TTL is how long mapped repos are cached for.
+	TTL time.Duration
+}
+
+// Map implements RepoMapper Map.
+func (m *CachedRepoMapper) Map(quayRepo string) (string, error) {
+	key := "repo:" + quayRepo
+
+	if githubRepo, ok, err := m.Cache.Get(key); err == nil && ok {
+		return githubRepo, nil
+	}
+
+	githubRepo, err := m.RepoMapper.Map(quayRepo)
+	if err != nil {
+		return "", err
+	}
+
+	// A cache failure shouldn't fail the mapping.
+	m.Cache.Set(key, githubRepo, m.TTL)
+
+	return githubRepo, nil
+}
+
+// MemoryCache is an in memory implementation of the Cache interface.
+type MemoryCache struct {
+	mu      sync.Mutex
+	entries map[string]memoryCacheEntry
+}
+
+type memoryCacheEntry struct {
+	value   string
+	expires time.Time
+}
+
+// Get implements Cache Get.
+func (c *MemoryCache) Get(key string) (string, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return "", false, nil
+	}
+
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		delete(c.entries, key)
+		return "", false, nil
+	}
+
+	return e.value, true, nil
+}
+
+// Set implements Cache Set.
+func (c *MemoryCache) Set(key, value string, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]memoryCacheEntry)
+	}
+
+	e := memoryCacheEntry{value: value}
+	if ttl > 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+	c.entries[key] = e
+
+	return nil
+}
+
+// Add implements Cache Add.
+func (c *MemoryCache) Add(key, value string, ttl time.Duration) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok && (e.expires.IsZero() || time.Now().Before(e.expires)) {
+		return false, nil
+	}
+
+	if c.entries == nil {
+		c.entries = make(map[string]memoryCacheEntry)
+	}
+
+	e := memoryCacheEntry{value: value}
+	if ttl > 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+	c.entries[key] = e
+
+	return true, nil
+}
+
+// Delete implements Cache Delete.
+func (c *MemoryCache) Delete(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, key)
+	return nil
+}
+
+// RedisCache is an implementation of the Cache interface backed by Redis,
+// which allows the cache to be shared between replicas.
+type RedisCache struct {
+	// Addr is the `host:port` of the Redis server.
+	Addr string
+
+	// Password, if set, authenticates the connection.
+	Password string
+
+	// Prefix is prepended to all keys. Defaults to "quayd:".
+	Prefix string
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// Get implements Cache Get.
+func (c *RedisCache) Get(key string) (string, bool, error) {
+	reply, err := c.do("GET", c.prefix()+key)
+	if err != nil {
+		return "", false, err
+	}
+
+	if reply == nil {
+		return "", false, nil
+	}
+
+	return *reply, true, nil
+}
+
+// Set implements Cache Set.
+func (c *RedisCache) Set(key, value string, ttl time.Duration) error {
+	args := []string{"SET", c.prefix() + key, value}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(int64(ttl/time.Millisecond), 10))
+	}
+
+	_, err := c.do(args...)
+	return err
+}
+
+// Add implements Cache Add, with SET NX.
+func (c *RedisCache) Add(key, value string, ttl time.Duration) (bool, error) {
+	args := []string{"SET", c.prefix() + key, value, "NX"}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(int64(ttl/time.Millisecond), 10))
+	}
+
+	reply, err := c.do(args...)
+	if err != nil {
+		return false, err
+	}
+
+	// SET NX replies with a nil bulk string when the key exists.
+	return reply != nil, nil
+}
+
+// Delete implements Cache Delete.
+func (c *RedisCache) Delete(key string) error {
+	_, err := c.do("DEL", c.prefix()+key)
+	return err
+}
+
+// do sends a command to Redis and reads the reply. A nil reply is returned for
+// Redis nil bulk strings.
+func (c *RedisCache) do(args ...string) (*string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		conn, r, err := redisDial(c.Addr, c.Password)
+		if err != nil {
+			return nil, err
+		}
+		c.conn, c.r = conn, r
+	}
+
+	reply, err := c.roundTrip(args)
+	if err != nil {
+		// Don't reuse a connection that may be in a bad state.
+		c.conn.Close()
+		c.conn = nil
+	}
+
+	return reply, err
+}
+
+func (c *RedisCache) roundTrip(args []string) (*string, error) {
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := io.WriteString(c.conn, redisCommand(args)); err != nil {
+		return nil, err
+	}
+
+	return readRedisReply(c.r)
+}
+
+// redisDial connects to the Redis server at addr, authenticating with
+// password if it's set.
+func redisDial(addr, password string) (net.Conn, *bufio.Reader, error) {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := bufio.NewReader(conn)
+
+	if password != "" {
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err = io.WriteString(conn, redisCommand([]string{"AUTH", password})); err == nil {
+			_, err = readRedisReply(r)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+
+	return conn, r, nil
+}
+
+// redisCommand encodes a command in the Redis protocol.
+func redisCommand(args []string) string {
+	cmd := fmt.Sprintf("*%d\r\n", len(args))
+	for _, arg := range args {
+		cmd += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return cmd
+}
+
+// readRedisLine reads a reply line, without the trailing CRLF.
+func readRedisLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 3 {
+		return "", errors.New("redis: malformed reply")
+	}
+	return line[:len(line)-2], nil
+}
+
+// readRedisReply reads a simple, integer, error or bulk string reply. A nil
+// reply is returned for Redis nil bulk strings.
+func readRedisReply(r *bufio.Reader) (*string, error) {
+	line, err := readRedisLine(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch line[0] {
+	case '+', ':':
+		s := line[1:]
+		return &s, nil
+	case '-':
+		return nil, errors.New("redis: " + line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		s := string(buf[:n])
+		return &s, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
+
+func (c *RedisCache) prefix() string {
+	if c.Prefix == "" {
+		return "quayd:"
+	}
+
+	return c.Prefix
+}
@@ -0,0 +1,233 @@
+package quayd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingCommitResolver counts the number of times Resolve is called. Refs
+// resolve to shas, in shas, or to the ref padded out to a full sha.
+type countingCommitResolver struct {
+	shas  map[string]string
+	calls int
+}
+
+func (cr *countingCommitResolver) Resolve(ctx context.Context, repo, short string) (string, error) {
+	cr.calls++
+	if sha, ok := cr.shas[short]; ok {
+		return sha, nil
+	}
+	return short + strings.Repeat("0", 40-len(short)), nil
+}
+
+func TestCachedCommitResolver(t *testing.T) {
+	c := &countingCommitResolver{}
+	cr := &CachedCommitResolver{CommitResolver: c, Cache: &MemoryCache{}, TTL: time.Hour}
+
+	for i := 0; i < 2; i++ {
+		sha, err := cr.Resolve(context.Background(), "ejholmes/docker-statsd", "f1fb3b0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := sha, "f1fb3b0000000000000000000000000000000000"; got != want {
+			t.Fatalf("Sha => %s; want %s", got, want)
+		}
+	}
+
+	if got, want := c.calls, 1; got != want {
+		t.Fatalf("Calls => %d; want %d", got, want)
+	}
+}
+
+func TestCachedCommitResolver_Branches(t *testing.T) {
+	// A branch that looks like a sha isn't cached either.
+	c := &countingCommitResolver{shas: map[string]string{"master": "f1fb3b0000000000000000000000000000000000", "deadbeef": "a5d2c71000000000000000000000000000000000"}}
+	cr := &CachedCommitResolver{CommitResolver: c, Cache: &MemoryCache{}, TTL: time.Hour}
+
+	for _, ref := range []string{"master", "deadbeef"} {
+		for i := 0; i < 2; i++ {
+			if _, err := cr.Resolve(context.Background(), "ejholmes/docker-statsd", ref); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if got, want := c.calls, 4; got != want {
+		t.Fatalf("Calls => %d; want %d", got, want)
+	}
+}
+
+func TestCachedRepoMapper(t *testing.T) {
+	var calls int
+	m := &CachedRepoMapper{
+		RepoMapper: RepoMapperFunc(func(quayRepo string) (string, error) {
+			calls++
+			return "remind101/" + strings.TrimPrefix(quayRepo, "images/"), nil
+		}),
+		Cache: &MemoryCache{},
+		TTL:   time.Hour,
+	}
+
+	for i := 0; i < 2; i++ {
+		githubRepo, err := m.Map("images/acme-inc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := githubRepo, "remind101/acme-inc"; got != want {
+			t.Fatalf("Repo => %s; want %s", got, want)
+		}
+	}
+
+	if got, want := calls, 1; got != want {
+		t.Fatalf("Calls => %d; want %d", got, want)
+	}
+}
+
+func TestMemoryCache_Expires(t *testing.T) {
+	c := &MemoryCache{}
+	c.Set("foo", "bar", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	if _, ok, _ := c.Get("foo"); ok {
+		t.Fatal("Expected the entry to have expired")
+	}
+}
+
+func TestRedisCache(t *testing.T) {
+	l := newFakeRedis(t)
+	defer l.Close()
+
+	c := &RedisCache{Addr: l.Addr().String()}
+
+	if _, ok, err := c.Get("foo"); err != nil || ok {
+		t.Fatalf("Get => %v, %v; want a miss", ok, err)
+	}
+
+	if err := c.Set("foo", "bar", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	v, ok, err := c.Get("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ok || v != "bar" {
+		t.Fatalf("Get => %q, %v; want %q, true", v, ok, "bar")
+	}
+
+	if added, err := c.Add("foo", "baz", time.Minute); err != nil || added {
+		t.Fatalf("Add => %v, %v; want false", added, err)
+	}
+
+	if err := c.Delete("foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	if added, err := c.Add("foo", "baz", time.Minute); err != nil || !added {
+		t.Fatalf("Add => %v, %v; want true", added, err)
+	}
+}
+
+func TestRedisCache_Password(t *testing.T) {
+	l := newFakeRedis(t)
+	defer l.Close()
+
+	if _, _, err := (&RedisCache{Addr: l.Addr().String(), Password: "wrong"}).Get("foo"); err == nil {
+		t.Fatal("Expected a wrong password to fail")
+	}
+
+	if _, _, err := (&RedisCache{Addr: l.Addr().String(), Password: "secret"}).Get("foo"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newFakeRedis starts a server that speaks just enough of the Redis protocol
+// to support GET, SET (with NX), DEL, RPUSH, a non-blocking BLPOP and AUTH,
+// with the password "secret".
+func newFakeRedis(t testing.TB) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	data := make(map[string]string)
+	lists := make(map[string][]string)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+
+					args := make([]string, n)
+					for i := range args {
+						r.ReadString('\n')
+						arg, _ := r.ReadString('\n')
+						args[i] = strings.TrimSpace(arg)
+					}
+
+					mu.Lock()
+					switch args[0] {
+					case "AUTH":
+						if args[1] != "secret" {
+							fmt.Fprint(conn, "-WRONGPASS invalid password\r\n")
+							break
+						}
+						fmt.Fprint(conn, "+OK\r\n")
+					case "RPUSH":
+						lists[args[1]] = append(lists[args[1]], args[2])
+						fmt.Fprintf(conn, ":%d\r\n", len(lists[args[1]]))
+					case "BLPOP":
+						if len(lists[args[1]]) == 0 {
+							fmt.Fprint(conn, "*-1\r\n")
+							break
+						}
+						v := lists[args[1]][0]
+						lists[args[1]] = lists[args[1]][1:]
+						fmt.Fprintf(conn, "*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(args[1]), args[1], len(v), v)
+					case "GET":
+						v, ok := data[args[1]]
+						if !ok {
+							fmt.Fprint(conn, "$-1\r\n")
+							break
+						}
+						fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(v), v)
+					case "SET":
+						if _, ok := data[args[1]]; ok && len(args) > 3 && args[3] == "NX" {
+							fmt.Fprint(conn, "$-1\r\n")
+							break
+						}
+						data[args[1]] = args[2]
+						fmt.Fprint(conn, "+OK\r\n")
+					case "DEL":
+						delete(data, args[1])
+						fmt.Fprint(conn, ":1\r\n")
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+	}()
+
+	return l
+}
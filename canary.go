@@ -0,0 +1,199 @@
+package quayd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// DefaultMaxCanaryReports is the default number of reports that a Canary
+// keeps.
+const DefaultMaxCanaryReports = 100
+
+// Canary runs a fraction of build events through an alternate Quayd
+// configuration in dry-run, and reports how what it would have done differs
+// from the primary configuration. A nil *Canary does nothing.
+type Canary struct {
+	// Quayd is the alternate configuration. It never writes to GitHub or
+	// the registry.
+	Quayd *Quayd
+
+	// Percent is the percentage of build events, from 0 to 100, that are
+	// compared.
+	Percent float64
+
+	// Max is the number of reports to keep. Defaults to
+	// DefaultMaxCanaryReports.
+	Max int
+
+	mu      sync.Mutex
+	reports []*CanaryReport
+}
+
+// CanaryReport compares the actions that the primary and canary
+// configurations would take for a build event.
+type CanaryReport struct {
+	ID           string    `json:"id"`
+	Repo         string    `json:"repo"`
+	Ref          string    `json:"ref"`
+	State        string    `json:"state"`
+	Primary      []string  `json:"primary"`
+	Canary       []string  `json:"canary"`
+	PrimaryError string    `json:"primary_error,omitempty"`
+	CanaryError  string    `json:"canary_error,omitempty"`
+	Match        bool      `json:"match"`
+	ComparedAt   time.Time `json:"compared_at"`
+}
+
+// Observe compares the primary and canary configurations for the build
+// event, if it's sampled.
+func (c *Canary) Observe(ctx context.Context, primary *Quayd, e *BuildEvent) {
+	if c == nil || c.Quayd == nil || rand.Float64()*100 >= c.Percent {
+		return
+	}
+
+	r := &CanaryReport{
+		ID:         e.ID,
+		Repo:       e.Repo,
+		Ref:        e.Ref,
+		State:      e.State,
+		ComparedAt: time.Now(),
+	}
+
+	var err error
+	r.Primary, err = primary.plan(ctx, e)
+	if err != nil {
+		r.PrimaryError = err.Error()
+	}
+	r.Canary, err = c.Quayd.plan(ctx, e)
+	if err != nil {
+		r.CanaryError = err.Error()
+	}
+	r.Match = reflect.DeepEqual(r.Primary, r.Canary) && r.PrimaryError == r.CanaryError
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.reports = append(c.reports, r)
+	if max := c.max(); len(c.reports) > max {
+		c.reports = c.reports[len(c.reports)-max:]
+	}
+}
+
+// Reports returns the most recent reports.
+func (c *Canary) Reports() []*CanaryReport {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]*CanaryReport(nil), c.reports...)
+}
+
+func (c *Canary) max() int {
+	if c.Max == 0 {
+		return DefaultMaxCanaryReports
+	}
+
+	return c.Max
+}
+
+// plan returns the writes that handling the build event would make, without
+// making them. Reads, like resolving commits and tags, are still made. Writes
+// that don't go through the recorder are disabled by ReadOnly, so that new
+// ones don't slip through.
+func (q *Quayd) plan(ctx context.Context, e *BuildEvent) ([]string, error) {
+	r := &recorder{}
+
+	p := *q
+	p.recorder = r
+	p.ReadOnly = true
+	p.AutoPromotions = nil
+	p.Ignored = nil
+	p.Stats = DefaultStats
+	p.Deliveries = nil
+	p.Metrics = nil
+	p.Dependencies = nil
+	p.Timelines = nil
+	p.Costs = nil
+	p.Durations = nil
+	p.FailureIssues = nil
+	p.Canary = nil
+	p.Attempts = nil
+	p.Notifiers = nil
+	p.SemVer = nil
+	p.Environments = nil
+	p.GitOps = nil
+
+	err := p.handle(ctx, e)
+	return r.actions, err
+}
+
+// recorder records writes to GitHub and the registry instead of making them.
+type recorder struct {
+	mu      sync.Mutex
+	actions []string
+}
+
+func (r *recorder) record(format string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.actions = append(r.actions, fmt.Sprintf(format, args...))
+}
+
+// Create implements StatusesRepository Create.
+func (r *recorder) Create(ctx context.Context, status *Status) error {
+	r.record("status %s@%s %s %q %q", status.Repo, status.Ref, status.State, status.Context, status.Description)
+	return nil
+}
+
+// Tag implements Tagger Tag.
+func (r *recorder) Tag(ctx context.Context, repo, imageID, tag string) error {
+	r.record("tag %s@%s %s", repo, imageID, tag)
+	return nil
+}
+
+// Untag implements Untagger Untag.
+func (r *recorder) Untag(ctx context.Context, repo, tag string) error {
+	r.record("untag %s %s", repo, tag)
+	return nil
+}
+
+// TagsApplied implements TagHook TagsApplied.
+func (r *recorder) TagsApplied(ctx context.Context, event *TagEvent) error {
+	r.record("tag hook %s@%s %v", event.Repo, event.Sha, event.Tags)
+	return nil
+}
+
+// CanaryHandler is an http.Handler that returns the canary reports.
+type CanaryHandler struct {
+	*Quayd
+}
+
+func (h *CanaryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reports := h.Canary.Reports()
+
+	var mismatches int
+	for _, report := range reports {
+		if !report.Match {
+			mismatches++
+		}
+	}
+	if reports == nil {
+		reports = []*CanaryReport{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"compared":   len(reports),
+		"mismatches": mismatches,
+		"reports":    reports,
+	})
+}
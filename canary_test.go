@@ -0,0 +1,121 @@
+package quayd
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// releaseAssetService is a fake ReleaseAssetService that counts uploads.
+type releaseAssetService struct {
+	uploads int
+}
+
+func (s *releaseAssetService) Upload(ctx context.Context, repo, tag, name string, content []byte) (bool, error) {
+	s.uploads++
+	return true, nil
+}
+
+func TestCanary(t *testing.T) {
+	registry := &MemoryRegistry{}
+	registry.Seed("remind101/acme-inc", "test")
+
+	r := &statusesRepository{}
+	canary := &Canary{
+		Quayd: &Quayd{
+			Tagger:      registry,
+			TagResolver: registry,
+			Routes:      Routes{"": &Route{Context: "Docker Image (canary)"}},
+		},
+		Percent: 100,
+	}
+	q := &Quayd{StatusesRepository: r, Tagger: registry, TagResolver: registry, Canary: canary}
+
+	if err := q.Handle(context.Background(), &BuildEvent{ID: "1", Repo: "remind101/acme-inc", Ref: "f1fb3b0", State: "success", Tags: []string{"test"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(r.statuses), 1; got != want {
+		t.Fatalf("Statuses => %d; want %d", got, want)
+	}
+
+	reports := canary.Reports()
+	if len(reports) != 1 {
+		t.Fatalf("Expected 1 report, got %d", len(reports))
+	}
+
+	report := reports[0]
+	if report.Match {
+		t.Fatal("Expected the canary not to match")
+	}
+
+	// The primary tags the image, while the canary route doesn't.
+	if got, want := len(report.Primary), 4; got != want {
+		t.Fatalf("Primary => %v; want %d actions", report.Primary, want)
+	}
+	if got, want := len(report.Canary), 1; got != want {
+		t.Fatalf("Canary => %v; want %d actions", report.Canary, want)
+	}
+}
+
+func TestPlan_NoWrites(t *testing.T) {
+	registry := &MemoryRegistry{}
+	registry.Seed("remind101/acme-inc", "test")
+
+	r := &statusesRepository{}
+	n := &notifier{}
+	approvals := &approvalService{}
+	releases := &releaseAssetService{}
+	rule, err := ParseEventRule(`true -> [status, tag, release, promote(staging), promote(production)]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := &Quayd{
+		StatusesRepository: r,
+		Tagger:             registry,
+		TagResolver:        registry,
+		Notifiers:          []Notifier{n},
+		Approvals:          &Approvals{Repo: "remind101/deploys", Service: approvals},
+		ReleaseAssets:      &ReleaseAssets{Releases: releases},
+		AutoPromotions: &AutoPromotions{Rules: []*BakeRule{
+			{From: "staging", To: "production", After: time.Hour},
+		}},
+		EventRules: EventRules{rule},
+	}
+	ctx := context.Background()
+
+	actions, err := q.plan(ctx, &BuildEvent{ID: "1", Repo: "remind101/acme-inc", Ref: "f1fb3b0", GitTag: "v1.0.0", State: "success", Tags: []string{"test"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.statuses) != 0 || len(n.statuses) != 0 {
+		t.Fatal("Expected no statuses or notifications")
+	}
+	if _, err := registry.Resolve(ctx, "remind101/acme-inc", "long-f1fb3b0"); err != ErrTagNotFound {
+		t.Fatal("Expected the image not to be tagged")
+	}
+	if staging, _ := registry.Resolve(ctx, "remind101/acme-inc", "staging"); staging != "" {
+		t.Fatal("Expected the image not to be promoted")
+	}
+	if len(approvals.pulls) != 0 {
+		t.Fatal("Expected no approval pull requests")
+	}
+	if releases.uploads != 0 {
+		t.Fatal("Expected no release assets")
+	}
+	if bakes := q.AutoPromotions.List(); len(bakes) != 0 {
+		t.Fatalf("Bakes => %v; want none", bakes)
+	}
+
+	var promotions int
+	for _, a := range actions {
+		if strings.HasPrefix(a, "promote ") || strings.HasPrefix(a, "approval ") || strings.HasPrefix(a, "release asset ") {
+			promotions++
+		}
+	}
+	if got, want := promotions, 3; got != want {
+		t.Fatalf("Actions => %v; want the promotions, approval and release asset recorded", actions)
+	}
+}
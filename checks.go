@@ -0,0 +1,312 @@
+package quayd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/ejholmes/go-github/github"
+)
+
+// CheckRun represents a GitHub Check Run.
+type CheckRun struct {
+	Name       string          `json:"name"`
+	HeadSHA    string          `json:"head_sha"`
+	Status     string          `json:"status"`
+	Conclusion string          `json:"conclusion,omitempty"`
+	DetailsURL string          `json:"details_url,omitempty"`
+	Output     *CheckRunOutput `json:"output,omitempty"`
+}
+
+// CheckRunOutput is the output shown for a Check Run in the GitHub UI.
+type CheckRunOutput struct {
+	Title   string `json:"title"`
+	Summary string `json:"summary"`
+	Text    string `json:"text,omitempty"`
+
+	// Annotations are shown alongside the file and line they refer to.
+	Annotations []*CheckRunAnnotation `json:"annotations,omitempty"`
+}
+
+// CheckRunAnnotation annotates a line of a file in a Check Run.
+type CheckRunAnnotation struct {
+	Path      string `json:"path"`
+	StartLine int    `json:"start_line"`
+	EndLine   int    `json:"end_line"`
+
+	// Level is one of notice, warning or failure.
+	Level   string `json:"annotation_level"`
+	Title   string `json:"title,omitempty"`
+	Message string `json:"message"`
+}
+
+// checkConclusions maps a status state to a Check Run conclusion.
+var checkConclusions = map[string]string{
+	"success": "success",
+	"failure": "failure",
+	"error":   "failure",
+}
+
+// DefaultPullTemplate renders copy-pasteable pull commands for each tag of an
+// Image, for the digest-pinned reference when the digest is known, and for
+// each platform of multi-arch images.
+var DefaultPullTemplate = template.Must(template.New("pull").Parse("```console\n" +
+	"{{range .Tags}}$ docker pull {{$.Name}}:{{.}}\n{{end}}" +
+	"{{if .Digest}}$ docker pull {{.Name}}@{{.Digest}}\n{{end}}" +
+	"{{range .Platforms}}$ docker pull {{$.Name}}@{{.Digest}} # {{.OS}}/{{.Architecture}}{{if .Variant}}/{{.Variant}}{{end}}\n{{end}}" +
+	"```\n"))
+
+// GitHubChecksRepository is an implementation of the StatusesRepository
+// interface that creates GitHub Check Runs instead of legacy commit statuses.
+type GitHubChecksRepository struct {
+	Client interface {
+		NewRequest(method, urlStr string, body interface{}) (*http.Request, error)
+		Do(req *http.Request, v interface{}) (*github.Response, error)
+	}
+
+	// PullTemplate, if set, is rendered with the Image of the status and
+	// included in the Check Run output.
+	PullTemplate *template.Template
+
+	// RequiredHints, if set, notes in the Check Run output whether the
+	// check is required by the branch protection of the branch that was
+	// built, so developers can tell whether a failure blocks merging. The
+	// token needs read access to the repo's administration settings; if
+	// the protection can't be read, the note is left out.
+	RequiredHints bool
+
+	// MaxRuns is the number of Check Run ids to remember. Defaults to
+	// DefaultMaxCheckRuns. Runs that have been forgotten are looked up by
+	// their check name and head sha.
+	MaxRuns int
+
+	mu sync.Mutex
+	// ids maps a repo, sha and check name to the Check Run for it, so that
+	// later statuses and retried builds update it instead of adding another.
+	ids   map[string]int
+	order []string
+
+	// required caches the required checks of each repo and branch.
+	required map[string]*requiredChecks
+}
+
+// DefaultMaxCheckRuns is the default number of Check Run ids that
+// GitHubChecksRepository remembers.
+const DefaultMaxCheckRuns = 1000
+
+// DefaultRequiredChecksTTL is how long the required checks of a branch are
+// cached for.
+const DefaultRequiredChecksTTL = 10 * time.Minute
+
+type requiredChecks struct {
+	contexts []string
+	expires  time.Time
+}
+
+// Create implements StatusesRepository Create.
+func (r *GitHubChecksRepository) Create(ctx context.Context, status *Status) error {
+	check := NewCheckRun(status)
+
+	if r.PullTemplate != nil && status.Image != nil {
+		var buf bytes.Buffer
+		if err := r.PullTemplate.Execute(&buf, status.Image); err != nil {
+			return err
+		}
+		check.Output.Text = buf.String()
+	}
+
+	if r.RequiredHints && status.Branch != "" {
+		if required, err := r.Required(ctx, status.Repo, status.Branch, status.Context); err == nil {
+			check.Output.Summary += "\n\n" + requiredHint(required, status.Branch)
+		}
+	}
+
+	key := status.Repo + "@" + status.Ref + "#" + status.Context
+	r.mu.Lock()
+	id, ok := r.ids[key]
+	r.mu.Unlock()
+
+	if !ok {
+		found, err := r.FindCheckRun(ctx, status.Repo, status.Ref, status.Context)
+		if err != nil {
+			return err
+		}
+		id = found
+	}
+
+	if id == 0 {
+		created, err := r.CreateCheckRun(ctx, status.Repo, check)
+		if err != nil {
+			return err
+		}
+		r.remember(key, created)
+		return nil
+	}
+
+	if !ok {
+		r.remember(key, id)
+	}
+
+	return r.UpdateCheckRun(ctx, status.Repo, id, check)
+}
+
+// remember records the id of the Check Run for key, forgetting the oldest
+// ids once there are more than MaxRuns.
+func (r *GitHubChecksRepository) remember(key string, id int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.ids == nil {
+		r.ids = make(map[string]int)
+	}
+	if _, ok := r.ids[key]; !ok {
+		r.order = append(r.order, key)
+	}
+	r.ids[key] = id
+
+	max := r.MaxRuns
+	if max == 0 {
+		max = DefaultMaxCheckRuns
+	}
+	for len(r.order) > max {
+		delete(r.ids, r.order[0])
+		r.order = r.order[1:]
+	}
+}
+
+// FindCheckRun returns the id of the latest Check Run with the given name for
+// sha in repo, or 0 if there isn't one.
+func (r *GitHubChecksRepository) FindCheckRun(ctx context.Context, repo, sha, name string) (int, error) {
+	req, err := r.Client.NewRequest("GET", fmt.Sprintf("repos/%s/commits/%s/check-runs?check_name=%s&filter=latest", repo, sha, url.QueryEscape(name)), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	var found struct {
+		CheckRuns []struct {
+			ID int `json:"id"`
+		} `json:"check_runs"`
+	}
+	if _, err := r.Client.Do(req.WithContext(ctx), &found); err != nil {
+		return 0, err
+	}
+
+	if len(found.CheckRuns) == 0 {
+		return 0, nil
+	}
+
+	return found.CheckRuns[0].ID, nil
+}
+
+// CreateCheckRun creates a Check Run in repo and returns its id.
+func (r *GitHubChecksRepository) CreateCheckRun(ctx context.Context, repo string, check *CheckRun) (int, error) {
+	req, err := r.Client.NewRequest("POST", fmt.Sprintf("repos/%s/check-runs", repo), check)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	var created struct {
+		ID int `json:"id"`
+	}
+	_, err = r.Client.Do(req.WithContext(ctx), &created)
+	return created.ID, err
+}
+
+// UpdateCheckRun updates the Check Run with the given id in repo.
+func (r *GitHubChecksRepository) UpdateCheckRun(ctx context.Context, repo string, id int, check *CheckRun) error {
+	req, err := r.Client.NewRequest("PATCH", fmt.Sprintf("repos/%s/check-runs/%d", repo, id), check)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	_, err = r.Client.Do(req.WithContext(ctx), nil)
+	return err
+}
+
+// Required returns true if the check with the given name is a required status
+// check in the branch protection of branch. Branches without protection
+// require nothing.
+func (r *GitHubChecksRepository) Required(ctx context.Context, repo, branch, name string) (bool, error) {
+	key := repo + "@" + branch
+	r.mu.Lock()
+	cached, ok := r.required[key]
+	r.mu.Unlock()
+
+	if !ok || time.Now().After(cached.expires) {
+		contexts, err := r.requiredContexts(ctx, repo, branch)
+		if err != nil {
+			return false, err
+		}
+
+		cached = &requiredChecks{contexts: contexts, expires: time.Now().Add(DefaultRequiredChecksTTL)}
+		r.mu.Lock()
+		if r.required == nil {
+			r.required = make(map[string]*requiredChecks)
+		}
+		r.required[key] = cached
+		r.mu.Unlock()
+	}
+
+	for _, c := range cached.contexts {
+		if c == name {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// requiredContexts returns the required status checks of branch.
+func (r *GitHubChecksRepository) requiredContexts(ctx context.Context, repo, branch string) ([]string, error) {
+	return (&GitHubBranchProtection{Client: r.Client}).RequiredContexts(ctx, repo, branch)
+}
+
+// requiredHint returns the note about whether the check blocks merging into
+// branch.
+func requiredHint(required bool, branch string) string {
+	if required {
+		return fmt.Sprintf("This check is **required** to merge into `%s`.", branch)
+	}
+
+	return fmt.Sprintf("This check is not required to merge into `%s`.", branch)
+}
+
+// NewCheckRun returns the CheckRun that represents status.
+func NewCheckRun(status *Status) *CheckRun {
+	c := &CheckRun{
+		Name:       status.Context,
+		HeadSHA:    status.Ref,
+		Status:     "in_progress",
+		DetailsURL: status.TargetURL,
+		Output: &CheckRunOutput{
+			Title:   status.Description,
+			Summary: checkSummary(status),
+		},
+	}
+
+	if conclusion, ok := checkConclusions[status.State]; ok {
+		c.Status = "completed"
+		c.Conclusion = conclusion
+	}
+
+	return c
+}
+
+// checkSummary returns the markdown summary for a Check Run.
+func checkSummary(status *Status) string {
+	lines := []string{status.Description + "."}
+	if status.TargetURL != "" {
+		lines = append(lines, fmt.Sprintf("[View the build logs on Quay](%s)", status.TargetURL))
+	}
+
+	return strings.Join(lines, "\n\n")
+}
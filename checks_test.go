@@ -0,0 +1,243 @@
+package quayd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/ejholmes/go-github/github"
+)
+
+func TestGitHubChecksRepository(t *testing.T) {
+	var got CheckRun
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && r.URL.Path == "/repos/ejholmes/docker-statsd/commits/6607c19d3fd492ec53439f4104b39e4c62ece179/check-runs" {
+			w.Write([]byte(`{"total_count":0,"check_runs":[]}`))
+			return
+		}
+
+		if r.Method != "POST" || r.URL.Path != "/repos/ejholmes/docker-statsd/check-runs" {
+			t.Errorf("Unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(201)
+		w.Write([]byte(`{"id":1}`))
+	}))
+	defer s.Close()
+
+	g := github.NewClient(nil)
+	g.BaseURL, _ = url.Parse(s.URL + "/")
+	r := &GitHubChecksRepository{Client: g}
+
+	if err := r.Create(context.Background(), &Status{
+		Repo:        "ejholmes/docker-statsd",
+		Ref:         "6607c19d3fd492ec53439f4104b39e4c62ece179",
+		State:       "success",
+		Context:     "Docker Image",
+		TargetURL:   "https://quay.io/repository/ejholmes/docker-statsd/build?current=1",
+		Description: "The Docker image was built",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := CheckRun{
+		Name:       "Docker Image",
+		HeadSHA:    "6607c19d3fd492ec53439f4104b39e4c62ece179",
+		Status:     "completed",
+		Conclusion: "success",
+		DetailsURL: "https://quay.io/repository/ejholmes/docker-statsd/build?current=1",
+		Output: &CheckRunOutput{
+			Title:   "The Docker image was built",
+			Summary: "The Docker image was built.\n\n[View the build logs on Quay](https://quay.io/repository/ejholmes/docker-statsd/build?current=1)",
+		},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("CheckRun => %+v; want %+v", got, want)
+	}
+}
+
+func TestNewCheckRun_Pending(t *testing.T) {
+	c := NewCheckRun(&Status{State: "pending"})
+
+	if got, want := c.Status, "in_progress"; got != want {
+		t.Fatalf("Status => %s; want %s", got, want)
+	}
+
+	if c.Conclusion != "" {
+		t.Fatalf("Expected no conclusion, got %s", c.Conclusion)
+	}
+}
+
+func TestGitHubChecksRepository_PullTemplate(t *testing.T) {
+	var got CheckRun
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(201)
+		w.Write([]byte(`{"id":1}`))
+	}))
+	defer s.Close()
+
+	g := github.NewClient(nil)
+	g.BaseURL, _ = url.Parse(s.URL + "/")
+	r := &GitHubChecksRepository{Client: g, PullTemplate: DefaultPullTemplate}
+
+	if err := r.Create(context.Background(), &Status{
+		Repo:  "ejholmes/docker-statsd",
+		State: "success",
+		Image: &Image{
+			Registry: "quay.io",
+			Repo:     "ejholmes/docker-statsd",
+			Digest:   "sha256:abcd",
+			Tags:     []string{"master", "6607c19"},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "```console\n" +
+		"$ docker pull quay.io/ejholmes/docker-statsd:master\n" +
+		"$ docker pull quay.io/ejholmes/docker-statsd:6607c19\n" +
+		"$ docker pull quay.io/ejholmes/docker-statsd@sha256:abcd\n" +
+		"```\n"
+
+	if got := got.Output.Text; got != want {
+		t.Fatalf("Text => %q; want %q", got, want)
+	}
+}
+
+func TestGitHubChecksRepository_Update(t *testing.T) {
+	var requests []string
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		if r.Method == "GET" {
+			w.Write([]byte(`{"total_count":0,"check_runs":[]}`))
+			return
+		}
+		w.Write([]byte(`{"id":1}`))
+	}))
+	defer s.Close()
+
+	g := github.NewClient(nil)
+	g.BaseURL, _ = url.Parse(s.URL + "/")
+	r := &GitHubChecksRepository{Client: g}
+
+	for _, status := range []*Status{
+		{Repo: "ejholmes/docker-statsd", Ref: "abcd", State: "pending", Context: "Docker Image", Attempt: 1},
+		{Repo: "ejholmes/docker-statsd", Ref: "abcd", State: "success", Context: "Docker Image", Attempt: 1},
+		{Repo: "ejholmes/docker-statsd", Ref: "abcd", State: "pending", Context: "Docker Image", Attempt: 2},
+	} {
+		if err := r.Create(context.Background(), status); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{
+		"GET /repos/ejholmes/docker-statsd/commits/abcd/check-runs",
+		"POST /repos/ejholmes/docker-statsd/check-runs",
+		"PATCH /repos/ejholmes/docker-statsd/check-runs/1",
+		"PATCH /repos/ejholmes/docker-statsd/check-runs/1",
+	}
+	if !reflect.DeepEqual(requests, want) {
+		t.Fatalf("Requests => %v; want %v", requests, want)
+	}
+}
+
+func TestGitHubChecksRepository_Find(t *testing.T) {
+	var requests []string
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		if r.Method == "GET" {
+			if got, want := r.URL.Query().Get("check_name"), "Docker Image"; got != want {
+				t.Errorf("check_name => %q; want %q", got, want)
+			}
+			w.Write([]byte(`{"total_count":1,"check_runs":[{"id":42}]}`))
+			return
+		}
+	}))
+	defer s.Close()
+
+	g := github.NewClient(nil)
+	g.BaseURL, _ = url.Parse(s.URL + "/")
+	r := &GitHubChecksRepository{Client: g, MaxRuns: 1}
+
+	for _, ref := range []string{"abcd", "efgh", "abcd"} {
+		if err := r.Create(context.Background(), &Status{Repo: "ejholmes/docker-statsd", Ref: ref, State: "success", Context: "Docker Image"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The run for abcd is forgotten once efgh is remembered, so it's
+	// looked up again.
+	want := []string{
+		"GET /repos/ejholmes/docker-statsd/commits/abcd/check-runs",
+		"PATCH /repos/ejholmes/docker-statsd/check-runs/42",
+		"GET /repos/ejholmes/docker-statsd/commits/efgh/check-runs",
+		"PATCH /repos/ejholmes/docker-statsd/check-runs/42",
+		"GET /repos/ejholmes/docker-statsd/commits/abcd/check-runs",
+		"PATCH /repos/ejholmes/docker-statsd/check-runs/42",
+	}
+	if !reflect.DeepEqual(requests, want) {
+		t.Fatalf("Requests => %v; want %v", requests, want)
+	}
+}
+
+func TestGitHubChecksRepository_RequiredHints(t *testing.T) {
+	var protections int
+	var summaries []string
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/ejholmes/docker-statsd/branches/main/protection/required_status_checks":
+			protections++
+			w.Write([]byte(`{"contexts":["ci"],"checks":[{"context":"Docker Image"}]}`))
+		case "/repos/ejholmes/docker-statsd/branches/feature/protection/required_status_checks":
+			protections++
+			w.WriteHeader(404)
+			w.Write([]byte(`{"message":"Branch not protected"}`))
+		case "/repos/ejholmes/docker-statsd/commits/abcd/check-runs":
+			w.Write([]byte(`{"total_count":0,"check_runs":[]}`))
+		case "/repos/ejholmes/docker-statsd/check-runs", "/repos/ejholmes/docker-statsd/check-runs/1":
+			var check CheckRun
+			json.NewDecoder(r.Body).Decode(&check)
+			summaries = append(summaries, check.Output.Summary)
+			w.Write([]byte(`{"id":1}`))
+		default:
+			t.Errorf("Unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer s.Close()
+
+	g := github.NewClient(nil)
+	g.BaseURL, _ = url.Parse(s.URL + "/")
+	r := &GitHubChecksRepository{Client: g, RequiredHints: true}
+
+	for _, branch := range []string{"main", "main", "feature"} {
+		if err := r.Create(context.Background(), &Status{Repo: "ejholmes/docker-statsd", Ref: "abcd", State: "failure", Context: "Docker Image", Description: "The Docker image failed to build", Branch: branch}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{
+		"The Docker image failed to build.\n\nThis check is **required** to merge into `main`.",
+		"The Docker image failed to build.\n\nThis check is **required** to merge into `main`.",
+		"The Docker image failed to build.\n\nThis check is not required to merge into `feature`.",
+	}
+	if !reflect.DeepEqual(summaries, want) {
+		t.Fatalf("Summaries => %q; want %q", summaries, want)
+	}
+
+	// The protection of main is cached.
+	if got, want := protections, 2; got != want {
+		t.Fatalf("Protection requests => %d; want %d", got, want)
+	}
+}
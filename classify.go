@@ -0,0 +1,108 @@
+package quayd
+
+import (
+	"regexp"
+
+	"github.com/ejholmes/go-github/github"
+)
+
+// FailureClass is the kind of failure that a failed build or webhook is,
+// which determines which team should look at it.
+type FailureClass string
+
+// The failure classes.
+const (
+	// FailureUser is a build that failed because of the repo itself, like
+	// an invalid Dockerfile or a failing RUN step.
+	FailureUser FailureClass = "user"
+
+	// FailureQuay is a failure of Quay's build workers or registry.
+	FailureQuay FailureClass = "quay"
+
+	// FailureConfig is a failure caused by how quayd is configured, like
+	// an expired token or a repo that isn't mapped correctly.
+	FailureConfig FailureClass = "config"
+
+	// FailureGitHub is a failure of the GitHub API.
+	FailureGitHub FailureClass = "github"
+
+	// FailureUnknown is a failure that couldn't be classified.
+	FailureUnknown FailureClass = "unknown"
+)
+
+// FailureDescriptions are appended to the commit status description of
+// failed builds, so that developers can tell at a glance whether the failure
+// is theirs to fix.
+var FailureDescriptions = map[FailureClass]string{
+	FailureUser:   "Dockerfile error",
+	FailureQuay:   "Quay infrastructure error",
+	FailureConfig: "quayd configuration error",
+	FailureGitHub: "GitHub outage",
+}
+
+// quayFailurePatterns match the build errors that are caused by Quay's
+// infrastructure rather than the repo. They're checked before
+// userFailurePatterns, since the messages of infrastructure failures can
+// mention the Dockerfile too.
+var quayFailurePatterns = regexp.MustCompile(`(?i)(worker|internal error|timed? ?out|no space left|connection reset|service unavailable|bad gateway|could not push)`)
+
+// userFailurePatterns match the build errors that are caused by the repo.
+var userFailurePatterns = regexp.MustCompile(`(?i)(dockerfile|unknown instruction|returned a non-zero code|copy failed|add failed|no such file or directory|not found: manifest unknown|pull access denied)`)
+
+// ClassifyBuild classifies the failure of a build from the error message that
+// Quay reported for it. Builds that didn't fail, or failed without an
+// error message, are FailureUnknown.
+func ClassifyBuild(e *BuildEvent) FailureClass {
+	if e.State != "failure" && e.State != "error" {
+		return FailureUnknown
+	}
+
+	switch {
+	case e.Error == "":
+		return FailureUnknown
+	case quayFailurePatterns.MatchString(e.Error):
+		return FailureQuay
+	case userFailurePatterns.MatchString(e.Error):
+		return FailureUser
+	}
+
+	// Most build failures are the repo's, and an unrecognized message
+	// comes from the build itself rather than Quay.
+	return FailureUser
+}
+
+// ClassifyError classifies an error returned while processing a webhook, from
+// its type. Authorization errors, and repos or images that don't exist, are
+// configuration errors; server errors are outages of whoever returned them.
+func ClassifyError(err error) FailureClass {
+	if perr, ok := err.(*PipelineError); ok {
+		err = perr.Err
+	}
+
+	switch err := err.(type) {
+	case *github.ErrorResponse:
+		if err.Response == nil {
+			return FailureUnknown
+		}
+		return classifyStatusCode(err.Response.StatusCode, FailureGitHub)
+	case *HTTPError:
+		return classifyStatusCode(err.StatusCode, FailureQuay)
+	}
+
+	if err == ErrTagNotFound || err == ErrUntagUnsupported {
+		return FailureConfig
+	}
+
+	return FailureUnknown
+}
+
+func classifyStatusCode(code int, outage FailureClass) FailureClass {
+	switch {
+	case code == 401 || code == 403 || code == 404 || code == 422:
+		return FailureConfig
+	case code >= 500:
+		return outage
+	}
+
+	return FailureUnknown
+}
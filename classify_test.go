@@ -0,0 +1,79 @@
+package quayd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ejholmes/go-github/github"
+)
+
+func TestClassifyBuild(t *testing.T) {
+	tests := []struct {
+		state, err string
+		class      FailureClass
+	}{
+		{"failure", "Could not parse Dockerfile: unknown instruction: GIT", FailureUser},
+		{"failure", "The command '/bin/sh -c make' returned a non-zero code: 2", FailureUser},
+		{"failure", "Build worker timed out", FailureQuay},
+		{"error", "write /var/lib/docker/tmp: no space left on device", FailureQuay},
+		{"failure", "something else went wrong", FailureUser},
+		{"failure", "", FailureUnknown},
+		{"success", "", FailureUnknown},
+	}
+
+	for _, tt := range tests {
+		if got := ClassifyBuild(&BuildEvent{State: tt.state, Error: tt.err}); got != tt.class {
+			t.Fatalf("ClassifyBuild(%q) => %s; want %s", tt.err, got, tt.class)
+		}
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	githubError := func(code int) error {
+		return &github.ErrorResponse{Response: &http.Response{StatusCode: code}}
+	}
+
+	tests := []struct {
+		err   error
+		class FailureClass
+	}{
+		{githubError(401), FailureConfig},
+		{githubError(404), FailureConfig},
+		{githubError(502), FailureGitHub},
+		{&HTTPError{StatusCode: 403}, FailureConfig},
+		{&HTTPError{StatusCode: 500}, FailureQuay},
+		{&PipelineError{Stage: "tag-sha", Err: &HTTPError{StatusCode: 503}}, FailureQuay},
+		{ErrUntagUnsupported, FailureConfig},
+		{errors.New("boom"), FailureUnknown},
+	}
+
+	for _, tt := range tests {
+		if got := ClassifyError(tt.err); got != tt.class {
+			t.Fatalf("ClassifyError(%v) => %s; want %s", tt.err, got, tt.class)
+		}
+	}
+}
+
+func TestHandle_FailureClass(t *testing.T) {
+	r := &statusesRepository{}
+	m := &Metrics{}
+	q := &Quayd{StatusesRepository: r, Metrics: m}
+
+	if err := q.Handle(context.Background(), &BuildEvent{Repo: "remind101/acme-inc", Ref: "abcd", State: "failure", Error: "Dockerfile not found"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := r.statuses[0].Description, "The Docker image failed to build (Dockerfile error)"; got != want {
+		t.Fatalf("Description => %s; want %s", got, want)
+	}
+
+	var buf bytes.Buffer
+	m.WriteTo(&buf, 0)
+	if want := `quayd_failures_total{repo="remind101/acme-inc",class="user"} 1`; !strings.Contains(buf.String(), want) {
+		t.Fatalf("Expected metrics to contain %s, got:\n%s", want, buf.String())
+	}
+}
@@ -0,0 +1,239 @@
+package quayd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+)
+
+// invalidTagChars matches the characters that aren't allowed in docker tags.
+var invalidTagChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// DefaultProtectedTags are the tags that branch cleanup never removes, in
+// addition to BranchCleanup.Protected.
+var DefaultProtectedTags = []string{"latest"}
+
+// BranchTag returns the tag that builds of branch are pushed with. Characters
+// that aren't allowed in docker tags, like the "/" in "feature/login", are
+// replaced with "-", so different branches (e.g. "a/b" and "a-b") can share a
+// tag.
+func BranchTag(branch string) string {
+	return invalidTagChars.ReplaceAllString(branch, "-")
+}
+
+// BranchCleanup removes the tags of images built from a branch when the branch
+// is deleted, so that registries don't accumulate a tag for every branch that
+// was ever pushed.
+type BranchCleanup struct {
+	// Repos maps a GitHub repo to the Quay repos built from it. GitHub
+	// repos that aren't in it are cleaned up in the Quay repos that map to
+	// them in the RepoMap, or in the Quay repo with the same name.
+	Repos map[string][]string `json:"repos"`
+
+	// Protected are patterns, as used by path.Match, of tags that are never
+	// removed, like tags that deploys point at. Environment tags, and the
+	// tags that images are promoted to, are always protected, so deleting
+	// a branch named "production" doesn't untag production.
+	Protected []string `json:"protected"`
+}
+
+// protectedTag returns true if branch cleanup must not remove tag.
+func (q *Quayd) protectedTag(tag string) bool {
+	patterns := append([]string(nil), DefaultProtectedTags...)
+	if q.Cleanup != nil {
+		patterns = append(patterns, q.Cleanup.Protected...)
+	}
+	if q.Quarantines != nil {
+		patterns = append(patterns, q.Quarantines.EnvironmentTags...)
+	}
+	if q.AutoPromotions != nil {
+		for _, r := range q.AutoPromotions.Rules {
+			patterns = append(patterns, r.From, r.To)
+		}
+	}
+	for _, r := range q.EventRules {
+		patterns = append(patterns, r.actions.Promote...)
+	}
+
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, tag); ok {
+			return true
+		}
+	}
+	return q.Approvals.Required(tag)
+}
+
+// quayRepos returns the Quay repos that are built from githubRepo: the repos
+// listed for it in explicit, the repos that map to it in the RepoMap, or the
+// Quay repo with the same name.
+func (q *Quayd) quayRepos(githubRepo string, explicit map[string][]string) []string {
+	if repos, ok := explicit[githubRepo]; ok {
+		return repos
+	}
+
+	var repos []string
+	if m, ok := q.RepoMapper.(RepoMap); ok {
+		for quayRepo, to := range m {
+			if to == githubRepo {
+				repos = append(repos, quayRepo)
+			}
+		}
+	}
+	if len(repos) == 0 {
+		return []string{githubRepo}
+	}
+
+	sort.Strings(repos)
+	return repos
+}
+
+// DeleteBranch removes the tag of the deleted branch from each Quay repo
+// that's built from the GitHub repo. It returns the repos that the tag was
+// removed from; repos that don't have the tag are skipped.
+func (q *Quayd) DeleteBranch(ctx context.Context, githubRepo, branch string) ([]string, error) {
+	if q.Cleanup == nil {
+		return nil, errors.New("branch cleanup is not enabled")
+	}
+
+	tag := BranchTag(branch)
+	if q.protectedTag(tag) {
+		q.logger().Log(ctx, "branch cleanup skipped (protected tag)", "repo", githubRepo, "tag", tag)
+		return nil, nil
+	}
+
+	if q.ReadOnly {
+		q.logger().Log(ctx, "branch cleanup skipped (read-only)", "repo", githubRepo, "tag", tag)
+		return nil, nil
+	}
+
+	var removed []string
+	for _, repo := range q.quayRepos(githubRepo, q.Cleanup.Repos) {
+		t := q.forTenant(repo)
+		if _, err := t.tagResolver().Resolve(ctx, repo, tag); err != nil {
+			continue
+		}
+
+		u, ok := t.tagger().(Untagger)
+		if !ok {
+			return removed, ErrUntagUnsupported
+		}
+		if err := u.Untag(ctx, repo, tag); err != nil {
+			return removed, err
+		}
+		removed = append(removed, repo)
+	}
+
+	q.logger().Log(ctx, "branch tags removed", "repo", githubRepo, "tag", tag, "repos", removed)
+	return removed, nil
+}
+
+// GitHubDeleteEvent is the payload of a GitHub `delete` webhook, which is
+// sent when a branch or tag is deleted.
+type GitHubDeleteEvent struct {
+	Ref        string `json:"ref"`
+	RefType    string `json:"ref_type"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+// GitHubWebhook is an http.Handler that handles GitHub webhooks. Deleted
+// branches have their image tags removed when BranchCleanup is enabled, and
+// `/rebuild` comments on pull requests restart their builds when Rebuilds is
+// enabled, and reviews and merges of promotion pull requests apply their tags
+// when Approvals is enabled. Other events are acknowledged and ignored.
+type GitHubWebhook struct {
+	*Quayd
+}
+
+func (wh *GitHubWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p, err := wh.readPayload(r, nil)
+	if err != nil {
+		payloadError(w, err)
+		return
+	}
+	defer p.release()
+	body := p.Bytes()
+
+	if err := wh.WebhookValidators.Validate("github", r, body); err != nil {
+		http.Error(w, err.Error(), 401)
+		return
+	}
+
+	switch event := r.Header.Get("X-GitHub-Event"); {
+	case event == "delete" && wh.Cleanup != nil:
+		wh.delete(w, r, body)
+	case event == "issue_comment" && wh.Rebuilds != nil:
+		wh.issueComment(w, r, body)
+	case (event == "pull_request" || event == "pull_request_review") && wh.Approvals != nil:
+		wh.approval(w, r, event, body)
+	default:
+		w.WriteHeader(204)
+	}
+}
+
+// delete handles deleted branches.
+func (wh *GitHubWebhook) delete(w http.ResponseWriter, r *http.Request, body []byte) {
+	var e GitHubDeleteEvent
+	if err := json.Unmarshal(body, &e); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	if e.RefType != "branch" {
+		w.WriteHeader(204)
+		return
+	}
+
+	cleanup(wh.Quayd, w, r, e.Repository.FullName, e.Ref)
+}
+
+// CleanupHandler is an http.Handler that removes the image tags of a deleted
+// branch, for setups where GitHub's `delete` webhook can't reach quayd.
+type CleanupHandler struct {
+	*Quayd
+}
+
+// CleanupForm is the payload of a request to CleanupHandler.
+type CleanupForm struct {
+	Repo   string `json:"repo"`
+	Branch string `json:"branch"`
+}
+
+func (h *CleanupHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var form CleanupForm
+	if err := json.NewDecoder(r.Body).Decode(&form); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	if form.Repo == "" || form.Branch == "" {
+		http.Error(w, "repo and branch are required", 400)
+		return
+	}
+
+	cleanup(h.Quayd, w, r, form.Repo, form.Branch)
+}
+
+// cleanup removes the tags of the branch and responds with the repos that it
+// was removed from.
+func cleanup(q *Quayd, w http.ResponseWriter, r *http.Request, repo, branch string) {
+	removed, err := q.DeleteBranch(r.Context(), repo, branch)
+	if err != nil {
+		q.errorResponse(w, r, err)
+		return
+	}
+	if removed == nil {
+		removed = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tag":   BranchTag(branch),
+		"repos": removed,
+	})
+}
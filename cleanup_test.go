@@ -0,0 +1,165 @@
+package quayd
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestBranchTag(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"master", "master"},
+		{"feature/login", "feature-login"},
+		{"fix_1.2", "fix_1.2"},
+	}
+
+	for _, tt := range tests {
+		if got := BranchTag(tt.in); got != tt.out {
+			t.Fatalf("BranchTag(%q) => %q; want %q", tt.in, got, tt.out)
+		}
+	}
+}
+
+func TestGitHubWebhook_Delete(t *testing.T) {
+	registry := &MemoryRegistry{}
+	registry.Seed("remind101/acme-inc", "feature-login", "master")
+	registry.Seed("remind101/acme-inc-worker", "feature-login")
+	q := &Quayd{
+		Tagger:      registry,
+		TagResolver: registry,
+		RepoMapper:  RepoMap{"remind101/acme-inc-worker": "remind101/acme-inc", "remind101/acme-inc": "remind101/acme-inc"},
+		Cleanup:     &BranchCleanup{},
+	}
+	s := NewServer(q)
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/github", bytes.NewBufferString(`{"ref":"feature/login","ref_type":"branch","repository":{"full_name":"remind101/acme-inc"}}`))
+	req.Header.Set("X-GitHub-Event", "delete")
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 200; got != want {
+		t.Fatalf("Status => %d; want %d: %s", got, want, resp.Body.String())
+	}
+
+	for _, repo := range []string{"remind101/acme-inc", "remind101/acme-inc-worker"} {
+		if _, err := registry.Resolve(context.Background(), repo, "feature-login"); err != ErrTagNotFound {
+			t.Fatalf("Expected the feature-login tag to be removed from %s", repo)
+		}
+	}
+	if _, err := registry.Resolve(context.Background(), "remind101/acme-inc", "master"); err != nil {
+		t.Fatal("Expected the master tag to be kept")
+	}
+}
+
+func TestGitHubWebhook_IgnoredEvents(t *testing.T) {
+	registry := &MemoryRegistry{}
+	registry.Seed("remind101/acme-inc", "v1.0.0")
+	q := &Quayd{Tagger: registry, TagResolver: registry, Cleanup: &BranchCleanup{}}
+	s := NewServer(q)
+
+	tests := []struct {
+		event, body string
+	}{
+		{"ping", `{"zen":"Keep it logically awesome."}`},
+		{"delete", `{"ref":"v1.0.0","ref_type":"tag","repository":{"full_name":"remind101/acme-inc"}}`},
+	}
+
+	for _, tt := range tests {
+		resp := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/github", bytes.NewBufferString(tt.body))
+		req.Header.Set("X-GitHub-Event", tt.event)
+		s.ServeHTTP(resp, req)
+
+		if got, want := resp.Code, 204; got != want {
+			t.Fatalf("Status => %d; want %d", got, want)
+		}
+	}
+
+	if _, err := registry.Resolve(context.Background(), "remind101/acme-inc", "v1.0.0"); err != nil {
+		t.Fatal("Expected git tags to be ignored")
+	}
+}
+
+func TestDeleteBranch_Repos(t *testing.T) {
+	registry := &MemoryRegistry{}
+	registry.Seed("remind101/api", "feature-x")
+	q := &Quayd{
+		Tagger:      registry,
+		TagResolver: registry,
+		Cleanup:     &BranchCleanup{Repos: map[string][]string{"remind101/acme-inc": {"remind101/api", "remind101/web"}}},
+	}
+
+	removed, err := q.DeleteBranch(context.Background(), "remind101/acme-inc", "feature/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := removed, []string{"remind101/api"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Removed => %v; want %v", got, want)
+	}
+}
+
+func TestCleanupHandler(t *testing.T) {
+	registry := &MemoryRegistry{}
+	registry.Seed("remind101/acme-inc", "feature-x")
+	q := &Quayd{AdminToken: testAdminToken, Tagger: registry, TagResolver: registry, Cleanup: &BranchCleanup{}}
+	s := NewServer(q)
+
+	resp := httptest.NewRecorder()
+	req, _ := newAdminRequest("POST", "/admin/cleanup", bytes.NewBufferString(`{"repo":"remind101/acme-inc","branch":"feature/x"}`))
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 200; got != want {
+		t.Fatalf("Status => %d; want %d: %s", got, want, resp.Body.String())
+	}
+	if got, want := resp.Body.String(), `{"repos":["remind101/acme-inc"],"tag":"feature-x"}`+"\n"; got != want {
+		t.Fatalf("Body => %s; want %s", got, want)
+	}
+}
+
+func TestDeleteBranch_Protected(t *testing.T) {
+	registry := &MemoryRegistry{}
+	for _, tag := range []string{"production", "latest", "release-1"} {
+		registry.Seed("remind101/acme-inc", tag)
+	}
+	q := &Quayd{
+		Tagger:      registry,
+		TagResolver: registry,
+		Cleanup:     &BranchCleanup{Protected: []string{"release-*"}},
+		Quarantines: &Quarantines{EnvironmentTags: []string{"staging", "production"}},
+	}
+
+	for _, branch := range []string{"production", "latest", "release/1"} {
+		removed, err := q.DeleteBranch(context.Background(), "remind101/acme-inc", branch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(removed) != 0 {
+			t.Fatalf("Removed %s => %v; want none", branch, removed)
+		}
+		if _, err := registry.Resolve(context.Background(), "remind101/acme-inc", BranchTag(branch)); err != nil {
+			t.Fatalf("Expected the %s tag to be kept", BranchTag(branch))
+		}
+	}
+}
+
+func TestCleanupHandler_Unauthorized(t *testing.T) {
+	registry := &MemoryRegistry{}
+	registry.Seed("remind101/acme-inc", "feature-x")
+	s := NewServer(&Quayd{AdminToken: testAdminToken, Tagger: registry, TagResolver: registry, Cleanup: &BranchCleanup{}})
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/admin/cleanup", bytes.NewBufferString(`{"repo":"remind101/acme-inc","branch":"feature/x"}`))
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 401; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+	if _, err := registry.Resolve(context.Background(), "remind101/acme-inc", "feature-x"); err != nil {
+		t.Fatal("Expected the tag to be kept")
+	}
+}
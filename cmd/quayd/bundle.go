@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/remind101/quayd"
+)
+
+// loadBundle loads a signed config bundle from location, which can either be
+// a path or an http(s) URL. The signature is expected at location + ".sig",
+// and is verified against key, a base64 encoded ed25519 public key.
+func loadBundle(location, key string) (*quayd.Config, error) {
+	pub, err := parseKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := fetch(location + ".sig")
+	if err != nil {
+		return nil, err
+	}
+	defer sig.Close()
+
+	rawSig, err := ioutil.ReadAll(sig)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle, err := fetch(location)
+	if err != nil {
+		return nil, err
+	}
+	defer bundle.Close()
+
+	return quayd.LoadBundle(bundle, rawSig, pub)
+}
+
+// parseKey decodes a base64 encoded ed25519 public key.
+func parseKey(key string) (ed25519.PublicKey, error) {
+	pub, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config key: %s", err)
+	}
+
+	return ed25519.PublicKey(pub), nil
+}
+
+// fetch opens a file or http(s) URL.
+func fetch(location string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		return os.Open(location)
+	}
+
+	resp, err := http.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("fetching %s: %s", location, resp.Status)
+	}
+
+	return resp.Body, nil
+}
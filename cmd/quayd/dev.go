@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// tunnelProviders maps the name of a tunnel provider to the command that
+// starts a tunnel to a local port. `{{port}}` is replaced with the port.
+var tunnelProviders = map[string]string{
+	"ngrok":       "ngrok http {{port}} --log stdout",
+	"cloudflared": "cloudflared tunnel --url http://localhost:{{port}}",
+}
+
+// publicURL matches the public URL that tunnel providers print once the
+// tunnel is up.
+var publicURL = regexp.MustCompile(`https://[a-zA-Z0-9.-]+\.(ngrok\.io|ngrok-free\.app|ngrok\.app|trycloudflare\.com)`)
+
+// tunnel starts a public tunnel to port using the given provider (either the
+// name of a known provider, or a command containing `{{port}}`) and prints the
+// webhook URLs to configure in Quay once the tunnel is up. path returns the
+// path of the webhook for each status. It blocks while the tunnel is up, and
+// returns an error when the tunnel process exits, whether or not it printed a
+// public URL.
+func tunnel(provider, port string, path func(status string) string) error {
+	command, ok := tunnelProviders[provider]
+	if !ok {
+		command = provider
+	}
+
+	args := strings.Fields(strings.Replace(command, "{{port}}", port, -1))
+	if len(args) == 0 {
+		return fmt.Errorf("no tunnel command for provider %q", provider)
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	r, w := io.Pipe()
+	cmd.Stdout = w
+	cmd.Stderr = w
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+		w.Close()
+	}()
+
+	up := false
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		if url := publicURL.FindString(s.Text()); url != "" {
+			log.Printf("Tunnel is up. Configure your Quay webhooks to POST to:")
+			for _, status := range []string{"pending", "success", "failure"} {
+				log.Printf("  %s%s", url, path(status))
+			}
+			up = true
+			break
+		}
+	}
+
+	if !up {
+		if err := s.Err(); err != nil {
+			cmd.Process.Kill()
+			go io.Copy(ioutil.Discard, r)
+			return fmt.Errorf("reading tunnel output: %v", err)
+		}
+		return tunnelExited("tunnel exited before printing its public URL", <-exited)
+	}
+
+	// Keep draining the output so the tunnel process doesn't block.
+	io.Copy(ioutil.Discard, r)
+
+	return tunnelExited("tunnel exited", <-exited)
+}
+
+// tunnelExited returns the error for a tunnel process that exited, with the
+// reason it exited, if any.
+func tunnelExited(msg string, err error) error {
+	if err != nil {
+		return fmt.Errorf("%s: %v", msg, err)
+	}
+
+	return errors.New(msg)
+}
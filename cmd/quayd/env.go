@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+)
+
+// version is the version of quayd, set at build time with
+// `-ldflags "-X main.version=..."`.
+var version = "dev"
+
+// envPrefix is prepended to a flag's name to get the environment variable
+// that sets it (e.g. QUAYD_GITHUB_TOKEN for -github-token).
+const envPrefix = "QUAYD_"
+
+// setFlagsFromEnv sets each flag that wasn't given on the command line from
+// its environment variable, if present.
+func setFlagsFromEnv(fs *flag.FlagSet) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || err != nil {
+			return
+		}
+
+		if v, ok := os.LookupEnv(envName(f.Name)); ok {
+			err = fs.Set(f.Name, v)
+		}
+	})
+	return err
+}
+
+// envName returns the environment variable for the flag.
+func envName(flag string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(flag, "-", "_", -1))
+}
+
+// splitList splits a comma separated list, returning nil for an empty
+// string.
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
@@ -1,9 +1,16 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/remind101/quayd"
 )
@@ -11,13 +18,696 @@ import (
 func main() {
 	var (
 		port  = flag.String("port", "8080", "The port to run the server on.")
-		token = flag.String("github-token", "", "The GitHub API Token to use when creating commit statuses.")
+		addr  = flag.String("listen", "", "The address to listen on. Overrides -port.")
+		vers  = flag.Bool("version", false, "Print the version and exit.")
+		reg   = flag.String("registry", quayd.DefaultRegistry, "The registry host that images are tagged in.")
+		dgst  = flag.Bool("digest-tagging", false, "Resolve tags to manifest digests and tag images by digest with the v2 registry api, instead of by v1 image id.")
+		ptags = flag.Bool("platform-tags", false, "Also tag the platform specific manifests of multi-arch images with the git sha and their platform (<sha>-arm64-v8). Requires -digest-tagging.")
+		rschm = flag.String("registry-scheme", "https", "The URL scheme used to reach the registry. Use http only for test registries.")
+		rca   = flag.String("registry-ca", "", "Path to PEM encoded CA certificates to trust for the registry, in addition to the system's.")
+		sctx  = flag.String("context", quayd.Context, "The commit status context.")
+		level = flag.String("log-level", "info", "The minimum level of log lines to write (debug, info or error).")
+		token = flag.String("github-token", "", "The GitHub API Token to use when creating commit statuses. Use env:NAME, file:/path or oidc:https://host/path?id_token_file=/path to read a token that rotates.")
 		auth  = flag.String("registry-auth", "", "The authorization (ex: Quay requires username:password)")
+		rauth = flag.String("registry-read-auth", "", "A separate, read-only username:password used to resolve tags, so only -registry-auth holds write scope.")
+		chk   = flag.Bool("checks", false, "Create GitHub Check Runs instead of commit statuses.")
+		hints = flag.Bool("required-check-hints", false, "With -checks, note in each Check Run whether branch protection requires it to merge.")
+		ro    = flag.Bool("read-only", false, "Compute and log statuses and tags without writing to GitHub or the registry.")
+		rmap  = flag.String("repo-map", "", "Path to a JSON file mapping Quay repos to GitHub repos.")
+		dirs  = flag.Bool("commit-directives", false, "Honor directives in commit messages: [skip image] skips the build, [no-tag] skips tagging, and [no latest] skips floating release tags.")
+		cstat = flag.String("cancelled-state", "error", "The commit status state (error or failure) that cancelled builds are reported with.")
+		scans = flag.Bool("security-scans", false, "Report Quay security scan notifications (POST /quay/scan) as an \"Image Security Scan\" status.")
+		sevr  = flag.String("scan-fail-severity", "", "If set, fail the security scan status when more than -scan-threshold vulnerabilities of this severity (e.g. High) or higher are found.")
+		sthr  = flag.Int("scan-threshold", 0, "The number of vulnerabilities at or above -scan-fail-severity that are allowed.")
+		rels  = flag.Bool("release-assets", false, "Attach a digest file describing the image to the GitHub release of builds of git tags.")
+		rbld  = flag.Bool("rebuild-comments", false, "Restart the builds of pull requests commented with /rebuild (reported by GitHub's issue_comment webhook to POST /github). Requires -quay-token.")
+		clean = flag.Bool("cleanup-branches", false, "Remove the image tags of branches when GitHub's delete webhook (POST /github) reports them deleted.")
+		semvr = flag.Bool("semver-tags", false, "Apply version and floating tags (1, 1.2, latest-stable) to images built from semver git tags.")
+		incl  = flag.String("include-refs", "", "Comma separated patterns of refs (like refs/heads/main or refs/tags/v*) to act on. Defaults to all refs.")
+		excl  = flag.String("exclude-refs", "", "Comma separated patterns of refs to ignore.")
+		all   = flag.Bool("all-commits", false, "Create statuses for every commit in the push, not just the one that was built.")
+		fails = flag.Int("failure-issue-threshold", 0, "If set, file an issue after this many consecutive failed builds of the default branch.")
+		hooks = flag.String("tag-hook-urls", "", "Comma separated URLs to notify after tags are applied.")
+		sec   = flag.String("tag-hook-secret", "", "Secret used to sign tag hook requests.")
+		tun   = flag.String("tunnel", "ngrok", "The tunnel provider to use in dev mode (ngrok, cloudflared, or a command containing {{port}}).")
+		qtok  = flag.String("quay-token", "", "The Quay API OAuth token. Enables starting builds with POST /trigger/{namespace}/{name}, which requires -admin-token.")
+		nsrc  = flag.String("notification-redis", "", "Consume Quay notifications from a Redis list (redis://[:password@]host:port/key), in addition to webhooks.")
+		qrepo = flag.String("monitor-repos", "", "Comma separated Quay repositories whose build queues should be monitored.")
+		qmax  = flag.Int("monitor-threshold", 5, "Alert when more than this many builds are waiting in a monitored repo.")
+		qorgs = flag.String("monitor-orgs", "", "Comma separated Quay organizations whose plan quota should be monitored.")
+		qquot = flag.Float64("monitor-quota-threshold", quayd.DefaultQuotaThreshold, "Alert when a monitored organization has used this fraction of its private repositories.")
+		cfg   = flag.String("config", "", "Path to a JSON or TOML config file. The file is reloaded on SIGHUP.")
+		bndl  = flag.String("config-bundle", "", "Path or URL of a signed config bundle to load configuration from.")
+		key   = flag.String("config-key", "", "Base64 encoded ed25519 public key used to verify the config bundle, and the config from the control plane.")
+		cp    = flag.String("control-plane", "", "https URL of a control plane to poll for configuration. Requires -control-plane-token and -config-key.")
+		cptok = flag.String("control-plane-token", "", "Bearer token used to authenticate with the control plane.")
+		cpsec = flag.String("control-plane-secret", "", "Secret used to sign health reports sent to the control plane.")
+		redis = flag.String("redis", "", "Address of a Redis server used to cache GitHub lookups and processed deliveries.")
+		rdpwd = flag.String("redis-password", "", "Password for the -redis server.")
+		whsec = flag.String("webhook-secret", "", "If set, webhooks must include this as the `secret` query parameter.")
+		ghsec = flag.String("github-webhook-secret", "", "If set, GitHub webhooks to POST /github must be signed with this secret.")
+		slack = flag.String("slack-signing-secret", "", "If set, enables interactive Slack actions, verified with this signing secret.")
+		slkwh = flag.String("slack-webhook-url", "", "If set, build results are posted to Slack through this incoming webhook.")
+		admin = flag.String("admin-token", "", "If set, enables the admin endpoints under /admin, authenticated with this token as a bearer token or the token query parameter.")
+		lbls  = flag.String("required-labels", "", "Comma separated image labels that successful builds must have, or \"default\" for the OCI source labels.")
+		pulls = flag.String("pull-access-namespaces", "", "Comma separated Kubernetes namespaces that must be able to pull built images. Requires running in the cluster.")
+		bakes = flag.String("auto-promote", "", "Comma separated rules, like staging=production:24h or owner/*:staging=production:24h, that promote images once they've been in the first tag for the duration without a failed build or quarantine.")
+		bnote = flag.Duration("auto-promote-notice", 0, "How long before an automatic promotion it's announced to the notifiers. Defaults to when the image starts baking.")
+		bstat = flag.String("auto-promote-state", "", "If set, images that are baking for -auto-promote are saved to this file, so they're still promoted after a restart.")
+		envs  = flag.String("environment-tags", "staging,production", "Comma separated environment tags that are removed from quarantined images.")
+		qstat = flag.String("quarantine-state", "", "If set, quarantined images are saved to this file, so they stay quarantined after a restart.")
+		cpm   = flag.Float64("cost-per-minute", 0, "Estimated cost of a minute of Quay build time, for cost attribution.")
+		tmout = flag.Duration("timeout", time.Minute, "The maximum time to spend handling a single webhook.")
+		rtry  = flag.Int("retry-attempts", quayd.DefaultRetryPolicy.MaxAttempts, "The total number of attempts, including the first, for GitHub and registry requests that fail with transient errors.")
+		rbkof = flag.Duration("retry-backoff", quayd.DefaultRetryPolicy.Backoff, "The delay before the first retry. It doubles after each attempt, up to -retry-max-backoff.")
+		rmxbo = flag.Duration("retry-max-backoff", quayd.DefaultRetryPolicy.MaxBackoff, "The longest delay between retries.")
+		rjitr = flag.Float64("retry-jitter", quayd.DefaultRetryPolicy.Jitter, "The fraction that retry delays are randomized by.")
+		ghrsv = flag.Int("github-rate-reserve", quayd.DefaultRateLimitReserve, "Hold commit statuses, rather than failing to create them, once this few GitHub API requests remain in the rate limit window. -1 disables it.")
+		mxbdy = flag.Int64("max-payload-size", 0, "The largest webhook payload, in bytes, that's accepted. Defaults to 1MB.")
+		cgit  = flag.Bool("custom-git", false, "Process builds triggered from custom git remotes, mapping a remote like git@host:org/repo.git to the repo org/repo.")
+		ipals = flag.String("ip-allowlist", "", "If set, a comma separated list of CIDRs (e.g. Quay's published IP ranges) that Quay webhooks are accepted from.")
+		trprx = flag.String("trusted-proxies", "", "A comma separated list of CIDRs of reverse proxies whose X-Forwarded-For header is trusted by -ip-allowlist.")
+		bpath = flag.String("base-path", "", "Mount every route under this path, e.g. /hooks/quayd, when quayd is behind a reverse proxy that doesn't strip it.")
+		qpath = flag.String("quay-path", "", "The path of the Quay webhook routes. Defaults to /quay.")
+		lbdgt = flag.Duration("latency-budget", 0, "If set, skip optional steps, like notifications and deployments, for build events that have taken longer than this since their webhook was received.")
+		grace = flag.Duration("shutdown-timeout", 30*time.Second, "The maximum time to spend draining in flight webhooks and the queue at shutdown.")
+		async = flag.Bool("async", false, "Acknowledge webhooks immediately and process them in the background.")
+		wrkrs = flag.Int("workers", quayd.DefaultQueueConcurrency, "The number of background workers when running with -async.")
+		spill = flag.String("spill-file", "", "If set with -async, webhooks that are still queued at shutdown are written to this file, and requeued at the next start.")
+		qsize = flag.Int("queue-size", quayd.DefaultQueueSize, "The number of webhooks that can be queued when running with -async.")
+		dlvrs = flag.String("deliveries-dir", "", "If set, received webhooks are persisted to this directory so they can be replayed after a restart.")
+		dbolt = flag.String("deliveries-bolt", "", "If set, received webhooks are persisted to this BoltDB file so they can be replayed after a restart.")
+		dpsql = flag.String("deliveries-postgres", "", "If set, received webhooks are persisted to this Postgres database, like postgres://localhost/quayd, so they can be replayed after a restart.")
+		sla   = flag.Duration("sla-target", 0, "If set, track whether commit statuses are posted within this long of receiving the webhook.")
+		slas  = flag.String("sla-targets", "", "Comma separated per repo SLA targets, like owner/repo=30s.")
+		lag   = flag.Duration("lag-threshold", 5*time.Minute, "Log when Quay takes longer than this to deliver a webhook.")
 	)
 	flag.Parse()
+	if err := setFlagsFromEnv(flag.CommandLine); err != nil {
+		log.Fatal(err)
+	}
 
-	q := quayd.New(*token, *auth)
+	if *vers {
+		fmt.Println(version)
+		return
+	}
+
+	lvl, err := quayd.ParseLevel(*level)
+	if err != nil {
+		log.Fatal(err)
+	}
+	quayd.DefaultLogger = &quayd.JSONLogger{Writer: os.Stderr, Level: lvl}
+	quayd.DefaultRegistry = *reg
+	quayd.Context = *sctx
+	if _, err := quayd.ParseTokenSource(*token); err != nil {
+		log.Fatal(err)
+	}
+	opts := []quayd.Option{quayd.WithRegistryScheme(*rschm), quayd.WithRegistryReadAuth(*rauth)}
+	if *dgst {
+		opts = append(opts, quayd.WithDigestTagging())
+	}
+	if *ghrsv >= 0 {
+		opts = append(opts, quayd.WithGitHubRateLimit(*ghrsv))
+	}
+	retry := *quayd.DefaultRetryPolicy
+	retry.MaxAttempts, retry.Backoff, retry.MaxBackoff, retry.Jitter = *rtry, *rbkof, *rmxbo, *rjitr
+	opts = append(opts, quayd.WithRetryPolicy(&retry))
+	if *chk {
+		opts = append(opts, quayd.WithChecks(*hints))
+	}
+	if *rca != "" {
+		c, err := quayd.NewRegistryClient(*rca)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, quayd.WithRegistryClient(c))
+	}
+
+	// set are the flags that were given explicitly, on the command line or
+	// through the environment.
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	var repoMap quayd.RepoMap
+	if *rmap != "" {
+		var err error
+		repoMap, err = quayd.LoadRepoMap(*rmap)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// withFlags overrides the settings of c with the flags that were given
+	// explicitly, so that they aren't dropped when quayd is configured, or
+	// reconfigured, from a config.
+	withFlags := func(c *quayd.Config) *quayd.Config {
+		for name := range set {
+			switch name {
+			case "github-token":
+				c.GitHubToken = *token
+			case "registry-auth":
+				c.RegistryAuth = *auth
+			case "registry-read-auth":
+				c.RegistryReadAuth = *rauth
+			case "registry-scheme":
+				c.RegistryScheme = *rschm
+			case "registry-ca":
+				c.RegistryCA = *rca
+			case "digest-tagging":
+				c.DigestTagging = *dgst
+			case "platform-tags":
+				c.PlatformTags = *ptags
+			case "checks":
+				c.Checks = *chk
+			case "required-check-hints":
+				c.RequiredCheckHints = *hints
+			case "read-only":
+				c.ReadOnly = *ro
+			case "repo-map":
+				c.Repos = repoMap
+			case "commit-directives":
+				if !*dirs {
+					c.Directives = nil
+				} else if c.Directives == nil {
+					c.Directives = map[string]string{}
+				}
+			case "cancelled-state":
+				c.CancelledState = *cstat
+			case "security-scans", "scan-fail-severity", "scan-threshold":
+				if *scans {
+					c.SecurityScans = &quayd.SecurityScans{FailSeverity: *sevr, Threshold: *sthr}
+				} else {
+					c.SecurityScans = nil
+				}
+			case "release-assets":
+				c.ReleaseAssets = *rels
+			case "rebuild-comments":
+				if !*rbld {
+					c.Rebuilds = nil
+				} else if c.Rebuilds == nil {
+					c.Rebuilds = &quayd.Rebuilds{}
+				}
+			case "cleanup-branches":
+				if !*clean {
+					c.BranchCleanup = nil
+				} else if c.BranchCleanup == nil {
+					c.BranchCleanup = &quayd.BranchCleanup{}
+				}
+			case "semver-tags":
+				if !*semvr {
+					c.SemVerTags = nil
+				} else if c.SemVerTags == nil {
+					c.SemVerTags = []string{}
+				}
+			case "include-refs", "exclude-refs":
+				c.Refs = &quayd.RefFilter{Include: splitList(*incl), Exclude: splitList(*excl)}
+			case "all-commits":
+				c.AllCommits = *all
+			case "failure-issue-threshold":
+				c.FailureIssueThreshold = *fails
+			case "tag-hook-urls":
+				c.TagHookURLs = splitList(*hooks)
+			case "tag-hook-secret":
+				c.TagHookSecret = *sec
+			case "quay-token":
+				c.QuayToken = *qtok
+			case "webhook-secret":
+				c.WebhookSecret = *whsec
+			case "github-webhook-secret":
+				c.GitHubWebhookSecret = *ghsec
+			case "slack-signing-secret":
+				c.SlackSigningSecret = *slack
+			case "slack-webhook-url":
+				c.SlackWebhookURL = *slkwh
+			case "admin-token":
+				c.AdminToken = *admin
+			case "required-labels":
+				switch *lbls {
+				case "":
+					c.RequiredLabels = nil
+				case "default":
+					c.RequiredLabels = []string{}
+				default:
+					c.RequiredLabels = splitList(*lbls)
+				}
+			case "custom-git":
+				if !*cgit {
+					c.GitRemotes = nil
+				} else if c.GitRemotes == nil {
+					c.GitRemotes = map[string]string{}
+				}
+			case "github-rate-reserve":
+				c.GitHubRateReserve = *ghrsv
+			case "max-payload-size":
+				c.MaxPayloadSize = *mxbdy
+			case "retry-attempts", "retry-backoff", "retry-max-backoff", "retry-jitter":
+				if c.Retry == nil {
+					c.Retry = &quayd.RetryConfig{}
+				}
+				jitter := *rjitr
+				c.Retry.MaxAttempts, c.Retry.Backoff, c.Retry.MaxBackoff, c.Retry.Jitter = *rtry, rbkof.String(), rmxbo.String(), &jitter
+			}
+		}
+		return c
+	}
+
+	var q *quayd.Quayd
+	switch flag.Arg(0) {
+	case "rules", "dashboard":
+		// Print observability assets generated from quayd's metrics.
+		var repos []string
+		if *qrepo != "" {
+			repos = strings.Split(*qrepo, ",")
+		}
+
+		generate := func(repos []string) ([]byte, error) {
+			return quayd.AlertingRules(repos, *qquot)
+		}
+		if flag.Arg(0) == "dashboard" {
+			generate = quayd.GrafanaDashboard
+		}
+
+		raw, err := generate(repos)
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Stdout.Write(raw)
+		return
+	case "plan", "apply":
+		// Compare the live state against the managed state in the
+		// config, and print (or make) the changes.
+		if *cfg == "" {
+			log.Fatalf("%s requires -config", flag.Arg(0))
+		}
+		c, err := quayd.LoadConfig(*cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if c.Managed == nil {
+			log.Fatal("the config doesn't have a managed section")
+		}
+		q, err = quayd.NewFromConfig(c)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ctx := context.Background()
+		plan, err := q.Plan(ctx, c.Managed, &quayd.GitHubBranchProtection{Client: quayd.NewGitHubClient(c.GitHubToken)})
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Print(plan)
+		if flag.Arg(0) == "apply" && len(plan) > 0 {
+			if err := q.Apply(ctx, plan); err != nil {
+				log.Fatal(err)
+			}
+			fmt.Printf("Apply complete! Resources: %d changed.\n", len(plan))
+		}
+		return
+	case "schema":
+		// Print the JSON Schema for published build events.
+		fmt.Print(quayd.BuildEventSchema)
+		return
+	case "demo":
+		// Run entirely in memory, without any credentials.
+		q = quayd.NewDemo(quayd.DemoRepos)
+	case "dev":
+		// Expose the local server through a public tunnel, so real Quay
+		// webhooks can be received.
+		q = quayd.New(*token, *auth, opts...)
+	default:
+		if *cfg != "" {
+			c, err := quayd.LoadConfig(*cfg)
+			if err != nil {
+				log.Fatal(err)
+			}
+			q, err = quayd.NewFromConfig(withFlags(c))
+			if err != nil {
+				log.Fatal(err)
+			}
+		} else if *bndl != "" {
+			c, err := loadBundle(*bndl, *key)
+			if err != nil {
+				log.Fatal(err)
+			}
+			q, err = quayd.NewFromConfig(withFlags(c))
+			if err != nil {
+				log.Fatal(err)
+			}
+		} else {
+			q = quayd.New(*token, *auth, opts...)
+			q.AllCommits = *all
+			if *incl != "" || *excl != "" {
+				refs, err := quayd.NewRefFilter(splitList(*incl), splitList(*excl))
+				if err != nil {
+					log.Fatal(err)
+				}
+				q.RefFilter = refs
+			}
+			if *semvr {
+				q.SemVer = &quayd.SemVerTags{}
+			}
+			q.PlatformTags = *ptags
+			if *cgit {
+				q.RefResolver = &quayd.GitURLRefResolver{}
+			}
+			if *clean {
+				q.Cleanup = &quayd.BranchCleanup{}
+			}
+			if *rels {
+				q.ReleaseAssets = &quayd.ReleaseAssets{Releases: &quayd.GitHubReleaseAssetService{Client: quayd.NewGitHubClient(*token)}}
+			}
+			if *rbld {
+				if *qtok == "" {
+					log.Fatal("-rebuild-comments requires -quay-token")
+				}
+				q.Rebuilds = &quayd.Rebuilds{PullRequests: &quayd.GitHubPullRequestResolver{Client: quayd.NewGitHubClient(*token)}}
+			}
+			if *cstat != "error" && *cstat != "failure" {
+				log.Fatalf("invalid -cancelled-state: %s", *cstat)
+			}
+			q.CancelledState = *cstat
+			if *dirs {
+				q.Directives = &quayd.Directives{Messages: &quayd.GitHubCommitMessageResolver{RepositoriesService: quayd.NewGitHubClient(*token).Repositories}}
+			}
+			if *scans {
+				q.SecurityScans = &quayd.SecurityScans{FailSeverity: *sevr, Threshold: *sthr}
+			}
+			if *lbls != "" {
+				policy := &quayd.LabelPolicy{}
+				if *lbls != "default" {
+					policy.Required = splitList(*lbls)
+				}
+				read := *rauth
+				if read == "" {
+					read = *auth
+				}
+				if parts := strings.SplitN(read, ":", 2); len(parts) == 2 {
+					policy.Username, policy.Password = parts[0], parts[1]
+				}
+				q.LabelPolicy = policy
+			}
+			q.ReadOnly = *ro
+			if repoMap != nil {
+				q.RepoMapper = repoMap
+			}
+			q.SlackSigningSecret = *slack
+			if *slkwh != "" {
+				q.Notifiers = append(q.Notifiers, &quayd.SlackNotifier{WebhookURL: *slkwh, Actions: *slack != ""})
+			}
+			q.AdminToken = *admin
+			if *fails > 0 {
+				q.FailureIssues = &quayd.FailureIssues{Issues: quayd.NewGitHubClient(*token).Issues, Threshold: *fails}
+			}
+			if *whsec != "" {
+				q.WebhookValidators = quayd.WebhookValidators{"*": &quayd.SharedSecretValidator{Secret: *whsec}}
+			}
+			if *ghsec != "" {
+				if q.WebhookValidators == nil {
+					q.WebhookValidators = quayd.WebhookValidators{}
+				}
+				q.WebhookValidators["github"] = &quayd.HMACValidator{Secret: *ghsec, Header: quayd.GitHubSignatureHeader}
+			}
+			if *hooks != "" {
+				q.TagHook = &quayd.WebhookTagHook{URLs: strings.Split(*hooks, ","), Secret: *sec}
+			}
+		}
+	}
+	var (
+		stats      = &quayd.LagStats{Threshold: *lag}
+		timelines  = &quayd.Timelines{}
+		metrics    = &quayd.Metrics{}
+		deps       = &quayd.Dependencies{}
+		events     = &quayd.EventStream{}
+		tail       = &quayd.LogTail{}
+		attempts   = &quayd.Attempts{}
+		targets    *quayd.SLA
+		quarantine = &quayd.Quarantines{EnvironmentTags: strings.Split(*envs, ","), Path: *qstat}
+		costs      = &quayd.Costs{CostPerMinute: *cpm}
+		durations  = &quayd.DurationMonitor{}
+		pauses     = &quayd.Pauses{}
+		ignored    = &quayd.IgnoredEvents{}
+		autoPromos *quayd.AutoPromotions
+		allowlist  *quayd.IPAllowlist
+		cache      quayd.Cache
+		dedupe     quayd.DedupeStore   = &quayd.MemoryDedupeStore{}
+		deliveries quayd.DeliveryStore = &quayd.MemoryDeliveryStore{}
+		queue      *quayd.Queue
+		pullAccess *quayd.PullAccessCheck
+		monitor    *quayd.QueueMonitor
+		quay       *quayd.QuayClient
+	)
+	if *qtok != "" {
+		quay = &quayd.QuayClient{Token: *qtok}
+	}
+	if err := quarantine.Load(); err != nil {
+		log.Fatal(err)
+	}
+	if *bakes != "" {
+		rules, err := quayd.ParseBakeRules(*bakes)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, r := range rules {
+			r.Notice = *bnote
+		}
+		autoPromos = &quayd.AutoPromotions{Rules: rules, Path: *bstat}
+		if err := autoPromos.Load(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *sla > 0 {
+		targets = &quayd.SLA{Target: *sla, Repos: make(map[string]time.Duration)}
+		for _, t := range strings.Split(*slas, ",") {
+			if t == "" {
+				continue
+			}
+			parts := strings.SplitN(t, "=", 2)
+			d, err := time.ParseDuration(parts[len(parts)-1])
+			if err != nil || len(parts) != 2 {
+				log.Fatalf("invalid sla target: %s", t)
+			}
+			targets.Repos[parts[0]] = d
+		}
+	}
+	if *async {
+		queue = &quayd.Queue{Concurrency: *wrkrs, Size: *qsize}
+		queue.Start()
+	}
+	switch {
+	case *dpsql != "":
+		s, err := quayd.NewPostgresDeliveryStore(*dpsql)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer s.Close()
+		deliveries = s
+	case *dbolt != "":
+		s, err := quayd.NewBoltDeliveryStore(*dbolt)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer s.Close()
+		deliveries = s
+	case *dlvrs != "":
+		deliveries = &quayd.FileDeliveryStore{Dir: *dlvrs}
+	}
+	if *redis != "" {
+		cache = &quayd.RedisCache{Addr: *redis, Password: *rdpwd}
+		dedupe = &quayd.CacheDedupeStore{Cache: cache, TTL: 24 * time.Hour}
+	}
+	if *pulls != "" {
+		k, err := quayd.NewInClusterKubernetesClient()
+		if err != nil {
+			log.Fatal(err)
+		}
+		pullAccess = &quayd.PullAccessCheck{Kubernetes: k, Namespaces: strings.Split(*pulls, ",")}
+	}
+	if *qrepo != "" || *qorgs != "" {
+		monitor = &quayd.QueueMonitor{Token: *qtok, Repos: splitList(*qrepo), Orgs: splitList(*qorgs), Threshold: *qmax, QuotaThreshold: *qquot, Dependencies: deps}
+	}
+	if *ipals != "" {
+		var err error
+		allowlist, err = quayd.ParseIPAllowlist(splitList(*ipals), splitList(*trprx))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// configure applies the settings that are shared by every Quayd
+	// instance that this process runs.
+	configure := func(q *quayd.Quayd) *quayd.Quayd {
+		q.Stats = stats
+		q.Timeout = *tmout
+		q.Ignored = ignored
+		if allowlist != nil {
+			q.IPAllowlist = allowlist
+		}
+		q.LatencyBudget = *lbdgt
+		if *mxbdy > 0 {
+			q.MaxPayloadSize = *mxbdy
+		}
+		// -read-only is honoured in every mode, so nothing is ever written
+		// when it's given.
+		if *ro {
+			q.ReadOnly = true
+		}
+		if *bpath != "" {
+			q.BasePath = *bpath
+		}
+		if *qpath != "" {
+			q.QuayPath = *qpath
+		}
+		q.Timelines = timelines
+		q.Metrics = metrics
+		q.Dependencies = deps
+		q.Events = events
+		q.Tail = tail
+		q.Attempts = attempts
+		q.Quarantines = quarantine
+		q.SLA = targets
+		q.Costs = costs
+		q.Durations = durations
+		q.Pauses = pauses
+		q.AutoPromotions = autoPromos
+		q.Queue = queue
+		q.Deliveries = deliveries
+		q.Dedupe = dedupe
+		q.PullAccess = pullAccess
+		q.QueueMonitor = monitor
+		if monitor != nil {
+			monitor.SetNotifiers(q.Notifiers)
+		}
+		if quay != nil {
+			q.Quay = quay
+		}
+		if cache != nil {
+			q.CommitResolver = &quayd.CachedCommitResolver{CommitResolver: q.CommitResolver, Cache: cache, TTL: 24 * time.Hour}
+			for _, t := range q.Tenants {
+				t.CommitResolver = &quayd.CachedCommitResolver{CommitResolver: t.CommitResolver, Cache: cache, TTL: 24 * time.Hour}
+			}
+			// Static repo maps are already cheap to look up.
+			if _, static := q.RepoMapper.(quayd.RepoMap); q.RepoMapper != nil && !static {
+				q.RepoMapper = &quayd.CachedRepoMapper{RepoMapper: q.RepoMapper, Cache: cache, TTL: time.Hour}
+			}
+		}
+		return q
+	}
+	configure(q)
+	switch flag.Arg(0) {
+	case "demo":
+		log.Printf("Running in demo mode. Try: curl -X POST -d '{\"repository\":\"%s\",\"trigger_kind\":\"github\",\"docker_tags\":[\"test\"],\"build_name\":\"f1fb3b0\"}' http://localhost:%s%s", quayd.DemoRepos[0], *port, q.WebhookPath("success"))
+	case "dev":
+		go func() {
+			if err := tunnel(*tun, *port, q.WebhookPath); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+	if queue != nil && *spill != "" {
+		n, err := queue.LoadSpill(q, *spill)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if n > 0 {
+			log.Printf("requeued %d events from %s", n, *spill)
+		}
+	}
+	if monitor != nil {
+		go monitor.Run(time.Minute, nil)
+	}
 	s := quayd.NewServer(q)
+	if autoPromos != nil {
+		go quayd.RunAutoPromotions(s.Quayd, time.Minute, nil)
+	}
+
+	consume, stopConsuming := context.WithCancel(context.Background())
+	defer stopConsuming()
+	if *nsrc != "" {
+		src, err := quayd.ParseRedisSource(*nsrc)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c := &quayd.Consumer{Source: src, Quayd: s.Quayd}
+		go c.Run(consume)
+	}
+
+	if *cp != "" {
+		pub, err := parseKey(*key)
+		if err != nil {
+			log.Fatal(err)
+		}
+		host, _ := os.Hostname()
+		c := &quayd.ControlPlane{
+			URL:      *cp,
+			Token:    *cptok,
+			Key:      pub,
+			Instance: host,
+			Secret:   *cpsec,
+			Apply: func(c *quayd.Config) error {
+				// An invalid config leaves the current one in place.
+				q, err := quayd.NewFromConfig(withFlags(c))
+				if err != nil {
+					return err
+				}
+				s.Reload(configure(q))
+				return nil
+			},
+		}
+		go c.Run(30*time.Second, nil)
+	}
+
+	if *cfg != "" {
+		// Reload the config file on SIGHUP. Webhooks that are in flight
+		// finish with the configuration they started with.
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				c, err := quayd.LoadConfig(*cfg)
+				if err != nil {
+					log.Printf("config reload failed: %v", err)
+					continue
+				}
+				q, err := quayd.NewFromConfig(withFlags(c))
+				if err != nil {
+					log.Printf("config reload failed, keeping the current config: %v", err)
+					continue
+				}
+				s.Reload(configure(q))
+				log.Printf("reloaded config from %s", *cfg)
+			}
+		}()
+	}
+
+	if *addr == "" {
+		*addr = ":" + *port
+	}
+	srv := &http.Server{Addr: *addr, Handler: s}
+
+	// On shutdown, stop accepting webhooks and drain the queue. Events
+	// that are still queued at the deadline are spilled to disk, so they
+	// aren't lost across restarts.
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-term
+		stopConsuming()
+		ctx, cancel := context.WithTimeout(context.Background(), *grace)
+		defer cancel()
+
+		if err := s.Shutdown(ctx); err != nil {
+			log.Printf("shutdown: %v", err)
+			if queue != nil && *spill != "" {
+				if err := queue.Spill(*spill); err != nil {
+					log.Fatal(err)
+				}
+			}
+		}
+		srv.Close()
+	}()
 
-	log.Fatal(http.ListenAndServe(":"+*port, s))
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
 }
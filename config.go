@@ -0,0 +1,606 @@
+package quayd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Config is the configuration for a Quayd instance.
+type Config struct {
+	// GitHubToken is the GitHub API token used to create commit statuses,
+	// or a source of rotating tokens. See ParseTokenSource.
+	GitHubToken string `json:"github_token"`
+
+	// GitHubURL, if set, is the GitHub API that commit statuses are created
+	// with, like a GitHub Enterprise API. Defaults to api.github.com.
+	GitHubURL string `json:"github_url"`
+
+	// RegistryAuth is the `username:password` used to tag images.
+	RegistryAuth string `json:"registry_auth"`
+
+	// RegistryReadAuth is the `username:password` used to read from the
+	// registry, such as to resolve tags and check labels. It should be a
+	// read-only credential, so that only RegistryAuth holds write scope.
+	// Defaults to RegistryAuth for label checks, and anonymous reads
+	// otherwise.
+	RegistryReadAuth string `json:"registry_read_auth"`
+
+	// Registry is the registry host that images are tagged in. Defaults to
+	// DefaultRegistry.
+	Registry string `json:"registry"`
+
+	// RegistryScheme is the URL scheme used to reach the registry.
+	// Defaults to https.
+	RegistryScheme string `json:"registry_scheme"`
+
+	// DigestTagging, if true, resolves tags to manifest digests and tags
+	// images by digest with the v2 registry api, instead of by v1 image id.
+	DigestTagging bool `json:"digest_tagging"`
+
+	// PlatformTags, if true, also tags the platform specific manifests of
+	// multi-arch images with the git sha and their platform. It requires
+	// DigestTagging.
+	PlatformTags bool `json:"platform_tags"`
+
+	// RegistryCA is the path to PEM encoded CA certificates that the
+	// registry's certificate is trusted with, for registries behind a
+	// private CA.
+	RegistryCA string `json:"registry_ca"`
+
+	// Tenants configures the credentials used for particular GitHub owners
+	// or `owner/repo`s, when they differ from the defaults above.
+	Tenants map[string]*TenantConfig `json:"tenants"`
+
+	// Checks creates GitHub Check Runs instead of commit statuses.
+	Checks bool `json:"checks"`
+
+	// RequiredCheckHints notes in each Check Run whether it's required by
+	// the branch protection of the branch that was built.
+	RequiredCheckHints bool `json:"required_check_hints"`
+
+	// Routes configures per media type handling of builds.
+	Routes Routes `json:"routes"`
+
+	// Repos maps Quay repos to the GitHub repos they're built from.
+	Repos RepoMap `json:"repos"`
+
+	// Contexts maps a repo to the commit status context to use for it.
+	Contexts map[string]string `json:"contexts"`
+
+	// Policies restricts which parts of the pipeline run for each repo.
+	Policies Policies `json:"policies"`
+
+	// ReadOnly disables all writes to GitHub and the registry.
+	ReadOnly bool `json:"read_only"`
+
+	// AllCommits enables statuses for every commit in a push.
+	AllCommits bool `json:"all_commits"`
+
+	// Refs limits the branches and tags that are acted on.
+	Refs *RefFilter `json:"refs"`
+
+	// Environments maps branches and tags to the GitHub environments that
+	// successful builds are deployed to.
+	Environments []*EnvironmentRule `json:"environments"`
+
+	// GitOps, if set, opens pull requests that bump images in GitOps
+	// repos.
+	GitOps *GitOpsConfig `json:"gitops"`
+
+	// QuayToken, if set, is an OAuth token for the Quay API, which enables
+	// starting builds with POST /trigger/{namespace}/{name}.
+	QuayToken string `json:"quay_token"`
+
+	// ReleaseAssets, if true, attaches a digest file describing the image
+	// to the GitHub release of builds of git tags.
+	ReleaseAssets bool `json:"release_assets"`
+
+	// Managed is the live state that `quayd plan` and `quayd apply`
+	// manage.
+	Managed *ManagedState `json:"managed"`
+
+	// States, if set, overrides how build states are reported as commit
+	// statuses, and adds custom states.
+	States StateMappings `json:"states"`
+
+	// MaxPayloadSize is the largest webhook payload, in bytes, that's
+	// accepted.
+	MaxPayloadSize int64 `json:"max_payload_size"`
+
+	// GitHubRateReserve is the number of GitHub API requests to keep in
+	// reserve; commit statuses are held until the quota resets once it's
+	// reached. Defaults to DefaultRateLimitReserve, and -1 disables it.
+	GitHubRateReserve int `json:"github_rate_reserve"`
+
+	// Retry overrides DefaultRetryPolicy for GitHub and registry requests.
+	Retry *RetryConfig `json:"retry"`
+
+	// BasePath and QuayPath set where the routes are mounted. See Quayd.
+	BasePath string `json:"base_path"`
+	QuayPath string `json:"quay_path"`
+
+	// GitRemotes, if set, processes builds from custom git remotes, mapping
+	// their git URLs to repos. Remotes that aren't listed are mapped by
+	// their path. See GitURLRefResolver.
+	GitRemotes map[string]string `json:"git_remotes"`
+
+	// IPAllowlist, if set, only accepts Quay webhooks from these networks
+	// (CIDRs or IPs), trusting X-Forwarded-For from TrustedProxies.
+	IPAllowlist    []string `json:"ip_allowlist"`
+	TrustedProxies []string `json:"trusted_proxies"`
+
+	// Rebuilds, if set, restarts the builds of pull requests that are
+	// commented with `/rebuild`. It requires QuayToken.
+	Rebuilds *Rebuilds `json:"rebuilds"`
+
+	// Approvals, if set, requires promotions to some tags to be approved
+	// in a pull request in a deploys repo.
+	Approvals *Approvals `json:"approvals"`
+
+	// Rules decide which actions are taken for each build event, like
+	// `repo.startsWith("infra/") && branch == "main" -> [status, tag,
+	// promote(staging)]`. See EventRule.
+	Rules []string `json:"rules"`
+
+	// Directives, if set, enables commit message directives, mapping each
+	// directive to its action (skip, no-tags or no-floating). An empty map
+	// uses DefaultDirectives.
+	Directives map[string]string `json:"directives"`
+
+	// CancelledState is the state, "error" or "failure", that cancelled
+	// builds are reported with. Defaults to "error".
+	CancelledState string `json:"cancelled_state"`
+
+	// SecurityScans, if set, reports Quay security scan notifications
+	// (POST /quay/scan) as an "Image Security Scan" status.
+	SecurityScans *SecurityScans `json:"security_scans"`
+
+	// BranchCleanup, if set, removes the image tags of branches when
+	// they're deleted on GitHub.
+	BranchCleanup *BranchCleanup `json:"branch_cleanup"`
+
+	// SemVerTags, if set, are the floating tag rules applied to images
+	// built from semver git tags. An empty list uses DefaultSemVerRules.
+	SemVerTags []string `json:"semver_tags"`
+
+	// RequiredLabels, if set, fails the status of images that are missing
+	// any of these labels. An empty list uses DefaultRequiredLabels.
+	RequiredLabels []string `json:"required_labels"`
+
+	// FailureIssueThreshold, if set, files an issue after this many
+	// consecutive failed builds of the default branch.
+	FailureIssueThreshold int `json:"failure_issue_threshold"`
+
+	// TagHookURLs are notified after tags are applied.
+	TagHookURLs []string `json:"tag_hook_urls"`
+
+	// TagHookSecret is used to sign tag hook requests.
+	TagHookSecret string `json:"tag_hook_secret"`
+
+	// SlackSigningSecret, if set, enables interactive Slack actions.
+	SlackSigningSecret string `json:"slack_signing_secret"`
+
+	// SlackWebhookURL, if set, posts build results to a Slack channel.
+	SlackWebhookURL string `json:"slack_webhook_url"`
+
+	// AdminToken, if set, enables the admin endpoints.
+	AdminToken string `json:"admin_token"`
+
+	// Canary, if set, compares an alternate configuration against this one
+	// for a fraction of build events.
+	Canary *CanaryConfig `json:"canary"`
+
+	// WebhookSecret, if set, is required as the `secret` query parameter on
+	// incoming webhooks.
+	WebhookSecret string `json:"webhook_secret"`
+
+	// GitHubWebhookSecret, if set, is the secret that GitHub webhooks to
+	// POST /github are signed with. It's required to approve promotions.
+	GitHubWebhookSecret string `json:"github_webhook_secret"`
+}
+
+// TenantConfig configures the credentials for a Tenant. Empty credentials
+// default to those of the Config.
+type TenantConfig struct {
+	GitHubToken      string `json:"github_token"`
+	RegistryAuth     string `json:"registry_auth"`
+	RegistryReadAuth string `json:"registry_read_auth"`
+
+	// GitLabToken, if set, creates commit statuses on GitLab instead of
+	// GitHub, for repos whose source lives there.
+	GitLabToken string `json:"gitlab_token"`
+
+	// GitLabURL is the base URL of the GitLab API. Defaults to
+	// DefaultGitLabURL.
+	GitLabURL string `json:"gitlab_url"`
+}
+
+// RetryConfig configures the RetryPolicy. Zero values default to those of
+// DefaultRetryPolicy.
+type RetryConfig struct {
+	MaxAttempts int `json:"max_attempts"`
+
+	// Backoff and MaxBackoff are durations, like "500ms".
+	Backoff    string `json:"backoff"`
+	MaxBackoff string `json:"max_backoff"`
+
+	// Jitter, if set, overrides the fraction that delays are randomized
+	// by. 0 disables jitter.
+	Jitter *float64 `json:"jitter"`
+
+	RetryableStatusCodes []int `json:"retryable_status_codes"`
+}
+
+// policy returns the RetryPolicy for the config, or an error if a duration
+// is invalid.
+func (c *RetryConfig) policy() (*RetryPolicy, error) {
+	if c == nil {
+		return DefaultRetryPolicy, nil
+	}
+
+	p := *DefaultRetryPolicy
+	if c.MaxAttempts > 0 {
+		p.MaxAttempts = c.MaxAttempts
+	}
+	for _, d := range []struct {
+		value string
+		dst   *time.Duration
+	}{
+		{c.Backoff, &p.Backoff},
+		{c.MaxBackoff, &p.MaxBackoff},
+	} {
+		if d.value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(d.value)
+		if err != nil {
+			return nil, fmt.Errorf("retry: %s", err)
+		}
+		*d.dst = duration
+	}
+	if c.Jitter != nil {
+		p.Jitter = *c.Jitter
+	}
+	if c.RetryableStatusCodes != nil {
+		p.RetryableStatusCodes = c.RetryableStatusCodes
+	}
+	return &p, nil
+}
+
+// GitOpsConfig configures GitOps.
+type GitOpsConfig struct {
+	Rules []*GitOpsRule `json:"rules"`
+
+	// Window is how long bumps are batched for, as a duration like "10m".
+	// Defaults to DefaultGitOpsWindow.
+	Window string `json:"window"`
+
+	// SigningKey is the path to an ed25519 SSH private key used to sign
+	// commits.
+	SigningKey string `json:"signing_key"`
+}
+
+// CanaryConfig configures a Canary.
+type CanaryConfig struct {
+	// Percent is the percentage of build events to compare.
+	Percent float64 `json:"percent"`
+
+	// Config is the alternate configuration. Credentials default to those
+	// of the primary configuration.
+	Config *Config `json:"config"`
+}
+
+// registryOptions returns the Options for reaching the configured registry,
+// or an error if the retry policy or CA file is invalid.
+func registryOptions(c *Config) ([]Option, error) {
+	var opts []Option
+	if c.RegistryScheme != "" {
+		opts = append(opts, WithRegistryScheme(c.RegistryScheme))
+	}
+	if c.RegistryReadAuth != "" {
+		opts = append(opts, WithRegistryReadAuth(c.RegistryReadAuth))
+	}
+	if c.DigestTagging {
+		opts = append(opts, WithDigestTagging())
+	}
+	if c.GitHubRateReserve >= 0 {
+		opts = append(opts, WithGitHubRateLimit(c.GitHubRateReserve))
+	}
+	if c.GitHubURL != "" {
+		opts = append(opts, WithGitHubURL(c.GitHubURL))
+	}
+	if c.Retry != nil {
+		policy, err := c.Retry.policy()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithRetryPolicy(policy))
+	}
+	if c.Checks {
+		opts = append(opts, WithChecks(c.RequiredCheckHints))
+	}
+	if c.RegistryCA != "" {
+		client, err := NewRegistryClient(c.RegistryCA)
+		if err != nil {
+			return nil, fmt.Errorf("registry: %s", err)
+		}
+		opts = append(opts, WithRegistryClient(client))
+	}
+	return opts, nil
+}
+
+// newGitOps returns the GitOps for the config, or an error if the window or
+// signing key is invalid.
+func newGitOps(c *GitOpsConfig, token string) (*GitOps, error) {
+	service := &GitHubGitOpsService{Client: NewGitHubClient(token)}
+	g := &GitOps{Rules: c.Rules, Service: service}
+
+	if c.Window != "" {
+		window, err := time.ParseDuration(c.Window)
+		if err != nil {
+			return nil, fmt.Errorf("gitops: invalid window: %s", err)
+		}
+		g.Window = window
+	}
+
+	if c.SigningKey != "" {
+		raw, err := ioutil.ReadFile(c.SigningKey)
+		var signer *SSHCommitSigner
+		if err == nil {
+			signer, err = ParseSSHCommitSigner(raw)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("gitops: invalid signing key: %s", err)
+		}
+		service.Signer = signer
+	}
+
+	return g, nil
+}
+
+// DecodeConfig decodes a JSON encoded Config from r.
+func DecodeConfig(r io.Reader) (*Config, error) {
+	var c Config
+	if err := json.NewDecoder(r).Decode(&c); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// LoadConfig loads a Config from the file at path. Files ending in .toml are
+// decoded as TOML, and anything else as JSON.
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if filepath.Ext(path) != ".toml" {
+		return DecodeConfig(f)
+	}
+
+	var tree map[string]interface{}
+	if _, err := toml.NewDecoder(f).Decode(&tree); err != nil {
+		return nil, err
+	}
+
+	// Round trip through JSON, so that the same field names are used in
+	// both formats.
+	raw, err := json.Marshal(tree)
+	if err != nil {
+		return nil, err
+	}
+
+	var c Config
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// NewFromConfig returns a new Quayd instance backed by GitHub
+// implementations, configured from c. It returns an error if any part of c
+// is invalid, so that a typo can't start quayd, or reload it, with a
+// feature silently disabled.
+func NewFromConfig(c *Config) (*Quayd, error) {
+	registry := c.Registry
+	if registry == "" {
+		registry = DefaultRegistry
+	}
+
+	opts, err := registryOptions(c)
+	if err != nil {
+		return nil, err
+	}
+	retry, err := c.Retry.policy()
+	if err != nil {
+		return nil, err
+	}
+	q := newQuayd(c.GitHubToken, c.RegistryAuth, registry, opts...)
+	q.Routes = c.Routes
+	for key, t := range c.Tenants {
+		token, auth := t.GitHubToken, t.RegistryAuth
+		if token == "" {
+			token = c.GitHubToken
+		}
+		if auth == "" {
+			auth = c.RegistryAuth
+		}
+		if q.Tenants == nil {
+			q.Tenants = make(Tenants)
+		}
+		topts := opts
+		if t.RegistryReadAuth != "" {
+			topts = append(opts[:len(opts):len(opts)], WithRegistryReadAuth(t.RegistryReadAuth))
+		}
+		tenant := NewTenant(token, auth, registry, topts...)
+		if t.GitLabToken != "" {
+			gitlab := &GitLabClient{Token: t.GitLabToken, URL: t.GitLabURL}
+			tenant.StatusesRepository = &RetryStatusesRepository{
+				StatusesRepository: NewGitLabStatusesRepository(gitlab),
+				Policy:             retry,
+			}
+			tenant.Checks = nil
+			tenant.CommitResolver = &GitLabCommitResolver{gitlab}
+		}
+		q.Tenants[key] = tenant
+	}
+	q.Contexts = c.Contexts
+	if c.Repos != nil {
+		q.RepoMapper = c.Repos
+	}
+	q.Policies = c.Policies
+	q.AllCommits = c.AllCommits
+	if err := c.Refs.Compile(); err != nil {
+		return nil, err
+	}
+	q.RefFilter = c.Refs
+	if len(c.Environments) > 0 {
+		q.Environments = &Environments{
+			Rules:       c.Environments,
+			Deployments: &GitHubDeploymentsService{Client: NewGitHubClient(c.GitHubToken)},
+		}
+	}
+	if c.GitOps != nil && len(c.GitOps.Rules) > 0 {
+		q.GitOps, err = newGitOps(c.GitOps, c.GitHubToken)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.RequiredLabels != nil {
+		read := c.RegistryReadAuth
+		if read == "" {
+			read = c.RegistryAuth
+		}
+		q.LabelPolicy = newLabelPolicy(c.RequiredLabels, read)
+		if c.RegistryScheme != "" {
+			q.LabelPolicy.RegistryURL = registryURL(c.RegistryScheme, registry)
+		}
+		q.LabelPolicy.Client = newOptions(opts).registryClient
+	}
+	q.PlatformTags = c.PlatformTags
+	q.Cleanup = c.BranchCleanup
+	q.SecurityScans = c.SecurityScans
+	if c.QuayToken != "" {
+		q.Quay = &QuayClient{Token: c.QuayToken}
+	}
+	if c.ReleaseAssets {
+		q.ReleaseAssets = &ReleaseAssets{Releases: &GitHubReleaseAssetService{Client: NewGitHubClient(c.GitHubToken)}}
+	}
+	if err := c.States.Validate(); err != nil {
+		return nil, fmt.Errorf("states: %v", err)
+	}
+	q.States = c.States
+	q.MaxPayloadSize = c.MaxPayloadSize
+	q.BasePath, q.QuayPath = c.BasePath, c.QuayPath
+	if c.GitRemotes != nil {
+		q.RefResolver = &GitURLRefResolver{Repos: c.GitRemotes}
+	}
+	if len(c.IPAllowlist) > 0 {
+		allowlist, err := ParseIPAllowlist(c.IPAllowlist, c.TrustedProxies)
+		if err != nil {
+			return nil, fmt.Errorf("ip_allowlist: %v", err)
+		}
+		q.IPAllowlist = allowlist
+	}
+	if c.Rebuilds != nil {
+		q.Rebuilds = c.Rebuilds
+		q.Rebuilds.PullRequests = &GitHubPullRequestResolver{Client: NewGitHubClient(c.GitHubToken)}
+		if q.Quay == nil {
+			return nil, errors.New("rebuilds require quay_token")
+		}
+	}
+	for _, source := range c.Rules {
+		rule, err := ParseEventRule(source)
+		if err != nil {
+			return nil, fmt.Errorf("rules: %v", err)
+		}
+		q.EventRules = append(q.EventRules, rule)
+	}
+	if c.Approvals != nil {
+		if c.Approvals.Repo == "" {
+			return nil, errors.New("approvals: repo is required")
+		}
+		q.Approvals = c.Approvals
+		q.Approvals.Service = &GitHubApprovalService{Client: NewGitHubClient(c.GitHubToken)}
+	}
+	if c.Directives != nil {
+		q.Directives = &Directives{
+			Messages: &GitHubCommitMessageResolver{NewGitHubClient(c.GitHubToken).Repositories},
+			Rules:    c.Directives,
+		}
+	}
+	if c.CancelledState != "" {
+		if c.CancelledState != "error" && c.CancelledState != "failure" {
+			return nil, fmt.Errorf("invalid cancelled_state: %s", c.CancelledState)
+		}
+		q.CancelledState = c.CancelledState
+	}
+	if c.SemVerTags != nil {
+		q.SemVer = &SemVerTags{Rules: c.SemVerTags}
+	}
+	q.ReadOnly = c.ReadOnly
+	if c.FailureIssueThreshold > 0 {
+		q.FailureIssues = &FailureIssues{
+			Issues:    NewGitHubClient(c.GitHubToken).Issues,
+			Threshold: c.FailureIssueThreshold,
+		}
+	}
+	q.SlackSigningSecret = c.SlackSigningSecret
+	if c.SlackWebhookURL != "" {
+		q.Notifiers = append(q.Notifiers, &SlackNotifier{WebhookURL: c.SlackWebhookURL, Actions: c.SlackSigningSecret != ""})
+	}
+	for _, route := range q.Routes {
+		if route.SlackWebhookURL != "" {
+			route.Notifiers = []Notifier{&SlackNotifier{WebhookURL: route.SlackWebhookURL, Actions: c.SlackSigningSecret != ""}}
+		}
+	}
+	q.AdminToken = c.AdminToken
+	if len(c.TagHookURLs) > 0 {
+		q.TagHook = &WebhookTagHook{URLs: c.TagHookURLs, Secret: c.TagHookSecret}
+	}
+
+	if c.Canary != nil && c.Canary.Config != nil {
+		cc := *c.Canary.Config
+		if cc.GitHubToken == "" {
+			cc.GitHubToken = c.GitHubToken
+		}
+		if cc.RegistryAuth == "" {
+			cc.RegistryAuth = c.RegistryAuth
+		}
+		if cc.RegistryReadAuth == "" {
+			cc.RegistryReadAuth = c.RegistryReadAuth
+		}
+		canary, err := NewFromConfig(&cc)
+		if err != nil {
+			return nil, fmt.Errorf("canary: %v", err)
+		}
+		q.Canary = &Canary{Quayd: canary, Percent: c.Canary.Percent}
+	}
+
+	if c.WebhookSecret != "" {
+		q.WebhookValidators = WebhookValidators{"*": &SharedSecretValidator{Secret: c.WebhookSecret}}
+	}
+	if c.GitHubWebhookSecret != "" {
+		if q.WebhookValidators == nil {
+			q.WebhookValidators = WebhookValidators{}
+		}
+		q.WebhookValidators["github"] = &HMACValidator{Secret: c.GitHubWebhookSecret, Header: GitHubSignatureHeader}
+	} else if q.Approvals != nil {
+		return nil, errors.New("approvals: github_webhook_secret is required to approve promotions")
+	}
+	q.routeTenants()
+
+	return q, nil
+}
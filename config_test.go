@@ -0,0 +1,266 @@
+package quayd
+
+import (
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testTOMLConfig = `# quayd.toml
+github_token = "1234"
+registry_auth = "user:pass"
+registry = "registry.example.com"
+webhook_secret = 'abcd' # literal string
+
+contexts = { "remind101/acme-inc" = "Docker Image (acme)" }
+
+[repos]
+"remind101/*" = "acme"
+
+[policies]
+"*" = [
+  "statuses",
+  "tags",
+]
+
+[routes."application/vnd.cncf.helm.config.v1+json"]
+context = "Helm Chart"
+tag = false
+capabilities = ["statuses"]
+
+[[environments]]
+branch = "release/[0-9]*"
+environment = "staging [eu]"
+
+[[environments]]
+tag = "v*"
+environment = "production"
+`
+
+func TestLoadConfig_TOML(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quayd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "quayd.toml")
+	if err := ioutil.WriteFile(path, []byte(testTOMLConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statuses := CapabilityStatuses
+	want := &Config{
+		GitHubToken:   "1234",
+		RegistryAuth:  "user:pass",
+		Registry:      "registry.example.com",
+		WebhookSecret: "abcd",
+		Contexts:      map[string]string{"remind101/acme-inc": "Docker Image (acme)"},
+		Repos:         RepoMap{"remind101/*": "acme"},
+		Policies:      Policies{"*": CapabilityStatuses | CapabilityTags},
+		Routes:        Routes{MediaTypeChart: &Route{Context: "Helm Chart", Capabilities: &statuses}},
+		Environments: []*EnvironmentRule{
+			{Branch: "release/[0-9]*", Environment: "staging [eu]"},
+			{Tag: "v*", Environment: "production"},
+		},
+	}
+
+	if !reflect.DeepEqual(c, want) {
+		t.Fatalf("Config => %+v; want %+v", c, want)
+	}
+}
+
+func TestLoadConfig_JSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quayd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "quayd.json")
+	if err := ioutil.WriteFile(path, []byte(`{"github_token":"1234","read_only":true}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.GitHubToken != "1234" || !c.ReadOnly {
+		t.Fatalf("Config => %+v", c)
+	}
+}
+
+func TestLoadConfig_InvalidTOML(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quayd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "quayd.toml")
+	if err := ioutil.WriteFile(path, []byte("github_token\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadConfig(path); err == nil {
+		t.Fatal("Expected an error")
+	}
+}
+
+func TestNewFromConfig_PrivateRegistry(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`"abcd"`))
+	}))
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "quayd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := filepath.Join(dir, "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+	if err := ioutil.WriteFile(ca, cert, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := NewFromConfig(&Config{Registry: strings.TrimPrefix(s.URL, "https://"), RegistryCA: ca})
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageID, err := q.TagResolver.Resolve(context.Background(), "remind101/acme-inc", "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := imageID, "abcd"; got != want {
+		t.Fatalf("Image => %s; want %s", got, want)
+	}
+}
+
+func TestNewFromConfig_RegistryScheme(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`"abcd"`))
+	}))
+	defer s.Close()
+
+	q, err := NewFromConfig(&Config{Registry: strings.TrimPrefix(s.URL, "http://"), RegistryScheme: "http"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.TagResolver.Resolve(context.Background(), "remind101/acme-inc", "latest"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewFromConfig_RegistryReadAuth(t *testing.T) {
+	var users []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _, _ := r.BasicAuth()
+		users = append(users, r.Method+" "+user)
+		w.Write([]byte(`"abcd"`))
+	}))
+	defer s.Close()
+
+	q, err := NewFromConfig(&Config{
+		Registry:         strings.TrimPrefix(s.URL, "http://"),
+		RegistryScheme:   "http",
+		RegistryAuth:     "writer:secret",
+		RegistryReadAuth: "reader:secret",
+		Tenants: map[string]*TenantConfig{
+			"remind101": {RegistryReadAuth: "tenant-reader:secret"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := q.TagResolver.Resolve(ctx, "remind101/acme-inc", "latest"); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Tagger.Tag(ctx, "remind101/acme-inc", "abcd", "latest"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Tenants["remind101"].TagResolver.Resolve(ctx, "remind101/acme-inc", "latest"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"GET reader", "PUT writer", "GET tenant-reader"}
+	if !reflect.DeepEqual(users, want) {
+		t.Fatalf("Requests => %v; want %v", users, want)
+	}
+}
+
+func TestNewFromConfig_TenantChecks(t *testing.T) {
+	q, err := NewFromConfig(&Config{
+		Checks:  true,
+		Tenants: map[string]*TenantConfig{"acme": {GitHubToken: "acme"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := q.Tenants["acme"].Checks.(*GitHubChecksRepository); !ok {
+		t.Fatalf("Expected the tenant to create Check Runs, got %T", q.Tenants["acme"].Checks)
+	}
+	if _, ok := q.SupplyChain.Checks.(*tenantCheckRuns); !ok {
+		t.Fatalf("Expected supply chain Check Runs to be routed to tenants, got %T", q.SupplyChain.Checks)
+	}
+}
+
+func TestNewFromConfig_Retry(t *testing.T) {
+	jitter := 0.0
+	q, err := NewFromConfig(&Config{
+		Checks: true,
+		Retry:  &RetryConfig{MaxAttempts: 5, Backoff: "1s", Jitter: &jitter},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limited, ok := q.StatusesRepository.(*RateLimitedStatusesRepository)
+	if !ok {
+		t.Fatalf("Expected Check Runs to be rate limited, got %T", q.StatusesRepository)
+	}
+	retry, ok := limited.StatusesRepository.(*RetryStatusesRepository)
+	if !ok {
+		t.Fatalf("Expected Check Runs to be retried, got %T", limited.StatusesRepository)
+	}
+	if _, ok := retry.StatusesRepository.(*GitHubChecksRepository); !ok {
+		t.Fatalf("Expected Check Runs, got %T", retry.StatusesRepository)
+	}
+
+	p := retry.Policy
+	if p.MaxAttempts != 5 || p.Backoff != time.Second || p.Jitter != 0 || p.MaxBackoff != DefaultRetryPolicy.MaxBackoff {
+		t.Fatalf("Policy => %+v", p)
+	}
+}
+
+func TestNewFromConfig_Invalid(t *testing.T) {
+	for _, c := range []*Config{
+		{RegistryCA: "/nonexistent/ca.pem"},
+		{Retry: &RetryConfig{Backoff: "soon"}},
+		{GitOps: &GitOpsConfig{Rules: []*GitOpsRule{{}}, Window: "10"}},
+		{CancelledState: "pending"},
+		{Rules: []string{"-> [skip]"}},
+	} {
+		if _, err := NewFromConfig(c); err == nil {
+			t.Fatalf("Expected an error for %+v", c)
+		}
+	}
+}
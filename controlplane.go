@@ -0,0 +1,224 @@
+package quayd
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ConfigSignatureHeader is the header that the control plane sends the base64
+// encoded ed25519 signature of the Config in.
+const ConfigSignatureHeader = "X-Quayd-Config-Signature"
+
+// DefaultControlPlaneTimeout is the default timeout for requests to the
+// control plane.
+const DefaultControlPlaneTimeout = 10 * time.Second
+
+var defaultControlPlaneClient = &http.Client{Timeout: DefaultControlPlaneTimeout}
+
+var (
+	// ErrControlPlaneInsecure is returned when the control plane URL isn't
+	// https.
+	ErrControlPlaneInsecure = errors.New("control plane URL must be https")
+
+	// ErrControlPlaneNoToken is returned when no Token is configured for
+	// the control plane.
+	ErrControlPlaneNoToken = errors.New("control plane token is required")
+)
+
+// ControlPlane is a client for a central configuration service. It polls
+// URL/config for a Config, applies it when it changes, and reports the health
+// of the instance back to URL/health.
+//
+// The control plane must be served over https, requests are authenticated
+// with Token, and a Config is only applied if it's signed by Key, the same
+// way as a config bundle.
+type ControlPlane struct {
+	// URL is the base URL of the control plane. It must be https.
+	URL string
+
+	// Token is sent as a bearer token with each request.
+	Token string
+
+	// Key is the ed25519 public key that each Config must be signed with,
+	// in the ConfigSignatureHeader.
+	Key ed25519.PublicKey
+
+	// Instance identifies this quayd instance to the control plane.
+	Instance string
+
+	// Apply is called with each new Config. The Config is only considered
+	// applied if Apply returns nil.
+	Apply func(*Config) error
+
+	// Secret, if set, is used to sign the health reports, in the same
+	// SignatureHeader as tag hooks, so the control plane can verify that
+	// they come from a quayd instance.
+	Secret string
+
+	// Client is the http.Client to use. Defaults to a client with a
+	// DefaultControlPlaneTimeout.
+	Client *http.Client
+
+	// version is the ETag of the currently applied Config.
+	version string
+}
+
+// Health is reported to the control plane after every poll.
+type Health struct {
+	Instance string `json:"instance"`
+	Version  string `json:"version"`
+	Healthy  bool   `json:"healthy"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Run polls the control plane every interval until stop is closed.
+func (c *ControlPlane) Run(interval time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		if err := c.Poll(); err != nil {
+			log.Printf("control plane: %s", err)
+		}
+
+		select {
+		case <-t.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Poll fetches the Config once, applies it if it has changed, and reports
+// health back to the control plane.
+func (c *ControlPlane) Poll() error {
+	err := c.poll()
+
+	h := &Health{Instance: c.Instance, Version: c.version, Healthy: err == nil}
+	if err != nil {
+		h.Error = err.Error()
+	}
+
+	if rerr := c.report(h); rerr != nil && err == nil {
+		err = rerr
+	}
+
+	return err
+}
+
+func (c *ControlPlane) poll() error {
+	req, err := c.newRequest("GET", "/config", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Quayd-Instance", c.Instance)
+	if c.version != "" {
+		req.Header.Set("If-None-Match", c.version)
+	}
+
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case 200:
+	case 304:
+		return nil
+	default:
+		return fmt.Errorf("control plane responded with %s", resp.Status)
+	}
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(resp.Header.Get(ConfigSignatureHeader))
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	if err := verifySignature(raw, sig, c.Key); err != nil {
+		return err
+	}
+
+	config, err := DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+
+	if err := c.Apply(config); err != nil {
+		return err
+	}
+
+	c.version = resp.Header.Get("ETag")
+	return nil
+}
+
+func (c *ControlPlane) report(h *Health) error {
+	raw, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+
+	req, err := c.newRequest("POST", "/health", bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign([]byte(c.Secret), raw))
+	}
+
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("control plane responded with %s", resp.Status)
+	}
+
+	return nil
+}
+
+// newRequest returns an authenticated request to path on the control plane,
+// or an error if the control plane isn't https or there's no Token.
+func (c *ControlPlane) newRequest(method, path string, body io.Reader) (*http.Request, error) {
+	if !strings.HasPrefix(c.URL, "https://") {
+		return nil, ErrControlPlaneInsecure
+	}
+
+	if c.Token == "" {
+		return nil, ErrControlPlaneNoToken
+	}
+
+	req, err := http.NewRequest(method, c.URL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+
+	return req, nil
+}
+
+func (c *ControlPlane) client() *http.Client {
+	if c.Client == nil {
+		return defaultControlPlaneClient
+	}
+
+	return c.Client
+}
@@ -0,0 +1,123 @@
+package quayd
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestControlPlane(t *testing.T) {
+	var health []Health
+
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	config := []byte(`{"all_commits":true}`)
+
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Header.Get("Authorization"), "Bearer token"; got != want {
+			t.Errorf("Authorization => %q; want %q", got, want)
+		}
+
+		switch r.URL.Path {
+		case "/config":
+			if r.Header.Get("If-None-Match") == "v1" {
+				w.WriteHeader(304)
+				return
+			}
+			w.Header().Set("ETag", "v1")
+			w.Header().Set(ConfigSignatureHeader, base64.StdEncoding.EncodeToString(ed25519.Sign(priv, config)))
+			w.Write(config)
+		case "/health":
+			raw, _ := ioutil.ReadAll(r.Body)
+			var h Health
+			json.Unmarshal(raw, &h)
+			health = append(health, h)
+			if got, want := r.Header.Get(SignatureHeader), Sign([]byte("secret"), raw); got != want {
+				t.Errorf("Signature => %s; want %s", got, want)
+			}
+		}
+	}))
+	defer s.Close()
+
+	var applied []*Config
+	c := &ControlPlane{
+		URL:      s.URL,
+		Token:    "token",
+		Key:      pub,
+		Instance: "quayd-1",
+		Secret:   "secret",
+		Client:   s.Client(),
+		Apply: func(config *Config) error {
+			applied = append(applied, config)
+			return nil
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := c.Poll(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, want := len(applied), 1; got != want {
+		t.Fatalf("Applied => %d; want %d", got, want)
+	}
+
+	if !applied[0].AllCommits {
+		t.Fatal("Expected AllCommits to be true")
+	}
+
+	if got, want := len(health), 2; got != want {
+		t.Fatalf("Health reports => %d; want %d", got, want)
+	}
+
+	if got, want := health[1], (Health{Instance: "quayd-1", Version: "v1", Healthy: true}); got != want {
+		t.Fatalf("Health => %v; want %v", got, want)
+	}
+}
+
+func TestControlPlane_InvalidSignature(t *testing.T) {
+	pub, _, _ := ed25519.GenerateKey(nil)
+	_, other, _ := ed25519.GenerateKey(nil)
+	config := []byte(`{"all_commits":true}`)
+
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/config" {
+			w.Header().Set(ConfigSignatureHeader, base64.StdEncoding.EncodeToString(ed25519.Sign(other, config)))
+			w.Write(config)
+		}
+	}))
+	defer s.Close()
+
+	c := &ControlPlane{
+		URL:    s.URL,
+		Token:  "token",
+		Key:    pub,
+		Client: s.Client(),
+		Apply: func(config *Config) error {
+			t.Fatal("Expected the config not to be applied")
+			return nil
+		},
+	}
+
+	if err := c.Poll(); err != ErrInvalidSignature {
+		t.Fatalf("err => %v; want %v", err, ErrInvalidSignature)
+	}
+}
+
+func TestControlPlane_Insecure(t *testing.T) {
+	c := &ControlPlane{URL: "http://control-plane", Token: "token"}
+
+	if err := c.Poll(); err != ErrControlPlaneInsecure {
+		t.Fatalf("err => %v; want %v", err, ErrControlPlaneInsecure)
+	}
+
+	c = &ControlPlane{URL: "https://control-plane"}
+
+	if err := c.Poll(); err != ErrControlPlaneNoToken {
+		t.Fatalf("err => %v; want %v", err, ErrControlPlaneNoToken)
+	}
+}
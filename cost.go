@@ -0,0 +1,94 @@
+package quayd
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// CostSummary is the build usage attributed to a team.
+type CostSummary struct {
+	Team    string  `json:"team"`
+	Builds  int     `json:"builds"`
+	Minutes float64 `json:"minutes"`
+	Cost    float64 `json:"cost"`
+}
+
+// Costs estimates build minutes per repo from the build timestamps in Quay
+// notifications, and attributes them to teams so Quay usage can be charged
+// back. A nil *Costs records nothing.
+type Costs struct {
+	// CostPerMinute is the estimated cost of a minute of build time.
+	CostPerMinute float64
+
+	// Teams maps a repo to the team that owns it. Repos that aren't
+	// mapped are attributed to their namespace.
+	Teams map[string]string
+
+	mu      sync.Mutex
+	builds  map[string]int
+	minutes map[string]float64
+}
+
+// Record records the build duration for a finished build.
+func (c *Costs) Record(e *BuildEvent) {
+	if c == nil || e.State == "pending" || e.StartedAt.IsZero() || e.CompletedAt.IsZero() {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.builds == nil {
+		c.builds = make(map[string]int)
+		c.minutes = make(map[string]float64)
+	}
+
+	c.builds[e.Repo]++
+	c.minutes[e.Repo] += e.CompletedAt.Sub(e.StartedAt).Minutes()
+}
+
+// Summary returns the usage for each team, sorted by team.
+func (c *Costs) Summary() []*CostSummary {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	teams := make(map[string]*CostSummary)
+	for repo, builds := range c.builds {
+		team := c.team(repo)
+
+		s, ok := teams[team]
+		if !ok {
+			s = &CostSummary{Team: team}
+			teams[team] = s
+		}
+		s.Builds += builds
+		s.Minutes += c.minutes[repo]
+		s.Cost = s.Minutes * c.CostPerMinute
+	}
+
+	summary := make([]*CostSummary, 0, len(teams))
+	for _, s := range teams {
+		summary = append(summary, s)
+	}
+	sort.Sort(byTeam(summary))
+	return summary
+}
+
+func (c *Costs) team(repo string) string {
+	if team, ok := c.Teams[repo]; ok {
+		return team
+	}
+
+	return strings.Split(repo, "/")[0]
+}
+
+type byTeam []*CostSummary
+
+func (s byTeam) Len() int           { return len(s) }
+func (s byTeam) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byTeam) Less(i, j int) bool { return s[i].Team < s[j].Team }
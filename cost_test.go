@@ -0,0 +1,33 @@
+package quayd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCosts(t *testing.T) {
+	c := &Costs{
+		CostPerMinute: 0.5,
+		Teams:         map[string]string{"remind101/acme-inc": "platform"},
+	}
+
+	start := time.Unix(1420070400, 0)
+	for _, e := range []*BuildEvent{
+		{Repo: "remind101/acme-inc", State: "success", StartedAt: start, CompletedAt: start.Add(10 * time.Minute)},
+		{Repo: "remind101/r101-api", State: "failure", StartedAt: start, CompletedAt: start.Add(4 * time.Minute)},
+		{Repo: "remind101/r101-api", State: "success", StartedAt: start, CompletedAt: start.Add(6 * time.Minute)},
+		{Repo: "remind101/r101-api", State: "pending", StartedAt: start},
+	} {
+		c.Record(e)
+	}
+
+	want := []*CostSummary{
+		{Team: "platform", Builds: 1, Minutes: 10, Cost: 5},
+		{Team: "remind101", Builds: 2, Minutes: 10, Cost: 5},
+	}
+
+	if got := c.Summary(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Summary => %v; want %v", got, want)
+	}
+}
@@ -0,0 +1,220 @@
+package quayd
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultMaxDedupeKeys is the default number of processed deliveries that
+// MemoryDedupeStore remembers.
+const DefaultMaxDedupeKeys = 10000
+
+// DefaultDedupeClaimTTL is how long CacheDedupeStore holds the claim on a
+// delivery that's being processed, in case the instance processing it dies.
+const DefaultDedupeClaimTTL = 5 * time.Minute
+
+// DedupeStore remembers which deliveries are being, or have been, processed,
+// so that webhooks that Quay redelivers after a timeout aren't processed
+// twice.
+type DedupeStore interface {
+	// Claim atomically marks key as being processed. It returns false if
+	// key was already claimed.
+	Claim(key string) (bool, error)
+
+	// Add records key as processed.
+	Add(key string) error
+
+	// Release forgets a claimed key, so that a redelivery is processed.
+	Release(key string) error
+}
+
+// dedupeKey returns the key that identifies the delivery of e, or "" if the
+// event can't be deduplicated because the build id is unknown.
+func dedupeKey(e *BuildEvent) string {
+	if e.BuildID == "" {
+		return ""
+	}
+
+	return "dedupe:" + e.BuildID + ":" + e.State
+}
+
+// duplicate claims the event, and returns true if it's already being, or was
+// already, processed. Errors from the store are logged, and the event is
+// processed anyway.
+func (q *Quayd) duplicate(ctx context.Context, e *BuildEvent) bool {
+	key := dedupeKey(e)
+	if q.Dedupe == nil || key == "" || replaying(ctx) {
+		return false
+	}
+
+	claimed, err := q.Dedupe.Claim(key)
+	if err != nil {
+		q.logger().Log(ctx, "dedupe claim failed", "error", err)
+		return false
+	}
+
+	return !claimed
+}
+
+// deduped records the event as processed, unless processing it failed, in
+// which case the claim is released so that a redelivery is processed again.
+func (q *Quayd) deduped(ctx context.Context, e *BuildEvent, err error) {
+	key := dedupeKey(e)
+	if q.Dedupe == nil || key == "" {
+		return
+	}
+
+	if err != nil {
+		// A failed replay doesn't undo the original delivery.
+		if replaying(ctx) {
+			return
+		}
+		if err := q.Dedupe.Release(key); err != nil {
+			q.logger().Log(ctx, "dedupe release failed", "error", err)
+		}
+		return
+	}
+
+	if err := q.Dedupe.Add(key); err != nil {
+		q.logger().Log(ctx, "dedupe record failed", "error", err)
+	}
+}
+
+type replayKey struct{}
+
+// withReplay marks ctx as replaying a delivery, which bypasses the dedupe
+// check.
+func withReplay(ctx context.Context) context.Context {
+	return context.WithValue(ctx, replayKey{}, true)
+}
+
+func replaying(ctx context.Context) bool {
+	r, _ := ctx.Value(replayKey{}).(bool)
+	return r
+}
+
+// MemoryDedupeStore is a DedupeStore that remembers the most recently used
+// keys in memory, evicting the least recently used ones.
+type MemoryDedupeStore struct {
+	// Max is the number of keys to remember. Defaults to
+	// DefaultMaxDedupeKeys.
+	Max int
+
+	mu    sync.Mutex
+	order *list.List
+	keys  map[string]*list.Element
+}
+
+// Seen returns true if key was claimed or added.
+func (s *MemoryDedupeStore) Seen(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.keys[key]
+	if ok {
+		s.order.MoveToFront(el)
+	}
+
+	return ok, nil
+}
+
+// Claim implements DedupeStore Claim.
+func (s *MemoryDedupeStore) Claim(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.keys[key]; ok {
+		s.order.MoveToFront(el)
+		return false, nil
+	}
+	s.add(key)
+
+	return true, nil
+}
+
+// Add implements DedupeStore Add.
+func (s *MemoryDedupeStore) Add(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.add(key)
+	return nil
+}
+
+// Release implements DedupeStore Release.
+func (s *MemoryDedupeStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.keys[key]; ok {
+		s.order.Remove(el)
+		delete(s.keys, key)
+	}
+
+	return nil
+}
+
+func (s *MemoryDedupeStore) add(key string) {
+	if s.keys == nil {
+		s.keys = make(map[string]*list.Element)
+		s.order = list.New()
+	}
+
+	if el, ok := s.keys[key]; ok {
+		s.order.MoveToFront(el)
+		return
+	}
+	s.keys[key] = s.order.PushFront(key)
+
+	for s.order.Len() > s.max() {
+		el := s.order.Back()
+		s.order.Remove(el)
+		delete(s.keys, el.Value.(string))
+	}
+}
+
+func (s *MemoryDedupeStore) max() int {
+	if s.Max == 0 {
+		return DefaultMaxDedupeKeys
+	}
+
+	return s.Max
+}
+
+// CacheDedupeStore is a DedupeStore backed by a Cache, such as RedisCache,
+// so that multiple instances share which deliveries have been processed.
+// Claims are atomic, so concurrent deliveries to different instances are
+// only processed once.
+type CacheDedupeStore struct {
+	Cache Cache
+
+	// TTL is how long keys are remembered for. It only needs to cover
+	// the window in which Quay redelivers webhooks.
+	TTL time.Duration
+
+	// ClaimTTL is how long a delivery that's being processed is claimed
+	// for. Defaults to DefaultDedupeClaimTTL.
+	ClaimTTL time.Duration
+}
+
+// Claim implements DedupeStore Claim.
+func (s *CacheDedupeStore) Claim(key string) (bool, error) {
+	ttl := s.ClaimTTL
+	if ttl == 0 {
+		ttl = DefaultDedupeClaimTTL
+	}
+
+	return s.Cache.Add(key, "processing", ttl)
+}
+
+// Add implements DedupeStore Add.
+func (s *CacheDedupeStore) Add(key string) error {
+	return s.Cache.Set(key, "1", s.TTL)
+}
+
+// Release implements DedupeStore Release.
+func (s *CacheDedupeStore) Release(key string) error {
+	return s.Cache.Delete(key)
+}
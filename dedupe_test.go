@@ -0,0 +1,95 @@
+package quayd
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestQuayd_Dedupe(t *testing.T) {
+	r := &statusesRepository{}
+	q := &Quayd{StatusesRepository: r, Dedupe: &MemoryDedupeStore{}}
+	e := &BuildEvent{Repo: "remind101/acme-inc", Ref: "abcd", BuildID: "1234", State: "pending"}
+
+	for i := 0; i < 2; i++ {
+		if err := q.Handle(context.Background(), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := len(r.statuses), 1; got != want {
+		t.Fatalf("Statuses => %d; want %d", got, want)
+	}
+
+	// A different event for the same build is processed.
+	failure := *e
+	failure.State = "failure"
+	if err := q.Handle(context.Background(), &failure); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(r.statuses), 2; got != want {
+		t.Fatalf("Statuses => %d; want %d", got, want)
+	}
+
+	// Replays are always processed.
+	if err := q.Handle(withReplay(context.Background()), e); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(r.statuses), 3; got != want {
+		t.Fatalf("Statuses => %d; want %d", got, want)
+	}
+}
+
+func TestQuayd_Dedupe_Failed(t *testing.T) {
+	q := &Quayd{StatusesRepository: &downStatusesRepository{down: true}, Dedupe: &MemoryDedupeStore{}}
+	e := &BuildEvent{Repo: "remind101/acme-inc", Ref: "abcd", BuildID: "1234", State: "pending"}
+
+	if err := q.Handle(context.Background(), e); err == nil {
+		t.Fatal("Expected an error")
+	}
+
+	// A failed delivery is retried when it's redelivered.
+	if seen, _ := q.Dedupe.(*MemoryDedupeStore).Seen(dedupeKey(e)); seen {
+		t.Fatal("Expected the failed delivery not to be recorded")
+	}
+}
+
+func TestCacheDedupeStore_Claim(t *testing.T) {
+	s := &CacheDedupeStore{Cache: &MemoryCache{}, TTL: time.Hour}
+
+	if claimed, _ := s.Claim("a"); !claimed {
+		t.Fatal("Expected the first delivery to be claimed")
+	}
+
+	// A concurrent delivery is skipped while the first is in flight.
+	if claimed, _ := s.Claim("a"); claimed {
+		t.Fatal("Expected an in-flight delivery not to be claimed again")
+	}
+
+	if err := s.Release("a"); err != nil {
+		t.Fatal(err)
+	}
+	if claimed, _ := s.Claim("a"); !claimed {
+		t.Fatal("Expected a released delivery to be claimed")
+	}
+
+	if err := s.Add("a"); err != nil {
+		t.Fatal(err)
+	}
+	if claimed, _ := s.Claim("a"); claimed {
+		t.Fatal("Expected a processed delivery not to be claimed")
+	}
+}
+
+func TestMemoryDedupeStore(t *testing.T) {
+	s := &MemoryDedupeStore{Max: 2}
+	s.Add("a")
+	s.Add("b")
+	s.Seen("a")
+	s.Add("c")
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if got, _ := s.Seen(key); got != want {
+			t.Fatalf("Seen(%s) => %v; want %v", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,455 @@
+package quayd
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/lib/pq"
+	bolt "go.etcd.io/bbolt"
+)
+
+// DefaultMaxDeliveries is the default number of deliveries that
+// MemoryDeliveryStore keeps.
+const DefaultMaxDeliveries = 1000
+
+// ErrDeliveryNotFound is returned by a DeliveryStore when a delivery does not
+// exist.
+var ErrDeliveryNotFound = errors.New("delivery not found")
+
+// Delivery is a received webhook, and the outcome of processing it.
+type Delivery struct {
+	ID          string    `json:"id"`
+	Status      string    `json:"status"`
+	Context     string    `json:"context,omitempty"`
+	Payload     []byte    `json:"payload"`
+	ReceivedAt  time.Time `json:"received_at"`
+	ProcessedAt time.Time `json:"processed_at"`
+	Error       string    `json:"error,omitempty"`
+
+	// Ignored is the reason that the delivery was intentionally not
+	// processed, if it wasn't. See IgnoredEvent.
+	Ignored string `json:"ignored,omitempty"`
+
+	// Stages are the results of the pipeline stages that ran before
+	// processing failed, which shows what was done before the failure.
+	Stages []*StageResult `json:"stages,omitempty"`
+}
+
+// Failed returns true if processing the delivery failed.
+func (d *Delivery) Failed() bool {
+	return d.Error != ""
+}
+
+// State returns the processing state of the delivery: received, processed,
+// ignored or failed.
+func (d *Delivery) State() string {
+	switch {
+	case d.Failed():
+		return "failed"
+	case d.Ignored != "":
+		return "ignored"
+	case !d.ProcessedAt.IsZero():
+		return "processed"
+	}
+	return "received"
+}
+
+// DeliveryStore records received webhooks, so that failed deliveries can be
+// replayed.
+type DeliveryStore interface {
+	// Save creates or replaces the delivery.
+	Save(*Delivery) error
+
+	// Find returns the delivery with the given id, or ErrDeliveryNotFound.
+	Find(id string) (*Delivery, error)
+}
+
+// DeliveryLister is implemented by DeliveryStores that can list their
+// deliveries.
+type DeliveryLister interface {
+	// List returns up to limit deliveries, newest first, after skipping
+	// offset of them, along with the total number of deliveries.
+	List(offset, limit int) ([]*Delivery, int, error)
+}
+
+// Replay reprocesses the recorded delivery with the given id. It returns
+// ErrDeliveryNotFound if there's no delivery store, or the delivery isn't in
+// it.
+func (q *Quayd) Replay(ctx context.Context, id string) error {
+	if q.Deliveries == nil {
+		return ErrDeliveryNotFound
+	}
+
+	d, err := q.Deliveries.Find(id)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	e, err := newBuildEvent(d.ID, d.Status, d.Payload, q.Quay.Triggered)
+	q.Timelines.Record(id, "replayed", start, err)
+	if err != nil || e == nil {
+		return err
+	}
+	e.Context = d.Context
+
+	return q.Handle(withReplay(ctx), e)
+}
+
+// MemoryDeliveryStore is a DeliveryStore that keeps the most recent
+// deliveries in memory.
+type MemoryDeliveryStore struct {
+	// Max is the number of deliveries to keep. Defaults to
+	// DefaultMaxDeliveries.
+	Max int
+
+	mu         sync.Mutex
+	order      []string
+	deliveries map[string]Delivery
+}
+
+func (s *MemoryDeliveryStore) Save(d *Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.deliveries == nil {
+		s.deliveries = make(map[string]Delivery)
+	}
+
+	if _, ok := s.deliveries[d.ID]; !ok {
+		s.order = append(s.order, d.ID)
+	}
+	s.deliveries[d.ID] = *d
+
+	for len(s.order) > s.max() {
+		delete(s.deliveries, s.order[0])
+		s.order = s.order[1:]
+	}
+
+	return nil
+}
+
+func (s *MemoryDeliveryStore) Find(id string) (*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.deliveries[id]
+	if !ok {
+		return nil, ErrDeliveryNotFound
+	}
+
+	return &d, nil
+}
+
+// List implements DeliveryLister List.
+func (s *MemoryDeliveryStore) List(offset, limit int) ([]*Delivery, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deliveries []*Delivery
+	for i := len(s.order) - 1 - offset; i >= 0 && len(deliveries) < limit; i-- {
+		d := s.deliveries[s.order[i]]
+		deliveries = append(deliveries, &d)
+	}
+	return deliveries, len(s.order), nil
+}
+
+func (s *MemoryDeliveryStore) max() int {
+	if s.Max == 0 {
+		return DefaultMaxDeliveries
+	}
+
+	return s.Max
+}
+
+// FileDeliveryStore is a DeliveryStore that persists each delivery as a JSON
+// file in a directory, so deliveries survive restarts.
+type FileDeliveryStore struct {
+	Dir string
+}
+
+func (s *FileDeliveryStore) Save(d *Delivery) error {
+	raw, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so a crash can't leave a partially
+	// written delivery behind.
+	tmp := s.path(d.ID) + ".tmp"
+	if err := ioutil.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.path(d.ID))
+}
+
+func (s *FileDeliveryStore) Find(id string) (*Delivery, error) {
+	raw, err := ioutil.ReadFile(s.path(id))
+	if os.IsNotExist(err) {
+		return nil, ErrDeliveryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var d Delivery
+	if err := json.Unmarshal(raw, &d); err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
+
+// List implements DeliveryLister List. Deliveries are ordered by when their
+// files were last written.
+func (s *FileDeliveryStore) List(offset, limit int) ([]*Delivery, int, error) {
+	files, err := ioutil.ReadDir(s.Dir)
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var ids []string
+	sort.SliceStable(files, func(i, j int) bool { return files[i].ModTime().After(files[j].ModTime()) })
+	for _, fi := range files {
+		if name := fi.Name(); strings.HasSuffix(name, ".json") {
+			ids = append(ids, strings.TrimSuffix(name, ".json"))
+		}
+	}
+
+	var deliveries []*Delivery
+	for i := offset; i < len(ids) && len(deliveries) < limit; i++ {
+		d, err := s.Find(ids[i])
+		if err != nil {
+			return nil, 0, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, len(ids), nil
+}
+
+func (s *FileDeliveryStore) path(id string) string {
+	return filepath.Join(s.Dir, filepath.Base(id)+".json")
+}
+
+// The BoltDB buckets that BoltDeliveryStore uses.
+var (
+	boltDeliveries = []byte("deliveries")
+	boltOrder      = []byte("delivery_order")
+)
+
+// BoltDeliveryStore is a DeliveryStore that persists deliveries in a BoltDB
+// file.
+type BoltDeliveryStore struct {
+	db *bolt.DB
+}
+
+// NewBoltDeliveryStore opens, or creates, the BoltDB file at path.
+func NewBoltDeliveryStore(path string) (*BoltDeliveryStore, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltDeliveries, boltOrder} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltDeliveryStore{db: db}, nil
+}
+
+func (s *BoltDeliveryStore) Save(d *Delivery) error {
+	raw, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		deliveries := tx.Bucket(boltDeliveries)
+
+		// Deliveries are listed in the order that they were first saved.
+		if deliveries.Get([]byte(d.ID)) == nil {
+			order := tx.Bucket(boltOrder)
+			seq, err := order.NextSequence()
+			if err != nil {
+				return err
+			}
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, seq)
+			if err := order.Put(key, []byte(d.ID)); err != nil {
+				return err
+			}
+		}
+
+		return deliveries.Put([]byte(d.ID), raw)
+	})
+}
+
+func (s *BoltDeliveryStore) Find(id string) (*Delivery, error) {
+	var d Delivery
+	err := s.db.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket(boltDeliveries).Get([]byte(id))
+		if raw == nil {
+			return ErrDeliveryNotFound
+		}
+		return json.Unmarshal(raw, &d)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
+
+// List implements DeliveryLister List.
+func (s *BoltDeliveryStore) List(offset, limit int) ([]*Delivery, int, error) {
+	var (
+		deliveries []*Delivery
+		total      int
+	)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		order := tx.Bucket(boltOrder)
+		total = order.Stats().KeyN
+
+		c, skipped := order.Cursor(), 0
+		for k, id := c.Last(); k != nil && len(deliveries) < limit; k, id = c.Prev() {
+			if skipped < offset {
+				skipped++
+				continue
+			}
+
+			var d Delivery
+			if err := json.Unmarshal(tx.Bucket(boltDeliveries).Get(id), &d); err != nil {
+				return err
+			}
+			deliveries = append(deliveries, &d)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return deliveries, total, nil
+}
+
+// Close closes the BoltDB file.
+func (s *BoltDeliveryStore) Close() error {
+	return s.db.Close()
+}
+
+// PostgresDeliveryStore is a DeliveryStore that persists deliveries in a
+// Postgres table, so that several quayd processes can share them.
+type PostgresDeliveryStore struct {
+	db *sql.DB
+}
+
+// NewPostgresDeliveryStore connects to the Postgres database at url, and
+// creates the deliveries table if it doesn't exist.
+func NewPostgresDeliveryStore(url string) (*PostgresDeliveryStore, error) {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS deliveries (
+  seq bigserial,
+  id text PRIMARY KEY,
+  delivery jsonb NOT NULL
+)`); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &PostgresDeliveryStore{db: db}, nil
+}
+
+func (s *PostgresDeliveryStore) Save(d *Delivery) error {
+	raw, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`INSERT INTO deliveries (id, delivery) VALUES ($1, $2)
+ON CONFLICT (id) DO UPDATE SET delivery = EXCLUDED.delivery`, d.ID, raw)
+	return err
+}
+
+func (s *PostgresDeliveryStore) Find(id string) (*Delivery, error) {
+	var raw []byte
+	err := s.db.QueryRow(`SELECT delivery FROM deliveries WHERE id = $1`, id).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return nil, ErrDeliveryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var d Delivery
+	if err := json.Unmarshal(raw, &d); err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
+
+// List implements DeliveryLister List.
+func (s *PostgresDeliveryStore) List(offset, limit int) ([]*Delivery, int, error) {
+	var total int
+	if err := s.db.QueryRow(`SELECT count(*) FROM deliveries`).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.db.Query(`SELECT delivery FROM deliveries ORDER BY seq DESC LIMIT $1 OFFSET $2`, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var deliveries []*Delivery
+	for rows.Next() {
+		var raw []byte
+		if err := rows.Scan(&raw); err != nil {
+			return nil, 0, err
+		}
+
+		var d Delivery
+		if err := json.Unmarshal(raw, &d); err != nil {
+			return nil, 0, err
+		}
+		deliveries = append(deliveries, &d)
+	}
+
+	return deliveries, total, rows.Err()
+}
+
+// Close closes the connection pool.
+func (s *PostgresDeliveryStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,148 @@
+package quayd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMemoryDeliveryStore(t *testing.T) {
+	s := &MemoryDeliveryStore{Max: 2}
+
+	for _, id := range []string{"a", "b", "c"} {
+		if err := s.Save(&Delivery{ID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := s.Find("a"); err != ErrDeliveryNotFound {
+		t.Fatalf("Expected the oldest delivery to be evicted, got %v", err)
+	}
+
+	if _, err := s.Find("c"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileDeliveryStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quayd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &FileDeliveryStore{Dir: dir}
+
+	d := &Delivery{
+		ID:         "a",
+		Status:     "success",
+		Payload:    []byte(`{"repository":"remind101/acme-inc"}`),
+		ReceivedAt: time.Unix(1420070400, 0).UTC(),
+		Error:      "unavailable",
+	}
+	if err := s.Save(d); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Find("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, d) {
+		t.Fatalf("Delivery => %+v; want %+v", got, d)
+	}
+
+	if _, err := s.Find("b"); err != ErrDeliveryNotFound {
+		t.Fatalf("Err => %v; want %v", err, ErrDeliveryNotFound)
+	}
+}
+
+func TestFileDeliveryStore_List(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quayd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &FileDeliveryStore{Dir: dir}
+	for i, id := range []string{"a", "b", "c"} {
+		if err := s.Save(&Delivery{ID: id}); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Unix(1420070400+int64(i), 0)
+		if err := os.Chtimes(s.path(id), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deliveries, total, err := s.List(1, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := total, 3; got != want {
+		t.Fatalf("Total => %d; want %d", got, want)
+	}
+	var ids []string
+	for _, d := range deliveries {
+		ids = append(ids, d.ID)
+	}
+	if got, want := ids, []string{"b", "a"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("IDs => %v; want %v", got, want)
+	}
+}
+
+func TestBoltDeliveryStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quayd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewBoltDeliveryStore(filepath.Join(dir, "deliveries.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for _, id := range []string{"a", "b", "c"} {
+		if err := s.Save(&Delivery{ID: id, Status: "success"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Save(&Delivery{ID: "a", Status: "success", Error: "unavailable"}); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := s.Find("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := d.Error, "unavailable"; got != want {
+		t.Fatalf("Error => %q; want %q", got, want)
+	}
+
+	if _, err := s.Find("d"); err != ErrDeliveryNotFound {
+		t.Fatalf("Err => %v; want %v", err, ErrDeliveryNotFound)
+	}
+
+	deliveries, total, err := s.List(1, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := total, 3; got != want {
+		t.Fatalf("Total => %d; want %d", got, want)
+	}
+	var ids []string
+	for _, d := range deliveries {
+		ids = append(ids, d.ID)
+	}
+	if got, want := ids, []string{"b", "a"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("IDs => %v; want %v", got, want)
+	}
+}
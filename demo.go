@@ -0,0 +1,119 @@
+package quayd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+)
+
+// DemoRepos are the repositories that are seeded into the registry when
+// running in demo mode.
+var DemoRepos = []string{"ejholmes/docker-statsd"}
+
+// NewDemo returns a Quayd instance that is wired up entirely in memory, with
+// the given repos seeded into a fake registry. It requires no credentials and
+// is safe for concurrent use, which makes it useful for demos and tests.
+func NewDemo(repos []string) *Quayd {
+	r := &MemoryRegistry{}
+	for _, repo := range repos {
+		r.Seed(repo, "latest", "test")
+	}
+
+	return &Quayd{
+		StatusesRepository: &LogStatusesRepository{&statusesRepository{}},
+		CommitResolver:     &commitResolver{},
+		Tagger:             r,
+		TagResolver:        r,
+	}
+}
+
+// LogStatusesRepository is a StatusesRepository that logs each status before
+// passing it on to the wrapped StatusesRepository.
+type LogStatusesRepository struct {
+	StatusesRepository
+}
+
+// Create implements StatusesRepository Create.
+func (r *LogStatusesRepository) Create(ctx context.Context, status *Status) error {
+	log.Printf("status: repo=%s ref=%s state=%s context=%q", status.Repo, status.Ref, status.State, status.Context)
+	return r.StatusesRepository.Create(ctx, status)
+}
+
+// ErrTagNotFound is returned by MemoryRegistry when a tag does not exist.
+var ErrTagNotFound = errors.New("tag not found")
+
+// MemoryRegistry is an in memory implementation of both the Tagger and
+// TagResolver interfaces.
+type MemoryRegistry struct {
+	mu   sync.Mutex
+	tags map[string]map[string]string
+}
+
+// Seed adds the given tags to repo, each pointing at an image id derived from
+// the repo and tag.
+func (r *MemoryRegistry) Seed(repo string, tags ...string) {
+	for _, tag := range tags {
+		sum := sha256.Sum256([]byte(repo + ":" + tag))
+		r.Tag(context.Background(), repo, hex.EncodeToString(sum[:]), tag)
+	}
+}
+
+// Tag implements Tagger Tag.
+func (r *MemoryRegistry) Tag(ctx context.Context, repo, imageID, tag string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.tags == nil {
+		r.tags = make(map[string]map[string]string)
+	}
+	if r.tags[repo] == nil {
+		r.tags[repo] = make(map[string]string)
+	}
+	r.tags[repo][tag] = imageID
+
+	return nil
+}
+
+// Untag implements Untagger Untag.
+func (r *MemoryRegistry) Untag(ctx context.Context, repo, tag string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.tags[repo][tag]; !ok {
+		return ErrTagNotFound
+	}
+	delete(r.tags[repo], tag)
+
+	return nil
+}
+
+// Tags implements TagLister Tags.
+func (r *MemoryRegistry) Tags(ctx context.Context, repo string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var tags []string
+	for tag := range r.tags[repo] {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	return tags, nil
+}
+
+// Resolve implements TagResolver Resolve.
+func (r *MemoryRegistry) Resolve(ctx context.Context, repo, tag string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	imageID, ok := r.tags[repo][tag]
+	if !ok {
+		return "", ErrTagNotFound
+	}
+
+	return imageID, nil
+}
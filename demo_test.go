@@ -0,0 +1,56 @@
+package quayd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestDemo(t *testing.T) {
+	q := NewDemo(DemoRepos)
+	s := NewServer(q)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			resp := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/quay/success", loadFixture("pending_build", t))
+
+			s.ServeHTTP(resp, req)
+
+			if resp.Code != 200 {
+				t.Errorf("Status => %d; want 200", resp.Code)
+			}
+		}()
+	}
+	wg.Wait()
+
+	r := q.TagResolver.(*MemoryRegistry)
+	test, _ := r.Resolve(context.Background(), "ejholmes/docker-statsd", "test")
+	sha, err := r.Resolve(context.Background(), "ejholmes/docker-statsd", "long-f1fb3b0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := sha, test; got != want {
+		t.Fatalf("ImageID => %s; want %s", got, want)
+	}
+
+	statuses := q.StatusesRepository.(*LogStatusesRepository).StatusesRepository.(*statusesRepository).statuses
+	if got, want := len(statuses), 10; got != want {
+		t.Fatalf("Statuses => %d; want %d", got, want)
+	}
+}
+
+func TestMemoryRegistry_NotFound(t *testing.T) {
+	r := &MemoryRegistry{}
+
+	if _, err := r.Resolve(context.Background(), "ejholmes/docker-statsd", "latest"); err != ErrTagNotFound {
+		t.Fatalf("err => %v; want %v", err, ErrTagNotFound)
+	}
+}
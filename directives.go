@@ -0,0 +1,106 @@
+package quayd
+
+import (
+	"context"
+	"strings"
+
+	"github.com/ejholmes/go-github/github"
+)
+
+// The actions that commit message directives can take.
+const (
+	// DirectiveSkip skips the build entirely: no commit status is created
+	// and the image isn't tagged.
+	DirectiveSkip = "skip"
+
+	// DirectiveNoTags creates the commit status, but doesn't tag the
+	// image.
+	DirectiveNoTags = "no-tags"
+
+	// DirectiveNoFloating applies the exact version tag of a release, but
+	// doesn't move floating tags like "latest-stable".
+	DirectiveNoFloating = "no-floating"
+)
+
+// DefaultDirectives are the directives used when Directives.Rules is empty.
+var DefaultDirectives = map[string]string{
+	"[skip image]": DirectiveSkip,
+	"[no-tag]":     DirectiveNoTags,
+	"[no latest]":  DirectiveNoFloating,
+}
+
+// CommitMessageResolver returns the message of a commit.
+type CommitMessageResolver interface {
+	Message(ctx context.Context, repo, ref string) (string, error)
+}
+
+// GitHubCommitMessageResolver is a CommitMessageResolver backed by a
+// github.Client.
+type GitHubCommitMessageResolver struct {
+	RepositoriesService interface {
+		GetCommit(owner, repo, sha string) (*github.RepositoryCommit, *github.Response, error)
+	}
+}
+
+// Message implements CommitMessageResolver Message.
+func (r *GitHubCommitMessageResolver) Message(ctx context.Context, repo, ref string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	c := strings.Split(repo, "/")
+	cm, _, err := r.RepositoriesService.GetCommit(c[0], c[1], ref)
+	if err != nil {
+		return "", err
+	}
+
+	if cm.Commit != nil && cm.Commit.Message != nil {
+		return *cm.Commit.Message, nil
+	}
+	if cm.Message != nil {
+		return *cm.Message, nil
+	}
+	return "", nil
+}
+
+// Directives lets developers control how their build is processed from the
+// commit message, with directives like `[skip image]`.
+type Directives struct {
+	Messages CommitMessageResolver
+
+	// Rules maps a directive, matched case insensitively anywhere in the
+	// commit message, to its action. Defaults to DefaultDirectives.
+	Rules map[string]string
+}
+
+// Actions returns the set of actions that the message of the commit asks
+// for.
+func (d *Directives) Actions(ctx context.Context, repo, ref string) (map[string]bool, error) {
+	if d == nil {
+		return nil, nil
+	}
+
+	message, err := d.Messages.Message(ctx, repo, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.Parse(message), nil
+}
+
+// Parse returns the set of actions of the directives in message.
+func (d *Directives) Parse(message string) map[string]bool {
+	rules := d.Rules
+	if len(rules) == 0 {
+		rules = DefaultDirectives
+	}
+
+	message = strings.ToLower(message)
+	actions := make(map[string]bool)
+	for directive, action := range rules {
+		if strings.Contains(message, strings.ToLower(directive)) {
+			actions[action] = true
+		}
+	}
+	return actions
+}
@@ -0,0 +1,78 @@
+package quayd
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// commitMessages is a fake CommitMessageResolver.
+type commitMessages map[string]string
+
+func (m commitMessages) Message(ctx context.Context, repo, ref string) (string, error) {
+	return m[ref], nil
+}
+
+func TestDirectives_Parse(t *testing.T) {
+	d := &Directives{}
+
+	tests := []struct {
+		message string
+		actions map[string]bool
+	}{
+		{"Fix typo [Skip Image]", map[string]bool{DirectiveSkip: true}},
+		{"Hotfix\n\n[no-tag] [no latest]", map[string]bool{DirectiveNoTags: true, DirectiveNoFloating: true}},
+		{"Add feature", map[string]bool{}},
+	}
+
+	for _, tt := range tests {
+		if got := d.Parse(tt.message); !reflect.DeepEqual(got, tt.actions) {
+			t.Fatalf("Parse(%q) => %v; want %v", tt.message, got, tt.actions)
+		}
+	}
+}
+
+func TestHandle_Directives(t *testing.T) {
+	r := &statusesRepository{}
+	registry := &MemoryRegistry{}
+	registry.Seed("remind101/acme-inc", "test")
+	q := &Quayd{
+		StatusesRepository: r,
+		TagResolver:        registry,
+		Tagger:             registry,
+		SemVer:             &SemVerTags{},
+		Directives: &Directives{Messages: commitMessages{
+			"skip":     "Update README [skip image]",
+			"notag":    "Experiment [no-tag]",
+			"nolatest": "Backport [no latest]",
+		}},
+	}
+	ctx := context.Background()
+
+	if err := q.Handle(ctx, &BuildEvent{Repo: "remind101/acme-inc", Ref: "skip", State: "success", Tags: []string{"test"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.statuses) != 0 {
+		t.Fatal("Expected no commit status for a skipped build")
+	}
+
+	if err := q.Handle(ctx, &BuildEvent{Repo: "remind101/acme-inc", Ref: "notag", State: "success", Tags: []string{"test"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.statuses) != 1 || r.statuses[0].Image != nil {
+		t.Fatalf("Expected a commit status without an image, got %+v", r.statuses)
+	}
+	if _, err := registry.Resolve(ctx, "remind101/acme-inc", "long-notag"); err != ErrTagNotFound {
+		t.Fatal("Expected the image not to be tagged")
+	}
+
+	if err := q.Handle(ctx, &BuildEvent{Repo: "remind101/acme-inc", Ref: "nolatest", GitTag: "v1.2.0", State: "success", Tags: []string{"test"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := registry.Resolve(ctx, "remind101/acme-inc", "1.2.0"); err != nil {
+		t.Fatal("Expected the exact version tag to be applied")
+	}
+	if _, err := registry.Resolve(ctx, "remind101/acme-inc", "latest-stable"); err != ErrTagNotFound {
+		t.Fatal("Expected the floating tags not to be applied")
+	}
+}
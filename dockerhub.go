@@ -0,0 +1,181 @@
+package quayd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+// DockerHubRegistry is the registry host for images built on Docker Hub.
+const DockerHubRegistry = "docker.io"
+
+// DockerHubCallbackHosts are the hosts that DockerHubWebhook will send
+// callbacks to. Callback URLs for any other host are ignored, so that a
+// forged payload can't make quayd send requests to arbitrary hosts.
+var DockerHubCallbackHosts = []string{"registry.hub.docker.com", "hub.docker.com"}
+
+// dockerHubSha matches a Docker Hub tag that is a short or full commit sha.
+var dockerHubSha = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// DockerHubWebhook is an http.Handler that handles Docker Hub repository push
+// webhooks.
+type DockerHubWebhook struct {
+	*Quayd
+}
+
+// DockerHubForm is the payload of a Docker Hub webhook.
+type DockerHubForm struct {
+	CallbackURL string `json:"callback_url"`
+
+	PushData struct {
+		Tag      string  `json:"tag"`
+		PushedAt float64 `json:"pushed_at"`
+	} `json:"push_data"`
+
+	Repository struct {
+		RepoName string `json:"repo_name"`
+		RepoURL  string `json:"repo_url"`
+	} `json:"repository"`
+}
+
+// DockerHubCallback is the payload sent to a Docker Hub callback URL to mark
+// the hook as delivered.
+type DockerHubCallback struct {
+	State       string `json:"state"`
+	Description string `json:"description"`
+	Context     string `json:"context"`
+	TargetURL   string `json:"target_url"`
+}
+
+func (wh *DockerHubWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, received := newID(), time.Now()
+	w.Header().Set("X-Delivery-ID", id)
+	wh.Timelines.Record(id, "received", received, nil)
+
+	p, err := wh.readPayload(r, nil)
+	if err != nil {
+		payloadError(w, err)
+		return
+	}
+	defer p.release()
+	body := p.Bytes()
+
+	if err := wh.WebhookValidators.Validate("dockerhub", r, body); err != nil {
+		http.Error(w, err.Error(), 401)
+		return
+	}
+
+	var form DockerHubForm
+
+	start := time.Now()
+	err = json.Unmarshal(body, &form)
+	wh.Timelines.Record(id, "parsed", start, err)
+	if err != nil {
+		wh.errorResponse(w, r, err)
+		return
+	}
+
+	// Docker Hub only sends webhooks after an image has been pushed, so
+	// there's no pending state.
+	e := &BuildEvent{
+		ID:         id,
+		Repo:       form.Repository.RepoName,
+		Ref:        form.PushData.Tag,
+		URL:        form.Repository.RepoURL,
+		State:      "success",
+		Tags:       []string{form.PushData.Tag},
+		Registry:   DockerHubRegistry,
+		ReceivedAt: received,
+	}
+	if form.PushData.PushedAt > 0 {
+		e.CompletedAt = time.Unix(int64(form.PushData.PushedAt), 0)
+	}
+
+	// Docker Hub builds are tagged by the automated build rules, so the tag
+	// is only a commit when the rules tag images with the sha.
+	if dockerHubSha.MatchString(e.Ref) {
+		err = wh.Quayd.Handle(r.Context(), e)
+	} else {
+		wh.logger().Log(r.Context(), "dockerhub push skipped (tag is not a sha)", "repo", e.Repo, "tag", e.Ref)
+		wh.ignore(r.Context(), e, IgnoredNotSha)
+	}
+
+	callback := &DockerHubCallback{
+		State:       "success",
+		Description: Statuses[e.State],
+		Context:     Context,
+		TargetURL:   e.URL,
+	}
+	if err != nil {
+		callback.State = "error"
+		callback.Description = err.Error()
+	}
+
+	if !wh.ReadOnly {
+		start = time.Now()
+		cerr := sendDockerHubCallback(r.Context(), form.CallbackURL, callback)
+		wh.Timelines.Record(id, "callback", start, cerr)
+		if cerr != nil {
+			wh.logger().Log(r.Context(), "dockerhub callback failed", "error", cerr)
+		}
+	}
+
+	if err != nil {
+		wh.errorResponse(w, r, err)
+		return
+	}
+}
+
+// sendDockerHubCallback posts the result of handling a webhook to its
+// callback URL.
+func sendDockerHubCallback(ctx context.Context, callbackURL string, callback *DockerHubCallback) error {
+	if callbackURL == "" {
+		return nil
+	}
+
+	u, err := url.Parse(callbackURL)
+	if err != nil {
+		return err
+	}
+
+	if !dockerHubCallbackHost(u.Host) {
+		return fmt.Errorf("dockerhub: refusing to send callback to %s", u.Host)
+	}
+
+	raw, err := json.Marshal(callback)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", callbackURL, bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	return nil
+}
+
+func dockerHubCallbackHost(host string) bool {
+	for _, h := range DockerHubCallbackHosts {
+		if h == host {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package quayd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDockerHubWebhook(t *testing.T) {
+	var callback DockerHubCallback
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&callback)
+	}))
+	defer hub.Close()
+
+	u, _ := url.Parse(hub.URL)
+	defer func(hosts []string) { DockerHubCallbackHosts = hosts }(DockerHubCallbackHosts)
+	DockerHubCallbackHosts = []string{u.Host}
+
+	raw, err := ioutil.ReadFile("test-fixtures/hub.docker.com/push.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw = bytes.Replace(raw, []byte("https://registry.hub.docker.com"), []byte(hub.URL), 1)
+
+	r := &statusesRepository{}
+	s := NewServer(&Quayd{StatusesRepository: r})
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/dockerhub", bytes.NewReader(raw))
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 200; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+
+	want := &Status{
+		Repo:        "ejholmes/docker-statsd",
+		Ref:         "long-f1fb3b0",
+		State:       "success",
+		Context:     "Docker Image",
+		TargetURL:   "https://registry.hub.docker.com/u/ejholmes/docker-statsd/",
+		Description: "The Docker image was built",
+		Image:       &Image{Registry: "docker.io", Repo: "ejholmes/docker-statsd", Tags: []string{"f1fb3b0"}},
+	}
+	if len(r.statuses) != 1 || !reflect.DeepEqual(r.statuses[0], want) {
+		t.Fatalf("Statuses => %+v; want %+v", r.statuses, want)
+	}
+
+	if got, want := callback.State, "success"; got != want {
+		t.Fatalf("Callback state => %s; want %s", got, want)
+	}
+}
+
+func TestDockerHubWebhook_NotSha(t *testing.T) {
+	raw, err := ioutil.ReadFile("test-fixtures/hub.docker.com/push.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw = bytes.Replace(raw, []byte(`"tag": "f1fb3b0"`), []byte(`"tag": "latest"`), 1)
+
+	r := &statusesRepository{}
+	s := NewServer(&Quayd{StatusesRepository: r})
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/dockerhub", bytes.NewReader(raw))
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 200; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+	if len(r.statuses) != 0 {
+		t.Fatalf("Expected no commit statuses for a latest tag, got %+v", r.statuses)
+	}
+}
+
+func TestSendDockerHubCallback_UnknownHost(t *testing.T) {
+	err := sendDockerHubCallback(context.Background(), "http://169.254.169.254/", &DockerHubCallback{})
+	if err == nil || !strings.Contains(err.Error(), "refusing") {
+		t.Fatalf("Expected callback to be refused, got %v", err)
+	}
+}
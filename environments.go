@@ -0,0 +1,180 @@
+package quayd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+
+	"github.com/ejholmes/go-github/github"
+)
+
+// EnvironmentRule maps the branches or tags that match a pattern to a GitHub
+// environment.
+type EnvironmentRule struct {
+	// Branch is a pattern, as used by path.Match, for the branches that
+	// deploy to the environment.
+	Branch string `json:"branch,omitempty"`
+
+	// Tag is a pattern for the git tags that deploy to the environment.
+	Tag string `json:"tag,omitempty"`
+
+	// Environment is the name of the GitHub environment.
+	Environment string `json:"environment"`
+
+	// URL is the URL of the environment, shown on the deployment.
+	URL string `json:"url,omitempty"`
+}
+
+// Matches reports whether the build event matches the rule.
+func (r *EnvironmentRule) Matches(e *BuildEvent) bool {
+	if r.Branch != "" && e.Branch != "" {
+		if ok, _ := path.Match(r.Branch, e.Branch); ok {
+			return true
+		}
+	}
+
+	if r.Tag != "" && e.GitTag != "" {
+		if ok, _ := path.Match(r.Tag, e.GitTag); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DeploymentsService is an interface for creating GitHub environments,
+// deployments and deployment statuses. GitHubDeploymentsService implements
+// it.
+type DeploymentsService interface {
+	// CreateEnvironment creates the environment, if it doesn't exist.
+	CreateEnvironment(ctx context.Context, repo, name string) error
+
+	// CreateDeployment creates a deployment of sha to the environment and
+	// returns its id.
+	CreateDeployment(ctx context.Context, repo, sha, environment string) (int, error)
+
+	// CreateDeploymentStatus sets the state of a deployment.
+	CreateDeploymentStatus(ctx context.Context, repo string, id int, state, environmentURL, logURL string) error
+}
+
+// Environments records successful builds as deployments to the GitHub
+// environments that their ref maps to, so the repo's Environments tab shows
+// where each image went. A nil *Environments deploys nothing.
+type Environments struct {
+	Rules       []*EnvironmentRule
+	Deployments DeploymentsService
+
+	mu      sync.Mutex
+	created map[string]bool
+}
+
+// Deploy creates a successful deployment of sha, in the GitHub repo, for
+// each environment that the build event matches. It returns the names of
+// the environments.
+func (envs *Environments) Deploy(ctx context.Context, e *BuildEvent, repo, sha, logURL string) ([]string, error) {
+	if envs == nil {
+		return nil, nil
+	}
+
+	var deployed []string
+	for _, rule := range envs.Rules {
+		if !rule.Matches(e) {
+			continue
+		}
+
+		if err := envs.createEnvironment(ctx, repo, rule.Environment); err != nil {
+			return deployed, err
+		}
+
+		id, err := envs.Deployments.CreateDeployment(ctx, repo, sha, rule.Environment)
+		if err != nil {
+			return deployed, err
+		}
+
+		if err := envs.Deployments.CreateDeploymentStatus(ctx, repo, id, "success", rule.URL, logURL); err != nil {
+			return deployed, err
+		}
+		deployed = append(deployed, rule.Environment)
+	}
+
+	return deployed, nil
+}
+
+// createEnvironment creates the environment the first time it's deployed to.
+func (envs *Environments) createEnvironment(ctx context.Context, repo, name string) error {
+	key := repo + "#" + name
+
+	envs.mu.Lock()
+	created := envs.created[key]
+	envs.mu.Unlock()
+	if created {
+		return nil
+	}
+
+	if err := envs.Deployments.CreateEnvironment(ctx, repo, name); err != nil {
+		return err
+	}
+
+	envs.mu.Lock()
+	if envs.created == nil {
+		envs.created = make(map[string]bool)
+	}
+	envs.created[key] = true
+	envs.mu.Unlock()
+
+	return nil
+}
+
+// GitHubDeploymentsService is an implementation of the DeploymentsService
+// interface backed by the GitHub API.
+type GitHubDeploymentsService struct {
+	Client interface {
+		NewRequest(method, urlStr string, body interface{}) (*http.Request, error)
+		Do(req *http.Request, v interface{}) (*github.Response, error)
+	}
+}
+
+// CreateEnvironment implements DeploymentsService CreateEnvironment. GitHub
+// creates the environment if it doesn't exist, and otherwise leaves it as
+// is.
+func (s *GitHubDeploymentsService) CreateEnvironment(ctx context.Context, repo, name string) error {
+	return s.do(ctx, "PUT", fmt.Sprintf("repos/%s/environments/%s", repo, url.PathEscape(name)), struct{}{}, nil)
+}
+
+// CreateDeployment implements DeploymentsService CreateDeployment.
+func (s *GitHubDeploymentsService) CreateDeployment(ctx context.Context, repo, sha, environment string) (int, error) {
+	var created struct {
+		ID int `json:"id"`
+	}
+	err := s.do(ctx, "POST", fmt.Sprintf("repos/%s/deployments", repo), map[string]interface{}{
+		"ref":               sha,
+		"environment":       environment,
+		"auto_merge":        false,
+		"required_contexts": []string{},
+	}, &created)
+	return created.ID, err
+}
+
+// CreateDeploymentStatus implements DeploymentsService
+// CreateDeploymentStatus.
+func (s *GitHubDeploymentsService) CreateDeploymentStatus(ctx context.Context, repo string, id int, state, environmentURL, logURL string) error {
+	return s.do(ctx, "POST", fmt.Sprintf("repos/%s/deployments/%d/statuses", repo, id), map[string]string{
+		"state":           state,
+		"environment_url": environmentURL,
+		"log_url":         logURL,
+	}, nil)
+}
+
+func (s *GitHubDeploymentsService) do(ctx context.Context, method, urlStr string, body, v interface{}) error {
+	req, err := s.Client.NewRequest(method, urlStr, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	_, err = s.Client.Do(req.WithContext(ctx), v)
+	return err
+}
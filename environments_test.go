@@ -0,0 +1,103 @@
+package quayd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/ejholmes/go-github/github"
+)
+
+// deploymentsService is a fake implementation of the DeploymentsService
+// interface.
+type deploymentsService struct {
+	calls []string
+}
+
+func (s *deploymentsService) CreateEnvironment(ctx context.Context, repo, name string) error {
+	s.calls = append(s.calls, "environment "+name)
+	return nil
+}
+
+func (s *deploymentsService) CreateDeployment(ctx context.Context, repo, sha, environment string) (int, error) {
+	s.calls = append(s.calls, "deployment "+sha+" "+environment)
+	return len(s.calls), nil
+}
+
+func (s *deploymentsService) CreateDeploymentStatus(ctx context.Context, repo string, id int, state, environmentURL, logURL string) error {
+	s.calls = append(s.calls, "status "+state+" "+environmentURL)
+	return nil
+}
+
+func TestQuayd_Environments(t *testing.T) {
+	d := &deploymentsService{}
+	registry := &MemoryRegistry{}
+	registry.Seed("remind101/acme-inc", "test")
+	q := &Quayd{
+		StatusesRepository: &statusesRepository{},
+		TagResolver:        registry,
+		Tagger:             registry,
+		Environments: &Environments{
+			Rules: []*EnvironmentRule{
+				{Branch: "master", Environment: "staging", URL: "https://staging.acme.com"},
+				{Tag: "v*", Environment: "production", URL: "https://acme.com"},
+			},
+			Deployments: d,
+		},
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := q.Handle(ctx, &BuildEvent{Repo: "remind101/acme-inc", Ref: "abcd", Branch: "master", State: "success", Tags: []string{"test"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{
+		"environment staging",
+		"deployment long-abcd staging",
+		"status success https://staging.acme.com",
+		"deployment long-abcd staging",
+		"status success https://staging.acme.com",
+	}
+	if !reflect.DeepEqual(d.calls, want) {
+		t.Fatalf("Calls => %v; want %v", d.calls, want)
+	}
+}
+
+func TestGitHubDeploymentsService(t *testing.T) {
+	var requests []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		w.Write([]byte(`{"id":7}`))
+	}))
+	defer s.Close()
+
+	g := github.NewClient(nil)
+	g.BaseURL, _ = url.Parse(s.URL + "/")
+	envs := &Environments{
+		Rules:       []*EnvironmentRule{{Tag: "v*", Environment: "production"}},
+		Deployments: &GitHubDeploymentsService{Client: g},
+	}
+
+	deployed, err := envs.Deploy(context.Background(), &BuildEvent{GitTag: "v1.0.0"}, "remind101/acme-inc", "abcd", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := deployed, []string{"production"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Deployed => %v; want %v", got, want)
+	}
+
+	want := []string{
+		"PUT /repos/remind101/acme-inc/environments/production",
+		"POST /repos/remind101/acme-inc/deployments",
+		"POST /repos/remind101/acme-inc/deployments/7/statuses",
+	}
+	if !reflect.DeepEqual(requests, want) {
+		t.Fatalf("Requests => %v; want %v", requests, want)
+	}
+}
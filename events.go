@@ -0,0 +1,76 @@
+package quayd
+
+import "time"
+
+// BuildEventSchemaVersion is the version of the BuildEvent schema that is
+// published to downstream consumers. Adding optional fields is backwards
+// compatible; removing or renaming a field, or changing its type, requires a
+// new version.
+const BuildEventSchemaVersion = 1
+
+// BuildEventType is the type of the events that quayd publishes.
+const BuildEventType = "com.remind101.quayd.build"
+
+// BuildEventSchema is the JSON Schema for version BuildEventSchemaVersion of
+// the published Envelope, suitable for registering with a schema registry.
+const BuildEventSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "https://github.com/remind101/quayd/schemas/build_event.v1.json",
+  "title": "BuildEvent",
+  "type": "object",
+  "required": ["schema_version", "type", "time", "data"],
+  "properties": {
+    "schema_version": {"type": "integer", "const": 1},
+    "type": {"type": "string"},
+    "time": {"type": "string", "format": "date-time"},
+    "data": {
+      "type": "object",
+      "required": ["id", "repo", "ref", "url", "state", "tags", "started_at", "completed_at"],
+      "properties": {
+        "id": {"type": "string"},
+        "repo": {"type": "string"},
+        "ref": {"type": "string"},
+        "url": {"type": "string"},
+        "build_id": {"type": "string"},
+        "attempt": {"type": "integer"},
+        "state": {"type": "string", "enum": ["pending", "success", "error", "failure"]},
+        "cancelled": {"type": "boolean"},
+        "error": {"type": "string"},
+        "tags": {"type": ["array", "null"], "items": {"type": "string"}},
+        "media_type": {"type": "string"},
+        "started_at": {"type": "string", "format": "date-time"},
+        "completed_at": {"type": "string", "format": "date-time"},
+        "received_at": {"type": "string", "format": "date-time"},
+        "commits": {"type": "array", "items": {"type": "string"}},
+        "context": {"type": "string"},
+        "branch": {"type": "string"},
+        "default_branch": {"type": "string"},
+        "git_tag": {"type": "string"},
+        "registry": {"type": "string"},
+        "skipped": {"type": "array", "items": {"type": "string"}},
+        "git_url": {"type": "string"}
+      }
+    }
+  }
+}
+`
+
+// Envelope wraps a BuildEvent with the version of its schema, so consumers can
+// tell which fields to expect.
+type Envelope struct {
+	SchemaVersion int         `json:"schema_version"`
+	Type          string      `json:"type"`
+	Time          time.Time   `json:"time"`
+	Data          *BuildEvent `json:"data"`
+}
+
+// NewEnvelope returns an Envelope for the BuildEvent at the current schema
+// version.
+func NewEnvelope(e *BuildEvent) *Envelope {
+	return &Envelope{
+		SchemaVersion: BuildEventSchemaVersion,
+		Type:          BuildEventType,
+		Time:          time.Now().UTC(),
+		Data:          e,
+	}
+}
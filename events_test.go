@@ -0,0 +1,115 @@
+package quayd
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// schema is the subset of JSON Schema that the compatibility check needs.
+type schema struct {
+	Type       interface{}        `json:"type"`
+	Required   []string           `json:"required"`
+	Properties map[string]*schema `json:"properties"`
+}
+
+// TestEnvelope_SchemaCompatibility ensures that a published BuildEvent still
+// matches the registered schema, so fields can't be removed, renamed or
+// change type without bumping BuildEventSchemaVersion.
+func TestEnvelope_SchemaCompatibility(t *testing.T) {
+	var s schema
+	if err := json.Unmarshal([]byte(BuildEventSchema), &s); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Unix(1420070400, 0)
+	raw, err := json.Marshal(NewEnvelope(&BuildEvent{
+		ID:            "1",
+		Repo:          "remind101/acme-inc",
+		Ref:           "f1fb3b0",
+		URL:           "https://quay.io/repository/remind101/acme-inc/build",
+		BuildID:       "077f3664-35d3-48e6-9da7-889f9be73070",
+		Attempt:       2,
+		State:         "success",
+		Tags:          []string{"latest"},
+		MediaType:     MediaTypeImage,
+		StartedAt:     start,
+		CompletedAt:   start.Add(time.Minute),
+		ReceivedAt:    start.Add(2 * time.Minute),
+		Commits:       []string{"a5d2c71"},
+		Context:       "Docker Image (api)",
+		Branch:        "master",
+		DefaultBranch: "master",
+		GitTag:        "v1.2.3",
+		Registry:      DefaultRegistry,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		t.Fatal(err)
+	}
+
+	checkSchema(t, "", &s, v)
+}
+
+func checkSchema(t *testing.T, path string, s *schema, v interface{}) {
+	if !schemaTypeMatches(s.Type, v) {
+		t.Fatalf("%s: %#v does not match type %v", path, v, s.Type)
+	}
+
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	for _, name := range s.Required {
+		if _, ok := obj[name]; !ok {
+			t.Fatalf("%s: missing required field %q", path, name)
+		}
+	}
+
+	for name, prop := range s.Properties {
+		if fv, ok := obj[name]; ok {
+			checkSchema(t, path+"."+name, prop, fv)
+		}
+	}
+
+	for name := range obj {
+		if _, ok := s.Properties[name]; !ok {
+			t.Fatalf("%s: field %q is not in the schema", path, name)
+		}
+	}
+}
+
+func schemaTypeMatches(typ interface{}, v interface{}) bool {
+	switch typ := typ.(type) {
+	case nil:
+		return true
+	case []interface{}:
+		for _, t := range typ {
+			if schemaTypeMatches(t, v) {
+				return true
+			}
+		}
+		return false
+	case string:
+		switch v.(type) {
+		case nil:
+			return typ == "null"
+		case string:
+			return typ == "string"
+		case float64:
+			return typ == "integer" || typ == "number"
+		case bool:
+			return typ == "boolean"
+		case []interface{}:
+			return typ == "array"
+		case map[string]interface{}:
+			return typ == "object"
+		}
+	}
+	return false
+}
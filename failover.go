@@ -0,0 +1,215 @@
+package quayd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// FailoverStatusesRepository is a StatusesRepository that creates statuses in
+// a Primary StatusesRepository, falling back to a Secondary when the Primary
+// is unavailable. Statuses that were only written to the Secondary are
+// replayed to the Primary, in the background, once it recovers, unless a
+// newer status for the same commit and context was created in the meantime.
+type FailoverStatusesRepository struct {
+	Primary   StatusesRepository
+	Secondary StatusesRepository
+
+	// Path, if set, is the file that the statuses waiting to be replayed
+	// are saved to, so that they're still replayed after a restart.
+	Path string
+
+	// Logger logs failures to save and replay the held statuses. Defaults
+	// to DefaultLogger.
+	Logger Logger
+
+	mu          sync.Mutex
+	pending     []*Status
+	reconciling bool
+}
+
+// Create implements StatusesRepository Create.
+func (r *FailoverStatusesRepository) Create(ctx context.Context, status *Status) error {
+	if err := r.Primary.Create(ctx, status); err != nil {
+		if err := r.Secondary.Create(ctx, status); err != nil {
+			return err
+		}
+
+		r.mu.Lock()
+		r.drop(status)
+		r.pending = append(r.pending, status)
+		r.mu.Unlock()
+
+		if err := r.Save(); err != nil {
+			r.logger().Log(ctx, "saving held statuses failed", "error", err)
+		}
+		return nil
+	}
+
+	// The status supersedes the ones held for the same commit and context.
+	// The primary is healthy again, so bring it up to date, without
+	// holding up this status.
+	r.mu.Lock()
+	r.drop(status)
+	reconcile := len(r.pending) > 0 && !r.reconciling
+	if reconcile {
+		r.reconciling = true
+	}
+	r.mu.Unlock()
+
+	if reconcile {
+		go r.reconcile()
+	}
+	return nil
+}
+
+// reconcile replays the held statuses in the background.
+func (r *FailoverStatusesRepository) reconcile() {
+	err := r.Reconcile(context.Background())
+
+	r.mu.Lock()
+	r.reconciling = false
+	r.mu.Unlock()
+
+	if err != nil {
+		r.logger().Log(context.Background(), "reconciling held statuses failed", "pending", r.Pending(), "error", err)
+	}
+}
+
+// Reconcile replays any statuses that were written to the Secondary while the
+// Primary was down. Statuses that still fail remain pending. The lock isn't
+// held while replaying, so statuses can be created in the meantime.
+func (r *FailoverStatusesRepository) Reconcile(ctx context.Context) error {
+	r.mu.Lock()
+	pending := append([]*Status(nil), r.pending...)
+	r.mu.Unlock()
+
+	err := r.replay(ctx, pending)
+	if serr := r.Save(); err == nil {
+		err = serr
+	}
+	return err
+}
+
+func (r *FailoverStatusesRepository) replay(ctx context.Context, pending []*Status) error {
+	for _, status := range pending {
+		// Statuses that were superseded since they were taken are
+		// skipped.
+		if !r.held(status) {
+			continue
+		}
+
+		if err := r.Primary.Create(ctx, status); err != nil {
+			return err
+		}
+
+		r.mu.Lock()
+		for i, s := range r.pending {
+			if s == status {
+				r.pending = append(r.pending[:i:i], r.pending[i+1:]...)
+				break
+			}
+		}
+		r.mu.Unlock()
+	}
+
+	return nil
+}
+
+// held returns true if the status is still waiting to be replayed.
+func (r *FailoverStatusesRepository) held(status *Status) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, s := range r.pending {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Pending returns the number of statuses waiting to be reconciled.
+func (r *FailoverStatusesRepository) Pending() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.pending)
+}
+
+// Load restores the held statuses saved to Path. A missing file isn't an
+// error.
+func (r *FailoverStatusesRepository) Load() error {
+	if r.Path == "" {
+		return nil
+	}
+
+	raw, err := ioutil.ReadFile(r.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var pending []*Status
+	if err := json.Unmarshal(raw, &pending); err != nil {
+		return fmt.Errorf("%s: %v", r.Path, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.pending = pending
+	return nil
+}
+
+// Save writes the held statuses to Path.
+func (r *FailoverStatusesRepository) Save() error {
+	if r.Path == "" {
+		return nil
+	}
+
+	r.mu.Lock()
+	raw, err := json.Marshal(r.pending)
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := r.Path + ".tmp"
+	if err := ioutil.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.Path)
+}
+
+func (r *FailoverStatusesRepository) logger() Logger {
+	if r.Logger == nil {
+		return DefaultLogger
+	}
+
+	return r.Logger
+}
+
+// drop removes the pending statuses that status supersedes. r.mu must be
+// held.
+func (r *FailoverStatusesRepository) drop(status *Status) {
+	key := statusKey(status)
+	pending := r.pending[:0]
+	for _, s := range r.pending {
+		if statusKey(s) != key {
+			pending = append(pending, s)
+		}
+	}
+	r.pending = pending
+}
+
+// statusKey identifies the commit and context of a status. A status replaces
+// the earlier statuses with the same key.
+func statusKey(status *Status) string {
+	return fmt.Sprintf("%s@%s/%s", status.Repo, status.Ref, status.Context)
+}
@@ -0,0 +1,176 @@
+package quayd
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// downStatusesRepository is a StatusesRepository that fails while down is
+// true.
+type downStatusesRepository struct {
+	statusesRepository
+	down bool
+}
+
+func (r *downStatusesRepository) Create(ctx context.Context, status *Status) error {
+	if r.down {
+		return errors.New("unavailable")
+	}
+
+	return r.statusesRepository.Create(ctx, status)
+}
+
+// waitReconciled waits for the held statuses to be replayed in the
+// background.
+func waitReconciled(t testing.TB, r *FailoverStatusesRepository) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		r.mu.Lock()
+		reconciling := r.reconciling
+		r.mu.Unlock()
+		if !reconciling {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the held statuses to be reconciled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFailoverStatusesRepository(t *testing.T) {
+	primary := &downStatusesRepository{down: true}
+	secondary := &statusesRepository{}
+	r := &FailoverStatusesRepository{Primary: primary, Secondary: secondary}
+
+	if err := r.Create(context.Background(), &Status{Ref: "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(secondary.statuses), 1; got != want {
+		t.Fatalf("Secondary statuses => %d; want %d", got, want)
+	}
+
+	if got, want := r.Pending(), 1; got != want {
+		t.Fatalf("Pending => %d; want %d", got, want)
+	}
+
+	primary.down = false
+
+	if err := r.Create(context.Background(), &Status{Ref: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	waitReconciled(t, r)
+
+	if got, want := len(primary.statuses), 2; got != want {
+		t.Fatalf("Primary statuses => %d; want %d", got, want)
+	}
+
+	if got, want := r.Pending(), 0; got != want {
+		t.Fatalf("Pending => %d; want %d", got, want)
+	}
+}
+
+func TestFailoverStatusesRepository_Superseded(t *testing.T) {
+	primary := &downStatusesRepository{down: true}
+	r := &FailoverStatusesRepository{Primary: primary, Secondary: &statusesRepository{}}
+	ctx := context.Background()
+
+	r.Create(ctx, &Status{Ref: "a", Context: "ci", State: "pending"})
+	r.Create(ctx, &Status{Ref: "b", Context: "ci", State: "pending"})
+	r.Create(ctx, &Status{Ref: "b", Context: "ci", State: "failure"})
+	if got, want := r.Pending(), 2; got != want {
+		t.Fatalf("Pending => %d; want %d", got, want)
+	}
+
+	primary.down = false
+	if err := r.Create(ctx, &Status{Ref: "a", Context: "ci", State: "success"}); err != nil {
+		t.Fatal(err)
+	}
+	waitReconciled(t, r)
+
+	// The held pending status for a is dropped, rather than replayed over
+	// the newer success.
+	var states []string
+	for _, s := range primary.statuses {
+		states = append(states, s.Ref+":"+s.State)
+	}
+	if got, want := strings.Join(states, ","), "a:success,b:failure"; got != want {
+		t.Fatalf("Primary statuses => %s; want %s", got, want)
+	}
+}
+
+// flakyStatusesRepository is a StatusesRepository that only fails the
+// statuses of failing refs.
+type flakyStatusesRepository struct {
+	statusesRepository
+	failing map[string]bool
+}
+
+func (r *flakyStatusesRepository) Create(ctx context.Context, status *Status) error {
+	if r.failing[status.Ref] {
+		return errors.New("unavailable")
+	}
+
+	return r.statusesRepository.Create(ctx, status)
+}
+
+func TestFailoverStatusesRepository_ReconcileFailure(t *testing.T) {
+	primary := &flakyStatusesRepository{failing: map[string]bool{"a": true}}
+	r := &FailoverStatusesRepository{Primary: primary, Secondary: &statusesRepository{}}
+	ctx := context.Background()
+
+	r.Create(ctx, &Status{Ref: "a"})
+
+	if err := r.Create(ctx, &Status{Ref: "b"}); err != nil {
+		t.Fatalf("Expected a status that was created not to fail, got %v", err)
+	}
+	waitReconciled(t, r)
+	if got, want := r.Pending(), 1; got != want {
+		t.Fatalf("Pending => %d; want %d", got, want)
+	}
+}
+
+func TestFailoverStatusesRepository_Save(t *testing.T) {
+	dir, err := ioutil.TempDir("", "failover")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "failover.json")
+
+	r := &FailoverStatusesRepository{Primary: &downStatusesRepository{down: true}, Secondary: &statusesRepository{}, Path: path}
+	if err := r.Create(context.Background(), &Status{Ref: "a", Context: "ci", State: "success"}); err != nil {
+		t.Fatal(err)
+	}
+
+	primary := &statusesRepository{}
+	loaded := &FailoverStatusesRepository{Primary: primary, Secondary: &statusesRepository{}, Path: path}
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := loaded.Pending(), 1; got != want {
+		t.Fatalf("Pending => %d; want %d", got, want)
+	}
+
+	if err := loaded.Reconcile(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(primary.statuses) != 1 || primary.statuses[0].State != "success" {
+		t.Fatalf("Primary statuses => %+v", primary.statuses)
+	}
+
+	// The reconciled statuses aren't replayed again after a restart.
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := loaded.Pending(), 0; got != want {
+		t.Fatalf("Pending => %d; want %d", got, want)
+	}
+}
@@ -0,0 +1,179 @@
+package quayd
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// RefFilter limits the refs that quayd acts on, e.g. to ignore dependabot
+// branches or only act on main and release tags. Patterns match the full ref
+// name, like refs/heads/main or refs/tags/v1.2.3, and are either globs or
+// regular expressions wrapped in slashes, like `/^refs\/heads\/dependabot\//`.
+// In globs, `*` and `?` don't match a `/`, but `**` matches anything, so
+// `refs/heads/dependabot/**` matches every dependabot branch. A nil
+// *RefFilter allows every ref.
+type RefFilter struct {
+	// Include, if set, only allows refs that match one of the patterns.
+	Include []string `json:"include,omitempty"`
+
+	// Exclude rejects refs that match any of the patterns, even if they're
+	// included.
+	Exclude []string `json:"exclude,omitempty"`
+
+	once             sync.Once
+	include, exclude []*regexp.Regexp
+}
+
+// NewRefFilter returns a RefFilter for the patterns, or an error if any of
+// them is invalid.
+func NewRefFilter(include, exclude []string) (*RefFilter, error) {
+	f := &RefFilter{Include: include, Exclude: exclude}
+	if err := f.Compile(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Compile compiles the patterns, returning an error if any of them is
+// invalid. Filters that aren't compiled are compiled the first time they're
+// used, skipping invalid patterns.
+func (f *RefFilter) Compile() error {
+	if f == nil {
+		return nil
+	}
+
+	include, err := compilePatterns(f.Include)
+	if err != nil {
+		return err
+	}
+	exclude, err := compilePatterns(f.Exclude)
+	if err != nil {
+		return err
+	}
+
+	f.once.Do(func() {
+		f.include, f.exclude = include, exclude
+	})
+	return nil
+}
+
+// Allows reports whether quayd should act on the build event. Events without
+// a known branch or tag are always allowed.
+func (f *RefFilter) Allows(e *BuildEvent) bool {
+	if f == nil {
+		return true
+	}
+
+	ref := refName(e)
+	if ref == "" {
+		return true
+	}
+
+	f.once.Do(func() {
+		f.include, f.exclude = validPatterns(f.Include), validPatterns(f.Exclude)
+	})
+
+	if len(f.Include) > 0 && !matchAny(f.include, ref) {
+		return false
+	}
+
+	return !matchAny(f.exclude, ref)
+}
+
+// refName returns the full name of the ref that was built.
+func refName(e *BuildEvent) string {
+	switch {
+	case e.GitTag != "":
+		return "refs/tags/" + e.GitTag
+	case e.Branch != "":
+		return "refs/heads/" + e.Branch
+	}
+	return ""
+}
+
+func matchAny(patterns []*regexp.Regexp, ref string) bool {
+	for _, re := range patterns {
+		if re.MatchString(ref) {
+			return true
+		}
+	}
+	return false
+}
+
+// compilePatterns compiles each of the patterns.
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, p := range patterns {
+		re, err := compilePattern(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ref pattern %q: %v", p, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// validPatterns compiles the patterns that are valid.
+func validPatterns(patterns []string) []*regexp.Regexp {
+	var compiled []*regexp.Regexp
+	for _, p := range patterns {
+		if re, err := compilePattern(p); err == nil {
+			compiled = append(compiled, re)
+		}
+	}
+	return compiled
+}
+
+// compilePattern compiles a regular expression wrapped in slashes, or a glob.
+func compilePattern(p string) (*regexp.Regexp, error) {
+	if len(p) > 1 && strings.HasPrefix(p, "/") && strings.HasSuffix(p, "/") {
+		return regexp.Compile(p[1 : len(p)-1])
+	}
+
+	return globRegexp(p)
+}
+
+// globRegexp translates a glob into an anchored regular expression. `*` and
+// `?` match within a path segment, `**` matches across segments, and
+// character classes, like `[0-9]` or `[!a-z]`, match a single character.
+func globRegexp(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated character class")
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		case '\\':
+			if i+1 == len(glob) {
+				return nil, fmt.Errorf("trailing backslash")
+			}
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+
+	return regexp.Compile(b.String())
+}
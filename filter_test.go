@@ -0,0 +1,87 @@
+package quayd
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRefFilter(t *testing.T) {
+	f := &RefFilter{
+		Include: []string{"refs/heads/*", "refs/tags/v*"},
+		Exclude: []string{`/^refs\/heads\/dependabot\//`},
+	}
+
+	tests := []struct {
+		e    *BuildEvent
+		want bool
+	}{
+		{&BuildEvent{Branch: "main"}, true},
+		{&BuildEvent{Branch: "dependabot/npm_and_yarn/lodash"}, false},
+		{&BuildEvent{GitTag: "v1.2.3"}, true},
+		{&BuildEvent{GitTag: "nightly"}, false},
+		{&BuildEvent{}, true},
+	}
+
+	for _, tt := range tests {
+		if got := f.Allows(tt.e); got != tt.want {
+			t.Fatalf("Allows(%s) => %v; want %v", refName(tt.e), got, tt.want)
+		}
+	}
+}
+
+func TestRefFilter_Globs(t *testing.T) {
+	f, err := NewRefFilter([]string{"refs/heads/release-[0-9]*", "refs/tags/**"}, []string{"refs/heads/dependabot/**"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		e    *BuildEvent
+		want bool
+	}{
+		{&BuildEvent{Branch: "release-1.2"}, true},
+		{&BuildEvent{Branch: "release-1/hotfix"}, false},
+		{&BuildEvent{Branch: "release-x"}, false},
+		{&BuildEvent{GitTag: "nested/v1.2.3"}, true},
+		{&BuildEvent{Branch: "main"}, false},
+	}
+
+	for _, tt := range tests {
+		if got := f.Allows(tt.e); got != tt.want {
+			t.Fatalf("Allows(%s) => %v; want %v", refName(tt.e), got, tt.want)
+		}
+	}
+
+	f, err = NewRefFilter(nil, []string{"refs/heads/dependabot/**"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Allows(&BuildEvent{Branch: "dependabot/npm_and_yarn/lodash"}) {
+		t.Fatal("Expected ** to match nested branches")
+	}
+}
+
+func TestNewRefFilter_Invalid(t *testing.T) {
+	for _, pattern := range []string{`/refs\/heads\/(/`, "refs/heads/[main"} {
+		if _, err := NewRefFilter(nil, []string{pattern}); err == nil {
+			t.Fatalf("Expected %q to be invalid", pattern)
+		}
+	}
+
+	if _, err := NewFromConfig(&Config{Refs: &RefFilter{Include: []string{"refs/heads/[main"}}}); err == nil {
+		t.Fatal("Expected an invalid ref pattern in the config to error")
+	}
+}
+
+func TestQuayd_RefFilter(t *testing.T) {
+	r := &statusesRepository{}
+	q := &Quayd{StatusesRepository: r, RefFilter: &RefFilter{Include: []string{"refs/heads/main"}}}
+
+	if err := q.Handle(context.Background(), &BuildEvent{Repo: "remind101/acme-inc", Ref: "abcd", Branch: "feature", State: "pending"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.statuses) != 0 {
+		t.Fatal("Expected 0 commit statuses")
+	}
+}
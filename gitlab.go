@@ -0,0 +1,122 @@
+package quayd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// DefaultGitLabURL is the base URL of the GitLab API.
+const DefaultGitLabURL = "https://gitlab.com/api/v4"
+
+// GitLabClient makes authenticated requests to the GitLab API.
+type GitLabClient struct {
+	// Token is a GitLab personal or project access token with the api
+	// scope.
+	Token string
+
+	// URL is the base URL of the API. Defaults to DefaultGitLabURL.
+	URL string
+
+	// Client is the http.Client to use. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// do sends a request for the project path, like `group/project`, and
+// decodes the JSON response into v, if it's not nil.
+func (c *GitLabClient) do(ctx context.Context, method, project, path string, body, v interface{}) error {
+	var raw []byte
+	if body != nil {
+		var err error
+		if raw, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	u := c.url() + "/projects/" + url.PathEscape(project) + path
+	req, err := http.NewRequest(method, u, bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("PRIVATE-TOKEN", c.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("gitlab: %s %s responded with %s", method, u, resp.Status)
+	}
+
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (c *GitLabClient) url() string {
+	if c.URL == "" {
+		return DefaultGitLabURL
+	}
+
+	return c.URL
+}
+
+// GitLabStatusesRepository is an implementation of the StatusesRepository
+// interface that creates GitLab commit statuses, for repos whose source lives
+// on GitLab. Statuses must already be in GitLab's vocabulary, so it's
+// wrapped in a MappedStatusesRepository by NewGitLabStatusesRepository.
+type GitLabStatusesRepository struct {
+	*GitLabClient
+}
+
+// NewGitLabStatusesRepository returns a StatusesRepository that creates GitLab
+// commit statuses with c, translating states with GitLabStates.
+func NewGitLabStatusesRepository(c *GitLabClient) StatusesRepository {
+	return &MappedStatusesRepository{
+		StatusesRepository: &GitLabStatusesRepository{c},
+		States:             GitLabStates,
+	}
+}
+
+// Create implements StatusesRepository Create.
+func (r *GitLabStatusesRepository) Create(ctx context.Context, status *Status) error {
+	return r.do(ctx, "POST", status.Repo, "/statuses/"+status.Ref, map[string]string{
+		"state":       status.State,
+		"name":        status.Context,
+		"target_url":  status.TargetURL,
+		"description": status.Description,
+	}, nil)
+}
+
+// GitLabCommitResolver is an implementation of the CommitResolver interface
+// that resolves short shas against a GitLab project.
+type GitLabCommitResolver struct {
+	*GitLabClient
+}
+
+// Resolve implements CommitResolver Resolve.
+func (r *GitLabCommitResolver) Resolve(ctx context.Context, repo, short string) (string, error) {
+	var commit struct {
+		ID string `json:"id"`
+	}
+	if err := r.do(ctx, "GET", repo, "/repository/commits/"+url.PathEscape(short), nil, &commit); err != nil {
+		return "", err
+	}
+
+	return commit.ID, nil
+}
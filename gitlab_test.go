@@ -0,0 +1,76 @@
+package quayd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGitLabStatusesRepository(t *testing.T) {
+	var (
+		path string
+		body map[string]string
+	)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Header.Get("PRIVATE-TOKEN"), "1234"; got != want {
+			t.Errorf("PRIVATE-TOKEN => %s; want %s", got, want)
+		}
+		path = r.URL.EscapedPath()
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(201)
+	}))
+	defer s.Close()
+
+	r := NewGitLabStatusesRepository(&GitLabClient{Token: "1234", URL: s.URL})
+	err := r.Create(context.Background(), &Status{
+		Repo:        "remind101/acme-inc",
+		Ref:         "abcd",
+		State:       "failure",
+		Context:     "Docker Image",
+		Description: "The Docker image failed to build",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := path, "/projects/remind101%2Facme-inc/statuses/abcd"; got != want {
+		t.Fatalf("Path => %s; want %s", got, want)
+	}
+
+	if got, want := body["state"], "failed"; got != want {
+		t.Fatalf("State => %s; want %s", got, want)
+	}
+
+	if got, want := body["name"], "Docker Image"; got != want {
+		t.Fatalf("Name => %s; want %s", got, want)
+	}
+
+	if err := r.Create(context.Background(), &Status{Repo: "remind101/acme-inc", Ref: "abcd", State: "error", Cancelled: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := body["state"], "canceled"; got != want {
+		t.Fatalf("State => %s; want %s", got, want)
+	}
+}
+
+func TestGitLabCommitResolver(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.URL.EscapedPath(), "/projects/remind101%2Facme-inc/repository/commits/f1fb3b0"; got != want {
+			t.Errorf("Path => %s; want %s", got, want)
+		}
+		w.Write([]byte(`{"id":"f1fb3b0ea1c6b8b0b8e3c9a8d4c2b1a0f9e8d7c6"}`))
+	}))
+	defer s.Close()
+
+	r := &GitLabCommitResolver{&GitLabClient{URL: s.URL}}
+	sha, err := r.Resolve(context.Background(), "remind101/acme-inc", "f1fb3b0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := sha, "f1fb3b0ea1c6b8b0b8e3c9a8d4c2b1a0f9e8d7c6"; got != want {
+		t.Fatalf("Sha => %s; want %s", got, want)
+	}
+}
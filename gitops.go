@@ -0,0 +1,448 @@
+package quayd
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ejholmes/go-github/github"
+)
+
+// DefaultGitOpsWindow is the default window in which image bumps for the
+// same GitOps repo are batched into a single pull request.
+const DefaultGitOpsWindow = 10 * time.Minute
+
+// GitOpsRule maps images to a file in a GitOps repo, whose references to the
+// image are bumped when a new image is built.
+type GitOpsRule struct {
+	// Image is a pattern, as used by path.Match, for the Quay repos
+	// (`namespace/name`) that the rule applies to.
+	Image string `json:"image"`
+
+	// Branch is a pattern for the branches whose builds are bumped. An
+	// empty pattern matches the repo's default branch.
+	Branch string `json:"branch,omitempty"`
+
+	// Repo is the GitOps repo, as `owner/repo`.
+	Repo string `json:"repo"`
+
+	// Path is the file in Repo that references the image.
+	Path string `json:"path"`
+
+	// Base is the branch that pull requests are opened against. Defaults
+	// to master.
+	Base string `json:"base,omitempty"`
+}
+
+// Matches reports whether the build event matches the rule.
+func (r *GitOpsRule) Matches(e *BuildEvent) bool {
+	if ok, _ := path.Match(r.Image, e.Repo); !ok {
+		return false
+	}
+
+	if r.Branch == "" {
+		return e.Branch != "" && e.Branch == e.DefaultBranch
+	}
+
+	ok, _ := path.Match(r.Branch, e.Branch)
+	return e.Branch != "" && ok
+}
+
+func (r *GitOpsRule) base() string {
+	if r.Base == "" {
+		return "master"
+	}
+
+	return r.Base
+}
+
+// ImageBump updates the references to an image in a file to a new tag.
+type ImageBump struct {
+	// Path is the file that references the image.
+	Path string
+
+	// Image is the image name, without a tag, like
+	// `quay.io/remind101/acme-inc`.
+	Image string
+
+	// Tag is the new tag, which is the git sha the image was built from.
+	Tag string
+
+	// BuildURL links to the build of the image.
+	BuildURL string
+}
+
+// Apply returns content with every reference to the image retagged.
+func (b *ImageBump) Apply(content []byte) []byte {
+	re := regexp.MustCompile(regexp.QuoteMeta(b.Image) + `:[\w][\w.-]{0,127}`)
+	return re.ReplaceAllLiteral(content, []byte(b.Image+":"+b.Tag))
+}
+
+// GitOpsPullRequest is a pull request that bumps images in a GitOps repo.
+type GitOpsPullRequest struct {
+	Repo   string
+	Base   string
+	Branch string
+	Title  string
+	Body   string
+	Bumps  []*ImageBump
+}
+
+// GitOpsService is an interface for committing image bumps to a new branch
+// and opening a pull request for them. GitHubGitOpsService implements it.
+type GitOpsService interface {
+	// OpenPullRequest opens the pull request and returns its URL.
+	OpenPullRequest(ctx context.Context, pr *GitOpsPullRequest) (string, error)
+}
+
+// GitOps opens pull requests that bump the images referenced in GitOps repos
+// when new images are built. Bumps for the same GitOps repo within Window
+// are batched into a single pull request, so a burst of builds doesn't open
+// dozens of them. A nil *GitOps bumps nothing.
+type GitOps struct {
+	Rules   []*GitOpsRule
+	Service GitOpsService
+
+	// Window is how long bumps are batched for. Defaults to
+	// DefaultGitOpsWindow.
+	Window time.Duration
+
+	mu      sync.Mutex
+	batches map[string]*gitopsBatch
+}
+
+// gitopsBatch is the pending bumps for a GitOps repo and base branch.
+type gitopsBatch struct {
+	repo, base string
+	bumps      []*ImageBump
+	timer      *time.Timer
+}
+
+// Bump batches a bump of image to tag, for each rule that the build event
+// matches. It returns the number of bumps.
+func (g *GitOps) Bump(e *BuildEvent, image *Image, tag, buildURL string) int {
+	if g == nil {
+		return 0
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	n := 0
+	for _, rule := range g.Rules {
+		if !rule.Matches(e) {
+			continue
+		}
+
+		key := rule.Repo + "@" + rule.base()
+		b, ok := g.batches[key]
+		if !ok {
+			if g.batches == nil {
+				g.batches = make(map[string]*gitopsBatch)
+			}
+			b = &gitopsBatch{repo: rule.Repo, base: rule.base()}
+			g.batches[key] = b
+			b.timer = time.AfterFunc(g.window(), func() {
+				if err := g.flush(context.Background(), key); err != nil {
+					log.Printf("gitops: %s: %s", key, err)
+				}
+			})
+		}
+
+		bump := &ImageBump{
+			Path:     rule.Path,
+			Image:    image.Registry + "/" + image.Repo,
+			Tag:      tag,
+			BuildURL: buildURL,
+		}
+		b.add(bump)
+		n++
+	}
+
+	return n
+}
+
+// Flush opens pull requests for every pending batch, without waiting for
+// the window to end.
+func (g *GitOps) Flush(ctx context.Context) error {
+	if g == nil {
+		return nil
+	}
+
+	g.mu.Lock()
+	var keys []string
+	for key, b := range g.batches {
+		b.timer.Stop()
+		keys = append(keys, key)
+	}
+	g.mu.Unlock()
+	sort.Strings(keys)
+
+	var errs MultiError
+	for _, key := range keys {
+		if err := g.flush(ctx, key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// flush opens a pull request for the batch with the given key.
+func (g *GitOps) flush(ctx context.Context, key string) error {
+	g.mu.Lock()
+	b, ok := g.batches[key]
+	delete(g.batches, key)
+	g.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	_, err := g.Service.OpenPullRequest(ctx, b.pullRequest(time.Now()))
+	return err
+}
+
+func (g *GitOps) window() time.Duration {
+	if g.Window == 0 {
+		return DefaultGitOpsWindow
+	}
+
+	return g.Window
+}
+
+// add adds the bump, replacing an earlier bump of the same image in the
+// same file.
+func (b *gitopsBatch) add(bump *ImageBump) {
+	for i, existing := range b.bumps {
+		if existing.Path == bump.Path && existing.Image == bump.Image {
+			b.bumps[i] = bump
+			return
+		}
+	}
+	b.bumps = append(b.bumps, bump)
+}
+
+func (b *gitopsBatch) pullRequest(now time.Time) *GitOpsPullRequest {
+	pr := &GitOpsPullRequest{
+		Repo:   b.repo,
+		Base:   b.base,
+		Branch: fmt.Sprintf("quayd/bump-%d", now.Unix()),
+		Bumps:  b.bumps,
+	}
+
+	if len(b.bumps) == 1 {
+		pr.Title = fmt.Sprintf("Bump %s to %s", b.bumps[0].Image, shortSha(b.bumps[0].Tag))
+	} else {
+		pr.Title = fmt.Sprintf("Bump %d images", len(b.bumps))
+	}
+
+	var body strings.Builder
+	body.WriteString("This pull request was opened by quayd to deploy newly built images.\n\n")
+	body.WriteString("| Image | Tag | File | Build |\n| --- | --- | --- | --- |\n")
+	for _, bump := range b.bumps {
+		build := ""
+		if bump.BuildURL != "" {
+			build = "[build](" + bump.BuildURL + ")"
+		}
+		fmt.Fprintf(&body, "| `%s` | `%s` | `%s` | %s |\n", bump.Image, bump.Tag, bump.Path, build)
+	}
+	pr.Body = body.String()
+
+	return pr
+}
+
+// gitops batches an image bump for a successful build. GitOps pull requests
+// are never opened from a read-only instance.
+func (q *Quayd) gitops(ctx context.Context, e *BuildEvent, image *Image, sha, buildURL string) {
+	if q.GitOps == nil {
+		return
+	}
+
+	if q.ReadOnly {
+		q.logger().Log(ctx, "gitops bump skipped (read-only)", "repo", e.Repo, "sha", sha)
+		return
+	}
+
+	if n := q.GitOps.Bump(e, image, sha, buildURL); n > 0 {
+		q.logger().Log(ctx, "gitops bump batched", "repo", e.Repo, "sha", sha, "bumps", n)
+	}
+}
+
+// GitHubGitOpsService is an implementation of the GitOpsService interface
+// backed by the GitHub API. The bumps are committed with the Git Data API,
+// so that the commit can be signed.
+type GitHubGitOpsService struct {
+	Client interface {
+		NewRequest(method, urlStr string, body interface{}) (*http.Request, error)
+		Do(req *http.Request, v interface{}) (*github.Response, error)
+	}
+
+	// Signer, if set, signs the commits, which is required by branch
+	// protections that only allow signed commits.
+	Signer CommitSigner
+
+	// AuthorName and AuthorEmail are used as the author and committer of
+	// the commits. They default to "quayd" and a noreply address.
+	AuthorName  string
+	AuthorEmail string
+}
+
+// OpenPullRequest implements GitOpsService OpenPullRequest.
+func (s *GitHubGitOpsService) OpenPullRequest(ctx context.Context, pr *GitOpsPullRequest) (string, error) {
+	var ref struct {
+		Object struct {
+			Sha string `json:"sha"`
+		} `json:"object"`
+	}
+	if err := s.do(ctx, "GET", fmt.Sprintf("repos/%s/git/ref/heads/%s", pr.Repo, pr.Base), nil, &ref); err != nil {
+		return "", err
+	}
+	parent := ref.Object.Sha
+
+	var commit struct {
+		Tree struct {
+			Sha string `json:"sha"`
+		} `json:"tree"`
+	}
+	if err := s.do(ctx, "GET", fmt.Sprintf("repos/%s/git/commits/%s", pr.Repo, parent), nil, &commit); err != nil {
+		return "", err
+	}
+
+	// Apply the bumps to each file.
+	var (
+		paths []string
+		files = make(map[string][]byte)
+	)
+	for _, bump := range pr.Bumps {
+		content, ok := files[bump.Path]
+		if !ok {
+			var err error
+			if content, err = s.content(ctx, pr.Repo, bump.Path, parent); err != nil {
+				return "", err
+			}
+			paths = append(paths, bump.Path)
+		}
+		files[bump.Path] = bump.Apply(content)
+	}
+
+	var entries []map[string]string
+	for _, p := range paths {
+		entries = append(entries, map[string]string{
+			"path":    p,
+			"mode":    "100644",
+			"type":    "blob",
+			"content": string(files[p]),
+		})
+	}
+	var tree struct {
+		Sha string `json:"sha"`
+	}
+	if err := s.do(ctx, "POST", fmt.Sprintf("repos/%s/git/trees", pr.Repo), map[string]interface{}{
+		"base_tree": commit.Tree.Sha,
+		"tree":      entries,
+	}, &tree); err != nil {
+		return "", err
+	}
+
+	sha, err := s.commit(ctx, pr, tree.Sha, parent)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.do(ctx, "POST", fmt.Sprintf("repos/%s/git/refs", pr.Repo), map[string]string{
+		"ref": "refs/heads/" + pr.Branch,
+		"sha": sha,
+	}, nil); err != nil {
+		return "", err
+	}
+
+	var created struct {
+		HTMLURL string `json:"html_url"`
+	}
+	err = s.do(ctx, "POST", fmt.Sprintf("repos/%s/pulls", pr.Repo), map[string]string{
+		"title": pr.Title,
+		"head":  pr.Branch,
+		"base":  pr.Base,
+		"body":  pr.Body,
+	}, &created)
+	return created.HTMLURL, err
+}
+
+// content returns the content of the file at ref.
+func (s *GitHubGitOpsService) content(ctx context.Context, repo, file, ref string) ([]byte, error) {
+	var content struct {
+		Content string `json:"content"`
+	}
+	if err := s.do(ctx, "GET", fmt.Sprintf("repos/%s/contents/%s?ref=%s", repo, file, url.QueryEscape(ref)), nil, &content); err != nil {
+		return nil, err
+	}
+
+	return base64.StdEncoding.DecodeString(strings.Replace(content.Content, "\n", "", -1))
+}
+
+// commit creates a commit of tree, signing it if there's a Signer.
+func (s *GitHubGitOpsService) commit(ctx context.Context, pr *GitOpsPullRequest, tree, parent string) (string, error) {
+	c := &GitCommit{
+		Message:     pr.Title + "\n",
+		Tree:        tree,
+		Parents:     []string{parent},
+		AuthorName:  s.AuthorName,
+		AuthorEmail: s.AuthorEmail,
+		Date:        time.Now().UTC().Truncate(time.Second),
+	}
+	if c.AuthorName == "" {
+		c.AuthorName = "quayd"
+	}
+	if c.AuthorEmail == "" {
+		c.AuthorEmail = "quayd@users.noreply.github.com"
+	}
+
+	author := map[string]string{
+		"name":  c.AuthorName,
+		"email": c.AuthorEmail,
+		"date":  c.Date.Format(time.RFC3339),
+	}
+	body := map[string]interface{}{
+		"message":   c.Message,
+		"tree":      c.Tree,
+		"parents":   c.Parents,
+		"author":    author,
+		"committer": author,
+	}
+	if s.Signer != nil {
+		sig, err := s.Signer.SignCommit(c.Payload())
+		if err != nil {
+			return "", err
+		}
+		body["signature"] = sig
+	}
+
+	var created struct {
+		Sha string `json:"sha"`
+	}
+	err := s.do(ctx, "POST", fmt.Sprintf("repos/%s/git/commits", pr.Repo), body, &created)
+	return created.Sha, err
+}
+
+func (s *GitHubGitOpsService) do(ctx context.Context, method, urlStr string, body, v interface{}) error {
+	req, err := s.Client.NewRequest(method, urlStr, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	_, err = s.Client.Do(req.WithContext(ctx), v)
+	return err
+}
@@ -0,0 +1,201 @@
+package quayd
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ejholmes/go-github/github"
+)
+
+// gitopsService is a fake implementation of the GitOpsService interface.
+type gitopsService struct {
+	mu  sync.Mutex
+	prs []*GitOpsPullRequest
+}
+
+func (s *gitopsService) OpenPullRequest(ctx context.Context, pr *GitOpsPullRequest) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prs = append(s.prs, pr)
+	return "", nil
+}
+
+func TestQuayd_GitOps(t *testing.T) {
+	s := &gitopsService{}
+	registry := &MemoryRegistry{}
+	registry.Seed("remind101/acme-inc", "test")
+	registry.Seed("remind101/acme-worker", "test")
+	q := &Quayd{
+		StatusesRepository: &statusesRepository{},
+		TagResolver:        registry,
+		Tagger:             registry,
+		GitOps: &GitOps{
+			Rules: []*GitOpsRule{
+				{Image: "remind101/*", Repo: "remind101/deploys", Path: "staging/acme.yml"},
+			},
+			Service: s,
+			Window:  time.Hour,
+		},
+	}
+
+	ctx := context.Background()
+	for _, e := range []*BuildEvent{
+		{Repo: "remind101/acme-inc", Ref: "abcd", Branch: "master", DefaultBranch: "master", State: "success", Tags: []string{"test"}},
+		{Repo: "remind101/acme-worker", Ref: "abcd", Branch: "master", DefaultBranch: "master", State: "success", Tags: []string{"test"}},
+		{Repo: "remind101/acme-inc", Ref: "ef01", Branch: "master", DefaultBranch: "master", State: "success", Tags: []string{"test"}},
+		{Repo: "remind101/acme-inc", Ref: "2345", Branch: "feature", DefaultBranch: "master", State: "success", Tags: []string{"test"}},
+	} {
+		if err := q.Handle(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(s.prs) != 0 {
+		t.Fatal("Expected bumps to be batched until the window ends")
+	}
+	if err := q.GitOps.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(s.prs), 1; got != want {
+		t.Fatalf("Pull requests => %d; want %d", got, want)
+	}
+	pr := s.prs[0]
+	if got, want := pr.Title, "Bump 2 images"; got != want {
+		t.Fatalf("Title => %s; want %s", got, want)
+	}
+
+	var tags []string
+	for _, b := range pr.Bumps {
+		tags = append(tags, b.Image+":"+b.Tag)
+	}
+	if want := []string{"quay.io/remind101/acme-inc:long-ef01", "quay.io/remind101/acme-worker:long-abcd"}; !reflect.DeepEqual(tags, want) {
+		t.Fatalf("Bumps => %v; want %v", tags, want)
+	}
+	if !strings.Contains(pr.Body, "| `quay.io/remind101/acme-worker` | `long-abcd` | `staging/acme.yml` |") {
+		t.Fatalf("Body => %s", pr.Body)
+	}
+}
+
+func TestGitOps_Window(t *testing.T) {
+	s := &gitopsService{}
+	g := &GitOps{
+		Rules:   []*GitOpsRule{{Image: "*/*", Branch: "*", Repo: "remind101/deploys", Path: "acme.yml"}},
+		Service: s,
+		Window:  time.Millisecond,
+	}
+
+	g.Bump(&BuildEvent{Repo: "remind101/acme-inc", Branch: "master"}, &Image{Registry: "quay.io", Repo: "remind101/acme-inc"}, "abcd", "")
+
+	for i := 0; ; i++ {
+		s.mu.Lock()
+		opened := len(s.prs)
+		s.mu.Unlock()
+		if opened == 1 {
+			break
+		}
+		if i == 100 {
+			t.Fatal("Expected a pull request once the window ended")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestImageBump_Apply(t *testing.T) {
+	b := &ImageBump{Image: "quay.io/remind101/acme-inc", Tag: "abcd"}
+	in := "image: quay.io/remind101/acme-inc:1234\nworker: quay.io/remind101/acme-inc-worker:1234\n"
+	want := "image: quay.io/remind101/acme-inc:abcd\nworker: quay.io/remind101/acme-inc-worker:1234\n"
+
+	if got := string(b.Apply([]byte(in))); got != want {
+		t.Fatalf("Apply => %q; want %q", got, want)
+	}
+}
+
+func TestGitHubGitOpsService(t *testing.T) {
+	var (
+		requests []string
+		commit   map[string]interface{}
+		tree     map[string]interface{}
+	)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch r.Method + " " + r.URL.Path {
+		case "GET /repos/remind101/deploys/git/ref/heads/master":
+			w.Write([]byte(`{"object":{"sha":"parent"}}`))
+		case "GET /repos/remind101/deploys/git/commits/parent":
+			w.Write([]byte(`{"tree":{"sha":"base"}}`))
+		case "GET /repos/remind101/deploys/contents/acme.yml":
+			content := base64.StdEncoding.EncodeToString([]byte("image: quay.io/remind101/acme-inc:1234\n"))
+			json.NewEncoder(w).Encode(map[string]string{"content": content})
+		case "POST /repos/remind101/deploys/git/trees":
+			json.NewDecoder(r.Body).Decode(&tree)
+			w.Write([]byte(`{"sha":"tree"}`))
+		case "POST /repos/remind101/deploys/git/commits":
+			json.NewDecoder(r.Body).Decode(&commit)
+			w.Write([]byte(`{"sha":"commit"}`))
+		case "POST /repos/remind101/deploys/pulls":
+			w.Write([]byte(`{"html_url":"https://github.com/remind101/deploys/pull/1"}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer s.Close()
+
+	g := github.NewClient(nil)
+	g.BaseURL, _ = url.Parse(s.URL + "/")
+	raw, err := ioutil.ReadFile("test-fixtures/ssh/id_ed25519")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ParseSSHCommitSigner(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := &GitHubGitOpsService{Client: g, Signer: signer}
+
+	u, err := service.OpenPullRequest(context.Background(), &GitOpsPullRequest{
+		Repo:   "remind101/deploys",
+		Base:   "master",
+		Branch: "quayd/bump-1",
+		Title:  "Bump quay.io/remind101/acme-inc to abcd",
+		Bumps:  []*ImageBump{{Path: "acme.yml", Image: "quay.io/remind101/acme-inc", Tag: "abcd"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := u, "https://github.com/remind101/deploys/pull/1"; got != want {
+		t.Fatalf("URL => %s; want %s", got, want)
+	}
+
+	want := []string{
+		"GET /repos/remind101/deploys/git/ref/heads/master",
+		"GET /repos/remind101/deploys/git/commits/parent",
+		"GET /repos/remind101/deploys/contents/acme.yml",
+		"POST /repos/remind101/deploys/git/trees",
+		"POST /repos/remind101/deploys/git/commits",
+		"POST /repos/remind101/deploys/git/refs",
+		"POST /repos/remind101/deploys/pulls",
+	}
+	if !reflect.DeepEqual(requests, want) {
+		t.Fatalf("Requests => %v; want %v", requests, want)
+	}
+
+	entry := tree["tree"].([]interface{})[0].(map[string]interface{})
+	if got, want := entry["content"], "image: quay.io/remind101/acme-inc:abcd\n"; got != want {
+		t.Fatalf("Content => %q; want %q", got, want)
+	}
+	if sig, _ := commit["signature"].(string); !strings.HasPrefix(sig, "-----BEGIN SSH SIGNATURE-----") {
+		t.Fatalf("Signature => %q", sig)
+	}
+}
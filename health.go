@@ -0,0 +1,129 @@
+package quayd
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The downstream dependencies whose health is reported on /statusz.
+const (
+	DependencyGitHub   = "github"
+	DependencyQuay     = "quay"
+	DependencyRegistry = "registry"
+	DependencyQueue    = "queue"
+)
+
+// DependencyHealth is the health of a single downstream dependency.
+type DependencyHealth struct {
+	Name string `json:"name"`
+
+	// Healthy is true when the most recent call to the dependency
+	// succeeded.
+	Healthy bool `json:"healthy"`
+
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastFailure *time.Time `json:"last_failure,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+// Dependencies records the outcome of calls to downstream dependencies. A
+// nil *Dependencies records nothing.
+type Dependencies struct {
+	mu   sync.Mutex
+	deps map[string]*DependencyHealth
+}
+
+// Observe records the outcome of a call to dependency.
+func (h *Dependencies) Observe(dependency string, err error) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.deps == nil {
+		h.deps = make(map[string]*DependencyHealth)
+	}
+	d, ok := h.deps[dependency]
+	if !ok {
+		d = &DependencyHealth{Name: dependency}
+		h.deps[dependency] = d
+	}
+
+	now := time.Now()
+	if err != nil {
+		d.Healthy = false
+		d.LastFailure = &now
+		d.LastError = err.Error()
+	} else {
+		d.Healthy = true
+		d.LastSuccess = &now
+	}
+}
+
+// Health returns a copy of the health of each observed dependency,
+// sorted by name.
+func (h *Dependencies) Health() []*DependencyHealth {
+	if h == nil {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	deps := make([]*DependencyHealth, 0, len(h.deps))
+	for _, d := range h.deps {
+		c := *d
+		deps = append(deps, &c)
+	}
+	sort.Slice(deps, func(i, j int) bool { return deps[i].Name < deps[j].Name })
+	return deps
+}
+
+// Statusz is the response of the /statusz endpoint.
+type Statusz struct {
+	// Status is "ok" when every dependency is healthy, and "degraded"
+	// otherwise.
+	Status string `json:"status"`
+
+	Dependencies []*DependencyHealth `json:"dependencies"`
+
+	// Queue describes the async queue, when it's enabled.
+	Queue *QueueStatus `json:"queue,omitempty"`
+}
+
+// QueueStatus is the depth of the async queue.
+type QueueStatus struct {
+	Depth    int `json:"depth"`
+	Capacity int `json:"capacity"`
+}
+
+// StatuszHandler is an http.Handler that aggregates the health of quayd's
+// downstream dependencies into a single JSON document, suitable for feeding
+// an external status page.
+type StatuszHandler struct {
+	*Quayd
+}
+
+func (h *StatuszHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s := &Statusz{Status: "ok", Dependencies: h.Dependencies.Health()}
+	if s.Dependencies == nil {
+		s.Dependencies = []*DependencyHealth{}
+	}
+	for _, d := range s.Dependencies {
+		if !d.Healthy {
+			s.Status = "degraded"
+		}
+	}
+
+	if h.Queue != nil {
+		s.Queue = &QueueStatus{Depth: h.Queue.Len(), Capacity: h.Queue.Cap()}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
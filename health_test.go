@@ -0,0 +1,68 @@
+package quayd
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatuszHandler(t *testing.T) {
+	q := &Quayd{StatusesRepository: &statusesRepository{}, Dependencies: &Dependencies{}, Queue: &Queue{Size: 5}}
+	s := NewServer(q)
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/quay/success", loadFixture("pending_build", t))
+	s.ServeHTTP(resp, req)
+
+	var statusz Statusz
+	resp = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/statusz", nil)
+	s.ServeHTTP(resp, req)
+	if err := json.NewDecoder(resp.Body).Decode(&statusz); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := statusz.Status, "ok"; got != want {
+		t.Fatalf("Status => %s; want %s", got, want)
+	}
+	if got, want := len(statusz.Dependencies), 1; got != want {
+		t.Fatalf("Dependencies => %d; want %d", got, want)
+	}
+	if d := statusz.Dependencies[0]; d.Name != DependencyQueue || d.LastSuccess == nil {
+		t.Fatalf("Dependency => %+v", d)
+	}
+	if got, want := *statusz.Queue, (QueueStatus{Depth: 1, Capacity: 5}); got != want {
+		t.Fatalf("Queue => %+v; want %+v", got, want)
+	}
+}
+
+func TestStatuszHandler_Degraded(t *testing.T) {
+	down := errors.New("github is down")
+	h := &Dependencies{}
+	h.Observe(DependencyGitHub, nil)
+	h.Observe(DependencyRegistry, nil)
+	h.Observe(DependencyGitHub, down)
+
+	s := NewServer(&Quayd{Dependencies: h})
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/statusz", nil)
+	s.ServeHTTP(resp, req)
+
+	var statusz Statusz
+	if err := json.NewDecoder(resp.Body).Decode(&statusz); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := statusz.Status, "degraded"; got != want {
+		t.Fatalf("Status => %s; want %s", got, want)
+	}
+	github := statusz.Dependencies[0]
+	if github.Name != DependencyGitHub || github.Healthy || github.LastSuccess == nil || github.LastError != down.Error() {
+		t.Fatalf("Dependency => %+v", github)
+	}
+	if statusz.Queue != nil {
+		t.Fatalf("Expected no queue, got %+v", statusz.Queue)
+	}
+}
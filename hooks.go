@@ -0,0 +1,108 @@
+package quayd
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/remind101/quayd/api"
+)
+
+// SignatureHeader is the header that outbound webhooks are signed with.
+const SignatureHeader = "X-Quayd-Signature"
+
+// DefaultTagHook is the default TagHook to use.
+var DefaultTagHook = &tagHook{}
+
+// TagEvent describes tags that quayd applied to an image.
+type TagEvent = api.TagEvent
+
+// TagHook is an interface that is notified after quayd applies tags to an
+// image.
+type TagHook = api.TagHook
+
+// tagHook is a fake implementation of the TagHook interface.
+type tagHook struct{}
+
+// TagsApplied implements TagHook TagsApplied.
+func (h *tagHook) TagsApplied(ctx context.Context, event *TagEvent) error {
+	return nil
+}
+
+// WebhookTagHook is a TagHook that POSTs the TagEvent as JSON to each of the
+// URLs. When a Secret is provided, the body is signed with HMAC-SHA256 and
+// the signature is sent in the X-Quayd-Signature header.
+type WebhookTagHook struct {
+	URLs   []string
+	Secret string
+
+	// Client is the http.Client to use. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// TagsApplied implements TagHook TagsApplied. Every URL is notified, even
+// if an earlier one fails, and the failures are returned together as a
+// MultiError.
+func (h *WebhookTagHook) TagsApplied(ctx context.Context, event *TagEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	var errs MultiError
+	for _, url := range h.URLs {
+		if err := h.post(ctx, url, body); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// post sends body to url.
+func (h *WebhookTagHook) post(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if h.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign([]byte(h.Secret), body))
+	}
+
+	resp, err := h.client().Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("tag hook %s responded with %s", url, resp.Status)
+	}
+
+	return nil
+}
+
+func (h *WebhookTagHook) client() *http.Client {
+	if h.Client == nil {
+		return http.DefaultClient
+	}
+
+	return h.Client
+}
+
+// Sign returns the `sha256=<hex>` HMAC signature of body using secret.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
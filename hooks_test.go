@@ -0,0 +1,70 @@
+package quayd
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestWebhookTagHook(t *testing.T) {
+	var (
+		signature string
+		got       TagEvent
+	)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get(SignatureHeader) != Sign([]byte("secret"), body) {
+			w.WriteHeader(401)
+			return
+		}
+
+		signature = r.Header.Get(SignatureHeader)
+		json.Unmarshal(body, &got)
+	}))
+	defer s.Close()
+
+	h := &WebhookTagHook{URLs: []string{s.URL}, Secret: "secret"}
+	want := TagEvent{Repo: "ejholmes/docker-statsd", Sha: "abcd", ImageID: "1234", Tags: []string{"abcd", "1234"}}
+
+	if err := h.TagsApplied(context.Background(), &want); err != nil {
+		t.Fatal(err)
+	}
+
+	if signature == "" {
+		t.Fatal("Expected a signature")
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("TagEvent => %v; want %v", got, want)
+	}
+}
+
+func TestWebhookTagHook_Error(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+	}))
+	defer s.Close()
+
+	var delivered int
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered++
+	}))
+	defer ok.Close()
+
+	h := &WebhookTagHook{URLs: []string{s.URL, ok.URL, s.URL}}
+
+	err, _ := h.TagsApplied(context.Background(), &TagEvent{}).(MultiError)
+	if got, want := len(err), 2; got != want {
+		t.Fatalf("Errors => %d; want %d", got, want)
+	}
+
+	// A failing hook doesn't stop the others from being notified.
+	if got, want := delivered, 1; got != want {
+		t.Fatalf("Deliveries => %d; want %d", got, want)
+	}
+}
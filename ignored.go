@@ -0,0 +1,157 @@
+package quayd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultMaxIgnoredEvents is the default number of ignored events that
+// IgnoredEvents keeps.
+const DefaultMaxIgnoredEvents = 1000
+
+// The reasons that events are ignored.
+const (
+	// IgnoredManualBuild is a build that was started manually in Quay,
+	// rather than by quayd or a push.
+	IgnoredManualBuild = "manual-build"
+
+	// IgnoredNotGitHub is a build that wasn't triggered from GitHub.
+	IgnoredNotGitHub = "not-github"
+
+	// IgnoredUnknownState is a notification for a state or event that
+	// quayd doesn't know.
+	IgnoredUnknownState = "unknown-state"
+
+	// IgnoredRefFilter is a build of a ref that the RefFilter excludes.
+	IgnoredRefFilter = "ref-filter"
+
+	// IgnoredDuplicate is a redelivery of an event that was already
+	// processed.
+	IgnoredDuplicate = "duplicate"
+
+	// IgnoredDirective is a build of a commit with a `[skip image]`
+	// directive.
+	IgnoredDirective = "skip-directive"
+
+	// IgnoredPolicy is a build of a repo whose policy doesn't allow commit
+	// statuses.
+	IgnoredPolicy = "policy"
+
+	// IgnoredRule is a build that an EventRule skips, or doesn't create
+	// commit statuses for.
+	IgnoredRule = "rule"
+
+	// IgnoredNotSha is a Docker Hub push of a tag, like `latest`, that
+	// isn't a commit sha.
+	IgnoredNotSha = "not-sha"
+)
+
+// IgnoredEvent is an event that was received, but intentionally not
+// processed, and why.
+type IgnoredEvent struct {
+	ID     string    `json:"id"`
+	Repo   string    `json:"repo"`
+	Ref    string    `json:"ref"`
+	State  string    `json:"state"`
+	Reason string    `json:"reason"`
+	At     time.Time `json:"at"`
+}
+
+// IgnoredEvents keeps the most recent ignored events, so that "why didn't my
+// commit get a status?" can be answered. A nil *IgnoredEvents records
+// nothing.
+type IgnoredEvents struct {
+	// Max is the number of events to keep. Defaults to
+	// DefaultMaxIgnoredEvents.
+	Max int
+
+	mu     sync.Mutex
+	events []*IgnoredEvent
+}
+
+// Record adds an ignored event, dropping the oldest when there are more than
+// Max.
+func (s *IgnoredEvents) Record(e *IgnoredEvent) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events = append(s.events, e)
+	if max := s.max(); len(s.events) > max {
+		s.events = s.events[len(s.events)-max:]
+	}
+}
+
+// List returns the ignored events, newest first, optionally only those of
+// repo and ref.
+func (s *IgnoredEvents) List(repo, ref string) []*IgnoredEvent {
+	events := []*IgnoredEvent{}
+	if s == nil {
+		return events
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := len(s.events) - 1; i >= 0; i-- {
+		e := s.events[i]
+		if (repo == "" || e.Repo == repo) && (ref == "" || e.Ref == ref) {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+func (s *IgnoredEvents) max() int {
+	if s.Max == 0 {
+		return DefaultMaxIgnoredEvents
+	}
+
+	return s.Max
+}
+
+// ignore records that the event was intentionally not processed.
+func (q *Quayd) ignore(ctx context.Context, e *BuildEvent, reason string) {
+	q.Metrics.EventIgnored(e.Repo, reason)
+	q.Ignored.Record(&IgnoredEvent{
+		ID:     e.ID,
+		Repo:   e.Repo,
+		Ref:    e.Ref,
+		State:  e.State,
+		Reason: reason,
+		At:     time.Now(),
+	})
+
+	if q.Deliveries == nil || e.ID == "" {
+		return
+	}
+
+	d, err := q.Deliveries.Find(e.ID)
+	if err != nil {
+		return
+	}
+	d.ProcessedAt = time.Now()
+	d.Ignored = reason
+	if err := q.Deliveries.Save(d); err != nil {
+		q.logger().Log(ctx, "saving delivery failed", "error", err)
+	}
+}
+
+// IgnoredHandler is an http.Handler that lists the ignored events, optionally
+// filtered by the `repo` and `ref` query parameters.
+type IgnoredHandler struct {
+	*Quayd
+}
+
+func (h *IgnoredHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.Ignored.List(query.Get("repo"), query.Get("ref")))
+}
@@ -0,0 +1,70 @@
+package quayd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIgnoredEvents(t *testing.T) {
+	q := &Quayd{
+		AdminToken:         testAdminToken,
+		StatusesRepository: &statusesRepository{},
+		Ignored:            &IgnoredEvents{},
+		Metrics:            &Metrics{},
+		RefFilter:          &RefFilter{Exclude: []string{"refs/heads/dependabot/*"}},
+	}
+	s := NewServer(q)
+
+	post := func(path, body string) {
+		resp := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", path, strings.NewReader(body))
+		s.ServeHTTP(resp, req)
+	}
+	post("/quay/pending", `{"repository":"remind101/acme-inc","trigger_kind":"github","is_manual":true,"build_name":"efgh"}`)
+	post("/quay/exploded", `{}`)
+	if err := q.Handle(context.Background(), &BuildEvent{Repo: "remind101/acme-inc", Ref: "abcd", Branch: "dependabot/npm", State: "pending"}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := httptest.NewRecorder()
+	req, _ := newAdminRequest("GET", "/admin/ignored", nil)
+	s.ServeHTTP(resp, req)
+
+	var events []*IgnoredEvent
+	if err := json.NewDecoder(resp.Body).Decode(&events); err != nil {
+		t.Fatal(err)
+	}
+
+	var reasons []string
+	for _, e := range events {
+		reasons = append(reasons, e.Reason)
+	}
+	want := []string{IgnoredRefFilter, IgnoredUnknownState, IgnoredManualBuild}
+	if len(reasons) != len(want) {
+		t.Fatalf("Reasons => %v; want %v", reasons, want)
+	}
+	for i := range want {
+		if reasons[i] != want[i] {
+			t.Fatalf("Reasons => %v; want %v", reasons, want)
+		}
+	}
+
+	if got := q.Ignored.List("remind101/acme-inc", "abcd"); len(got) != 1 || got[0].Reason != IgnoredRefFilter {
+		t.Fatalf("Unexpected events for remind101/acme-inc@abcd: %+v", got)
+	}
+}
+
+func TestIgnoredEvents_Max(t *testing.T) {
+	s := &IgnoredEvents{Max: 2}
+	for _, ref := range []string{"a", "b", "c"} {
+		s.Record(&IgnoredEvent{Ref: ref})
+	}
+
+	if events := s.List("", ""); len(events) != 2 || events[0].Ref != "c" || events[1].Ref != "b" {
+		t.Fatalf("Unexpected events %+v", events)
+	}
+}
@@ -0,0 +1,241 @@
+package quayd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultConsumerBackoff is how long a Consumer waits before receiving again
+// after its source fails.
+const DefaultConsumerBackoff = 5 * time.Second
+
+// MessageSource is a message queue that Quay notifications are delivered to,
+// for setups where quayd can't receive webhooks over HTTP. The messages are
+// Quay webhook payloads, which include the `event` that determines the state.
+//
+// RedisSource consumes a Redis list. Other queues, like an AMQP queue, can be
+// consumed by implementing MessageSource with their client library.
+type MessageSource interface {
+	// Receive blocks until a message is available, or ctx is done, and
+	// returns its body.
+	Receive(ctx context.Context) ([]byte, error)
+}
+
+// Consumer receives Quay notifications from a MessageSource and processes
+// them like webhooks.
+type Consumer struct {
+	Source MessageSource
+
+	// Quayd returns the Quayd instance that processes the notifications,
+	// so that they're processed with the current instance after a reload,
+	// e.g. Server.Quayd.
+	Quayd func() *Quayd
+
+	// Context, if set, is the commit status context to use, like the
+	// `context` query parameter of the webhook URL.
+	Context string
+
+	// Backoff is how long to wait after the source fails. Defaults to
+	// DefaultConsumerBackoff.
+	Backoff time.Duration
+}
+
+// Run receives and processes notifications until ctx is done. Notifications
+// that fail to process are recorded as failed deliveries, which can be
+// replayed.
+func (c *Consumer) Run(ctx context.Context) error {
+	for {
+		body, err := c.Source.Receive(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		q := c.Quayd()
+		if err != nil {
+			q.logger().Log(ctx, "receiving notification failed", "error", err)
+			select {
+			case <-time.After(c.backoff()):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			continue
+		}
+		if body == nil {
+			continue
+		}
+
+		if err := q.Ingest(ctx, body, c.Context); err != nil {
+			q.logger().Log(ctx, "processing notification failed", "error", err)
+		}
+	}
+}
+
+func (c *Consumer) backoff() time.Duration {
+	if c.Backoff == 0 {
+		return DefaultConsumerBackoff
+	}
+
+	return c.Backoff
+}
+
+// Ingest processes a Quay notification that was received from a message
+// queue, through the same parsing, validation, delivery recording and
+// queueing as webhooks.
+func (q *Quayd) Ingest(ctx context.Context, body []byte, statusContext string) error {
+	id, received := newID(), time.Now()
+	q.Timelines.Record(id, "received", received, nil)
+
+	start := time.Now()
+	var form WebhookForm
+	err := json.Unmarshal(body, &form)
+	q.Timelines.Record(id, "parsed", start, err)
+	if err != nil {
+		return err
+	}
+
+	status, err := formStatus(form.Event)
+	if err != nil {
+		q.ignore(ctx, &BuildEvent{ID: id, Repo: form.Repository, Ref: form.BuildName, State: form.Event}, IgnoredUnknownState)
+		return err
+	}
+
+	e, err := q.accept(ctx, id, status, statusContext, &form, body, received)
+	if err != nil || e == nil {
+		return err
+	}
+
+	if q.Queue != nil {
+		err := q.Queue.Push(q, e)
+		q.Dependencies.Observe(DependencyQueue, err)
+		return err
+	}
+
+	return q.Handle(ctx, e)
+}
+
+// RedisSource is a MessageSource that pops notifications off of a Redis list,
+// which a notification forwarder pushes them onto with RPUSH.
+type RedisSource struct {
+	// Addr is the `host:port` of the Redis server.
+	Addr string
+
+	// Password, if set, authenticates the connection.
+	Password string
+
+	// Key is the list that notifications are pushed onto.
+	Key string
+
+	// Timeout is how long a BLPOP blocks for, after which Receive returns
+	// a nil message. Defaults to 5 seconds.
+	Timeout time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// ParseRedisSource returns a RedisSource for a URL like
+// `redis://:password@host:6379/quay-notifications`, where the path is the
+// list key.
+func ParseRedisSource(rawurl string) (*RedisSource, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" || u.Host == "" || len(u.Path) < 2 {
+		return nil, fmt.Errorf("invalid redis source %q: expected redis://host:port/key", rawurl)
+	}
+
+	s := &RedisSource{Addr: u.Host, Key: u.Path[1:]}
+	if u.User != nil {
+		s.Password, _ = u.User.Password()
+	}
+	return s, nil
+}
+
+// Receive implements MessageSource Receive.
+func (s *RedisSource) Receive(ctx context.Context) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if s.conn == nil {
+		if err := s.dial(); err != nil {
+			return nil, err
+		}
+	}
+
+	body, err := s.pop()
+	if err != nil {
+		// Don't reuse a connection that may be in a bad state.
+		s.conn.Close()
+		s.conn = nil
+	}
+
+	return body, err
+}
+
+func (s *RedisSource) dial() error {
+	conn, r, err := redisDial(s.Addr, s.Password)
+	if err != nil {
+		return err
+	}
+	s.conn, s.r = conn, r
+
+	return nil
+}
+
+// pop blocks on BLPOP, returning nil when it times out.
+func (s *RedisSource) pop() ([]byte, error) {
+	timeout := s.timeout()
+	s.conn.SetDeadline(time.Now().Add(timeout + 5*time.Second))
+
+	cmd := redisCommand([]string{"BLPOP", s.Key, strconv.Itoa(int(timeout / time.Second))})
+	if _, err := io.WriteString(s.conn, cmd); err != nil {
+		return nil, err
+	}
+
+	// The reply is a [key, value] array, or a nil array on timeout.
+	line, err := readRedisLine(s.r)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case line == "*-1":
+		return nil, nil
+	case line == "*2":
+	case line[0] == '-':
+		return nil, errors.New("redis: " + line[1:])
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+
+	if _, err := readRedisReply(s.r); err != nil {
+		return nil, err
+	}
+	value, err := readRedisReply(s.r)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	return []byte(*value), nil
+}
+
+func (s *RedisSource) timeout() time.Duration {
+	if s.Timeout < time.Second {
+		return 5 * time.Second
+	}
+
+	return s.Timeout
+}
@@ -0,0 +1,81 @@
+package quayd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestParseRedisSource(t *testing.T) {
+	s, err := ParseRedisSource("redis://:secret@localhost:6379/quay-notifications")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Addr != "localhost:6379" || s.Password != "secret" || s.Key != "quay-notifications" {
+		t.Fatalf("Unexpected source %+v", s)
+	}
+
+	if _, err := ParseRedisSource("localhost:6379"); err == nil {
+		t.Fatal("Expected an error for a source without a key")
+	}
+}
+
+func TestConsumer(t *testing.T) {
+	l := newFakeRedis(t)
+	defer l.Close()
+
+	raw, _ := ioutil.ReadAll(loadFixture("build_success", t))
+	var body bytes.Buffer
+	if err := json.Compact(&body, raw); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&RedisCache{Addr: l.Addr().String()}).do("RPUSH", "quay", body.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &statusesRepository{}
+	q := &Quayd{StatusesRepository: r, Deliveries: &MemoryDeliveryStore{}}
+	c := &Consumer{
+		Source: &RedisSource{Addr: l.Addr().String(), Password: "secret", Key: "quay"},
+		Quayd:  func() *Quayd { return q },
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := c.Run(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Run => %v; want %v", err, context.DeadlineExceeded)
+	}
+
+	if len(r.statuses) != 1 || r.statuses[0].State != "success" {
+		t.Fatalf("Expected a success status, got %+v", r.statuses)
+	}
+}
+
+func TestQuayd_Ingest(t *testing.T) {
+	r := &statusesRepository{}
+	q := &Quayd{StatusesRepository: r, Ignored: &IgnoredEvents{}}
+
+	if err := q.Ingest(context.Background(), []byte(`{"event":"build_success","repository":"acme-inc"}`), ""); err == nil {
+		t.Fatal("Expected an invalid payload to error")
+	}
+
+	var form map[string]interface{}
+	if err := json.NewDecoder(loadFixture("pending_build.manual", t)).Decode(&form); err != nil {
+		t.Fatal(err)
+	}
+	form["event"], form["trigger_kind"] = "build_start", "github"
+	raw, _ := json.Marshal(form)
+	if err := q.Ingest(context.Background(), raw, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.statuses) != 0 {
+		t.Fatalf("Expected no statuses, got %+v", r.statuses)
+	}
+	if ignored := q.Ignored.List("", ""); len(ignored) != 1 || ignored[0].Reason != IgnoredManualBuild {
+		t.Fatalf("Expected the manual build to be ignored, got %+v", ignored)
+	}
+}
@@ -0,0 +1,10 @@
+// Package integration runs quayd's registry and GitHub code paths against a
+// real registry:2 container and an in-process fake GitHub API. The tests need
+// docker, so they're behind the integration build tag:
+//
+//	go test -tags integration ./integration
+//
+// Set QUAYD_TEST_REGISTRY to the `host:port` of a running registry to use it
+// instead of starting a container. The tests are skipped when the registry
+// can't be started, unless QUAYD_INTEGRATION=1, in which case they fail.
+package integration
@@ -0,0 +1,261 @@
+//go:build integration
+// +build integration
+
+package integration
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/remind101/quayd"
+)
+
+// registryImage is the image of the registry container.
+const registryImage = "registry:2"
+
+// registry is the `host:port` of the registry that the tests run against.
+var registry string
+
+// registryErr is why the registry couldn't be started, if it couldn't.
+var registryErr error
+
+// requireRegistry skips the test when the registry couldn't be started, e.g.
+// because docker isn't available. When QUAYD_INTEGRATION=1, like in CI, the
+// test fails instead, so the integration tests can't silently not run.
+func requireRegistry(t *testing.T) {
+	if registryErr == nil {
+		return
+	}
+
+	if os.Getenv("QUAYD_INTEGRATION") == "1" {
+		t.Fatalf("starting the registry: %v", registryErr)
+	}
+	t.Skipf("skipping: starting the registry: %v", registryErr)
+}
+
+// startRegistry starts a registry container on a random local port, and
+// returns its address and a function that removes it.
+func startRegistry() (string, func(), error) {
+	if addr := os.Getenv("QUAYD_TEST_REGISTRY"); addr != "" {
+		return addr, func() {}, waitForRegistry(addr)
+	}
+
+	if _, err := exec.LookPath("docker"); err != nil {
+		return "", nil, err
+	}
+
+	out, err := exec.Command("docker", "run", "-d", "-p", "127.0.0.1::5000", registryImage).Output()
+	if err != nil {
+		return "", nil, fmt.Errorf("starting %s: %v", registryImage, err)
+	}
+	id := strings.TrimSpace(string(out))
+	stop := func() {
+		exec.Command("docker", "rm", "-f", id).Run()
+	}
+
+	out, err = exec.Command("docker", "port", id, "5000/tcp").Output()
+	if err != nil {
+		stop()
+		return "", nil, fmt.Errorf("finding the registry's port: %v", err)
+	}
+	// Only the first line is used, since docker can also list an IPv6
+	// binding.
+	addr := strings.TrimSpace(strings.Split(string(out), "\n")[0])
+
+	if err := waitForRegistry(addr); err != nil {
+		stop()
+		return "", nil, err
+	}
+	return addr, stop, nil
+}
+
+// waitForRegistry waits for the registry to respond to the v2 API.
+func waitForRegistry(addr string) error {
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		resp, err := http.Get("http://" + addr + "/v2/")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == 200 {
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("registry at %s didn't start: %v", addr, err)
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// pushImage pushes a minimal single layer image to repo:tag, and returns the
+// digest of its manifest.
+func pushImage(repo, tag string) (string, error) {
+	var layer, tarball bytes.Buffer
+	tw := tar.NewWriter(&tarball)
+	tw.WriteHeader(&tar.Header{Name: "tag", Mode: 0644, Size: int64(len(tag))})
+	tw.Write([]byte(tag))
+	tw.Close()
+	gw := gzip.NewWriter(&layer)
+	gw.Write(tarball.Bytes())
+	gw.Close()
+
+	config, _ := json.Marshal(map[string]interface{}{
+		"architecture": "amd64",
+		"os":           "linux",
+		"rootfs":       map[string]interface{}{"type": "layers", "diff_ids": []string{digest(tarball.Bytes())}},
+	})
+
+	for _, blob := range [][]byte{config, layer.Bytes()} {
+		if err := pushBlob(repo, blob); err != nil {
+			return "", err
+		}
+	}
+
+	manifest, _ := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     quayd.MediaTypeImage,
+		"config": map[string]interface{}{
+			"mediaType": "application/vnd.docker.container.image.v1+json",
+			"size":      len(config),
+			"digest":    digest(config),
+		},
+		"layers": []map[string]interface{}{{
+			"mediaType": "application/vnd.docker.image.rootfs.diff.tar.gzip",
+			"size":      layer.Len(),
+			"digest":    digest(layer.Bytes()),
+		}},
+	})
+
+	req, _ := http.NewRequest("PUT", "http://"+registry+"/v2/"+repo+"/manifests/"+tag, bytes.NewReader(manifest))
+	req.Header.Set("Content-Type", quayd.MediaTypeImage)
+	if err := expect(req, 201); err != nil {
+		return "", fmt.Errorf("pushing manifest: %v", err)
+	}
+	return digest(manifest), nil
+}
+
+// pushBlob uploads a blob in a single request.
+func pushBlob(repo string, blob []byte) error {
+	resp, err := http.Post("http://"+registry+"/v2/"+repo+"/blobs/uploads/", "", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 202 {
+		return fmt.Errorf("starting blob upload: %s", resp.Status)
+	}
+
+	location := resp.Header.Get("Location")
+	sep := "?"
+	if strings.Contains(location, "?") {
+		sep = "&"
+	}
+	if !strings.HasPrefix(location, "http") {
+		location = "http://" + registry + location
+	}
+
+	req, _ := http.NewRequest("PUT", location+sep+"digest="+digest(blob), bytes.NewReader(blob))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if err := expect(req, 201); err != nil {
+		return fmt.Errorf("uploading blob: %v", err)
+	}
+	return nil
+}
+
+func expect(req *http.Request, status int) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != status {
+		var body bytes.Buffer
+		body.ReadFrom(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, body.String())
+	}
+	return nil
+}
+
+func digest(b []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(b))
+}
+
+// fakeGitHub is an in-process fake of the parts of the GitHub API that quayd
+// uses to resolve commits and create commit statuses.
+type fakeGitHub struct {
+	// Commits maps short shas to full shas.
+	Commits map[string]string
+
+	mu       sync.Mutex
+	statuses []*fakeStatus
+}
+
+// fakeStatus is a commit status created through the fake GitHub.
+type fakeStatus struct {
+	Repo        string
+	Sha         string
+	State       string `json:"state"`
+	Context     string `json:"context"`
+	Description string `json:"description"`
+	TargetURL   string `json:"target_url"`
+}
+
+// Start starts serving the fake GitHub, and returns its server.
+func (g *fakeGitHub) Start() *httptest.Server {
+	return httptest.NewServer(g)
+}
+
+func (g *fakeGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Paths are /repos/{owner}/{repo}/{resource}/{ref}.
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 5 || parts[0] != "repos" {
+		http.NotFound(w, r)
+		return
+	}
+	repo, resource, ref := parts[1]+"/"+parts[2], parts[3], parts[4]
+
+	switch {
+	case r.Method == "GET" && resource == "commits":
+		sha, ok := g.Commits[ref]
+		if !ok {
+			w.WriteHeader(422)
+			w.Write([]byte(`{"message":"No commit found for SHA: ` + ref + `"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"sha": sha})
+	case r.Method == "POST" && resource == "statuses":
+		status := &fakeStatus{Repo: repo, Sha: ref}
+		if err := json.NewDecoder(r.Body).Decode(status); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		g.mu.Lock()
+		g.statuses = append(g.statuses, status)
+		g.mu.Unlock()
+		w.WriteHeader(201)
+		json.NewEncoder(w).Encode(status)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Statuses returns the commit statuses that were created.
+func (g *fakeGitHub) Statuses() []*fakeStatus {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return append([]*fakeStatus{}, g.statuses...)
+}
@@ -0,0 +1,145 @@
+//go:build integration
+// +build integration
+
+package integration
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/remind101/quayd"
+)
+
+const sha = "6607c19d3fd492ec53439f4104b39e4c62ece179"
+
+func TestMain(m *testing.M) {
+	addr, stop, err := startRegistry()
+	if err != nil {
+		registryErr, stop = err, func() {}
+	}
+	registry = addr
+
+	code := m.Run()
+	stop()
+	os.Exit(code)
+}
+
+// newQuayd returns a Quayd configured like production, but against the
+// registry container and the fake GitHub.
+func newQuayd(t *testing.T, github *httptest.Server) *quayd.Quayd {
+	q, err := quayd.NewFromConfig(&quayd.Config{
+		GitHubToken:       "token",
+		GitHubURL:         github.URL,
+		GitHubRateReserve: -1,
+		Registry:          registry,
+		RegistryScheme:    "http",
+		DigestTagging:     true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func webhook(s http.Handler, repo, ref, tag, state string) *httptest.ResponseRecorder {
+	body := fmt.Sprintf(`{"build_id":"%s-%s","trigger_kind":"github","repository":"%s","docker_tags":["%s"],"build_name":"%s"}`, ref, state, repo, tag, ref)
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/quay/"+state, bytes.NewBufferString(body))
+	s.ServeHTTP(resp, req)
+	return resp
+}
+
+func TestRegistry_ResolveAndTag(t *testing.T) {
+	requireRegistry(t)
+
+	ctx := context.Background()
+	digest, err := pushImage("remind101/resolve", "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gh := (&fakeGitHub{}).Start()
+	defer gh.Close()
+
+	q := newQuayd(t, gh)
+
+	got, err := q.TagResolver.Resolve(ctx, "remind101/resolve", "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != digest {
+		t.Fatalf("Digest => %s; want %s", got, digest)
+	}
+
+	if err := q.Tagger.Tag(ctx, "remind101/resolve", digest, "staging"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := q.TagResolver.Resolve(ctx, "remind101/resolve", "staging"); err != nil || got != digest {
+		t.Fatalf("Resolve(staging) => %s, %v; want %s", got, err, digest)
+	}
+
+	if _, err := q.TagResolver.Resolve(ctx, "remind101/resolve", "missing"); err == nil {
+		t.Fatal("Expected resolving a missing tag to fail")
+	}
+}
+
+func TestWebhook_Success(t *testing.T) {
+	requireRegistry(t)
+
+	digest, err := pushImage("remind101/acme-inc", "build-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	github := &fakeGitHub{Commits: map[string]string{"6607c19": sha}}
+	gh := github.Start()
+	defer gh.Close()
+
+	q := newQuayd(t, gh)
+	s := quayd.NewServer(q)
+
+	if resp := webhook(s, "remind101/acme-inc", "6607c19", "build-1", "success"); resp.Code >= 300 {
+		t.Fatalf("Status => %d: %s", resp.Code, resp.Body.String())
+	}
+
+	statuses := github.Statuses()
+	if got, want := len(statuses), 1; got != want {
+		t.Fatalf("Statuses => %d; want %d", got, want)
+	}
+	if st := statuses[0]; st.Repo != "remind101/acme-inc" || st.Sha != sha || st.State != "success" {
+		t.Fatalf("Status => %+v", st)
+	}
+
+	got, err := q.TagResolver.Resolve(context.Background(), "remind101/acme-inc", sha)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != digest {
+		t.Fatalf("Expected the image to be tagged with the sha, got %s; want %s", got, digest)
+	}
+}
+
+func TestWebhook_TagNotFound(t *testing.T) {
+	requireRegistry(t)
+
+	github := &fakeGitHub{Commits: map[string]string{"6607c19": sha}}
+	gh := github.Start()
+	defer gh.Close()
+
+	s := quayd.NewServer(newQuayd(t, gh))
+	webhook(s, "remind101/missing", "6607c19", "build-1", "success")
+
+	statuses := github.Statuses()
+	if got, want := len(statuses), 1; got != want {
+		t.Fatalf("Statuses => %d; want %d", got, want)
+	}
+	if st := statuses[0]; st.State != "error" {
+		t.Fatalf("Expected an error status when the image can't be tagged, got %+v", st)
+	}
+}
@@ -0,0 +1,208 @@
+package quayd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ejholmes/go-github/github"
+)
+
+// DefaultFailureThreshold is the default number of consecutive failed builds
+// of the default branch before an issue is filed.
+const DefaultFailureThreshold = 3
+
+// IssuesService is the subset of the GitHub Issues API that FailureIssues
+// uses.
+type IssuesService interface {
+	Create(owner, repo string, issue *github.IssueRequest) (*github.Issue, *github.Response, error)
+	Edit(owner, repo string, number int, issue *github.IssueRequest) (*github.Issue, *github.Response, error)
+	CreateComment(owner, repo string, number int, comment *github.IssueComment) (*github.IssueComment, *github.Response, error)
+	ListByRepo(owner, repo string, opt *github.IssueListByRepoOptions) ([]github.Issue, *github.Response, error)
+}
+
+// FailureIssues opens a GitHub issue when the default branch of a repo fails
+// to build several times in a row, comments on it as further builds fail, and
+// closes it once the branch builds successfully again. Redeliveries of a
+// failed build only count once, and an issue that's already open, e.g. from
+// before a restart, is reused. A nil *FailureIssues does nothing.
+type FailureIssues struct {
+	Issues IssuesService
+
+	// Threshold is the number of consecutive failures before an issue is
+	// opened. Defaults to DefaultFailureThreshold.
+	Threshold int
+
+	// Labels are added to opened issues.
+	Labels []string
+
+	mu       sync.Mutex
+	failures map[string][]*BuildEvent
+	issues   map[string]int
+
+	// searched records the repos whose open issues were looked up.
+	searched map[string]bool
+}
+
+// Observe records the outcome of a build.
+func (f *FailureIssues) Observe(ctx context.Context, e *BuildEvent) error {
+	if f == nil || e.Branch == "" || e.Branch != e.DefaultBranch {
+		return nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.failures == nil {
+		f.failures = make(map[string][]*BuildEvent)
+		f.issues = make(map[string]int)
+		f.searched = make(map[string]bool)
+	}
+
+	switch e.State {
+	case "success":
+		return f.fixed(e)
+	case "failure":
+		return f.failed(e)
+	}
+
+	return nil
+}
+
+func (f *FailureIssues) failed(e *BuildEvent) error {
+	// Failures are keyed by build, so that redeliveries aren't counted
+	// again.
+	for _, failure := range f.failures[e.Repo] {
+		if e.BuildID != "" && failure.BuildID == e.BuildID {
+			return nil
+		}
+	}
+
+	failures := append(f.failures[e.Repo], e)
+	f.failures[e.Repo] = failures
+
+	if len(failures) < f.threshold() {
+		return nil
+	}
+
+	owner, repo := splitRepo(e.Repo)
+
+	if err := f.search(owner, repo, e.Repo, e.Branch); err != nil {
+		return err
+	}
+
+	if number, ok := f.issues[e.Repo]; ok {
+		_, _, err := f.Issues.CreateComment(owner, repo, number, &github.IssueComment{
+			Body: github.String("Still failing:\n\n" + failureSummary(e)),
+		})
+		return err
+	}
+
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "The last %d builds of `%s` failed:\n\n", len(failures), e.Branch)
+	for _, failure := range failures {
+		body.WriteString(failureSummary(failure))
+	}
+
+	issue, _, err := f.Issues.Create(owner, repo, &github.IssueRequest{
+		Title:  github.String(failureTitle(e.Branch)),
+		Body:   github.String(body.String()),
+		Labels: f.Labels,
+	})
+	if err != nil {
+		return err
+	}
+
+	if issue.Number != nil {
+		f.issues[e.Repo] = *issue.Number
+	}
+
+	return nil
+}
+
+func (f *FailureIssues) fixed(e *BuildEvent) error {
+	delete(f.failures, e.Repo)
+
+	owner, repo := splitRepo(e.Repo)
+
+	if err := f.search(owner, repo, e.Repo, e.Branch); err != nil {
+		return err
+	}
+
+	number, ok := f.issues[e.Repo]
+	if !ok {
+		return nil
+	}
+	delete(f.issues, e.Repo)
+
+	if _, _, err := f.Issues.CreateComment(owner, repo, number, &github.IssueComment{
+		Body: github.String(fmt.Sprintf("Fixed by %s.", e.Ref)),
+	}); err != nil {
+		return err
+	}
+
+	_, _, err := f.Issues.Edit(owner, repo, number, &github.IssueRequest{State: github.String("closed")})
+	return err
+}
+
+// search looks up the open failure issue of the branch, once per repo, so
+// that an issue filed before a restart is commented on and closed rather
+// than filed again.
+func (f *FailureIssues) search(owner, repo, fullName, branch string) error {
+	if f.searched[fullName] {
+		return nil
+	}
+	if _, ok := f.issues[fullName]; ok {
+		return nil
+	}
+
+	issues, _, err := f.Issues.ListByRepo(owner, repo, &github.IssueListByRepoOptions{
+		State:  "open",
+		Labels: f.Labels,
+	})
+	if err != nil {
+		return err
+	}
+	f.searched[fullName] = true
+
+	title := failureTitle(branch)
+	for _, issue := range issues {
+		if issue.Title != nil && *issue.Title == title && issue.Number != nil {
+			f.issues[fullName] = *issue.Number
+			break
+		}
+	}
+
+	return nil
+}
+
+func (f *FailureIssues) threshold() int {
+	if f.Threshold == 0 {
+		return DefaultFailureThreshold
+	}
+
+	return f.Threshold
+}
+
+func failureTitle(branch string) string {
+	return fmt.Sprintf("Docker image builds of %s are failing", branch)
+}
+
+func failureSummary(e *BuildEvent) string {
+	return fmt.Sprintf("* %s: [build](%s)\n", e.Ref, e.URL)
+}
+
+func splitRepo(fullName string) (owner, repo string) {
+	parts := strings.SplitN(fullName, "/", 2)
+	if len(parts) != 2 {
+		return fullName, ""
+	}
+
+	return parts[0], parts[1]
+}
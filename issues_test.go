@@ -0,0 +1,115 @@
+package quayd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ejholmes/go-github/github"
+)
+
+// issuesService is a fake IssuesService that records calls.
+type issuesService struct {
+	open     []github.Issue
+	created  []*github.IssueRequest
+	comments []*github.IssueComment
+	edited   []*github.IssueRequest
+	listed   int
+}
+
+func (s *issuesService) Create(owner, repo string, issue *github.IssueRequest) (*github.Issue, *github.Response, error) {
+	s.created = append(s.created, issue)
+	return &github.Issue{Number: github.Int(len(s.created))}, nil, nil
+}
+
+func (s *issuesService) Edit(owner, repo string, number int, issue *github.IssueRequest) (*github.Issue, *github.Response, error) {
+	s.edited = append(s.edited, issue)
+	return &github.Issue{Number: github.Int(number)}, nil, nil
+}
+
+func (s *issuesService) CreateComment(owner, repo string, number int, comment *github.IssueComment) (*github.IssueComment, *github.Response, error) {
+	s.comments = append(s.comments, comment)
+	return comment, nil, nil
+}
+
+func (s *issuesService) ListByRepo(owner, repo string, opt *github.IssueListByRepoOptions) ([]github.Issue, *github.Response, error) {
+	s.listed++
+	return s.open, nil, nil
+}
+
+func TestFailureIssues(t *testing.T) {
+	issues := &issuesService{}
+	f := &FailureIssues{Issues: issues, Threshold: 2}
+	ctx := context.Background()
+
+	build := func(state, branch string) *BuildEvent {
+		return &BuildEvent{Repo: "remind101/acme-inc", Ref: "f1fb3b0", State: state, Branch: branch, DefaultBranch: "master"}
+	}
+
+	for _, e := range []*BuildEvent{
+		build("failure", "master"),
+		build("failure", "feature"),
+		build("failure", "master"),
+		build("failure", "master"),
+	} {
+		if err := f.Observe(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, want := len(issues.created), 1; got != want {
+		t.Fatalf("Issues created => %d; want %d", got, want)
+	}
+
+	if got, want := len(issues.comments), 1; got != want {
+		t.Fatalf("Comments => %d; want %d", got, want)
+	}
+
+	if err := f.Observe(ctx, build("success", "master")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(issues.edited) != 1 || *issues.edited[0].State != "closed" {
+		t.Fatal("Expected the issue to be closed")
+	}
+}
+
+func TestFailureIssues_Redelivered(t *testing.T) {
+	issues := &issuesService{}
+	f := &FailureIssues{Issues: issues, Threshold: 2}
+	e := &BuildEvent{Repo: "remind101/acme-inc", Ref: "f1fb3b0", BuildID: "1", State: "failure", Branch: "master", DefaultBranch: "master"}
+
+	for i := 0; i < 3; i++ {
+		if err := f.Observe(context.Background(), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, want := len(issues.created), 0; got != want {
+		t.Fatalf("Issues created => %d; want %d", got, want)
+	}
+}
+
+func TestFailureIssues_ExistingIssue(t *testing.T) {
+	issues := &issuesService{open: []github.Issue{
+		{Number: github.Int(7), Title: github.String("Something else")},
+		{Number: github.Int(42), Title: github.String("Docker image builds of master are failing")},
+	}}
+	f := &FailureIssues{Issues: issues, Threshold: 1}
+	ctx := context.Background()
+
+	for _, id := range []string{"1", "2"} {
+		if err := f.Observe(ctx, &BuildEvent{Repo: "remind101/acme-inc", Ref: "f1fb3b0", BuildID: id, State: "failure", Branch: "master", DefaultBranch: "master"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, want := len(issues.created), 0; got != want {
+		t.Fatalf("Issues created => %d; want %d", got, want)
+	}
+	if got, want := len(issues.comments), 2; got != want {
+		t.Fatalf("Comments => %d; want %d", got, want)
+	}
+	if got, want := issues.listed, 1; got != want {
+		t.Fatalf("Searches => %d; want %d", got, want)
+	}
+}
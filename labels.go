@@ -0,0 +1,146 @@
+package quayd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// DefaultRequiredLabels are the image labels that a LabelPolicy requires by
+// default.
+var DefaultRequiredLabels = []string{
+	"maintainer",
+	"org.opencontainers.image.source",
+	"org.opencontainers.image.revision",
+	"org.opencontainers.image.licenses",
+}
+
+// MediaTypeOCIManifest is the media type of an OCI image manifest.
+const MediaTypeOCIManifest = "application/vnd.oci.image.manifest.v1+json"
+
+// MissingLabelsError is returned when an image is missing required labels.
+type MissingLabelsError struct {
+	Missing []string
+}
+
+// Error implements the error interface.
+func (e *MissingLabelsError) Error() string {
+	return "Missing required image labels: " + strings.Join(e.Missing, ", ")
+}
+
+// LabelPolicy verifies that built images have the labels that are required to
+// trace them back to their source, before they're reported as successful.
+type LabelPolicy struct {
+	// Required are the labels that must be set. Defaults to
+	// DefaultRequiredLabels.
+	Required []string
+
+	// Username and Password are used to read the image from the registry.
+	Username string
+	Password string
+
+	// RegistryURL overrides the base URL used to reach the registry,
+	// which is otherwise https://<registry>.
+	RegistryURL string
+
+	// Client is the http.Client to use. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// newLabelPolicy returns a LabelPolicy that reads images with registryAuth.
+func newLabelPolicy(required []string, registryAuth string) *LabelPolicy {
+	auth := append(strings.SplitN(registryAuth, ":", 2), "")
+	return &LabelPolicy{Required: required, Username: auth[0], Password: auth[1]}
+}
+
+// Check returns a *MissingLabelsError if the image is missing any of the
+// required labels.
+func (p *LabelPolicy) Check(ctx context.Context, image *Image) error {
+	if len(image.Tags) == 0 {
+		return nil
+	}
+
+	labels, err := p.Labels(ctx, image)
+	if err != nil {
+		return err
+	}
+
+	required := p.Required
+	if len(required) == 0 {
+		required = DefaultRequiredLabels
+	}
+
+	var missing []string
+	for _, label := range required {
+		if labels[label] == "" {
+			missing = append(missing, label)
+		}
+	}
+	sort.Strings(missing)
+
+	if len(missing) > 0 {
+		return &MissingLabelsError{Missing: missing}
+	}
+
+	return nil
+}
+
+// Labels returns the labels of the image, read from its config.
+func (p *LabelPolicy) Labels(ctx context.Context, image *Image) (map[string]string, error) {
+	var manifest struct {
+		Config struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+	}
+	if err := p.get(ctx, image, "/manifests/"+image.Tags[0], &manifest); err != nil {
+		return nil, err
+	}
+
+	var config struct {
+		Config struct {
+			Labels map[string]string `json:"Labels"`
+		} `json:"config"`
+	}
+	if err := p.get(ctx, image, "/blobs/"+manifest.Config.Digest, &config); err != nil {
+		return nil, err
+	}
+
+	return config.Config.Labels, nil
+}
+
+func (p *LabelPolicy) get(ctx context.Context, image *Image, path string, v interface{}) error {
+	base := p.RegistryURL
+	if base == "" {
+		base = "https://" + image.Registry
+	}
+
+	req, err := http.NewRequest("GET", base+"/v2/"+image.Repo+path, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", MediaTypeImage+", "+MediaTypeOCIManifest)
+	if p.Username != "" {
+		req.SetBasicAuth(p.Username, p.Password)
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("registry responded with %s", resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,59 @@
+package quayd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newLabelsRegistry(t *testing.T, config string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/remind101/acme-inc/manifests/test":
+			w.Write([]byte(`{"config":{"digest":"sha256:abcd"}}`))
+		case "/v2/remind101/acme-inc/blobs/sha256:abcd":
+			w.Write([]byte(config))
+		default:
+			t.Errorf("unexpected request: %s", r.URL.Path)
+			w.WriteHeader(404)
+		}
+	}))
+}
+
+func TestQuayd_LabelPolicy(t *testing.T) {
+	s := newLabelsRegistry(t, `{"config":{"Labels":{"maintainer":"platform@remind101.com","org.opencontainers.image.source":"https://github.com/remind101/acme-inc"}}}`)
+	defer s.Close()
+
+	r := &statusesRepository{}
+	registry := &MemoryRegistry{}
+	registry.Seed("remind101/acme-inc", "test")
+	q := &Quayd{
+		StatusesRepository: r,
+		TagResolver:        registry,
+		Tagger:             registry,
+		LabelPolicy:        &LabelPolicy{RegistryURL: s.URL},
+	}
+
+	if err := q.Handle(context.Background(), &BuildEvent{Repo: "remind101/acme-inc", Ref: "abcd", State: "success", Tags: []string{"test"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	st := r.statuses[0]
+	if got, want := st.State, "failure"; got != want {
+		t.Fatalf("State => %s; want %s", got, want)
+	}
+	if got, want := st.Description, "Missing required image labels: org.opencontainers.image.licenses, org.opencontainers.image.revision"; got != want {
+		t.Fatalf("Description => %s; want %s", got, want)
+	}
+}
+
+func TestLabelPolicy_Check(t *testing.T) {
+	s := newLabelsRegistry(t, `{"config":{"Labels":{"maintainer":"platform@remind101.com"}}}`)
+	defer s.Close()
+
+	p := &LabelPolicy{Required: []string{"maintainer"}, RegistryURL: s.URL}
+	if err := p.Check(context.Background(), &Image{Registry: "quay.io", Repo: "remind101/acme-inc", Tags: []string{"test"}}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,124 @@
+package quayd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/remind101/quayd/api"
+)
+
+// DefaultLogger is the Logger used when a Quayd instance doesn't have one.
+var DefaultLogger Logger = &JSONLogger{Writer: os.Stderr}
+
+// Logger is an interface for structured logging.
+type Logger = api.Logger
+
+// handlerLogger returns the Logger of h, if it's a Quayd instance, or
+// DefaultLogger.
+func handlerLogger(h Handler) Logger {
+	if q, ok := h.(*Quayd); ok {
+		return q.logger()
+	}
+
+	return DefaultLogger
+}
+
+// Level is the severity of a log line.
+type Level int
+
+// Log levels. Lines that include an "error" key are logged at LevelError,
+// and other lines at LevelInfo.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelError: "error",
+}
+
+// String implements fmt.Stringer.
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel parses a level name (debug, info or error).
+func ParseLevel(s string) (Level, error) {
+	for l, name := range levelNames {
+		if name == s {
+			return l, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown log level: %s", s)
+}
+
+// JSONLogger is a Logger that writes each line as a JSON object.
+type JSONLogger struct {
+	Writer io.Writer
+
+	// Level is the minimum level of lines that are written.
+	Level Level
+
+	mu sync.Mutex
+}
+
+// Log implements Logger Log.
+func (l *JSONLogger) Log(ctx context.Context, msg string, keyvals ...interface{}) {
+	level := LevelInfo
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		if keyvals[i] == "error" {
+			level = LevelError
+		}
+	}
+	if level < l.Level {
+		return
+	}
+
+	line := map[string]interface{}{
+		"time":  time.Now().UTC().Format(time.RFC3339Nano),
+		"level": level.String(),
+		"msg":   msg,
+	}
+	if id := RequestID(ctx); id != "" {
+		line["request_id"] = id
+	}
+
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		v := keyvals[i+1]
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		line[fmt.Sprint(keyvals[i])] = v
+	}
+
+	raw, err := json.Marshal(line)
+	if err != nil {
+		raw, _ = json.Marshal(map[string]string{"msg": msg, "error": err.Error()})
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.Writer.Write(append(raw, '\n'))
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx that carries the request id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request id carried by ctx, if any.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
@@ -0,0 +1,82 @@
+package quayd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestJSONLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := &JSONLogger{Writer: &buf}
+
+	l.Log(WithRequestID(context.Background(), "1234"), "status created", "repo", "remind101/acme-inc")
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatal(err)
+	}
+
+	for k, want := range map[string]string{"msg": "status created", "request_id": "1234", "repo": "remind101/acme-inc"} {
+		if got := line[k]; got != want {
+			t.Fatalf("%s => %v; want %s", k, got, want)
+		}
+	}
+}
+
+func TestQuayd_Handle_LogsRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	q := &Quayd{StatusesRepository: &statusesRepository{}, Logger: &JSONLogger{Writer: &buf}}
+
+	if err := q.Handle(context.Background(), &BuildEvent{ID: "1234", Repo: "remind101/acme-inc", Ref: "f1fb3b0", State: "pending"}); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 log lines, got %d", len(lines))
+	}
+
+	for _, line := range lines {
+		if !strings.Contains(line, `"request_id":"1234"`) {
+			t.Fatalf("Expected request id in %s", line)
+		}
+	}
+}
+
+func TestJSONLogger_Level(t *testing.T) {
+	var buf bytes.Buffer
+	l := &JSONLogger{Writer: &buf, Level: LevelError}
+
+	l.Log(context.Background(), "status created")
+	l.Log(context.Background(), "handling build failed", "error", "boom")
+
+	if got := strings.Count(buf.String(), "\n"); got != 1 {
+		t.Fatalf("Expected 1 line, got %d:\n%s", got, buf.String())
+	}
+
+	if !strings.Contains(buf.String(), `"level":"error"`) {
+		t.Fatalf("Expected an error line, got %s", buf.String())
+	}
+}
+
+func TestQuayd_ErrorResponse_Logs(t *testing.T) {
+	var buf bytes.Buffer
+	q := &Quayd{Logger: &JSONLogger{Writer: &buf}}
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/admin/replay/1234", nil)
+	q.errorResponse(resp, req, errors.New("boom"))
+
+	if got, want := resp.Code, 500; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+	if !strings.Contains(buf.String(), `"error":"boom"`) || !strings.Contains(buf.String(), `"path":"/admin/replay/1234"`) {
+		t.Fatalf("Expected the error to be logged, got %s", buf.String())
+	}
+}
@@ -0,0 +1,103 @@
+package quayd
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Marshaler encodes Statuses and BuildEvents for a sink, such as a file, an
+// HTTP endpoint or a message bus.
+type Marshaler interface {
+	// ContentType returns the media type of the encoding.
+	ContentType() string
+
+	// Marshal encodes v, which is a *Status or a *BuildEvent.
+	Marshal(v interface{}) ([]byte, error)
+}
+
+// JSONMarshaler is a Marshaler that encodes values using their canonical JSON
+// encoding. BuildEvents are wrapped in an Envelope, so consumers can tell
+// which version of the schema they were encoded with.
+type JSONMarshaler struct {
+	// Delimiter, if set, is appended to each encoded value (e.g. "\n" for
+	// newline delimited JSON).
+	Delimiter string
+}
+
+// ContentType implements Marshaler ContentType.
+func (m *JSONMarshaler) ContentType() string {
+	if m.Delimiter == "\n" {
+		return "application/x-ndjson"
+	}
+
+	return "application/json"
+}
+
+// Marshal implements Marshaler Marshal.
+func (m *JSONMarshaler) Marshal(v interface{}) ([]byte, error) {
+	if e, ok := v.(*BuildEvent); ok {
+		v = NewEnvelope(e)
+	}
+
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(raw, m.Delimiter...), nil
+}
+
+// DefaultMarshalers is the MarshalerRegistry with quayd's built in formats.
+var DefaultMarshalers = NewMarshalerRegistry()
+
+// MarshalerRegistry maps format names to Marshalers, so sinks can be
+// configured with the name of the format to write.
+type MarshalerRegistry struct {
+	mu         sync.RWMutex
+	marshalers map[string]Marshaler
+}
+
+// NewMarshalerRegistry returns a MarshalerRegistry with the built in "json"
+// and "ndjson" formats registered.
+func NewMarshalerRegistry() *MarshalerRegistry {
+	r := &MarshalerRegistry{}
+	r.Register("json", &JSONMarshaler{})
+	r.Register("ndjson", &JSONMarshaler{Delimiter: "\n"})
+	return r
+}
+
+// Register registers the Marshaler for the format, replacing any existing
+// Marshaler for it.
+func (r *MarshalerRegistry) Register(format string, m Marshaler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.marshalers == nil {
+		r.marshalers = make(map[string]Marshaler)
+	}
+	r.marshalers[format] = m
+}
+
+// Lookup returns the Marshaler for the format.
+func (r *MarshalerRegistry) Lookup(format string) (Marshaler, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	m, ok := r.marshalers[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown format: %s", format)
+	}
+
+	return m, nil
+}
+
+// Marshal encodes v using the Marshaler for the format.
+func (r *MarshalerRegistry) Marshal(format string, v interface{}) ([]byte, error) {
+	m, err := r.Lookup(format)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.Marshal(v)
+}
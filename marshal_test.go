@@ -0,0 +1,45 @@
+package quayd
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestJSONMarshaler_Status ensures that the canonical encoding of a Status
+// doesn't change, since custom sinks depend on it.
+func TestJSONMarshaler_Status(t *testing.T) {
+	raw, err := DefaultMarshalers.Marshal("ndjson", &Status{
+		Repo:        "remind101/acme-inc",
+		Ref:         "f1fb3b0",
+		State:       "success",
+		Context:     "Docker Image",
+		TargetURL:   "https://quay.io/repository/remind101/acme-inc/build/1234",
+		Description: "The Docker image was built",
+		Image:       &Image{Registry: "quay.io", Repo: "remind101/acme-inc", ID: "abcd", Tags: []string{"latest"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"repo":"remind101/acme-inc","ref":"f1fb3b0","state":"success","context":"Docker Image","target_url":"https://quay.io/repository/remind101/acme-inc/build/1234","description":"The Docker image was built","image":{"registry":"quay.io","repo":"remind101/acme-inc","id":"abcd","tags":["latest"]}}` + "\n"
+	if got := string(raw); got != want {
+		t.Fatalf("Marshal => %s; want %s", got, want)
+	}
+}
+
+func TestJSONMarshaler_BuildEvent(t *testing.T) {
+	raw, err := DefaultMarshalers.Marshal("json", &BuildEvent{ID: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(string(raw), `{"schema_version":1,`) {
+		t.Fatalf("Expected an envelope, got %s", raw)
+	}
+}
+
+func TestMarshalerRegistry_UnknownFormat(t *testing.T) {
+	if _, err := DefaultMarshalers.Marshal("avro", &Status{}); err == nil {
+		t.Fatal("Expected an error")
+	}
+}
@@ -0,0 +1,216 @@
+package quayd
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of the processing
+// latency histogram buckets.
+var DefaultLatencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Metrics collects the metrics that quayd exports in the Prometheus text
+// format. A nil *Metrics records nothing.
+type Metrics struct {
+	mu       sync.Mutex
+	counters map[string]map[string]float64
+	gauges   map[string]map[string]float64
+	buckets  []uint64
+	sum      float64
+	count    uint64
+}
+
+// WebhookReceived counts a webhook for repo with the given status.
+func (m *Metrics) WebhookReceived(status, repo string) {
+	m.add(MetricWebhooksReceived, labels("status", status, "repo", repo))
+}
+
+// GitHubError counts a failed GitHub API call.
+func (m *Metrics) GitHubError() {
+	m.add(MetricGitHubErrors, "")
+}
+
+// RegistryTag counts a registry tag operation for repo, with its result
+// (success or error).
+func (m *Metrics) RegistryTag(repo string, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	m.add(MetricRegistryTags, labels("repo", repo, "result", result))
+}
+
+// EventIgnored counts an event for repo that was intentionally not processed,
+// by the reason it was ignored.
+func (m *Metrics) EventIgnored(repo, reason string) {
+	m.add(MetricEventsIgnored, labels("repo", repo, "reason", reason))
+}
+
+// EventProcessed counts an event for repo that was processed, with its result
+// (success or error).
+func (m *Metrics) EventProcessed(repo string, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	m.add(MetricEventsProcessed, labels("repo", repo, "result", result))
+}
+
+// Failure counts a failed build or webhook for repo, by its FailureClass.
+func (m *Metrics) Failure(repo string, class FailureClass) {
+	m.add(MetricFailures, labels("repo", repo, "class", string(class)))
+}
+
+// DeliveryLag records how long Quay took to deliver the latest webhook for
+// repo.
+func (m *Metrics) DeliveryLag(repo string, lag time.Duration) {
+	m.set(MetricDeliveryLag, labels("repo", repo), lag.Seconds())
+}
+
+// SLA counts a delivery for repo that met, or breached, its SLA.
+func (m *Metrics) SLA(repo string, breached bool) {
+	result := "met"
+	if breached {
+		result = "breached"
+	}
+	m.add(MetricSLA, labels("repo", repo, "result", result))
+}
+
+// Processed observes how long it took to process a webhook.
+func (m *Metrics) Processed(d time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.buckets == nil {
+		m.buckets = make([]uint64, len(DefaultLatencyBuckets))
+	}
+
+	s := d.Seconds()
+	for i, le := range DefaultLatencyBuckets {
+		if s <= le {
+			m.buckets[i]++
+		}
+	}
+	m.sum += s
+	m.count++
+}
+
+// WriteTo writes the metrics to w in the Prometheus text format, along with
+// the given queue depth.
+func (m *Metrics) WriteTo(w io.Writer, queueDepth int) {
+	if m == nil {
+		m = &Metrics{}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeFamily(w, MetricWebhooksReceived, "counter", "Webhooks received, by status and repo.", m.counters[MetricWebhooksReceived])
+	writeFamily(w, MetricGitHubErrors, "counter", "Failed GitHub API calls.", m.counters[MetricGitHubErrors])
+	writeFamily(w, MetricRegistryTags, "counter", "Registry tag operations, by repo and result.", m.counters[MetricRegistryTags])
+	writeFamily(w, MetricFailures, "counter", "Failed builds and webhooks, by repo and failure class.", m.counters[MetricFailures])
+	writeFamily(w, MetricEventsProcessed, "counter", "Events that were processed, by repo and result.", m.counters[MetricEventsProcessed])
+	writeFamily(w, MetricEventsIgnored, "counter", "Events that were intentionally not processed, by repo and reason.", m.counters[MetricEventsIgnored])
+	writeFamily(w, MetricSLA, "counter", "Deliveries that met or breached their SLA, by repo.", m.counters[MetricSLA])
+	writeFamily(w, MetricDeliveryLag, "gauge", "Seconds between a build completing and quayd receiving the webhook.", m.gauges[MetricDeliveryLag])
+	writeFamily(w, MetricQueueDepth, "gauge", "Webhooks waiting to be processed.", map[string]float64{"": float64(queueDepth)})
+
+	fmt.Fprintf(w, "# HELP %s Time taken to process a webhook.\n", MetricProcessingSeconds)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", MetricProcessingSeconds)
+	for i, le := range DefaultLatencyBuckets {
+		var n uint64
+		if m.buckets != nil {
+			n = m.buckets[i]
+		}
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", MetricProcessingSeconds, le, n)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", MetricProcessingSeconds, m.count)
+	fmt.Fprintf(w, "%s_sum %g\n", MetricProcessingSeconds, m.sum)
+	fmt.Fprintf(w, "%s_count %d\n", MetricProcessingSeconds, m.count)
+}
+
+func (m *Metrics) add(name, labels string) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.counters == nil {
+		m.counters = make(map[string]map[string]float64)
+	}
+	if m.counters[name] == nil {
+		m.counters[name] = make(map[string]float64)
+	}
+	m.counters[name][labels]++
+}
+
+func (m *Metrics) set(name, labels string, v float64) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.gauges == nil {
+		m.gauges = make(map[string]map[string]float64)
+	}
+	if m.gauges[name] == nil {
+		m.gauges[name] = make(map[string]float64)
+	}
+	m.gauges[name][labels] = v
+}
+
+// labels formats label name/value pairs in the Prometheus text format.
+func labels(pairs ...string) string {
+	var l []string
+	for i := 0; i < len(pairs); i += 2 {
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(pairs[i+1])
+		l = append(l, fmt.Sprintf(`%s="%s"`, pairs[i], v))
+	}
+	return "{" + strings.Join(l, ",") + "}"
+}
+
+func writeFamily(w io.Writer, name, typ, help string, values map[string]float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+
+	var keys []string
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s%s %g\n", name, k, values[k])
+	}
+}
+
+// MetricsHandler is an http.Handler that exports metrics in the Prometheus
+// text format.
+type MetricsHandler struct {
+	*Quayd
+}
+
+func (h *MetricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var depth int
+	if h.Queue != nil {
+		depth = h.Queue.Len()
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	h.Metrics.WriteTo(w, depth)
+	h.RateLimit.writeMetrics(w)
+	h.QueueMonitor.writeMetrics(w)
+}
@@ -0,0 +1,56 @@
+package quayd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	q := &Quayd{StatusesRepository: &statusesRepository{}, Metrics: &Metrics{}}
+	s := NewServer(q)
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/quay/success", loadFixture("pending_build", t))
+	s.ServeHTTP(resp, req)
+
+	resp = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/metrics", nil)
+	s.ServeHTTP(resp, req)
+
+	for _, want := range []string{
+		`quayd_webhooks_received_total{status="success",repo="ejholmes/docker-statsd"} 1`,
+		`quayd_registry_tag_operations_total{repo="ejholmes/docker-statsd",result="success"} 1`,
+		`quayd_processing_duration_seconds_count 1`,
+		`quayd_queue_depth 0`,
+	} {
+		if !strings.Contains(resp.Body.String(), want) {
+			t.Fatalf("Expected metrics to contain %q:\n%s", want, resp.Body.String())
+		}
+	}
+}
+
+func TestMetrics_Labels(t *testing.T) {
+	if got, want := labels("repo", `a"b`), `{repo="a\"b"}`; got != want {
+		t.Fatalf("labels => %s; want %s", got, want)
+	}
+}
+
+func TestHandle_DeliveryLag(t *testing.T) {
+	m := &Metrics{}
+	q := &Quayd{StatusesRepository: &statusesRepository{}, Metrics: m}
+
+	// The event sat in the queue for an hour after it was received.
+	received := time.Now().Add(-time.Hour)
+	e := &BuildEvent{Repo: "remind101/acme-inc", Ref: "a", State: "pending", CompletedAt: received.Add(-2 * time.Minute), ReceivedAt: received}
+	if err := q.Handle(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := m.gauges[MetricDeliveryLag][labels("repo", "remind101/acme-inc")], 120.0; got != want {
+		t.Fatalf("Delivery lag => %g; want %g", got, want)
+	}
+}
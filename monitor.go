@@ -0,0 +1,390 @@
+package quayd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// MonitorAlert is an alert about a Quay build queue or organization, raised
+// or resolved by QueueMonitor. Unlike build notifications, it isn't about a
+// commit.
+type MonitorAlert struct {
+	// Subject is the repo or org that the alert is about.
+	Subject string
+
+	// Message describes the problem, or its resolution.
+	Message string
+
+	// Resolved is true when the problem has cleared up.
+	Resolved bool
+}
+
+// Alerter is implemented by Notifiers that can send QueueMonitor alerts.
+type Alerter interface {
+	SendAlert(ctx context.Context, alert *MonitorAlert) error
+}
+
+// DefaultQuotaThreshold is the fraction of an organization's private
+// repository quota above which QueueMonitor alerts.
+const DefaultQuotaThreshold = 0.9
+
+// QueueMonitor periodically queries the Quay API for the number of builds that
+// are waiting to run for each of Repos, and the plan quota of each of Orgs,
+// and alerts when the queue is saturated or the quota is nearly used up. A
+// saturated queue usually explains "missing" commit statuses.
+type QueueMonitor struct {
+	// URL is the base URL of the Quay API. Defaults to https://quay.io.
+	URL string
+
+	// Token is an OAuth token for the Quay API.
+	Token string
+
+	// Repos are the Quay repositories to monitor, as `namespace/name`.
+	Repos []string
+
+	// Orgs are the Quay organizations whose plan quota is monitored.
+	Orgs []string
+
+	// Threshold is the number of waiting builds above which Alert is called.
+	// Zero disables alerting.
+	Threshold int
+
+	// QuotaThreshold is the fraction of an org's private repository quota
+	// above which the org is alerted on. Defaults to
+	// DefaultQuotaThreshold.
+	QuotaThreshold float64
+
+	// Alert is called when the queue for a repo becomes saturated. The
+	// default notifies the Notifiers.
+	Alert func(repo string, waiting int)
+
+	// Notifiers that implement Alerter are alerted when a queue becomes
+	// saturated or a quota nearly exhausted, and again when it recovers.
+	// When there are none, alerts are logged.
+	Notifiers []Notifier
+
+	// Client is the http.Client to use. Defaults to http.DefaultClient.
+	Client *http.Client
+
+	// Dependencies, if set, records the outcome of each call to the Quay
+	// API.
+	Dependencies *Dependencies
+
+	// Logger logs failed checks, and alerts when there are no Alerters.
+	// Defaults to DefaultLogger.
+	Logger Logger
+
+	mu     sync.Mutex
+	queues map[string]int
+	quotas map[string]quayQuota
+	// alerting are the queues and quotas that are currently alerting, so
+	// that each problem is only alerted on once.
+	alerting map[string]bool
+}
+
+// quayQuota is the private repository quota of a Quay organization.
+type quayQuota struct {
+	used, allowed int
+}
+
+// Run checks the build queues every interval until stop is closed.
+func (m *QueueMonitor) Run(interval time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		if err := m.Check(); err != nil {
+			m.logger().Log(context.Background(), "checking build queues failed", "error", err)
+		}
+
+		select {
+		case <-t.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Check queries the build queue for each repo, and the quota of each org,
+// once. A failure for one repo or org doesn't stop the others from being
+// checked; the errors are returned together as a MultiError.
+func (m *QueueMonitor) Check() error {
+	var errs MultiError
+	for _, repo := range m.Repos {
+		waiting, err := m.waiting(repo)
+		m.Dependencies.Observe(DependencyQuay, err)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		m.mu.Lock()
+		if m.queues == nil {
+			m.queues = make(map[string]int)
+		}
+		m.queues[repo] = waiting
+		m.mu.Unlock()
+
+		if m.Threshold > 0 {
+			m.queueAlert(repo, waiting)
+		}
+	}
+
+	for _, org := range m.Orgs {
+		quota, err := m.quota(org)
+		m.Dependencies.Observe(DependencyQuay, err)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		m.mu.Lock()
+		if m.quotas == nil {
+			m.quotas = make(map[string]quayQuota)
+		}
+		m.quotas[org] = quota
+		m.mu.Unlock()
+
+		if quota.allowed > 0 {
+			m.quotaAlert(org, quota)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// QueueLengths returns a copy of the most recently observed number of
+// waiting builds for each repo.
+func (m *QueueMonitor) QueueLengths() map[string]int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	queues := make(map[string]int, len(m.queues))
+	for repo, n := range m.queues {
+		queues[repo] = n
+	}
+	return queues
+}
+
+// writeMetrics writes the number of waiting builds for each repo in the
+// Prometheus text format.
+func (m *QueueMonitor) writeMetrics(w io.Writer) {
+	if m == nil {
+		return
+	}
+
+	values := make(map[string]float64)
+	for repo, n := range m.QueueLengths() {
+		values[labels("repo", repo)] = float64(n)
+	}
+	writeFamily(w, MetricQuayBuildsWaiting, "gauge", "Builds waiting in Quay's build queue, by repo.", values)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.quotas) == 0 {
+		return
+	}
+	used, allowed := make(map[string]float64), make(map[string]float64)
+	for org, q := range m.quotas {
+		used[labels("org", org)] = float64(q.used)
+		allowed[labels("org", org)] = float64(q.allowed)
+	}
+	writeFamily(w, MetricQuayPrivateReposUsed, "gauge", "Private repositories used in each Quay organization.", used)
+	writeFamily(w, MetricQuayPrivateReposAllowed, "gauge", "Private repositories allowed by each Quay organization's plan.", allowed)
+}
+
+// waiting returns the number of builds for repo that are waiting to run.
+func (m *QueueMonitor) waiting(repo string) (int, error) {
+	var builds struct {
+		Builds []struct {
+			Phase string `json:"phase"`
+		} `json:"builds"`
+	}
+	if err := m.get("/api/v1/repository/"+repo+"/build/", &builds); err != nil {
+		return 0, err
+	}
+
+	var n int
+	for _, b := range builds.Builds {
+		if b.Phase == "waiting" {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// quota returns the number of private repositories that org uses, and the
+// number that its plan allows.
+func (m *QueueMonitor) quota(org string) (quayQuota, error) {
+	var plan struct {
+		Plan             string `json:"plan"`
+		UsedPrivateRepos int    `json:"usedPrivateRepos"`
+	}
+	if err := m.get("/api/v1/organization/"+org+"/plan", &plan); err != nil {
+		return quayQuota{}, err
+	}
+
+	var plans struct {
+		Plans []struct {
+			StripeID     string `json:"stripeId"`
+			PrivateRepos int    `json:"privateRepos"`
+		} `json:"plans"`
+	}
+	if err := m.get("/api/v1/plans/", &plans); err != nil {
+		return quayQuota{}, err
+	}
+
+	quota := quayQuota{used: plan.UsedPrivateRepos}
+	for _, p := range plans.Plans {
+		if p.StripeID == plan.Plan {
+			quota.allowed = p.PrivateRepos
+		}
+	}
+	return quota, nil
+}
+
+// get decodes the JSON response to a GET request for path into v.
+func (m *QueueMonitor) get(path string, v interface{}) error {
+	req, err := http.NewRequest("GET", m.url()+path, nil)
+	if err != nil {
+		return err
+	}
+	if m.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+m.Token)
+	}
+
+	resp, err := m.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("quay responded with %s for %s", resp.Status, path)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// queueAlert alerts when the queue of repo becomes saturated, and when it
+// recovers.
+func (m *QueueMonitor) queueAlert(repo string, waiting int) {
+	saturated := waiting > m.Threshold
+	if !m.changed("queue:"+repo, saturated) {
+		return
+	}
+
+	if !saturated {
+		m.notify(&MonitorAlert{Subject: repo, Resolved: true, Message: fmt.Sprintf("Quay's build queue has recovered: %d builds waiting (threshold %d)", waiting, m.Threshold)})
+		return
+	}
+
+	if m.Alert != nil {
+		m.Alert(repo, waiting)
+		return
+	}
+
+	m.notify(&MonitorAlert{Subject: repo, Message: fmt.Sprintf("Quay's build queue is saturated: %d builds waiting (threshold %d)", waiting, m.Threshold)})
+}
+
+// quotaAlert alerts when the private repository quota of org is nearly
+// exhausted, and when it recovers.
+func (m *QueueMonitor) quotaAlert(org string, quota quayQuota) {
+	exhausted := float64(quota.used) >= float64(quota.allowed)*m.quotaThreshold()
+	if !m.changed("quota:"+org, exhausted) {
+		return
+	}
+
+	message := fmt.Sprintf("%s is using %d of its %d private repositories", org, quota.used, quota.allowed)
+	m.notify(&MonitorAlert{Subject: org, Message: message, Resolved: !exhausted})
+}
+
+// changed records whether key is alerting, and returns true if that's
+// changed. Keys start out not alerting.
+func (m *QueueMonitor) changed(key string, alerting bool) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.alerting == nil {
+		m.alerting = make(map[string]bool)
+	}
+	if m.alerting[key] == alerting {
+		return false
+	}
+	m.alerting[key] = alerting
+	return true
+}
+
+// notify sends the alert to each of the Notifiers that is an Alerter, or logs
+// it if there are none.
+func (m *QueueMonitor) notify(alert *MonitorAlert) {
+	m.mu.Lock()
+	notifiers := m.Notifiers
+	m.mu.Unlock()
+
+	var sent bool
+	for _, n := range notifiers {
+		a, ok := n.(Alerter)
+		if !ok {
+			continue
+		}
+		sent = true
+		if err := a.SendAlert(context.Background(), alert); err != nil {
+			m.logger().Log(context.Background(), "sending alert failed", "subject", alert.Subject, "error", err)
+		}
+	}
+
+	if !sent {
+		m.logger().Log(context.Background(), alert.Message, "subject", alert.Subject, "resolved", alert.Resolved)
+	}
+}
+
+func (m *QueueMonitor) logger() Logger {
+	if m.Logger == nil {
+		return DefaultLogger
+	}
+
+	return m.Logger
+}
+
+// SetNotifiers replaces the Notifiers, so that alerts go to the Notifiers of
+// the current config after it's reloaded.
+func (m *QueueMonitor) SetNotifiers(notifiers []Notifier) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Notifiers = notifiers
+}
+
+func (m *QueueMonitor) quotaThreshold() float64 {
+	if m.QuotaThreshold == 0 {
+		return DefaultQuotaThreshold
+	}
+
+	return m.QuotaThreshold
+}
+
+func (m *QueueMonitor) url() string {
+	if m.URL == "" {
+		return "https://quay.io"
+	}
+
+	return m.URL
+}
+
+func (m *QueueMonitor) client() *http.Client {
+	if m.Client == nil {
+		return http.DefaultClient
+	}
+
+	return m.Client
+}
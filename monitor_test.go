@@ -0,0 +1,146 @@
+package quayd
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestQueueMonitor(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Header.Get("Authorization"), "Bearer 1234"; got != want {
+			t.Errorf("Authorization => %s; want %s", got, want)
+		}
+
+		switch r.URL.Path {
+		case "/api/v1/repository/remind101/acme-inc/build/":
+			w.Write([]byte(`{"builds":[{"phase":"waiting"},{"phase":"waiting"},{"phase":"complete"}]}`))
+		case "/api/v1/repository/remind101/r101-api/build/":
+			w.Write([]byte(`{"builds":[{"phase":"building"}]}`))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer s.Close()
+
+	var alerted []string
+	m := &QueueMonitor{
+		URL:       s.URL,
+		Token:     "1234",
+		Repos:     []string{"remind101/missing", "remind101/acme-inc", "remind101/r101-api"},
+		Threshold: 1,
+		Alert: func(repo string, waiting int) {
+			alerted = append(alerted, repo)
+		},
+	}
+
+	// A repo that can't be checked doesn't stop the others from being
+	// checked.
+	if err, ok := m.Check().(MultiError); !ok || len(err) != 1 {
+		t.Fatalf("Err => %v; want one error", err)
+	}
+
+	if got, want := m.QueueLengths()["remind101/acme-inc"], 2; got != want {
+		t.Fatalf("Waiting => %d; want %d", got, want)
+	}
+
+	if got, want := len(alerted), 1; got != want {
+		t.Fatalf("Alerts => %d; want %d", got, want)
+	}
+
+	var buf bytes.Buffer
+	m.writeMetrics(&buf)
+	if want := `quay_builds_waiting{repo="remind101/acme-inc"} 2`; !strings.Contains(buf.String(), want) {
+		t.Fatalf("Expected metrics to contain %q:\n%s", want, buf.String())
+	}
+}
+
+// alerter is a Notifier that records the alerts it's sent.
+type alerter struct {
+	notifier
+	alerts []*MonitorAlert
+}
+
+func (a *alerter) SendAlert(ctx context.Context, alert *MonitorAlert) error {
+	a.alerts = append(a.alerts, alert)
+	return nil
+}
+
+func TestQueueMonitor_Recovery(t *testing.T) {
+	waiting := []string{`{"phase":"waiting"}`, `{"phase":"waiting"}`}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"builds":[` + strings.Join(waiting, ",") + `]}`))
+	}))
+	defer s.Close()
+
+	a := &alerter{}
+	m := &QueueMonitor{URL: s.URL, Repos: []string{"remind101/acme-inc"}, Threshold: 1, Notifiers: []Notifier{a}}
+
+	// A saturated queue is only alerted on once.
+	for i := 0; i < 2; i++ {
+		if err := m.Check(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := len(a.alerts), 1; got != want {
+		t.Fatalf("Alerts => %d; want %d", got, want)
+	}
+
+	waiting = nil
+	if err := m.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(a.alerts), 2; got != want {
+		t.Fatalf("Alerts => %d; want %d", got, want)
+	}
+	if !a.alerts[1].Resolved {
+		t.Fatalf("Expected a recovery alert, got %+v", a.alerts[1])
+	}
+
+	// Build notifications aren't used for alerts.
+	if len(a.statuses) != 0 {
+		t.Fatalf("Expected no build notifications, got %+v", a.statuses)
+	}
+}
+
+func TestQueueMonitor_Quota(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/organization/remind101/plan":
+			w.Write([]byte(`{"plan":"bus-small-30","usedPrivateRepos":28}`))
+		case "/api/v1/plans/":
+			w.Write([]byte(`{"plans":[{"stripeId":"free","privateRepos":0},{"stripeId":"bus-small-30","privateRepos":30}]}`))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer s.Close()
+
+	a := &alerter{}
+	m := &QueueMonitor{URL: s.URL, Orgs: []string{"remind101"}, Notifiers: []Notifier{a}}
+
+	if err := m.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(a.alerts), 1; got != want {
+		t.Fatalf("Alerts => %d; want %d", got, want)
+	}
+	if got, want := *a.alerts[0], (MonitorAlert{Subject: "remind101", Message: "remind101 is using 28 of its 30 private repositories"}); got != want {
+		t.Fatalf("Alert => %+v; want %+v", got, want)
+	}
+
+	var buf bytes.Buffer
+	m.writeMetrics(&buf)
+	for _, want := range []string{
+		`quay_private_repos_used{org="remind101"} 28`,
+		`quay_private_repos_allowed{org="remind101"} 30`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("Expected metrics to contain %q:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package quayd
+
+import (
+	"context"
+	"strings"
+)
+
+// MultiError is returned by MultiStatusesRepository when statuses couldn't be
+// created in some of its repositories.
+type MultiError []error
+
+// Error implements the error interface.
+func (e MultiError) Error() string {
+	var msgs []string
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// MultiStatusesRepository is a StatusesRepository that creates each status in
+// all of its Repositories, such as GitHub and an audit log.
+type MultiStatusesRepository struct {
+	Repositories []StatusesRepository
+
+	// FailFast stops at the first repository that fails. Otherwise, the
+	// status is created in every repository and the errors are returned
+	// together as a MultiError.
+	FailFast bool
+}
+
+// Create implements StatusesRepository Create.
+func (r *MultiStatusesRepository) Create(ctx context.Context, status *Status) error {
+	var errs MultiError
+	for _, repo := range r.Repositories {
+		if err := repo.Create(ctx, status); err != nil {
+			if r.FailFast {
+				return err
+			}
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
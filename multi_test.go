@@ -0,0 +1,33 @@
+package quayd
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMultiStatusesRepository(t *testing.T) {
+	a, b := &statusesRepository{}, &statusesRepository{}
+	r := &MultiStatusesRepository{Repositories: []StatusesRepository{a, &downStatusesRepository{down: true}, b}}
+
+	err := r.Create(context.Background(), &Status{Repo: "remind101/acme-inc", Ref: "abcd", State: "success"})
+	if _, ok := err.(MultiError); !ok {
+		t.Fatalf("Expected a MultiError, got %v", err)
+	}
+
+	if len(a.statuses) != 1 || len(b.statuses) != 1 {
+		t.Fatal("Expected the status to be created in the healthy repositories")
+	}
+}
+
+func TestMultiStatusesRepository_FailFast(t *testing.T) {
+	a, b := &statusesRepository{}, &statusesRepository{}
+	r := &MultiStatusesRepository{Repositories: []StatusesRepository{a, &downStatusesRepository{down: true}, b}, FailFast: true}
+
+	if err := r.Create(context.Background(), &Status{Repo: "remind101/acme-inc", Ref: "abcd", State: "success"}); err == nil {
+		t.Fatal("Expected an error")
+	}
+
+	if len(a.statuses) != 1 || len(b.statuses) != 0 {
+		t.Fatal("Expected creation to stop at the failed repository")
+	}
+}
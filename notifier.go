@@ -0,0 +1,131 @@
+package quayd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/remind101/quayd/api"
+)
+
+// Notifier is an interface that is notified of each commit status that
+// quayd creates, for sending chat notifications and the like.
+type Notifier = api.Notifier
+
+// slackEmoji maps a build state to the emoji used in Slack messages.
+var slackEmoji = map[string]string{
+	"pending": ":hourglass_flowing_sand:",
+	"success": ":white_check_mark:",
+	"failure": ":x:",
+	"error":   ":warning:",
+}
+
+// SlackNotifier is a Notifier that posts build results to a Slack channel
+// through an incoming webhook.
+type SlackNotifier struct {
+	// WebhookURL is the Slack incoming webhook URL.
+	WebhookURL string
+
+	// States are the build states that are posted. Defaults to success
+	// and failure.
+	States []string
+
+	// Actions includes Retry and Promote buttons in each message, which
+	// requires the Slack app to be configured with quayd's /slack/actions
+	// endpoint.
+	Actions bool
+
+	// Client is the http.Client to use. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Notify implements Notifier Notify.
+func (n *SlackNotifier) Notify(ctx context.Context, e *BuildEvent, status *Status) error {
+	if !n.notifies(status.State) {
+		return nil
+	}
+
+	text := fmt.Sprintf("%s *%s* `%s`: %s", slackEmoji[status.State], status.Repo, shortSha(status.Ref), status.Description)
+	if status.TargetURL != "" {
+		text += fmt.Sprintf(" (<%s|build logs>)", status.TargetURL)
+	}
+
+	blocks := []interface{}{
+		map[string]interface{}{
+			"type": "section",
+			"text": &SlackText{Type: "mrkdwn", Text: text},
+		},
+	}
+	if n.Actions {
+		blocks = append(blocks, NewSlackActionsBlock(e))
+	}
+
+	return n.post(ctx, map[string]interface{}{"text": text, "blocks": blocks})
+}
+
+// SendAlert implements Alerter SendAlert. Alerts are posted as plain
+// messages, without actions.
+func (n *SlackNotifier) SendAlert(ctx context.Context, alert *MonitorAlert) error {
+	emoji := ":rotating_light:"
+	if alert.Resolved {
+		emoji = ":white_check_mark:"
+	}
+
+	return n.post(ctx, map[string]interface{}{"text": fmt.Sprintf("%s *%s*: %s", emoji, alert.Subject, alert.Message)})
+}
+
+// post posts the message to the incoming webhook.
+func (n *SlackNotifier) post(ctx context.Context, message interface{}) error {
+	raw, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", n.WebhookURL, bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("slack responded with %s", resp.Status)
+	}
+
+	return nil
+}
+
+func (n *SlackNotifier) notifies(state string) bool {
+	states := n.States
+	if len(states) == 0 {
+		states = []string{"success", "failure"}
+	}
+
+	for _, s := range states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// shortSha returns the first 7 characters of a sha.
+func shortSha(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
@@ -0,0 +1,110 @@
+package quayd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// notifier is a fake implementation of the Notifier interface.
+type notifier struct {
+	statuses []*Status
+}
+
+func (n *notifier) Notify(ctx context.Context, e *BuildEvent, status *Status) error {
+	n.statuses = append(n.statuses, status)
+	return nil
+}
+
+func TestQuayd_Notifiers(t *testing.T) {
+	n := &notifier{}
+	q := &Quayd{StatusesRepository: &statusesRepository{}, Notifiers: []Notifier{n}, AllCommits: true, Timelines: &Timelines{}}
+
+	if err := q.Handle(context.Background(), &BuildEvent{ID: "1", Repo: "remind101/acme-inc", Ref: "abcd", Commits: []string{"efgh"}, State: "failure"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(n.statuses), 1; got != want {
+		t.Fatalf("Notifications => %d; want %d", got, want)
+	}
+
+	if got, want := n.statuses[0].Ref, "long-abcd"; got != want {
+		t.Fatalf("Ref => %s; want %s", got, want)
+	}
+
+	tl, _ := q.Timelines.Get("1")
+	var notified bool
+	for _, step := range tl.Steps {
+		notified = notified || step.Name == "notified"
+	}
+	if !notified {
+		t.Fatalf("Expected a notified step, got %+v", tl.Steps)
+	}
+}
+
+func TestSlackNotifier(t *testing.T) {
+	var body struct {
+		Text   string            `json:"text"`
+		Blocks []json.RawMessage `json:"blocks"`
+	}
+	requests := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer s.Close()
+
+	n := &SlackNotifier{WebhookURL: s.URL, Actions: true}
+	e := &BuildEvent{ID: "1", Repo: "remind101/acme-inc", Ref: "f1fb3b0", State: "failure"}
+	ctx := context.Background()
+
+	if err := n.Notify(ctx, e, &Status{Repo: "remind101/acme-inc", Ref: "f1fb3b0ea1c6", State: "pending"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if requests != 0 {
+		t.Fatal("Expected pending builds not to be posted")
+	}
+
+	if err := n.Notify(ctx, e, &Status{
+		Repo:        "remind101/acme-inc",
+		Ref:         "f1fb3b0ea1c6",
+		State:       "failure",
+		Description: "The Docker image failed to build",
+		TargetURL:   "https://quay.io/repository/remind101/acme-inc/build/1",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := body.Text, ":x: *remind101/acme-inc* `f1fb3b0`: The Docker image failed to build (<https://quay.io/repository/remind101/acme-inc/build/1|build logs>)"; got != want {
+		t.Fatalf("Text => %s; want %s", got, want)
+	}
+
+	if len(body.Blocks) != 2 || !strings.Contains(string(body.Blocks[1]), SlackActionRetry) {
+		t.Fatalf("Expected a Retry button, got %s", body.Blocks)
+	}
+}
+
+func TestSlackNotifier_SendAlert(t *testing.T) {
+	var body map[string]interface{}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer s.Close()
+
+	n := &SlackNotifier{WebhookURL: s.URL, Actions: true}
+	if err := n.SendAlert(context.Background(), &MonitorAlert{Subject: "remind101", Message: "remind101 is using 28 of its 30 private repositories"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := body["text"], ":rotating_light: *remind101*: remind101 is using 28 of its 30 private repositories"; got != want {
+		t.Fatalf("Text => %s; want %s", got, want)
+	}
+
+	if _, ok := body["blocks"]; ok {
+		t.Fatalf("Expected no actions, got %v", body["blocks"])
+	}
+}
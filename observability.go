@@ -0,0 +1,192 @@
+package quayd
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// Names of the metrics that quayd exports.
+const (
+	MetricWebhooksReceived        = "quayd_webhooks_received_total"
+	MetricGitHubErrors            = "quayd_github_errors_total"
+	MetricRegistryTags            = "quayd_registry_tag_operations_total"
+	MetricProcessingSeconds       = "quayd_processing_duration_seconds"
+	MetricQueueDepth              = "quayd_queue_depth"
+	MetricDeliveryLag             = "quayd_delivery_lag_seconds"
+	MetricQuayBuildsWaiting       = "quay_builds_waiting"
+	MetricSLA                     = "quayd_sla_total"
+	MetricFailures                = "quayd_failures_total"
+	MetricEventsProcessed         = "quayd_events_processed_total"
+	MetricEventsIgnored           = "quayd_events_ignored_total"
+	MetricQuayPrivateReposUsed    = "quay_private_repos_used"
+	MetricQuayPrivateReposAllowed = "quay_private_repos_allowed"
+)
+
+// alertingRules is the template for the recommended Prometheus alerting
+// rules.
+var alertingRules = template.Must(template.New("rules").Parse(`groups:
+- name: quayd
+  rules:
+  - alert: QuaydWebhooksStopped
+    expr: sum(rate({{.M.WebhooksReceived}}[30m])) == 0
+    for: 30m
+    annotations:
+      summary: quayd has not received any webhooks from Quay in 30 minutes.
+  - alert: QuaydGitHubErrors
+    expr: sum(rate({{.M.GitHubErrors}}[5m])) > 0.1
+    for: 10m
+    annotations:
+      summary: quayd is failing to create commit statuses on GitHub.
+  - alert: QuaydSlowProcessing
+    expr: histogram_quantile(0.99, sum(rate({{.M.ProcessingSeconds}}_bucket[5m])) by (le)) > 30
+    for: 10m
+    annotations:
+      summary: quayd is taking more than 30s to process webhooks.
+  - alert: QuaydQueueBacklog
+    expr: {{.M.QueueDepth}} > 50
+    for: 5m
+    annotations:
+      summary: quayd's processing queue is backing up.
+  - alert: QuaydSLABreached
+    expr: sum(rate({{.M.SLA}}{result="breached"}[1h])) by (repo) / sum(rate({{.M.SLA}}[1h])) by (repo) > 0.01
+    for: 15m
+    annotations:
+      summary: More than 1% of commit statuses for {{"{{"}} $labels.repo {{"}}"}} missed their SLA.
+  - alert: QuaydInfrastructureFailures
+    expr: sum(rate({{.M.Failures}}{class=~"quay|github"}[15m])) by (class) > 0.05
+    for: 15m
+    labels:
+      team: platform
+    annotations:
+      summary: Builds are failing because of {{"{{"}} $labels.class {{"}}"}} infrastructure.
+  - alert: QuaydConfigFailures
+    expr: sum(rate({{.M.Failures}}{class="config"}[15m])) by (repo) > 0
+    for: 15m
+    labels:
+      team: platform
+    annotations:
+      summary: quayd is misconfigured for {{"{{"}} $labels.repo {{"}}"}}.
+  - alert: QuayQuotaNearlyExhausted
+    expr: {{.M.QuayPrivateReposUsed}} / {{.M.QuayPrivateReposAllowed}} >= {{.QuotaThreshold}}
+    for: 1h
+    labels:
+      team: platform
+    annotations:
+      summary: The {{"{{"}} $labels.org {{"}}"}} Quay organization has used {{.QuotaPercent}}% or more of its private repositories.
+{{- range .Repos}}
+  - alert: QuayDeliveryLag
+    expr: {{$.M.DeliveryLag}}{repo="{{.}}"} > 300
+    for: 5m
+    labels:
+      repo: {{.}}
+    annotations:
+      summary: Quay is taking more than 5 minutes to deliver webhooks for {{.}}.
+  - alert: QuayBuildQueueSaturated
+    expr: {{$.M.QuayBuildsWaiting}}{repo="{{.}}"} > 5
+    for: 10m
+    labels:
+      repo: {{.}}
+    annotations:
+      summary: Builds for {{.}} are waiting in Quay's build queue.
+{{- end}}
+`))
+
+// metricNames exposes the metric names to templates.
+var metricNames = map[string]string{
+	"WebhooksReceived":        MetricWebhooksReceived,
+	"GitHubErrors":            MetricGitHubErrors,
+	"RegistryTags":            MetricRegistryTags,
+	"ProcessingSeconds":       MetricProcessingSeconds,
+	"QueueDepth":              MetricQueueDepth,
+	"DeliveryLag":             MetricDeliveryLag,
+	"QuayBuildsWaiting":       MetricQuayBuildsWaiting,
+	"QuayPrivateReposUsed":    MetricQuayPrivateReposUsed,
+	"QuayPrivateReposAllowed": MetricQuayPrivateReposAllowed,
+	"SLA":                     MetricSLA,
+	"Failures":                MetricFailures,
+}
+
+// AlertingRules returns the recommended Prometheus alerting rules, as YAML,
+// including per repo rules for each of repos. Quay quotas alert at the same
+// quotaThreshold as QueueMonitor; zero means DefaultQuotaThreshold.
+func AlertingRules(repos []string, quotaThreshold float64) ([]byte, error) {
+	if quotaThreshold == 0 {
+		quotaThreshold = DefaultQuotaThreshold
+	}
+
+	var buf bytes.Buffer
+	err := alertingRules.Execute(&buf, struct {
+		M              map[string]string
+		Repos          []string
+		QuotaThreshold string
+		QuotaPercent   string
+	}{metricNames, repos, strconv.FormatFloat(quotaThreshold, 'g', -1, 64), strconv.FormatFloat(math.Round(quotaThreshold*10000)/100, 'g', -1, 64)})
+	return buf.Bytes(), err
+}
+
+// GrafanaDashboard returns a Grafana dashboard, as JSON, that graphs quayd's
+// metrics, with a templating variable for selecting between repos.
+func GrafanaDashboard(repos []string) ([]byte, error) {
+	type target struct {
+		Expr         string `json:"expr"`
+		LegendFormat string `json:"legendFormat,omitempty"`
+	}
+
+	type panel struct {
+		ID      int               `json:"id"`
+		Title   string            `json:"title"`
+		Type    string            `json:"type"`
+		GridPos map[string]int    `json:"gridPos"`
+		Targets []target          `json:"targets"`
+		Options map[string]string `json:"options,omitempty"`
+	}
+
+	panels := []struct {
+		title, expr, legend string
+	}{
+		{"Webhooks received", `sum(rate(` + MetricWebhooksReceived + `{repo=~"$repo"}[5m])) by (status)`, "{{status}}"},
+		{"GitHub errors", `sum(rate(` + MetricGitHubErrors + `[5m]))`, ""},
+		{"Registry tag operations", `sum(rate(` + MetricRegistryTags + `{repo=~"$repo"}[5m])) by (result)`, "{{result}}"},
+		{"Processing latency (p99)", `histogram_quantile(0.99, sum(rate(` + MetricProcessingSeconds + `_bucket[5m])) by (le))`, ""},
+		{"Queue depth", MetricQueueDepth, ""},
+		{"SLA compliance", `sum(rate(` + MetricSLA + `{repo=~"$repo",result="met"}[1h])) by (repo) / sum(rate(` + MetricSLA + `{repo=~"$repo"}[1h])) by (repo)`, "{{repo}}"},
+		{"Quay delivery lag", MetricDeliveryLag + `{repo=~"$repo"}`, "{{repo}}"},
+		{"Failures by class", `sum(rate(` + MetricFailures + `{repo=~"$repo"}[5m])) by (class)`, "{{class}}"},
+		{"Quay builds waiting", MetricQuayBuildsWaiting + `{repo=~"$repo"}`, "{{repo}}"},
+		{"Quay private repository quota", MetricQuayPrivateReposUsed + ` / ` + MetricQuayPrivateReposAllowed, "{{org}}"},
+	}
+
+	d := map[string]interface{}{
+		"title":         "quayd",
+		"schemaVersion": 16,
+		"templating": map[string]interface{}{
+			"list": []interface{}{
+				map[string]interface{}{
+					"name":       "repo",
+					"type":       "custom",
+					"query":      strings.Join(repos, ","),
+					"includeAll": true,
+					"multi":      true,
+				},
+			},
+		},
+	}
+
+	var ps []panel
+	for i, p := range panels {
+		ps = append(ps, panel{
+			ID:      i + 1,
+			Title:   p.title,
+			Type:    "graph",
+			GridPos: map[string]int{"x": (i % 2) * 12, "y": (i / 2) * 8, "w": 12, "h": 8},
+			Targets: []target{{Expr: p.expr, LegendFormat: p.legend}},
+		})
+	}
+	d["panels"] = ps
+
+	return json.MarshalIndent(d, "", "  ")
+}
@@ -0,0 +1,56 @@
+package quayd
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestAlertingRules(t *testing.T) {
+	raw, err := AlertingRules([]string{"remind101/acme-inc"}, 0.8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := string(raw)
+
+	for _, want := range []string{
+		MetricWebhooksReceived,
+		MetricQueueDepth,
+		MetricDeliveryLag + `{repo="remind101/acme-inc"}`,
+		MetricQuayPrivateReposAllowed + " >= 0.8",
+		"used 80% or more",
+	} {
+		if !strings.Contains(rules, want) {
+			t.Fatalf("Expected rules to contain %q:\n%s", want, rules)
+		}
+	}
+}
+
+func TestGrafanaDashboard(t *testing.T) {
+	raw, err := GrafanaDashboard([]string{"remind101/acme-inc", "remind101/r101-api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var d struct {
+		Panels []struct {
+			Title string `json:"title"`
+		} `json:"panels"`
+		Templating struct {
+			List []struct {
+				Query string `json:"query"`
+			} `json:"list"`
+		} `json:"templating"`
+	}
+	if err := json.Unmarshal(raw, &d); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := d.Templating.List[0].Query, "remind101/acme-inc,remind101/r101-api"; got != want {
+		t.Fatalf("Repos => %s; want %s", got, want)
+	}
+
+	if len(d.Panels) == 0 {
+		t.Fatal("Expected panels")
+	}
+}
@@ -0,0 +1,194 @@
+package quayd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// PausedRepo is a repo whose builds are held, rather than processed.
+type PausedRepo struct {
+	Repo     string    `json:"repo"`
+	Reason   string    `json:"reason,omitempty"`
+	PausedAt time.Time `json:"paused_at"`
+
+	// Held is the number of build events that are waiting for the repo
+	// to be resumed.
+	Held int `json:"held"`
+
+	events []*BuildEvent
+}
+
+// Pauses holds the build events of paused repos until they're resumed, e.g.
+// during a registry migration or an incident. A nil *Pauses pauses nothing.
+type Pauses struct {
+	mu    sync.Mutex
+	repos map[string]*PausedRepo
+}
+
+// Pause pauses processing of the repo's builds. Pausing a paused repo updates
+// the reason.
+func (p *Pauses) Pause(repo, reason string) *PausedRepo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.repos == nil {
+		p.repos = make(map[string]*PausedRepo)
+	}
+	paused, ok := p.repos[repo]
+	if !ok {
+		paused = &PausedRepo{Repo: repo, PausedAt: time.Now()}
+		p.repos[repo] = paused
+	}
+	paused.Reason = reason
+
+	c := *paused
+	return &c
+}
+
+// Resume resumes the repo, and returns the events that were held while it was
+// paused, in the order they were received. It returns false if the repo
+// wasn't paused.
+func (p *Pauses) Resume(repo string) ([]*BuildEvent, bool) {
+	if p == nil {
+		return nil, false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	paused, ok := p.repos[repo]
+	if !ok {
+		return nil, false
+	}
+	delete(p.repos, repo)
+	return paused.events, true
+}
+
+// Hold holds the event if its repo is paused, and returns whether it did.
+func (p *Pauses) Hold(e *BuildEvent) bool {
+	if p == nil {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	paused, ok := p.repos[e.Repo]
+	if !ok {
+		return false
+	}
+	paused.events = append(paused.events, e)
+	paused.Held = len(paused.events)
+	return true
+}
+
+// List returns the paused repos, sorted by repo.
+func (p *Pauses) List() []*PausedRepo {
+	if p == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	list := make([]*PausedRepo, 0, len(p.repos))
+	for _, paused := range p.repos {
+		c := *paused
+		list = append(list, &c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Repo < list[j].Repo })
+	return list
+}
+
+// Resume resumes the repo, and processes the events that were held while it
+// was paused: they're pushed onto the Queue when there is one, and otherwise
+// handled in the background. It returns the number of held events.
+func (q *Quayd) Resume(ctx context.Context, repo string) (int, bool) {
+	events, ok := q.Pauses.Resume(repo)
+	if !ok {
+		return 0, false
+	}
+	q.logger().Log(ctx, "repo resumed", "repo", repo, "held", len(events))
+
+	if q.Queue != nil {
+		for _, e := range events {
+			if err := q.Queue.Push(q, e); err != nil {
+				q.logger().Log(ctx, "requeueing held build failed", "repo", repo, "build", e.BuildID, "error", err)
+			}
+		}
+		return len(events), true
+	}
+
+	go func() {
+		for _, e := range events {
+			q.Handle(context.Background(), e)
+		}
+	}()
+	return len(events), true
+}
+
+// PausesHandler is an http.Handler that lists the paused repos.
+type PausesHandler struct {
+	*Quayd
+}
+
+func (h *PausesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.Pauses == nil {
+		http.Error(w, "pausing repos is not enabled", 404)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.Pauses.List())
+}
+
+// PauseForm is the payload of a request to pause a repo.
+type PauseForm struct {
+	Reason string `json:"reason"`
+}
+
+// PauseHandler is an http.Handler that pauses (POST) or resumes (DELETE) the
+// processing of a repo's builds.
+type PauseHandler struct {
+	*Quayd
+}
+
+func (h *PauseHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.Pauses == nil {
+		http.Error(w, "pausing repos is not enabled", 404)
+		return
+	}
+
+	vars := mux.Vars(r)
+	repo := vars["namespace"] + "/" + vars["name"]
+
+	if r.Method == "DELETE" {
+		held, ok := h.Resume(r.Context(), repo)
+		if !ok {
+			http.Error(w, "Repo is not paused: "+repo, 404)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"repo": repo, "resumed": held})
+		return
+	}
+
+	var form PauseForm
+	if err := json.NewDecoder(r.Body).Decode(&form); err != nil && r.ContentLength != 0 {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	paused := h.Pauses.Pause(repo, form.Reason)
+	h.logger().Log(r.Context(), "repo paused", "repo", repo, "reason", form.Reason)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(paused)
+}
@@ -0,0 +1,79 @@
+package quayd
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPauseHandler(t *testing.T) {
+	r := &statusesRepository{}
+	s := NewServer(&Quayd{AdminToken: testAdminToken, StatusesRepository: r, Pauses: &Pauses{}})
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req, _ := newAdminRequest(method, path, bytes.NewBufferString(body))
+		s.ServeHTTP(resp, req)
+		return resp
+	}
+
+	if resp := do("POST", "/admin/pauses/ejholmes/docker-statsd", `{"reason":"registry migration"}`); resp.Code != 200 {
+		t.Fatalf("Status => %d; want 200", resp.Code)
+	}
+
+	// Builds of the paused repo are held.
+	req, _ := http.NewRequest("POST", "/quay/success", loadFixture("pending_build", t))
+	s.ServeHTTP(httptest.NewRecorder(), req)
+	if len(r.statuses) != 0 {
+		t.Fatal("Expected the build to be held")
+	}
+
+	var paused []*PausedRepo
+	json.NewDecoder(do("GET", "/admin/pauses", "").Body).Decode(&paused)
+	if len(paused) != 1 || paused[0].Repo != "ejholmes/docker-statsd" || paused[0].Reason != "registry migration" || paused[0].Held != 1 {
+		t.Fatalf("Unexpected paused repos %+v", paused)
+	}
+
+	// Resuming processes the held builds.
+	if resp := do("DELETE", "/admin/pauses/ejholmes/docker-statsd", ""); resp.Code != 200 {
+		t.Fatalf("Status => %d; want 200", resp.Code)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		r.mu.Lock()
+		n := len(r.statuses)
+		r.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the held build to be processed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if resp := do("DELETE", "/admin/pauses/ejholmes/docker-statsd", ""); resp.Code != 404 {
+		t.Fatalf("Status => %d; want 404", resp.Code)
+	}
+}
+
+func TestPauseHandler_Unauthorized(t *testing.T) {
+	pauses := &Pauses{}
+	s := NewServer(&Quayd{AdminToken: testAdminToken, Pauses: pauses})
+
+	for _, method := range []string{"POST", "DELETE"} {
+		resp := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, "/admin/pauses/ejholmes/docker-statsd", bytes.NewBufferString(`{}`))
+		s.ServeHTTP(resp, req)
+
+		if got, want := resp.Code, 401; got != want {
+			t.Fatalf("%s status => %d; want %d", method, got, want)
+		}
+	}
+	if paused := pauses.List(); len(paused) != 0 {
+		t.Fatalf("Expected no paused repos, got %+v", paused)
+	}
+}
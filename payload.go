@@ -0,0 +1,144 @@
+package quayd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// DefaultMaxPayloadSize is the largest webhook payload that's accepted when
+// Quayd.MaxPayloadSize isn't set.
+const DefaultMaxPayloadSize = 1 << 20
+
+// ErrPayloadTooLarge is returned for webhook payloads larger than
+// Quayd.MaxPayloadSize.
+var ErrPayloadTooLarge = errors.New("payload too large")
+
+// PayloadError is a webhook payload that's malformed or invalid. It's
+// returned to the client as a JSON body, so the sender can tell what's wrong
+// with it.
+type PayloadError struct {
+	// Code is a machine readable error code, like "invalid_payload".
+	Code    string        `json:"error"`
+	Message string        `json:"message"`
+	Fields  []*FieldError `json:"fields,omitempty"`
+}
+
+// FieldError is a problem with a single field of a payload.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error implements the error interface.
+func (e *PayloadError) Error() string {
+	msg := e.Message
+	for _, f := range e.Fields {
+		msg += "; " + f.Field + " " + f.Message
+	}
+	return msg
+}
+
+// payloadBuffers are reused between requests, so that a burst of webhooks
+// doesn't allocate a buffer for each of them.
+var payloadBuffers = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// payload is the body of a webhook request, in a pooled buffer. It must be
+// released when the request has been handled, and its bytes mustn't be kept
+// after that.
+type payload struct {
+	buf *bytes.Buffer
+}
+
+// Bytes returns the raw payload, which webhook validators need to verify
+// signatures.
+func (p *payload) Bytes() []byte {
+	return p.buf.Bytes()
+}
+
+// release returns the buffer to the pool.
+func (p *payload) release() {
+	// Don't hold on to the buffers of unusually large payloads.
+	if p.buf.Cap() <= DefaultMaxPayloadSize {
+		p.buf.Reset()
+		payloadBuffers.Put(p.buf)
+	}
+}
+
+// readPayload reads the body of the request into a pooled buffer, up to
+// MaxPayloadSize bytes. If v isn't nil, the body is decoded into it as JSON
+// while it's read, rather than after it's been buffered.
+func (q *Quayd) readPayload(r *http.Request, v interface{}) (*payload, error) {
+	p := &payload{buf: payloadBuffers.Get().(*bytes.Buffer)}
+
+	limit := q.maxPayloadSize()
+	body := io.TeeReader(io.LimitReader(r.Body, limit+1), p.buf)
+
+	var err error
+	if v != nil {
+		dec := json.NewDecoder(body)
+		err = dec.Decode(v)
+		if err == nil {
+			if _, terr := dec.Token(); terr != io.EOF {
+				err = &PayloadError{Code: "malformed_json", Message: "unexpected data after the JSON payload"}
+			}
+		}
+	}
+	// The rest of the body is read even after decoding, since signatures
+	// are computed over all of it.
+	if _, cerr := io.Copy(ioutil.Discard, body); err == nil {
+		err = cerr
+	}
+	if int64(p.buf.Len()) > limit {
+		err = ErrPayloadTooLarge
+	}
+	if err != nil {
+		p.release()
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (q *Quayd) maxPayloadSize() int64 {
+	if q.MaxPayloadSize <= 0 {
+		return DefaultMaxPayloadSize
+	}
+
+	return q.MaxPayloadSize
+}
+
+// payloadError responds with the error from reading or validating a payload,
+// as a JSON PayloadError.
+func payloadError(w http.ResponseWriter, err error) {
+	code := 400
+	var perr *PayloadError
+	switch e := err.(type) {
+	case *PayloadError:
+		perr = e
+	case *json.SyntaxError:
+		perr = &PayloadError{Code: "malformed_json", Message: fmt.Sprintf("%s (at offset %d)", e, e.Offset)}
+	case *json.UnmarshalTypeError:
+		perr = &PayloadError{Code: "invalid_payload", Message: "the payload has a field of the wrong type", Fields: []*FieldError{
+			{Field: e.Field, Message: "must be a " + e.Type.String() + ", not a " + e.Value},
+		}}
+	default:
+		perr = &PayloadError{Code: "malformed_json", Message: err.Error()}
+		if err == ErrPayloadTooLarge {
+			code = http.StatusRequestEntityTooLarge
+			perr.Code = "payload_too_large"
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(perr)
+}
@@ -0,0 +1,96 @@
+package quayd
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWebhook_PayloadTooLarge(t *testing.T) {
+	r := &statusesRepository{}
+	s := NewServer(&Quayd{StatusesRepository: r, MaxPayloadSize: 64})
+
+	body := `{"repository":"remind101/acme-inc","trigger_kind":"github","build_name":"` + strings.Repeat("a", 64) + `"}`
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/quay/pending", bytes.NewBufferString(body))
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 413; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+	if len(r.statuses) != 0 {
+		t.Fatal("Expected no commit status")
+	}
+}
+
+func TestReadPayload(t *testing.T) {
+	q := &Quayd{}
+
+	// Trailing data after the JSON value is still part of the payload,
+	// since signatures are computed over all of it.
+	body := `{"event":"build_success"}` + "\n\n"
+	req, _ := http.NewRequest("POST", "/", bytes.NewBufferString(body))
+
+	var form WebhookForm
+	p, err := q.readPayload(req, &form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.release()
+
+	if got, want := form.Event, "build_success"; got != want {
+		t.Fatalf("Event => %s; want %s", got, want)
+	}
+	if got, want := string(p.Bytes()), body; got != want {
+		t.Fatalf("Payload => %q; want %q", got, want)
+	}
+}
+
+func TestWebhook_InvalidPayload(t *testing.T) {
+	r := &statusesRepository{}
+	s := NewServer(&Quayd{StatusesRepository: r})
+
+	tests := []struct {
+		body   string
+		code   string
+		fields []string
+	}{
+		{`{"repository":`, "malformed_json", nil},
+		{`{"repository":"remind101/acme-inc","trigger_kind":"github","build_name":"abcd"} {}`, "malformed_json", nil},
+		{`{"repository":"remind101/acme-inc","docker_tags":"latest"}`, "invalid_payload", []string{"docker_tags"}},
+		{`{"repository":"acme-inc","trigger_kind":"github","started_at":-1}`, "invalid_payload", []string{"repository", "build_name", "started_at"}},
+	}
+
+	for _, tt := range tests {
+		resp := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/quay/pending", bytes.NewBufferString(tt.body))
+		s.ServeHTTP(resp, req)
+
+		if got, want := resp.Code, 400; got != want {
+			t.Fatalf("%s => %d; want %d", tt.body, got, want)
+		}
+
+		var perr PayloadError
+		if err := json.NewDecoder(resp.Body).Decode(&perr); err != nil {
+			t.Fatal(err)
+		}
+		if perr.Code != tt.code {
+			t.Fatalf("%s => %s; want %s", tt.body, perr.Code, tt.code)
+		}
+		var fields []string
+		for _, f := range perr.Fields {
+			fields = append(fields, f.Field)
+		}
+		if !reflect.DeepEqual(fields, tt.fields) {
+			t.Fatalf("%s => fields %v; want %v", tt.body, fields, tt.fields)
+		}
+	}
+
+	if len(r.statuses) != 0 {
+		t.Fatal("Expected no commit status")
+	}
+}
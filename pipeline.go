@@ -0,0 +1,230 @@
+package quayd
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// TagJob is the state that's threaded through the stages of a Pipeline. Each
+// stage reads what earlier stages produced and fills in its own part.
+type TagJob struct {
+	// Repo, Tag and Ref identify the build: the tag it was pushed with and
+	// the git ref it was built from.
+	Repo string
+	Tag  string
+	Ref  string
+
+	// Sha is the git sha that Ref resolves to.
+	Sha string
+
+	// Image is the built image. Its Tags are the tags that have been
+	// applied so far.
+	Image *Image
+}
+
+// Stage is a single step of a Pipeline.
+type Stage struct {
+	Name string
+
+	// Run performs the stage, updating the job.
+	Run func(ctx context.Context, q *Quayd, job *TagJob) error
+
+	// Retry, if set, retries transient failures of the stage.
+	Retry *RetryPolicy
+
+	// Optional stages log their failures rather than failing the
+	// pipeline, for things like notifying downstream systems.
+	Optional bool
+}
+
+// StageResult is the outcome of running a stage.
+type StageResult struct {
+	Name     string        `json:"name"`
+	Attempts int           `json:"attempts"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// PipelineError is returned when a stage of a Pipeline fails, or the pipeline
+// is cancelled. It carries the partial result, so that what was done before
+// the failure can be reported.
+type PipelineError struct {
+	// Stage is the stage that failed.
+	Stage string
+	Err   error
+
+	// Results are the results of the stages that ran, including the
+	// failed one.
+	Results []*StageResult
+
+	// Image is the image with the tags that were applied before the
+	// failure, or nil if it wasn't resolved.
+	Image *Image
+}
+
+// Error implements the error interface.
+func (e *PipelineError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Stage, e.Err)
+}
+
+// Unwrap returns the error of the failed stage.
+func (e *PipelineError) Unwrap() error {
+	return e.Err
+}
+
+// Pipeline is the sequence of stages that a successful build goes through to
+// tag its image. New stages, such as signing, scanning or promoting the image,
+// can be inserted anywhere in it.
+type Pipeline []*Stage
+
+// The stages of the DefaultPipeline.
+var (
+	// StageResolveCommit resolves the ref to a git sha.
+	StageResolveCommit = &Stage{Name: "resolve-commit", Run: func(ctx context.Context, q *Quayd, job *TagJob) (err error) {
+		job.Sha, err = q.resolveCommit(ctx, job.Repo, job.Ref)
+		return err
+	}}
+
+	// StageResolveImage resolves the pushed tag to an image id, or to a
+	// manifest digest with digest tagging.
+	StageResolveImage = &Stage{Name: "resolve-image", Run: func(ctx context.Context, q *Quayd, job *TagJob) error {
+		imageID, err := q.tagResolver().Resolve(ctx, job.Repo, job.Tag)
+		if err != nil {
+			return err
+		}
+		job.Image = &Image{Registry: DefaultRegistry, Repo: job.Repo, ID: imageID, Tags: []string{job.Tag}}
+		if IsDigest(imageID) {
+			job.Image.Digest = imageID
+		}
+		return nil
+	}}
+
+	// StageResolvePlatforms records the platform specific digests of
+	// multi-arch images, when the TagResolver can list them.
+	StageResolvePlatforms = &Stage{Name: "resolve-platforms", Run: func(ctx context.Context, q *Quayd, job *TagJob) (err error) {
+		r, ok := q.tagResolver().(PlatformResolver)
+		if !ok || job.Image.Digest == "" {
+			return nil
+		}
+		job.Image.Platforms, err = r.Platforms(ctx, job.Repo, job.Image.Digest)
+		return err
+	}}
+
+	// StageTagSha tags the image with the git sha, since the docker
+	// registry does not currently support pulling a docker image by its
+	// immutable identifier, only by a tag.
+	StageTagSha = &Stage{Name: "tag-sha", Run: func(ctx context.Context, q *Quayd, job *TagJob) error {
+		return job.tag(ctx, q, job.Sha)
+	}}
+
+	// StageTagPlatforms tags the platform specific images with the git
+	// sha and their platform, when PlatformTags is enabled.
+	StageTagPlatforms = &Stage{Name: "tag-platforms", Run: func(ctx context.Context, q *Quayd, job *TagJob) error {
+		if !q.PlatformTags {
+			return nil
+		}
+		for _, p := range job.Image.Platforms {
+			tag := PlatformTag(job.Sha, p)
+			if err := q.tagger().Tag(ctx, job.Repo, p.Digest, tag); err != nil {
+				return err
+			}
+			p.Tag = tag
+		}
+		return nil
+	}}
+
+	// StageTagImageID tags the image with its own id. Images resolved to
+	// a digest aren't, since a digest isn't a valid tag, and they can be
+	// pulled by it instead.
+	StageTagImageID = &Stage{Name: "tag-image-id", Run: func(ctx context.Context, q *Quayd, job *TagJob) error {
+		if job.Image.Digest != "" {
+			return nil
+		}
+		return job.tag(ctx, q, job.Image.ID)
+	}}
+
+	// StageTagHook notifies the TagHook of the applied tags, for repos
+	// that can be promoted.
+	StageTagHook = &Stage{Name: "tag-hook", Optional: true, Run: func(ctx context.Context, q *Quayd, job *TagJob) error {
+		if !q.Policies.Capabilities(job.Repo).Has(CapabilityPromote) {
+			return nil
+		}
+		return q.tagHook().TagsApplied(ctx, &TagEvent{
+			Repo:    job.Repo,
+			Sha:     job.Sha,
+			ImageID: job.Image.ID,
+			Tags:    job.Image.Tags[1:],
+		})
+	}}
+)
+
+// DefaultPipeline is the Pipeline used when Quayd.Pipeline is nil.
+var DefaultPipeline = Pipeline{
+	StageResolveCommit,
+	StageResolveImage,
+	StageTagSha,
+	StageTagImageID,
+	StageResolvePlatforms,
+	StageTagPlatforms,
+	StageTagHook,
+}
+
+// tag applies tag to the job's image.
+func (job *TagJob) tag(ctx context.Context, q *Quayd, tag string) error {
+	if err := q.tagger().Tag(ctx, job.Repo, job.Image.ID, tag); err != nil {
+		return err
+	}
+	job.Image.Tags = append(job.Image.Tags, tag)
+	return nil
+}
+
+// Run runs each stage in order. It stops at the first stage that fails, or
+// when ctx is cancelled between stages, returning a *PipelineError.
+func (p Pipeline) Run(ctx context.Context, q *Quayd, job *TagJob) error {
+	var results []*StageResult
+	fail := func(stage string, err error) error {
+		return &PipelineError{Stage: stage, Err: err, Results: results, Image: job.Image}
+	}
+
+	for _, stage := range p {
+		if err := ctx.Err(); err != nil {
+			return fail(stage.Name, err)
+		}
+
+		start := time.Now()
+		result := &StageResult{Name: stage.Name}
+		run := func() error {
+			result.Attempts++
+			return stage.Run(ctx, q, job)
+		}
+
+		var err error
+		if stage.Retry != nil {
+			err = stage.Retry.Do(ctx, run)
+		} else {
+			err = run()
+		}
+		result.Duration = time.Since(start)
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+
+		if err != nil && stage.Optional {
+			q.logger().Log(ctx, "optional stage failed", "repo", job.Repo, "stage", stage.Name, "error", err)
+		} else if err != nil {
+			return fail(stage.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (q *Quayd) pipeline() Pipeline {
+	if q.Pipeline == nil {
+		return DefaultPipeline
+	}
+
+	return q.Pipeline
+}
@@ -0,0 +1,141 @@
+package quayd
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestPipeline(t *testing.T) {
+	registry := &MemoryRegistry{}
+	registry.Seed("remind101/acme-inc", "test")
+
+	var signed string
+	sign := &Stage{Name: "sign", Run: func(ctx context.Context, q *Quayd, job *TagJob) error {
+		signed = job.Image.ID
+		return nil
+	}}
+	q := &Quayd{
+		TagResolver: registry,
+		Tagger:      registry,
+		Pipeline:    append(DefaultPipeline[:4:4], sign),
+	}
+
+	image, err := q.LoadImageTags(context.Background(), "test", "remind101/acme-inc", "abcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := image.Tags, []string{"test", "long-abcd", image.ID}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Tags => %v; want %v", got, want)
+	}
+	if signed != image.ID {
+		t.Fatalf("Expected the sign stage to run with the image")
+	}
+}
+
+func TestPipeline_PartialResult(t *testing.T) {
+	registry := &MemoryRegistry{}
+	registry.Seed("remind101/acme-inc", "test")
+
+	boom := errors.New("boom")
+	q := &Quayd{
+		TagResolver: registry,
+		Tagger:      registry,
+		Deliveries:  &MemoryDeliveryStore{},
+		Pipeline: Pipeline{
+			StageResolveCommit,
+			StageResolveImage,
+			StageTagSha,
+			{Name: "scan", Run: func(ctx context.Context, q *Quayd, job *TagJob) error { return boom }},
+			StageTagImageID,
+		},
+	}
+	q.Deliveries.Save(&Delivery{ID: "1"})
+
+	err := q.Handle(context.Background(), &BuildEvent{ID: "1", Repo: "remind101/acme-inc", Ref: "abcd", State: "success", Tags: []string{"test"}})
+	perr, ok := err.(*PipelineError)
+	if !ok {
+		t.Fatalf("Expected a *PipelineError, got %v", err)
+	}
+	if got, want := perr.Stage, "scan"; got != want {
+		t.Fatalf("Stage => %s; want %s", got, want)
+	}
+	if !errors.Is(err, boom) {
+		t.Fatalf("Expected the error to wrap the stage's error")
+	}
+	if got, want := perr.Image.Tags, []string{"test", "long-abcd"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Tags => %v; want %v", got, want)
+	}
+
+	d, _ := q.Deliveries.Find("1")
+	var stages []string
+	for _, s := range d.Stages {
+		stages = append(stages, s.Name)
+	}
+	if got, want := stages, []string{"resolve-commit", "resolve-image", "tag-sha", "scan"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Stages => %v; want %v", got, want)
+	}
+	if got, want := d.Stages[3].Error, "boom"; got != want {
+		t.Fatalf("Error => %s; want %s", got, want)
+	}
+}
+
+func TestPipeline_Retry(t *testing.T) {
+	var attempts int
+	p := Pipeline{{
+		Name:  "flaky",
+		Retry: &RetryPolicy{MaxAttempts: 3},
+		Run: func(ctx context.Context, q *Quayd, job *TagJob) error {
+			attempts++
+			if attempts < 3 {
+				return &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+			}
+			return nil
+		},
+	}}
+
+	if err := p.Run(context.Background(), &Quayd{}, &TagJob{}); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 {
+		t.Fatalf("Attempts => %d; want 3", attempts)
+	}
+}
+
+func TestPipeline_Optional(t *testing.T) {
+	var ran bool
+	p := Pipeline{
+		{Name: "notify", Optional: true, Run: func(ctx context.Context, q *Quayd, job *TagJob) error { return errors.New("boom") }},
+		{Name: "next", Run: func(ctx context.Context, q *Quayd, job *TagJob) error { ran = true; return nil }},
+	}
+
+	if err := p.Run(context.Background(), &Quayd{Logger: &JSONLogger{Writer: ioutil.Discard}}, &TagJob{}); err != nil {
+		t.Fatal(err)
+	}
+	if !ran {
+		t.Fatal("Expected the pipeline to continue after an optional stage failed")
+	}
+}
+
+func TestPipeline_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := Pipeline{
+		{Name: "first", Run: func(ctx context.Context, q *Quayd, job *TagJob) error { cancel(); return nil }},
+		{Name: "second", Run: func(ctx context.Context, q *Quayd, job *TagJob) error {
+			t.Fatal("Expected the pipeline to stop once cancelled")
+			return nil
+		}},
+	}
+
+	err := p.Run(ctx, &Quayd{}, &TagJob{})
+	perr, ok := err.(*PipelineError)
+	if !ok || perr.Stage != "second" || perr.Err != context.Canceled {
+		t.Fatalf("Expected the pipeline to be cancelled before the second stage, got %v", err)
+	}
+	if got, want := len(perr.Results), 1; got != want {
+		t.Fatalf("Results => %d; want %d", got, want)
+	}
+}
@@ -0,0 +1,350 @@
+package quayd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/ejholmes/go-github/github"
+)
+
+// ManagedState is the live state that `quayd plan` and `quayd apply` manage
+// for the repos in the config.
+type ManagedState struct {
+	// WebhookURL is the public URL of quayd, without its BasePath. Each
+	// Quay repo should have a webhook notification to its Quay endpoint
+	// for each build event.
+	WebhookURL string `json:"webhook_url"`
+
+	// Repos are the Quay repos to manage, in addition to those in the
+	// repo map.
+	Repos []string `json:"repos"`
+
+	// RequiredBranches are the branches whose protection should require
+	// the repo's commit status context.
+	RequiredBranches []string `json:"required_branches"`
+
+	// EnvironmentTags maps a Quay repo to the environment tags that should
+	// point at the image of another tag, e.g.
+	// {"remind101/acme-inc": {"production": "v1.2.0"}}.
+	EnvironmentTags map[string]map[string]string `json:"environment_tags"`
+}
+
+// The kinds of resources that a Plan changes.
+const (
+	ResourceQuayNotification = "quay_notification"
+	ResourceRequiredCheck    = "github_required_check"
+	ResourceEnvironmentTag   = "environment_tag"
+)
+
+// The actions of a Change.
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+)
+
+// Change is a difference between the ManagedState and the live state.
+type Change struct {
+	Action   string `json:"action"`
+	Resource string `json:"resource"`
+
+	// Repo is the Quay or GitHub repo that the resource belongs to, and
+	// Name identifies the resource within it.
+	Repo string `json:"repo"`
+	Name string `json:"name"`
+
+	// From and To are the live and desired values.
+	From string `json:"from,omitempty"`
+	To   string `json:"to"`
+
+	// apply makes the change.
+	apply func(ctx context.Context) error
+}
+
+// Plan is the set of changes that Apply would make.
+type Plan []*Change
+
+// String renders the plan like a terraform plan.
+func (p Plan) String() string {
+	if len(p) == 0 {
+		return "No changes. The live state matches the configuration.\n"
+	}
+
+	var b bytes.Buffer
+	var create, update int
+	for _, c := range p {
+		switch c.Action {
+		case ActionCreate:
+			create++
+			fmt.Fprintf(&b, "  # %s.%s[%q] will be created\n", c.Resource, c.Repo, c.Name)
+			fmt.Fprintf(&b, "  + %s\n\n", c.To)
+		case ActionUpdate:
+			update++
+			fmt.Fprintf(&b, "  # %s.%s[%q] will be updated in-place\n", c.Resource, c.Repo, c.Name)
+			fmt.Fprintf(&b, "  ~ %s -> %s\n\n", c.From, c.To)
+		}
+	}
+	fmt.Fprintf(&b, "Plan: %d to add, %d to change, 0 to destroy.\n", create, update)
+	return b.String()
+}
+
+// BranchProtection reads and updates the required status checks of branches.
+type BranchProtection interface {
+	RequiredContexts(ctx context.Context, repo, branch string) ([]string, error)
+	AddRequiredContexts(ctx context.Context, repo, branch string, contexts []string) error
+}
+
+// Plan compares the ManagedState against the live state of Quay, GitHub and
+// the registry, and returns the changes that would make them match.
+func (q *Quayd) Plan(ctx context.Context, state *ManagedState, protection BranchProtection) (Plan, error) {
+	var plan Plan
+	for _, repo := range q.managedRepos(state) {
+		if state.WebhookURL != "" {
+			changes, err := q.planNotifications(ctx, repo, strings.TrimSuffix(state.WebhookURL, "/")+strings.TrimSuffix(q.BasePath, "/")+q.quayPath())
+			if err != nil {
+				return nil, err
+			}
+			plan = append(plan, changes...)
+		}
+
+		if len(state.RequiredBranches) > 0 {
+			changes, err := q.planRequiredChecks(ctx, repo, state.RequiredBranches, protection)
+			if err != nil {
+				return nil, err
+			}
+			plan = append(plan, changes...)
+		}
+
+		changes, err := q.planEnvironmentTags(ctx, repo, state.EnvironmentTags[repo])
+		if err != nil {
+			return nil, err
+		}
+		plan = append(plan, changes...)
+	}
+	return plan, nil
+}
+
+// Apply makes the changes of the plan, stopping at the first failure.
+func (q *Quayd) Apply(ctx context.Context, plan Plan) error {
+	if q.ReadOnly {
+		return errors.New("cannot apply changes in read-only mode")
+	}
+
+	for _, c := range plan {
+		if err := c.apply(ctx); err != nil {
+			return fmt.Errorf("%s.%s[%q]: %v", c.Resource, c.Repo, c.Name, err)
+		}
+		q.logger().Log(ctx, "change applied", "resource", c.Resource, "repo", c.Repo, "name", c.Name, "action", c.Action)
+	}
+	return nil
+}
+
+// managedRepos returns the sorted Quay repos of the state and the repo map.
+func (q *Quayd) managedRepos(state *ManagedState) []string {
+	seen := make(map[string]bool)
+	for _, repo := range state.Repos {
+		seen[repo] = true
+	}
+	if m, ok := q.RepoMapper.(RepoMap); ok {
+		for repo := range m {
+			seen[repo] = true
+		}
+	}
+	for repo := range state.EnvironmentTags {
+		seen[repo] = true
+	}
+
+	repos := make([]string, 0, len(seen))
+	for repo := range seen {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+	return repos
+}
+
+// planNotifications plans a webhook notification to webhookURL for each Quay
+// build event that the repo doesn't have one for.
+func (q *Quayd) planNotifications(ctx context.Context, repo, webhookURL string) (Plan, error) {
+	if q.Quay == nil {
+		return nil, fmt.Errorf("planning quay notifications requires the Quay API")
+	}
+
+	notifications, err := q.Quay.Notifications(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := make(map[string]bool)
+	for _, n := range notifications {
+		if n.Method == "webhook" && n.Config.URL == webhookURL {
+			existing[n.Event] = true
+		}
+	}
+
+	events := make([]string, 0, len(QuayEvents))
+	for event := range QuayEvents {
+		events = append(events, event)
+	}
+	sort.Strings(events)
+
+	var plan Plan
+	for _, event := range events {
+		if existing[event] {
+			continue
+		}
+		event := event
+		plan = append(plan, &Change{
+			Action:   ActionCreate,
+			Resource: ResourceQuayNotification,
+			Repo:     repo,
+			Name:     event,
+			To:       webhookURL,
+			apply: func(ctx context.Context) error {
+				return q.Quay.CreateNotification(ctx, repo, &QuayNotification{Event: event, Method: "webhook", Config: QuayNotificationConfig{URL: webhookURL}})
+			},
+		})
+	}
+	return plan, nil
+}
+
+// planRequiredChecks plans requiring the repo's commit status context on each
+// of the branches.
+func (q *Quayd) planRequiredChecks(ctx context.Context, repo string, branches []string, protection BranchProtection) (Plan, error) {
+	if protection == nil {
+		return nil, fmt.Errorf("planning required checks requires GitHub branch protection")
+	}
+
+	githubRepo, err := q.githubRepo(repo)
+	if err != nil {
+		return nil, err
+	}
+	name := q.context(&BuildEvent{Repo: repo}, DefaultRoute)
+
+	var plan Plan
+	for _, branch := range branches {
+		contexts, err := protection.RequiredContexts(ctx, githubRepo, branch)
+		if err != nil {
+			return nil, err
+		}
+
+		required := false
+		for _, c := range contexts {
+			required = required || c == name
+		}
+		if required {
+			continue
+		}
+
+		branch := branch
+		plan = append(plan, &Change{
+			Action:   ActionCreate,
+			Resource: ResourceRequiredCheck,
+			Repo:     githubRepo,
+			Name:     branch,
+			To:       name,
+			apply: func(ctx context.Context) error {
+				return protection.AddRequiredContexts(ctx, githubRepo, branch, []string{name})
+			},
+		})
+	}
+	return plan, nil
+}
+
+// planEnvironmentTags plans pointing each environment tag at the image of its
+// source tag.
+func (q *Quayd) planEnvironmentTags(ctx context.Context, repo string, tags map[string]string) (Plan, error) {
+	envs := make([]string, 0, len(tags))
+	for env := range tags {
+		envs = append(envs, env)
+	}
+	sort.Strings(envs)
+
+	t := q.forTenant(repo)
+	var plan Plan
+	for _, env := range envs {
+		source := tags[env]
+		want, err := t.tagResolver().Resolve(ctx, repo, source)
+		if err != nil {
+			return nil, fmt.Errorf("resolving %s:%s: %v", repo, source, err)
+		}
+
+		live, err := t.tagResolver().Resolve(ctx, repo, env)
+		if err != nil && err != ErrTagNotFound && statusCode(err) != 404 {
+			return nil, err
+		}
+		if err == nil && live == want {
+			continue
+		}
+
+		c := &Change{Action: ActionCreate, Resource: ResourceEnvironmentTag, Repo: repo, Name: env, To: source + " (" + want + ")"}
+		if err == nil {
+			c.Action, c.From = ActionUpdate, live
+		}
+		c.apply = func(ctx context.Context) error {
+			return t.tagger().Tag(ctx, repo, want, env)
+		}
+		plan = append(plan, c)
+	}
+	return plan, nil
+}
+
+// GitHubBranchProtection is an implementation of the BranchProtection
+// interface backed by a github.Client.
+type GitHubBranchProtection struct {
+	Client interface {
+		NewRequest(method, urlStr string, body interface{}) (*http.Request, error)
+		Do(req *http.Request, v interface{}) (*github.Response, error)
+	}
+}
+
+// RequiredContexts implements BranchProtection RequiredContexts. Branches
+// without protection require nothing.
+func (p *GitHubBranchProtection) RequiredContexts(ctx context.Context, repo, branch string) ([]string, error) {
+	req, err := p.Client.NewRequest("GET", requiredStatusChecksPath(repo, branch), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	var checks struct {
+		Contexts []string `json:"contexts"`
+		Checks   []struct {
+			Context string `json:"context"`
+		} `json:"checks"`
+	}
+	_, err = p.Client.Do(req.WithContext(ctx), &checks)
+	if statusCode(err) == 404 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	contexts := checks.Contexts
+	for _, c := range checks.Checks {
+		contexts = append(contexts, c.Context)
+	}
+	return contexts, nil
+}
+
+// AddRequiredContexts implements BranchProtection AddRequiredContexts. The
+// branch must already be protected.
+func (p *GitHubBranchProtection) AddRequiredContexts(ctx context.Context, repo, branch string, contexts []string) error {
+	req, err := p.Client.NewRequest("POST", requiredStatusChecksPath(repo, branch)+"/contexts", contexts)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	_, err = p.Client.Do(req.WithContext(ctx), nil)
+	return err
+}
+
+func requiredStatusChecksPath(repo, branch string) string {
+	return fmt.Sprintf("repos/%s/branches/%s/protection/required_status_checks", repo, url.PathEscape(branch))
+}
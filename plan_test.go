@@ -0,0 +1,103 @@
+package quayd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// branchProtection is a fake BranchProtection.
+type branchProtection map[string][]string
+
+func (p branchProtection) RequiredContexts(ctx context.Context, repo, branch string) ([]string, error) {
+	return p[repo+"@"+branch], nil
+}
+
+func (p branchProtection) AddRequiredContexts(ctx context.Context, repo, branch string, contexts []string) error {
+	p[repo+"@"+branch] = append(p[repo+"@"+branch], contexts...)
+	return nil
+}
+
+func TestPlan(t *testing.T) {
+	var notifications []*QuayNotification
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/repository/remind101/acme-inc/notification/" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method == "POST" {
+			var n QuayNotification
+			json.NewDecoder(r.Body).Decode(&n)
+			notifications = append(notifications, &n)
+			w.WriteHeader(201)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"notifications": notifications})
+	}))
+	defer api.Close()
+
+	// The build_success notification is already configured.
+	notifications = append(notifications, &QuayNotification{Event: "build_success", Method: "webhook", Config: QuayNotificationConfig{URL: "https://quayd.example.com/quay"}})
+
+	registry := &MemoryRegistry{}
+	registry.Seed("remind101/acme-inc", "v1.2.0", "v1.1.0")
+	registry.Tag(context.Background(), "remind101/acme-inc", "old", "staging")
+	protection := branchProtection{"remind101/acme-inc@master": {"ci"}}
+
+	q := &Quayd{
+		Quay:        &QuayClient{URL: api.URL},
+		TagResolver: registry,
+		Tagger:      registry,
+	}
+	state := &ManagedState{
+		WebhookURL:       "https://quayd.example.com/",
+		Repos:            []string{"remind101/acme-inc"},
+		RequiredBranches: []string{"master"},
+		EnvironmentTags: map[string]map[string]string{
+			"remind101/acme-inc": {"production": "v1.2.0", "staging": "v1.1.0"},
+		},
+	}
+	ctx := context.Background()
+
+	plan, err := q.Plan(ctx, state, protection)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, c := range plan {
+		got = append(got, c.Action+" "+c.Resource+" "+c.Name)
+	}
+	want := []string{
+		"create quay_notification build_cancelled",
+		"create quay_notification build_failure",
+		"create quay_notification build_queued",
+		"create quay_notification build_start",
+		"create github_required_check master",
+		"create environment_tag production",
+		"update environment_tag staging",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Plan => %v; want %v", got, want)
+	}
+	if !strings.Contains(plan.String(), "Plan: 6 to add, 1 to change, 0 to destroy.") {
+		t.Fatalf("Unexpected plan output:\n%s", plan)
+	}
+
+	if err := q.Apply(ctx, plan); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once applied, the live state matches.
+	plan, err = q.Plan(ctx, state, protection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan) != 0 {
+		t.Fatalf("Expected no changes after apply, got:\n%s", plan)
+	}
+}
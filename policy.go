@@ -0,0 +1,75 @@
+package quayd
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Capabilities is a mask of the parts of the pipeline that quayd runs for a
+// repo.
+type Capabilities uint
+
+const (
+	// CapabilityStatuses creates commit statuses for builds.
+	CapabilityStatuses Capabilities = 1 << iota
+
+	// CapabilityTags tags successfully built images with the git sha.
+	CapabilityTags
+
+	// CapabilityPromote notifies tag hooks after images are tagged, so that
+	// downstream systems can promote them.
+	CapabilityPromote
+
+	// CapabilityFull runs the full pipeline.
+	CapabilityFull = CapabilityStatuses | CapabilityTags | CapabilityPromote
+)
+
+var capabilityNames = map[string]Capabilities{
+	"statuses": CapabilityStatuses,
+	"tags":     CapabilityTags,
+	"promote":  CapabilityPromote,
+	"full":     CapabilityFull,
+}
+
+// Has returns true if all of the capabilities in c2 are enabled.
+func (c Capabilities) Has(c2 Capabilities) bool {
+	return c&c2 == c2
+}
+
+// UnmarshalJSON decodes a list of capability names, such as
+// `["statuses", "tags"]`.
+func (c *Capabilities) UnmarshalJSON(raw []byte) error {
+	var names []string
+	if err := json.Unmarshal(raw, &names); err != nil {
+		return err
+	}
+
+	*c = 0
+	for _, name := range names {
+		capability, ok := capabilityNames[name]
+		if !ok {
+			return fmt.Errorf("unknown capability: %s", name)
+		}
+		*c |= capability
+	}
+
+	return nil
+}
+
+// Policies maps a repo, in the form `owner/repo`, to the capabilities that
+// are enabled for it. The "*" key, if present, applies to repos that aren't
+// listed. Repos are granted CapabilityFull when there's no matching policy.
+type Policies map[string]Capabilities
+
+// Capabilities returns the capabilities that are enabled for repo.
+func (p Policies) Capabilities(repo string) Capabilities {
+	if c, ok := p[repo]; ok {
+		return c
+	}
+
+	if c, ok := p["*"]; ok {
+		return c
+	}
+
+	return CapabilityFull
+}
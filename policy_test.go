@@ -0,0 +1,78 @@
+package quayd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPolicies(t *testing.T) {
+	var p Policies
+	if err := json.Unmarshal([]byte(`{"remind101/acme-inc": ["statuses"], "*": ["statuses", "tags"]}`), &p); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		repo string
+		want Capabilities
+	}{
+		{"remind101/acme-inc", CapabilityStatuses},
+		{"remind101/r101-api", CapabilityStatuses | CapabilityTags},
+	}
+
+	for _, tt := range tests {
+		if got := p.Capabilities(tt.repo); got != tt.want {
+			t.Fatalf("Capabilities(%s) => %v; want %v", tt.repo, got, tt.want)
+		}
+	}
+
+	if got := Policies(nil).Capabilities("remind101/acme-inc"); got != CapabilityFull {
+		t.Fatalf("Capabilities => %v; want %v", got, CapabilityFull)
+	}
+}
+
+func TestPolicies_UnknownCapability(t *testing.T) {
+	var p Policies
+	if err := json.Unmarshal([]byte(`{"*": ["deploy"]}`), &p); err == nil {
+		t.Fatal("Expected an error")
+	}
+}
+
+func TestWebhook_TagsOnlyPolicy(t *testing.T) {
+	r := &statusesRepository{}
+	s := NewServer(&Quayd{
+		StatusesRepository: r,
+		Policies:           Policies{"ejholmes/docker-statsd": CapabilityTags},
+	})
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/quay/success", loadFixture("pending_build", t))
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 200; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+
+	if len(r.statuses) != 0 {
+		t.Fatal("Expected 0 commit statuses")
+	}
+}
+
+func TestHandle_TagsOnlyPolicy_TagFailure(t *testing.T) {
+	registry := &MemoryRegistry{}
+	registry.Seed("remind101/acme-inc", "test")
+	q := &Quayd{
+		StatusesRepository: &statusesRepository{},
+		TagResolver:        registry,
+		Tagger:             &flakyTagger{err: errors.New("registry unavailable"), n: 100},
+		Policies:           Policies{"remind101/acme-inc": CapabilityTags},
+	}
+
+	err := q.Handle(context.Background(), &BuildEvent{Repo: "remind101/acme-inc", Ref: "abcd", State: "success", Tags: []string{"test"}})
+	if err == nil {
+		t.Fatal("Expected the tagging failure to be returned, so the event is retried")
+	}
+}
@@ -0,0 +1,258 @@
+package quayd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// PullAccessContext is the commit status context used to report whether the
+// configured namespaces can pull a built image.
+const PullAccessContext = "Pull Access"
+
+// DefaultPullSecret is the default name of the imagePullSecret that is
+// checked in each namespace.
+const DefaultPullSecret = "quay"
+
+// Paths of the service account credentials mounted into pods.
+const (
+	serviceAccountToken = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	serviceAccountCA    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+// KubernetesClient makes authenticated requests to the Kubernetes API.
+type KubernetesClient struct {
+	// URL is the base URL of the API server.
+	URL string
+
+	// Token is a bearer token that can read secrets in the namespaces.
+	Token string
+
+	// Client is the http.Client to use. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// NewInClusterKubernetesClient returns a KubernetesClient authenticated as
+// the pod's service account.
+func NewInClusterKubernetesClient() (*KubernetesClient, error) {
+	token, err := ioutil.ReadFile(serviceAccountToken)
+	if err != nil {
+		return nil, err
+	}
+
+	ca, err := ioutil.ReadFile(serviceAccountCA)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("kubernetes: invalid service account CA")
+	}
+
+	return &KubernetesClient{
+		URL:   "https://kubernetes.default.svc",
+		Token: strings.TrimSpace(string(token)),
+		Client: &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		}},
+	}, nil
+}
+
+// errSecretNotFound is returned when an imagePullSecret doesn't exist.
+var errSecretNotFound = errors.New("secret not found")
+
+// dockerConfig is the format of a kubernetes.io/dockerconfigjson secret.
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth     string `json:"auth"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"auths"`
+}
+
+// RegistryCredentials returns the username and password for registry from
+// the imagePullSecret with the given name.
+func (c *KubernetesClient) RegistryCredentials(ctx context.Context, namespace, name, registry string) (string, string, error) {
+	req, err := http.NewRequest("GET", c.URL+"/api/v1/namespaces/"+namespace+"/secrets/"+name, nil)
+	if err != nil {
+		return "", "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return "", "", errSecretNotFound
+	}
+	if resp.StatusCode >= 300 {
+		return "", "", fmt.Errorf("kubernetes responded with %s", resp.Status)
+	}
+
+	var secret struct {
+		Data map[string]string `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", "", err
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(secret.Data[".dockerconfigjson"])
+	if err != nil {
+		return "", "", err
+	}
+
+	var config dockerConfig
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return "", "", err
+	}
+
+	for host, auth := range config.Auths {
+		host = strings.TrimPrefix(strings.TrimPrefix(host, "https://"), "http://")
+		if strings.TrimSuffix(host, "/") != registry {
+			continue
+		}
+
+		if auth.Auth == "" {
+			return auth.Username, auth.Password, nil
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return "", "", err
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return "", "", errors.New("invalid auth in secret")
+		}
+		return parts[0], parts[1], nil
+	}
+
+	return "", "", fmt.Errorf("secret has no credentials for %s", registry)
+}
+
+// PullAccessError is returned when some namespaces can't pull an image.
+type PullAccessError struct {
+	// Namespaces maps each namespace without access to the reason.
+	Namespaces map[string]string
+}
+
+// Error implements the error interface.
+func (e *PullAccessError) Error() string {
+	var namespaces []string
+	for ns, reason := range e.Namespaces {
+		namespaces = append(namespaces, ns+" ("+reason+")")
+	}
+	sort.Strings(namespaces)
+
+	return "no pull access in " + strings.Join(namespaces, ", ")
+}
+
+// PullAccessCheck verifies that Kubernetes namespaces can pull an image, by
+// checking that their imagePullSecret exists and that its credentials are
+// accepted by the registry. This catches images that would fail with
+// ImagePullBackOff before they're deployed.
+type PullAccessCheck struct {
+	Kubernetes *KubernetesClient
+
+	// Namespaces are the namespaces that the image is deployed to.
+	Namespaces []string
+
+	// Secret is the name of the imagePullSecret in each namespace.
+	// Defaults to DefaultPullSecret.
+	Secret string
+
+	// RegistryURL overrides the base URL used to reach the registry,
+	// which is otherwise https://<registry>.
+	RegistryURL string
+
+	// Client is the http.Client used for the registry. Defaults to
+	// http.DefaultClient.
+	Client *http.Client
+}
+
+// Check returns a *PullAccessError if any of the namespaces can't pull the
+// image.
+func (c *PullAccessCheck) Check(ctx context.Context, image *Image) error {
+	if len(image.Tags) == 0 {
+		return nil
+	}
+
+	secret := c.Secret
+	if secret == "" {
+		secret = DefaultPullSecret
+	}
+
+	failed := make(map[string]string)
+	for _, ns := range c.Namespaces {
+		username, password, err := c.Kubernetes.RegistryCredentials(ctx, ns, secret, image.Registry)
+		if err == errSecretNotFound {
+			failed[ns] = "secret " + secret + " not found"
+			continue
+		}
+		if err != nil {
+			failed[ns] = err.Error()
+			continue
+		}
+
+		if err := c.canPull(ctx, image, username, password); err != nil {
+			failed[ns] = err.Error()
+		}
+	}
+
+	if len(failed) > 0 {
+		return &PullAccessError{Namespaces: failed}
+	}
+
+	return nil
+}
+
+// canPull checks that the credentials can read the image's manifest.
+func (c *PullAccessCheck) canPull(ctx context.Context, image *Image, username, password string) error {
+	base := c.RegistryURL
+	if base == "" {
+		base = "https://" + image.Registry
+	}
+
+	req, err := http.NewRequest("HEAD", base+"/v2/"+image.Repo+"/manifests/"+image.Tags[0], nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.SetBasicAuth(username, password)
+	req.Header.Set("Accept", MediaTypeImage)
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("registry responded with %s", resp.Status)
+	}
+
+	return nil
+}
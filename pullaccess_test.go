@@ -0,0 +1,84 @@
+package quayd
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPullAccessCheck(t *testing.T) {
+	auth := base64.StdEncoding.EncodeToString([]byte("robot:secret"))
+	config := base64.StdEncoding.EncodeToString([]byte(`{"auths":{"https://quay.io":{"auth":"` + auth + `"}}}`))
+
+	k8s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/namespaces/staging/secrets/quay":
+			fmt.Fprintf(w, `{"data":{".dockerconfigjson":%q}}`, config)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer k8s.Close()
+
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "robot" || pass != "secret" {
+			w.WriteHeader(401)
+		}
+	}))
+	defer registry.Close()
+
+	c := &PullAccessCheck{
+		Kubernetes:  &KubernetesClient{URL: k8s.URL},
+		Namespaces:  []string{"staging", "production"},
+		RegistryURL: registry.URL,
+	}
+
+	err := c.Check(context.Background(), &Image{Registry: "quay.io", Repo: "remind101/acme-inc", Tags: []string{"latest"}})
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+
+	e, ok := err.(*PullAccessError)
+	if !ok {
+		t.Fatalf("Expected a *PullAccessError, got %v", err)
+	}
+
+	if _, ok := e.Namespaces["staging"]; ok {
+		t.Fatal("Expected staging to have pull access")
+	}
+
+	if got, want := e.Namespaces["production"], "secret quay not found"; got != want {
+		t.Fatalf("Reason => %s; want %s", got, want)
+	}
+}
+
+func TestQuayd_PullAccess(t *testing.T) {
+	k8s := httptest.NewServer(http.NotFoundHandler())
+	defer k8s.Close()
+
+	r, registry := &statusesRepository{}, &MemoryRegistry{}
+	registry.Seed("remind101/acme-inc", "test")
+	q := &Quayd{
+		StatusesRepository: r,
+		TagResolver:        registry,
+		Tagger:             registry,
+		PullAccess:         &PullAccessCheck{Kubernetes: &KubernetesClient{URL: k8s.URL}, Namespaces: []string{"production"}},
+	}
+
+	if err := q.Handle(context.Background(), &BuildEvent{Repo: "remind101/acme-inc", Ref: "abcd", State: "success", Tags: []string{"test"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(r.statuses), 2; got != want {
+		t.Fatalf("Statuses => %d; want %d", got, want)
+	}
+
+	st := r.statuses[1]
+	if st.Context != PullAccessContext || st.State != "failure" || !strings.Contains(st.Description, "production") {
+		t.Fatalf("Unexpected status: %+v", st)
+	}
+}
@@ -0,0 +1,250 @@
+package quayd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// QuarantineContext is the commit status context used to warn about commits
+// whose image has been quarantined.
+var QuarantineContext = "Docker Image (quarantine)"
+
+// ErrUntagUnsupported is returned when tags need to be removed but the
+// Tagger can't remove them.
+var ErrUntagUnsupported = errors.New("tagger does not support removing tags")
+
+// QuarantinedImage is an image that must not be deployed, e.g. because it's a
+// bad release or has a critical vulnerability.
+type QuarantinedImage struct {
+	Repo   string `json:"repo"`
+	Digest string `json:"digest"`
+	Reason string `json:"reason"`
+
+	// Commits are the shas that the image was built from. Each is annotated
+	// with a warning status.
+	Commits []string `json:"commits"`
+
+	// RemovedTags are the environment tags that were removed from the
+	// image.
+	RemovedTags []string `json:"removed_tags"`
+
+	QuarantinedAt time.Time `json:"quarantined_at"`
+}
+
+// Quarantines keeps track of quarantined images. A nil *Quarantines
+// quarantines nothing.
+type Quarantines struct {
+	// EnvironmentTags are the tags that are removed from a quarantined
+	// image (e.g. "staging", "production").
+	EnvironmentTags []string
+
+	// Path, if set, is the file that quarantined images are saved to, so
+	// that they stay quarantined after a restart. See Load and Save.
+	Path string
+
+	mu     sync.Mutex
+	images map[string]*QuarantinedImage
+}
+
+// Load restores the quarantined images saved to Path. A missing file isn't an
+// error.
+func (q *Quarantines) Load() error {
+	if q == nil || q.Path == "" {
+		return nil
+	}
+
+	raw, err := ioutil.ReadFile(q.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var images []*QuarantinedImage
+	if err := json.Unmarshal(raw, &images); err != nil {
+		return fmt.Errorf("%s: %v", q.Path, err)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.images = make(map[string]*QuarantinedImage)
+	for _, image := range images {
+		q.images[image.Repo+"@"+image.Digest] = image
+	}
+	return nil
+}
+
+// Save writes the quarantined images to Path.
+func (q *Quarantines) Save() error {
+	if q == nil || q.Path == "" {
+		return nil
+	}
+
+	raw, err := json.Marshal(q.List())
+	if err != nil {
+		return err
+	}
+
+	tmp := q.Path + ".tmp"
+	if err := ioutil.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.Path)
+}
+
+// Quarantined returns true if the image with the given digest in repo has
+// been quarantined.
+func (q *Quarantines) Quarantined(repo, digest string) bool {
+	if q == nil {
+		return false
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, ok := q.images[repo+"@"+digest]
+	return ok
+}
+
+// List returns the quarantined images, sorted by repo and digest.
+func (q *Quarantines) List() []*QuarantinedImage {
+	if q == nil {
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var keys []string
+	for k := range q.images {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var images []*QuarantinedImage
+	for _, k := range keys {
+		images = append(images, q.images[k])
+	}
+	return images
+}
+
+func (q *Quarantines) add(image *QuarantinedImage) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.images == nil {
+		q.images = make(map[string]*QuarantinedImage)
+	}
+	q.images[image.Repo+"@"+image.Digest] = image
+}
+
+// Quarantine quarantines the image: environment tags that point at it are
+// removed, future promotions of it are blocked, and the commits it was built
+// from are annotated with a warning status.
+func (q *Quayd) Quarantine(ctx context.Context, image *QuarantinedImage) error {
+	if q.Quarantines == nil {
+		return errors.New("quarantine is not enabled")
+	}
+
+	image.QuarantinedAt = time.Now()
+	image.RemovedTags = nil
+
+	// Block promotions first, so the image can't be promoted while its tags
+	// are being removed.
+	q.Quarantines.add(image)
+	if err := q.Quarantines.Save(); err != nil {
+		return err
+	}
+
+	for _, tag := range q.Quarantines.EnvironmentTags {
+		digest, err := q.tagResolver().Resolve(ctx, image.Repo, tag)
+		if err == ErrTagNotFound || statusCode(err) == 404 {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if digest != image.Digest {
+			continue
+		}
+
+		u, ok := q.tagger().(Untagger)
+		if !ok {
+			return ErrUntagUnsupported
+		}
+		if err := u.Untag(ctx, image.Repo, tag); err != nil {
+			return err
+		}
+		image.RemovedTags = append(image.RemovedTags, tag)
+	}
+
+	githubRepo, err := q.githubRepo(image.Repo)
+	if err != nil {
+		return err
+	}
+
+	for _, sha := range image.Commits {
+		if err := q.statusesRepository().Create(ctx, &Status{
+			Repo:        githubRepo,
+			Ref:         sha,
+			State:       "failure",
+			Context:     QuarantineContext,
+			Description: fmt.Sprintf("The Docker image was quarantined: %s", image.Reason),
+		}); err != nil {
+			return err
+		}
+	}
+
+	q.logger().Log(ctx, "image quarantined", "repo", image.Repo, "digest", image.Digest, "removed_tags", image.RemovedTags)
+
+	return nil
+}
+
+// QuarantineHandler is an http.Handler that quarantines an image on POST, and
+// lists quarantined images on GET.
+type QuarantineHandler struct {
+	*Quayd
+}
+
+func (h *QuarantineHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		images := h.Quarantines.List()
+		if images == nil {
+			images = []*QuarantinedImage{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(images)
+		return
+	}
+
+	var image QuarantinedImage
+	if err := json.NewDecoder(r.Body).Decode(&image); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	if image.Repo == "" || image.Digest == "" {
+		http.Error(w, "repo and digest are required", 400)
+		return
+	}
+
+	if err := h.Quarantine(r.Context(), &image); err != nil {
+		h.errorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(&image)
+}
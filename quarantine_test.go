@@ -0,0 +1,137 @@
+package quayd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestQuarantine(t *testing.T) {
+	registry := &MemoryRegistry{}
+	registry.Seed("remind101/acme-inc", "long-f1fb3b0")
+	digest, _ := registry.Resolve(context.Background(), "remind101/acme-inc", "long-f1fb3b0")
+	registry.Tag(context.Background(), "remind101/acme-inc", digest, "staging")
+
+	r := &statusesRepository{}
+	q := &Quayd{
+		AdminToken:         testAdminToken,
+		StatusesRepository: r,
+		Tagger:             registry,
+		TagResolver:        registry,
+		Quarantines:        &Quarantines{EnvironmentTags: []string{"staging", "production"}},
+	}
+	s := NewServer(q)
+
+	resp := httptest.NewRecorder()
+	req, _ := newAdminRequest("POST", "/admin/quarantine", bytes.NewBufferString(`{"repo":"remind101/acme-inc","digest":"`+digest+`","reason":"CVE-2015-0001","commits":["long-f1fb3b0"]}`))
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 201; got != want {
+		t.Fatalf("Status => %d; want %d: %s", got, want, resp.Body.String())
+	}
+
+	if _, err := registry.Resolve(context.Background(), "remind101/acme-inc", "staging"); err != ErrTagNotFound {
+		t.Fatal("Expected the staging tag to be removed")
+	}
+
+	if len(r.statuses) != 1 || r.statuses[0].Context != QuarantineContext {
+		t.Fatalf("Expected a quarantine status, got %+v", r.statuses)
+	}
+
+	if _, err := q.Promote(context.Background(), "remind101/acme-inc", "f1fb3b0", "staging"); err == nil {
+		t.Fatal("Expected promoting a quarantined image to fail")
+	}
+}
+
+func TestQuarantine_Unauthorized(t *testing.T) {
+	registry := &MemoryRegistry{}
+	registry.Seed("remind101/acme-inc", "long-f1fb3b0")
+	digest, _ := registry.Resolve(context.Background(), "remind101/acme-inc", "long-f1fb3b0")
+	registry.Tag(context.Background(), "remind101/acme-inc", digest, "staging")
+
+	r := &statusesRepository{}
+	s := NewServer(&Quayd{
+		AdminToken:         testAdminToken,
+		StatusesRepository: r,
+		Tagger:             registry,
+		TagResolver:        registry,
+		Quarantines:        &Quarantines{EnvironmentTags: []string{"staging"}},
+	})
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/admin/quarantine", bytes.NewBufferString(`{"repo":"remind101/acme-inc","digest":"`+digest+`","reason":"CVE-2015-0001","commits":["long-f1fb3b0"]}`))
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 401; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+	if staging, _ := registry.Resolve(context.Background(), "remind101/acme-inc", "staging"); staging != digest {
+		t.Fatal("Expected the staging tag to be kept")
+	}
+	if len(r.statuses) != 0 {
+		t.Fatalf("Expected no statuses, got %+v", r.statuses)
+	}
+}
+
+// failingTagResolver is a TagResolver that always fails.
+type failingTagResolver struct {
+	err error
+}
+
+func (r *failingTagResolver) Resolve(ctx context.Context, repo, tag string) (string, error) {
+	return "", r.err
+}
+
+func TestQuarantine_ResolveError(t *testing.T) {
+	errBoom := errors.New("boom")
+	q := &Quayd{
+		StatusesRepository: &statusesRepository{},
+		TagResolver:        &failingTagResolver{err: errBoom},
+		Quarantines:        &Quarantines{EnvironmentTags: []string{"staging"}},
+	}
+
+	if err := q.Quarantine(context.Background(), &QuarantinedImage{Repo: "remind101/acme-inc", Digest: "sha256:abcd"}); err != errBoom {
+		t.Fatalf("err => %v; want %v", err, errBoom)
+	}
+
+	// The image is still quarantined, so it can't be promoted.
+	if !q.Quarantines.Quarantined("remind101/acme-inc", "sha256:abcd") {
+		t.Fatal("Expected the image to be quarantined")
+	}
+}
+
+func TestQuarantines_Save(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quarantines")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "quarantines.json")
+
+	q := &Quayd{
+		StatusesRepository: &statusesRepository{},
+		TagResolver:        &MemoryRegistry{},
+		Quarantines:        &Quarantines{Path: path},
+	}
+	if err := q.Quarantine(context.Background(), &QuarantinedImage{Repo: "remind101/acme-inc", Digest: "sha256:abcd", Reason: "CVE-2015-0001"}); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := &Quarantines{Path: path}
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Quarantined("remind101/acme-inc", "sha256:abcd") {
+		t.Fatalf("Loaded => %+v; want the quarantined image", loaded.List())
+	}
+
+	if err := (&Quarantines{Path: filepath.Join(dir, "missing.json")}).Load(); err != nil {
+		t.Fatalf("Expected a missing file to be ignored, got %v", err)
+	}
+}
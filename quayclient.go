@@ -0,0 +1,271 @@
+package quayd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// DefaultQuayURL is the base URL of the Quay API.
+const DefaultQuayURL = "https://quay.io"
+
+// ErrNoTrigger is returned when a Quay repo doesn't have a GitHub build
+// trigger to start builds with.
+var ErrNoTrigger = errors.New("quay: repository has no github build trigger")
+
+// QuayTrigger is a Quay build trigger.
+type QuayTrigger struct {
+	ID       string `json:"id"`
+	Service  string `json:"service"`
+	IsActive bool   `json:"is_active"`
+}
+
+// QuayBuild is a Quay build.
+type QuayBuild struct {
+	ID    string `json:"id"`
+	Phase string `json:"phase"`
+}
+
+// QuayNotification is a Quay repository notification.
+type QuayNotification struct {
+	UUID   string                 `json:"uuid,omitempty"`
+	Event  string                 `json:"event"`
+	Method string                 `json:"method"`
+	Config QuayNotificationConfig `json:"config"`
+	Title  string                 `json:"title,omitempty"`
+}
+
+// QuayNotificationConfig is the method specific config of a notification.
+type QuayNotificationConfig struct {
+	URL string `json:"url,omitempty"`
+}
+
+// QuayClient is an authenticated client for the Quay API.
+type QuayClient struct {
+	// URL is the base URL of the Quay API. Defaults to DefaultQuayURL.
+	URL string
+
+	// Token is an OAuth token for the Quay API, which needs the
+	// repo:admin scope to start builds.
+	Token string
+
+	// Client is the http.Client to use. Defaults to http.DefaultClient.
+	Client *http.Client
+
+	// triggered remembers the builds that were started through the client,
+	// which Quay reports as manual builds.
+	triggered MemoryDedupeStore
+}
+
+// Do sends an API request, encoding body as JSON if it's not nil, and decodes
+// the JSON response into v if it's not nil.
+func (c *QuayClient) Do(ctx context.Context, method, path string, body, v interface{}) error {
+	var r io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(raw)
+	}
+
+	req, err := http.NewRequest(method, c.url()+"/api/v1/"+strings.TrimPrefix(path, "/"), r)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Triggers returns the build triggers of the Quay repo.
+func (c *QuayClient) Triggers(ctx context.Context, repo string) ([]*QuayTrigger, error) {
+	var resp struct {
+		Triggers []*QuayTrigger `json:"triggers"`
+	}
+	err := c.Do(ctx, "GET", "repository/"+repo+"/trigger/", nil, &resp)
+	return resp.Triggers, err
+}
+
+// Notifications returns the notifications of the Quay repo.
+func (c *QuayClient) Notifications(ctx context.Context, repo string) ([]*QuayNotification, error) {
+	var resp struct {
+		Notifications []*QuayNotification `json:"notifications"`
+	}
+	err := c.Do(ctx, "GET", "repository/"+repo+"/notification/", nil, &resp)
+	return resp.Notifications, err
+}
+
+// CreateNotification adds the notification to the Quay repo.
+func (c *QuayClient) CreateNotification(ctx context.Context, repo string, n *QuayNotification) error {
+	body := map[string]interface{}{
+		"event":       n.Event,
+		"method":      n.Method,
+		"config":      n.Config,
+		"eventConfig": map[string]string{},
+		"title":       n.Title,
+	}
+	return c.Do(ctx, "POST", "repository/"+repo+"/notification/", body, nil)
+}
+
+// StartBuild starts a build of ref (a branch, a `refs/tags/` tag or a commit
+// sha) with the repo's GitHub build trigger.
+func (c *QuayClient) StartBuild(ctx context.Context, repo, ref string) (*QuayBuild, error) {
+	triggers, err := c.Triggers(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	var trigger *QuayTrigger
+	for _, t := range triggers {
+		if t.Service == "github" && t.IsActive {
+			trigger = t
+			break
+		}
+	}
+	if trigger == nil {
+		return nil, ErrNoTrigger
+	}
+
+	var build QuayBuild
+	if err := c.Do(ctx, "POST", "repository/"+repo+"/trigger/"+trigger.ID+"/start", triggerParameters(ref), &build); err != nil {
+		return nil, err
+	}
+
+	c.triggered.Add(build.ID)
+	return &build, nil
+}
+
+// Triggered returns true if the build was started through the client.
+func (c *QuayClient) Triggered(buildID string) bool {
+	if c == nil || buildID == "" {
+		return false
+	}
+
+	seen, _ := c.triggered.Seen(buildID)
+	return seen
+}
+
+// BuildURL returns the URL of the build in the Quay UI.
+func (c *QuayClient) BuildURL(repo, buildID string) string {
+	return c.url() + "/repository/" + repo + "/build/" + buildID
+}
+
+// triggerParameters returns the run parameters that start a build of ref.
+func triggerParameters(ref string) interface{} {
+	if fullSha.MatchString(ref) {
+		return map[string]string{"commit_sha": ref}
+	}
+
+	kind, name := "branch", strings.TrimPrefix(ref, "refs/heads/")
+	if strings.HasPrefix(ref, "refs/tags/") {
+		kind, name = "tag", strings.TrimPrefix(ref, "refs/tags/")
+	}
+	return map[string]interface{}{
+		"refs": map[string]string{"kind": kind, "name": name},
+	}
+}
+
+func (c *QuayClient) url() string {
+	if c.URL == "" {
+		return DefaultQuayURL
+	}
+
+	return c.URL
+}
+
+func (c *QuayClient) client() *http.Client {
+	if c.Client == nil {
+		return http.DefaultClient
+	}
+
+	return c.Client
+}
+
+// TriggerForm is the payload of a request to TriggerHandler.
+type TriggerForm struct {
+	Ref string `json:"ref"`
+}
+
+// TriggerHandler is an http.Handler that starts a Quay build of a ref, so
+// that failed builds can be rerun, e.g. by a bot, without logging into Quay.
+// Since it starts builds with the Quay token, it requires the AdminToken.
+type TriggerHandler struct {
+	*Quayd
+}
+
+func (h *TriggerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p, err := h.readPayload(r, nil)
+	if err != nil {
+		payloadError(w, err)
+		return
+	}
+	defer p.release()
+	body := p.Bytes()
+
+	if err := h.WebhookValidators.Validate("trigger", r, body); err != nil {
+		http.Error(w, err.Error(), 401)
+		return
+	}
+
+	if h.Quay == nil {
+		http.Error(w, "the Quay API is not configured", 404)
+		return
+	}
+
+	var form TriggerForm
+	if err := json.Unmarshal(body, &form); err != nil || form.Ref == "" {
+		http.Error(w, "ref is required", 400)
+		return
+	}
+
+	vars := mux.Vars(r)
+	repo := vars["namespace"] + "/" + vars["name"]
+
+	if h.ReadOnly {
+		h.logger().Log(r.Context(), "build trigger skipped (read-only)", "repo", repo, "ref", form.Ref)
+		w.WriteHeader(204)
+		return
+	}
+
+	build, err := h.Quay.StartBuild(r.Context(), repo, form.Ref)
+	if err == ErrNoTrigger {
+		http.Error(w, err.Error(), 422)
+		return
+	}
+	if err != nil {
+		h.errorResponse(w, r, fmt.Errorf("starting build of %s: %v", repo, err))
+		return
+	}
+	h.logger().Log(r.Context(), "build triggered", "repo", repo, "ref", form.Ref, "build", build.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(build)
+}
@@ -0,0 +1,142 @@
+package quayd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newQuayAPI returns a fake Quay API with a GitHub build trigger for
+// remind101/acme-inc, which starts builds with the given id.
+func newQuayAPI(t *testing.T, buildID string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Header.Get("Authorization"), "Bearer token"; got != want {
+			t.Errorf("Authorization => %q; want %q", got, want)
+		}
+
+		switch r.Method + " " + r.URL.Path {
+		case "GET /api/v1/repository/remind101/acme-inc/trigger/":
+			w.Write([]byte(`{"triggers":[{"id":"bitbucket","service":"bitbucket","is_active":true},{"id":"abc","service":"github","is_active":true}]}`))
+		case "POST /api/v1/repository/remind101/acme-inc/trigger/abc/start":
+			var params map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&params)
+			if params["commit_sha"] == nil && params["refs"] == nil {
+				t.Errorf("Unexpected trigger parameters %v", params)
+			}
+			w.Write([]byte(`{"id":"` + buildID + `","phase":"waiting"}`))
+		case "GET /api/v1/repository/remind101/no-trigger/trigger/":
+			w.Write([]byte(`{"triggers":[]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestTriggerHandler(t *testing.T) {
+	api := newQuayAPI(t, "077f3664-35d3-48e6-9da7-889f9be73070")
+	defer api.Close()
+
+	r := &statusesRepository{}
+	s := NewServer(&Quayd{
+		StatusesRepository: r,
+		Quay:               &QuayClient{URL: api.URL, Token: "token"},
+		AdminToken:         "admin",
+	})
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+		if strings.HasPrefix(path, "/trigger/") {
+			req.Header.Set("Authorization", "Bearer admin")
+		}
+		s.ServeHTTP(resp, req)
+		return resp
+	}
+
+	tests := []struct {
+		path, body string
+		code       int
+	}{
+		{"/trigger/remind101/acme-inc", `{"ref":"master"}`, 201},
+		{"/trigger/remind101/acme-inc", `{"ref":"f1fb3b0c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f80"}`, 201},
+		{"/trigger/remind101/acme-inc", `{}`, 400},
+		{"/trigger/remind101/no-trigger", `{"ref":"master"}`, 422},
+	}
+
+	for _, tt := range tests {
+		if resp := post(tt.path, tt.body); resp.Code != tt.code {
+			t.Fatalf("POST %s %s => %d; want %d: %s", tt.path, tt.body, resp.Code, tt.code, resp.Body.String())
+		}
+	}
+
+	// Quay reports the builds that quayd starts as manual builds, which
+	// should still be processed.
+	raw, _ := ioutil.ReadAll(loadFixture("pending_build", t))
+	manual := strings.Replace(string(raw), `"is_manual": false`, `"is_manual": true`, 1)
+	if resp := post("/quay/pending", manual); resp.Code != 200 {
+		t.Fatalf("Status => %d; want 200", resp.Code)
+	}
+	if len(r.statuses) != 1 {
+		t.Fatalf("Expected 1 commit status, got %d", len(r.statuses))
+	}
+}
+
+func TestTriggerHandler_ReadOnly(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatalf("Unexpected request %s %s", r.Method, r.URL.Path)
+	}))
+	defer api.Close()
+
+	s := NewServer(&Quayd{
+		StatusesRepository: &statusesRepository{},
+		Quay:               &QuayClient{URL: api.URL, Token: "token"},
+		ReadOnly:           true,
+		AdminToken:         "admin",
+	})
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/trigger/remind101/acme-inc", bytes.NewBufferString(`{"ref":"master"}`))
+	req.Header.Set("Authorization", "Bearer admin")
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 204; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+}
+
+func TestTriggerHandler_Unauthorized(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatalf("Unexpected request %s %s", r.Method, r.URL.Path)
+	}))
+	defer api.Close()
+
+	s := NewServer(&Quayd{
+		StatusesRepository: &statusesRepository{},
+		Quay:               &QuayClient{URL: api.URL, Token: "token"},
+		AdminToken:         "admin",
+	})
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/trigger/remind101/acme-inc", bytes.NewBufferString(`{"ref":"master"}`))
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 401; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+}
+
+func TestTriggerHandler_NotConfigured(t *testing.T) {
+	s := NewServer(&Quayd{StatusesRepository: &statusesRepository{}})
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/trigger/remind101/acme-inc", bytes.NewBufferString(`{"ref":"master"}`))
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 404; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+}
@@ -1,16 +1,29 @@
 package quayd
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
-	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
 	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
-	"code.google.com/p/goauth2/oauth"
 	"github.com/ejholmes/go-github/github"
+	"github.com/remind101/quayd/api"
 )
 
 var (
+	// DefaultRegistry is the registry host that images are tagged in.
+	DefaultRegistry = "quay.io"
+
 	// Context is the string that will be displayed when showing the commit
 	// status.
 	Context = "Docker Image"
@@ -30,38 +43,62 @@ var (
 	// Default is the default Quayd to use.
 	Default = &Quayd{}
 
+	// Statuses are the descriptions of the commit statuses of each build
+	// state, unless they're overridden by Quayd.States.
 	Statuses = map[string]string{
 		"pending": "The Docker image is building",
 		"success": "The Docker image was built",
 		"failure": "The Docker image failed to build",
+		"error":   "The Docker image build errored",
 	}
+
+	// CancelledDescription is the description of the status of cancelled
+	// builds.
+	CancelledDescription = "Build was cancelled"
 )
 
+// BuildEvent represents a build notification from Quay.
+type BuildEvent = api.BuildEvent
+
 // Status represents a GitHub Commit Status.
-type Status struct {
-	Repo        string
-	Ref         string
-	State       string
-	Context     string
-	TargetURL   string
-	Description string
-}
+type Status = api.Status
+
+// Image represents a docker image that quayd tagged.
+type Image = api.Image
+
+// Platform is the image of one platform of a multi-arch manifest list.
+type Platform = api.Platform
 
 // StatusesRepository is an interface that can be implemented for creating
 // Commit Statuses.
-type StatusesRepository interface {
-	// Create creates a GitHub Commit Status.
-	Create(*Status) error
-}
+type StatusesRepository = api.StatusesRepository
+
+// CommitResolver is an interface for resolving a short sha to a full 40
+// character sha.
+type CommitResolver = api.CommitResolver
+
+// TagResolver resolves a docker tag to an image id, or to a manifest digest
+// for v2 registries.
+type TagResolver = api.TagResolver
+
+// Tagger is an interface for tagging a docker image with a tag.
+type Tagger = api.Tagger
+
+// Untagger is implemented by Taggers that can also remove tags.
+type Untagger = api.Untagger
 
 // statusesRepository is a fake implementation of the StatusesRepository
 // interface.
 type statusesRepository struct {
+	mu       sync.Mutex
 	statuses []*Status
 }
 
 // Create implements StatusesRepository Create.
-func (r *statusesRepository) Create(status *Status) error {
+func (r *statusesRepository) Create(ctx context.Context, status *Status) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	r.statuses = append(r.statuses, status)
 
 	return nil
@@ -69,6 +106,9 @@ func (r *statusesRepository) Create(status *Status) error {
 
 // Reset resets the collection of Statuses.
 func (r *statusesRepository) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	r.statuses = nil
 }
 
@@ -81,7 +121,12 @@ type GitHubStatusesRepository struct {
 }
 
 // Create implements StatusesRepository Create.
-func (r *GitHubStatusesRepository) Create(status *Status) error {
+func (r *GitHubStatusesRepository) Create(ctx context.Context, status *Status) error {
+	// The github client doesn't support contexts, so the best we can do is
+	// not start requests that have already been canceled.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
 	st := &github.RepoStatus{
 		State:       &status.State,
@@ -102,21 +147,17 @@ func (r *GitHubStatusesRepository) Create(status *Status) error {
 	return err
 }
 
-// CommitResolver is an interface for resolving a short sha to a full 40
-// character sha.
-type CommitResolver interface {
-	// Resolve resolves the short sha to a full 40 character sha.
-	Resolve(repo, short string) (string, error)
-}
-
 // commitResolver returns the short sha prefixed with the string "long".
 type commitResolver struct{}
 
 // Resolve implements CommitResolver Resolve.
-func (cr *commitResolver) Resolve(repo, short string) (string, error) {
+func (cr *commitResolver) Resolve(ctx context.Context, repo, short string) (string, error) {
 	return "long-" + short, nil
 }
 
+// fullSha matches a full 40 character git sha.
+var fullSha = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
 // GitHubCommitResolver is an implementation of CommitResolver backed by a
 // github.Client.
 type GitHubCommitResolver struct {
@@ -126,7 +167,16 @@ type GitHubCommitResolver struct {
 }
 
 // Resolve implements CommitResolver Resolve.
-func (cr *GitHubCommitResolver) Resolve(repo, short string) (string, error) {
+func (cr *GitHubCommitResolver) Resolve(ctx context.Context, repo, short string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	// Full shas don't need to be looked up.
+	if fullSha.MatchString(short) {
+		return short, nil
+	}
+
 	// Split `owner/repo` into ["owner", "repo"].
 	c := strings.Split(repo, "/")
 	cm, _, err := cr.RepositoriesService.GetCommit(
@@ -140,18 +190,12 @@ func (cr *GitHubCommitResolver) Resolve(repo, short string) (string, error) {
 	return *cm.SHA, nil
 }
 
-// Tagger is an interface for tagging a docker image with a tag.
-type Tagger interface {
-	// Tag tags the imageID with the given tag.
-	Tag(repo, imageID, tag string) error
-}
-
 // tagger is a fake implementation of the Tagger interface.
 type tagger struct {
 }
 
 // Tag implements Tagger Tag.
-func (t *tagger) Tag(repo, imageID, tag string) error {
+func (t *tagger) Tag(ctx context.Context, repo, imageID, tag string) error {
 	return nil
 }
 
@@ -161,34 +205,94 @@ type DockerRegistryTagger struct {
 	registry string
 	username string
 	password string
+
+	// Scheme is the URL scheme used to reach the registry. Defaults to
+	// https.
+	Scheme string
+
+	// Client is the http.Client to use. Defaults to http.DefaultClient.
+	Client *http.Client
 }
 
-func (dt *DockerRegistryTagger) Tag(repo, imageID, tag string) error {
+func (dt *DockerRegistryTagger) Tag(ctx context.Context, repo, imageID, tag string) error {
 	req, err := http.NewRequest("PUT",
-		"https://"+dt.registry+"/v1/repositories/"+repo+"/tags/"+tag,
+		registryURL(dt.Scheme, dt.registry)+"/v1/repositories/"+repo+"/tags/"+tag,
 		strings.NewReader(`"`+imageID+`"`))
 	if err != nil {
 		return err
 	}
+	req = req.WithContext(ctx)
 	req.Header.Add("Content-Type", "application/json")
 	req.SetBasicAuth(dt.username, dt.password)
 
-	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode >= 300 {
-		return errors.New("Unsuccessful Request: " + resp.Status)
+	resp, err := dt.client().Do(req)
+	if err != nil {
+		return err
 	}
+	resp.Body.Close()
 
-	return err
+	if resp.StatusCode >= 300 {
+		return &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	return nil
 }
 
-// TagResolver resolves a docker tag to an image id.
-type TagResolver interface {
-	Resolve(repo, tag string) (string, error)
+// Untag implements Untagger Untag.
+func (dt *DockerRegistryTagger) Untag(ctx context.Context, repo, tag string) error {
+	req, err := http.NewRequest("DELETE", registryURL(dt.Scheme, dt.registry)+"/v1/repositories/"+repo+"/tags/"+tag, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.SetBasicAuth(dt.username, dt.password)
+
+	resp, err := dt.client().Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	return nil
+}
+
+func (dt *DockerRegistryTagger) client() *http.Client {
+	if dt.Client == nil {
+		return http.DefaultClient
+	}
+
+	return dt.Client
+}
+
+// registryURL returns the base URL of the registry host.
+func registryURL(scheme, host string) string {
+	if scheme == "" {
+		scheme = "https"
+	}
+
+	return scheme + "://" + host
+}
+
+// HTTPError is returned when a registry responds with an unsuccessful status
+// code.
+type HTTPError struct {
+	StatusCode int
+	Status     string
+}
+
+// Error implements the error interface.
+func (e *HTTPError) Error() string {
+	return "Unsuccessful Request: " + e.Status
 }
 
 // tagResolver is a fake implementation of the TagResolver interface.
 type tagResolver struct{}
 
-func (r *tagResolver) Resolve(repo, tag string) (string, error) {
+func (r *tagResolver) Resolve(ctx context.Context, repo, tag string) (string, error) {
 	return "", nil
 }
 
@@ -196,13 +300,39 @@ func (r *tagResolver) Resolve(repo, tag string) (string, error) {
 // image tag to a docker image id, using the docker api.
 type DockerRegistryTagResolver struct {
 	registry string
+
+	// username and password, if set, authenticate the reads. They can be
+	// read-only credentials, separate from those of the tagger.
+	username string
+	password string
+
+	// Scheme is the URL scheme used to reach the registry. Defaults to
+	// https.
+	Scheme string
+
+	// Client is the http.Client to use. Defaults to http.DefaultClient.
+	Client *http.Client
 }
 
-func (r *DockerRegistryTagResolver) Resolve(repo, tag string) (string, error) {
-	resp, err := http.Get("https://" + r.registry + "/v1/repositories/" + repo + "/tags/" + tag)
+func (r *DockerRegistryTagResolver) Resolve(ctx context.Context, repo, tag string) (string, error) {
+	req, err := http.NewRequest("GET", registryURL(r.Scheme, r.registry)+"/v1/repositories/"+repo+"/tags/"+tag, nil)
+	if err != nil {
+		return "", err
+	}
+	if r.username != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+
+	resp, err := r.client().Do(req.WithContext(ctx))
 	if err != nil {
 		return "", err
 	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return "", &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
 	var imageID string
 	if err := json.NewDecoder(resp.Body).Decode(&imageID); err != nil {
 		return "", err
@@ -210,6 +340,47 @@ func (r *DockerRegistryTagResolver) Resolve(repo, tag string) (string, error) {
 	return imageID, nil
 }
 
+// Tags implements TagLister Tags.
+func (r *DockerRegistryTagResolver) Tags(ctx context.Context, repo string) ([]string, error) {
+	req, err := http.NewRequest("GET", registryURL(r.Scheme, r.registry)+"/v1/repositories/"+repo+"/tags", nil)
+	if err != nil {
+		return nil, err
+	}
+	if r.username != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+
+	resp, err := r.client().Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return nil, &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	var images map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&images); err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	for tag := range images {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+func (r *DockerRegistryTagResolver) client() *http.Client {
+	if r.Client == nil {
+		return http.DefaultClient
+	}
+
+	return r.Client
+}
+
 // Quayd provides a Handle method for adding a GitHub Commit Status and tagging
 // the docker image.
 type Quayd struct {
@@ -217,64 +388,1017 @@ type Quayd struct {
 	CommitResolver
 	Tagger
 	TagResolver
+	TagHook
+	Stats
+
+	// Logger is used to log the steps taken to handle builds. Defaults to
+	// DefaultLogger.
+	Logger Logger
+
+	// BuildURLResolver resolves the TargetURL of commit statuses. Defaults
+	// to DefaultBuildURLResolver.
+	BuildURLResolver BuildURLResolver
+
+	// Routes configures per media type handling of builds.
+	Routes Routes
+
+	// RepoMapper, if set, maps Quay repos to the GitHub repos they're built
+	// from. By default they're assumed to have the same name.
+	RepoMapper RepoMapper
+
+	// Contexts maps a repo, in the form `owner/repo`, to the commit status
+	// context to use for it.
+	Contexts map[string]string
+
+	// Policies restricts which parts of the pipeline run for each repo.
+	Policies Policies
+
+	// WebhookValidators verify the authenticity of incoming webhooks.
+	WebhookValidators WebhookValidators
+
+	// Durations flags builds that took much longer than usual.
+	Durations *DurationMonitor
+
+	// Costs attributes build minutes to teams.
+	Costs *Costs
+
+	// Quarantines, if set, enables quarantining images.
+	Quarantines *Quarantines
+
+	// Quay, if set, is the Quay API client used to start builds.
+	Quay *QuayClient
+
+	// Rebuilds, if set, restarts the builds of pull requests that are
+	// commented with `/rebuild`. It requires Quay.
+	Rebuilds *Rebuilds
+
+	// PlatformTags, if true, also tags the platform specific manifests of
+	// multi-arch images with the git sha and their platform, like
+	// `<sha>-arm64-v8`.
+	PlatformTags bool
+
+	// Pauses, if set, holds the builds of paused repos until they're
+	// resumed with the admin API.
+	Pauses *Pauses
+
+	// RefResolver, if set, resolves builds from custom git remotes to a
+	// repo and ref in the SCM backend. Without it, they're ignored.
+	RefResolver RefResolver
+
+	// Ignored, if set, records the events that were received but
+	// intentionally not processed, and why.
+	Ignored *IgnoredEvents
+
+	// States, if set, overrides how build states are reported, and adds
+	// custom states. See StateMappings.
+	States StateMappings
+
+	// MaxPayloadSize is the largest webhook payload, in bytes, that's
+	// accepted. Defaults to DefaultMaxPayloadSize.
+	MaxPayloadSize int64
+
+	// BasePath, if set, mounts every route under this path, e.g.
+	// `/hooks/quayd`, so quayd can be served behind a reverse proxy that
+	// doesn't strip the prefix.
+	BasePath string
+
+	// QuayPath is the path of the Quay webhook routes (`{QuayPath}/{state}`
+	// and `{QuayPath}/scan`), relative to BasePath. Defaults to
+	// DefaultQuayPath.
+	QuayPath string
+
+	// IPAllowlist, if set, only accepts Quay webhooks from its networks.
+	IPAllowlist *IPAllowlist
+
+	// Directives, if set, adjusts how builds are processed from
+	// directives in the commit message, like `[skip image]`.
+	Directives *Directives
+
+	// CancelledState is the commit status state that cancelled builds are
+	// reported with, either "error" or "failure". Defaults to "error".
+	CancelledState string
+
+	// SecurityScans, if set, reports the vulnerabilities that Quay's
+	// security scanner finds as commit statuses.
+	SecurityScans *SecurityScans
+
+	// Cleanup, if set, removes the image tags of deleted branches.
+	Cleanup *BranchCleanup
+
+	// FailureIssues, if set, files an issue when the default branch keeps
+	// failing.
+	FailureIssues *FailureIssues
+
+	// Metrics collects the metrics exported on /metrics.
+	Metrics *Metrics
+
+	// QueueMonitor, if set, exports the length of Quay's build queues on
+	// /metrics.
+	QueueMonitor *QueueMonitor
+
+	// Dependencies records the outcome of calls to GitHub, Quay, the
+	// registry and the queue, for the /statusz endpoint.
+	Dependencies *Dependencies
+
+	// Timelines records the steps taken to process each delivery.
+	Timelines *Timelines
+
+	// Pipeline is the sequence of stages that tags the image of a
+	// successful build. Defaults to DefaultPipeline.
+	Pipeline Pipeline
+
+	// SupplyChain, if set, aggregates supply chain steps reported to the
+	// server into a summary Check Run.
+	SupplyChain *SupplyChain
+
+	// SlackSigningSecret, if set, enables the Slack interactivity endpoint,
+	// verifying requests with this secret.
+	SlackSigningSecret string
+
+	// Deliveries, if set, records received webhooks and the outcome of
+	// processing them, so that failed deliveries can be replayed.
+	Deliveries DeliveryStore
+
+	// Dedupe, if set, skips deliveries of a build event that has already
+	// been processed successfully, which Quay redelivers when a webhook
+	// times out.
+	Dedupe DedupeStore
+
+	// Queue, if set, is used to handle webhooks asynchronously.
+	Queue *Queue
+
+	// ReadOnly disables every write to GitHub and the registry. Statuses
+	// and tags are computed and logged instead, and registry reads are
+	// still made. Useful for running a shadow instance against production
+	// traffic.
+	ReadOnly bool
+
+	// SLA, if set, tracks how quickly commit statuses are posted.
+	SLA *SLA
+
+	// Canary, if set, compares what an alternate configuration would do
+	// with a fraction of build events.
+	Canary *Canary
+
+	// recorder, if set, records writes instead of making them.
+	recorder *recorder
+
+	// Timeout, if set, limits how long handling a single build event may
+	// take, including all GitHub and registry calls.
+	Timeout time.Duration
+
+	// LatencyBudget, if set, is how long a build event may take from when
+	// its webhook was received. Once it's used up, optional steps, like
+	// notifications and deployments, are skipped so that the commit status
+	// is posted as soon as possible.
+	LatencyBudget time.Duration
+
+	// RateLimit, if set, is the GitHub API quota of the instance, which is
+	// exported as metrics. See WithGitHubRateLimit.
+	RateLimit *RateLimit
+
+	// SemVer, if set, applies version and floating tags to images built
+	// from semver git tags.
+	SemVer *SemVerTags
+
+	// RefFilter, if set, limits the branches and tags that are acted on.
+	RefFilter *RefFilter
+
+	// Environments, if set, records successful builds as deployments to
+	// GitHub environments.
+	Environments *Environments
+
+	// GitOps, if set, opens pull requests that bump the images referenced
+	// in GitOps repos.
+	GitOps *GitOps
+
+	// Approvals, if set, requires promotions to some tags to be approved
+	// in a pull request in a deploys repo.
+	Approvals *Approvals
+
+	// AutoPromotions, if set, automatically promotes images once they've
+	// baked in a tag for long enough.
+	AutoPromotions *AutoPromotions
+
+	// EventRules, if set, decide which actions are taken for each build
+	// event. Events that no rule matches get DefaultEventActions.
+	EventRules EventRules
+
+	// ReleaseAssets, if set, attaches a digest file describing the image
+	// to the GitHub release of builds of git tags.
+	ReleaseAssets *ReleaseAssets
+
+	// Notifiers are notified after each commit status is created.
+	Notifiers []Notifier
+
+	// Attempts, if set, tracks retried builds of each commit.
+	Attempts *Attempts
+
+	// LabelPolicy, if set, fails the commit status of images that are
+	// missing required labels.
+	LabelPolicy *LabelPolicy
+
+	// PullAccess, if set, verifies that Kubernetes namespaces can pull
+	// successfully built images, and reports the result as a separate
+	// commit status.
+	PullAccess *PullAccessCheck
+
+	// Tenants selects the credentials used for each organization. Repos
+	// without a Tenant use the clients above. NewFromConfig also routes
+	// the clients of the optional features to the Tenant.
+	Tenants Tenants
+
+	// Events, if set, receives every processed build event, so that it can
+	// be streamed to subscribers.
+	Events *EventStream
+
+	// Tail, if set, lets operators follow the log lines for a delivery
+	// over the admin socket.
+	Tail *LogTail
+
+	// AdminToken, if set, enables the admin endpoints under /admin, which
+	// clients authenticate to with this token. See AdminAuth.
+	AdminToken string
+
+	// AllCommits controls whether statuses are also created for the other
+	// commits in the push (e.g. both parents of a merge build), not just the
+	// ref that was built.
+	AllCommits bool
 }
 
-// New returns a new Quayd instance backed by GitHub implementations.
-func New(token, registryAuth string) *Quayd {
-	t := &oauth.Transport{
-		Token: &oauth.Token{AccessToken: token},
+// Option configures the HTTP clients of a Quayd instance created by New.
+type Option func(*options)
+
+type options struct {
+	client *http.Client
+
+	registryClient   *http.Client
+	registryScheme   string
+	registryReadAuth string
+	digests          bool
+	rateLimit        *RateLimit
+	githubURL        string
+	retry            *RetryPolicy
+	checks           bool
+	checkHints       bool
+}
+
+// WithHTTPClient makes requests to GitHub and the registry with c, for
+// example to set timeouts. GitHub requests are authenticated by wrapping
+// c's Transport.
+func WithHTTPClient(c *http.Client) Option {
+	return func(o *options) {
+		o.client = c
+	}
+}
+
+// WithTransport makes requests to GitHub and the registry with t, for
+// example to use a proxy or a custom CA bundle.
+func WithTransport(t http.RoundTripper) Option {
+	return func(o *options) {
+		c := *o.client
+		c.Transport = t
+		o.client = &c
+	}
+}
+
+// WithRegistryClient makes requests to the registry with c, instead of the
+// client used for GitHub. Use NewRegistryClient to trust a private CA.
+func WithRegistryClient(c *http.Client) Option {
+	return func(o *options) {
+		o.registryClient = c
+	}
+}
+
+// WithRegistryScheme sets the URL scheme used to reach the registry. Plain
+// http is only useful for test registries.
+func WithRegistryScheme(scheme string) Option {
+	return func(o *options) {
+		o.registryScheme = scheme
+	}
+}
+
+// WithRegistryReadAuth authenticates registry reads, like resolving tags, with
+// a separate `username:password`, so a read-only token can be used for them
+// while only the tagger holds write scope. Reads are anonymous by default.
+func WithRegistryReadAuth(auth string) Option {
+	return func(o *options) {
+		o.registryReadAuth = auth
+	}
+}
+
+// WithDigestTagging resolves tags to manifest digests and tags images by
+// digest with the v2 registry api, instead of by v1 image id. v2-only
+// registries don't have v1 image ids at all.
+func WithDigestTagging() Option {
+	return func(o *options) {
+		o.digests = true
+	}
+}
+
+// WithGitHubRateLimit tracks the GitHub API quota, and holds commit statuses
+// while fewer than reserve requests remain, rather than failing to create
+// them. A reserve of 0 uses DefaultRateLimitReserve. Each instance created
+// with the option tracks its own quota, since tenants can have their own
+// tokens.
+func WithGitHubRateLimit(reserve int) Option {
+	return func(o *options) {
+		o.rateLimit = &RateLimit{Reserve: reserve}
+	}
+}
+
+// WithGitHubURL makes the requests that create commit statuses and resolve
+// commits to the GitHub API at u, like `https://github.example.com/api/v3/`
+// or a fake GitHub in tests, instead of api.github.com.
+func WithGitHubURL(u string) Option {
+	return func(o *options) {
+		o.githubURL = u
+	}
+}
+
+// WithRetryPolicy retries GitHub and registry requests that fail with
+// transient errors with p, instead of DefaultRetryPolicy.
+func WithRetryPolicy(p *RetryPolicy) Option {
+	return func(o *options) {
+		o.retry = p
+	}
+}
+
+// WithChecks creates GitHub Check Runs instead of commit statuses, with the
+// same retries and rate limiting, and enables supply chain reporting to them.
+// If requiredHints is set, each Check Run notes whether branch protection
+// requires it. See GitHubChecksRepository.
+func WithChecks(requiredHints bool) Option {
+	return func(o *options) {
+		o.checks = true
+		o.checkHints = requiredHints
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{client: &http.Client{}}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.registryClient == nil {
+		o.registryClient = o.client
+	}
+	if o.retry == nil {
+		o.retry = DefaultRetryPolicy
 	}
+	return o
+}
 
-	gh := github.NewClient(t.Client())
-	auth := strings.Split(registryAuth, ":")
+// NewRegistryClient returns an http.Client that trusts the CA certificates in
+// the PEM encoded caFile, in addition to the system's.
+func NewRegistryClient(caFile string) (*http.Client, error) {
+	raw, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(raw) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return &http.Client{Transport: t}, nil
+}
+
+// New returns a new Quayd instance backed by GitHub implementations.
+func New(token, registryAuth string, opts ...Option) *Quayd {
+	return newQuayd(token, registryAuth, DefaultRegistry, opts...)
+}
+
+// newQuayd returns a new Quayd instance that tags images in registry.
+func newQuayd(token, registryAuth, registry string, opts ...Option) *Quayd {
+	o := newOptions(opts)
+	client := o.client
+	if o.rateLimit != nil {
+		c := *client
+		c.Transport = o.rateLimit.Transport(c.Transport)
+		client = &c
+	}
+	gh := githubClient(token, client)
+	if o.githubURL != "" {
+		u, err := url.Parse(strings.TrimSuffix(o.githubURL, "/") + "/")
+		if err != nil {
+			log.Printf("github: invalid url %q: %s", o.githubURL, err)
+		} else {
+			gh.BaseURL = u
+		}
+	}
+	auth := append(strings.SplitN(registryAuth, ":", 2), "")
+	read := append(strings.SplitN(o.registryReadAuth, ":", 2), "")
+	var (
+		resolver TagResolver = &DockerRegistryTagResolver{registry: registry,
+			username: read[0],
+			password: read[1],
+			Scheme:   o.registryScheme,
+			Client:   o.registryClient}
+		tagger Tagger = &DockerRegistryTagger{registry: registry,
+			username: auth[0],
+			password: auth[1],
+			Scheme:   o.registryScheme,
+			Client:   o.registryClient}
+	)
+	if o.digests {
+		resolver = &DockerRegistryV2TagResolver{registry: registry,
+			username: read[0],
+			password: read[1],
+			Scheme:   o.registryScheme,
+			Client:   o.registryClient}
+		tagger = &DockerRegistryV2Tagger{registry: registry,
+			username: auth[0],
+			password: auth[1],
+			Scheme:   o.registryScheme,
+			Client:   o.registryClient}
+	}
+	var (
+		statuses    StatusesRepository = &GitHubStatusesRepository{gh.Repositories}
+		supplyChain *SupplyChain
+	)
+	if o.checks {
+		checks := &GitHubChecksRepository{
+			Client:        gh,
+			PullTemplate:  DefaultPullTemplate,
+			RequiredHints: o.checkHints,
+		}
+		statuses = checks
+		supplyChain = &SupplyChain{Checks: checks}
+	}
+	statuses = &RetryStatusesRepository{StatusesRepository: statuses, Policy: o.retry}
+	if o.rateLimit != nil {
+		statuses = &RateLimitedStatusesRepository{StatusesRepository: statuses, RateLimit: o.rateLimit}
+	}
 	return &Quayd{
-		StatusesRepository: &GitHubStatusesRepository{gh.Repositories},
+		StatusesRepository: statuses,
 		CommitResolver:     &GitHubCommitResolver{gh.Repositories},
-		TagResolver:        &DockerRegistryTagResolver{registry: "quay.io"},
-		Tagger: &DockerRegistryTagger{registry: "quay.io",
-			username: auth[0],
-			password: auth[1]},
+		TagResolver:        &RetryTagResolver{TagResolver: resolver, Policy: o.retry},
+		Tagger:             &RetryTagger{Tagger: tagger, Policy: o.retry},
+		RateLimit:          o.rateLimit,
+		SupplyChain:        supplyChain,
 	}
 }
 
+// NewGitHubClient returns a github.Client authenticated with token, which can
+// also be a token source, like `file:/path/to/token`. See ParseTokenSource.
+func NewGitHubClient(token string) *github.Client {
+	return githubClient(token, &http.Client{})
+}
+
+// githubClient returns a github.Client authenticated with token, that
+// otherwise makes requests like c.
+func githubClient(token string, c *http.Client) *github.Client {
+	source, err := ParseTokenSource(token)
+	if err != nil {
+		source = invalidTokenSource{err}
+	}
+
+	authenticated := *c
+	authenticated.Transport = &tokenTransport{
+		Source:    source,
+		Transport: c.Transport,
+	}
+
+	return github.NewClient(&authenticated)
+}
+
 // Handle resolves the ref to a full 40 character sha, then creates a new GitHub
-// Commit Status for that sha.
-func (q *Quayd) Handle(repo, ref, url, state string) error {
-	sha, err := q.commitResolver().Resolve(repo, ref)
+// Commit Status for that sha. If the build succeeded and the Route for the
+// artifact allows it, the image is also tagged with the sha.
+func (q *Quayd) Handle(ctx context.Context, e *BuildEvent) error {
+	if q.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, q.Timeout)
+		defer cancel()
+	}
+
+	if RequestID(ctx) == "" {
+		ctx = WithRequestID(ctx, e.ID)
+	}
+
+	if !q.RefFilter.Allows(e) {
+		q.logger().Log(ctx, "build skipped by ref filter", "repo", e.Repo, "ref", refName(e))
+		q.ignore(ctx, e, IgnoredRefFilter)
+		return nil
+	}
+
+	if q.Pauses.Hold(e) {
+		q.logger().Log(ctx, "build held (repo paused)", "repo", e.Repo, "ref", e.Ref, "state", e.State)
+		return nil
+	}
+
+	if e.GitURL != "" && q.RefResolver == nil {
+		q.logger().Log(ctx, "build skipped (no ref resolver for custom git)", "repo", e.Repo, "git_url", e.GitURL)
+		q.ignore(ctx, e, IgnoredNotGitHub)
+		return nil
+	}
+
+	if q.duplicate(ctx, e) {
+		q.logger().Log(ctx, "duplicate delivery skipped", "repo", e.Repo, "build", e.BuildID, "state", e.State)
+		q.ignore(ctx, e, IgnoredDuplicate)
+		return nil
+	}
+
+	q.Metrics.WebhookReceived(e.State, e.Repo)
+	q.logger().Log(ctx, "handling build", "repo", e.Repo, "ref", e.Ref, "state", e.State)
+
+	start := time.Now()
+	err := q.forTenant(e.Repo).handle(ctx, e)
+	q.Metrics.Processed(time.Since(start))
+	q.Metrics.EventProcessed(e.Repo, err)
+	if err != nil {
+		class := ClassifyError(err)
+		q.Metrics.Failure(e.Repo, class)
+		q.logger().Log(ctx, "handling build failed", "repo", e.Repo, "ref", e.Ref, "class", class, "error", err)
+	}
+	q.bakeFailed(ctx, e)
+	// Don't file issues from a read-only instance.
+	if !q.ReadOnly {
+		if err := q.observeFailures(ctx, e); err != nil {
+			q.logger().Log(ctx, "filing failure issue failed", "repo", e.Repo, "error", err)
+		}
+	}
+	if q.SLA != nil && !e.ReceivedAt.IsZero() {
+		breached := q.SLA.Observe(e.Repo, time.Since(e.ReceivedAt), err)
+		q.Metrics.SLA(e.Repo, breached)
+	}
+	q.processed(ctx, e.ID, err)
+	q.deduped(ctx, e, err)
+	q.Events.Publish(e)
+	q.Canary.Observe(ctx, q, e)
+	return err
+}
+
+func (q *Quayd) handle(ctx context.Context, e *BuildEvent) error {
+	if !e.CompletedAt.IsZero() {
+		// Measure up to when the webhook was received, so time spent
+		// waiting in the queue with -async isn't counted against Quay.
+		received := e.ReceivedAt
+		if received.IsZero() {
+			received = time.Now()
+		}
+		q.stats().DeliveryLag(e.Repo, received.Sub(e.CompletedAt))
+		q.Metrics.DeliveryLag(e.Repo, received.Sub(e.CompletedAt))
+	}
+	q.Costs.Record(e)
+	if attempt := q.Attempts.Record(e); attempt > 0 {
+		e.Attempt = attempt
+	}
+
+	mapping, err := q.mapState(e.State)
+	if err != nil {
+		return err
+	}
+	description := mapping.Description
+	if e.Cancelled {
+		description = CancelledDescription
+	}
+	if class := ClassifyBuild(e); class != FailureUnknown {
+		description += " (" + FailureDescriptions[class] + ")"
+		q.Metrics.Failure(e.Repo, class)
+	}
+	if warning := q.Durations.Observe(e); warning != "" {
+		description += " (" + warning + ")"
+	}
+	if e.Attempt > 1 {
+		description += fmt.Sprintf(" (attempt %d)", e.Attempt)
+	}
+
+	route := q.Routes.Route(e.MediaType)
+	capabilities := route.capabilities(q.Policies.Capabilities(e.Repo))
+	rule := q.EventRules.Actions(e)
+	if rule.Skip {
+		q.logger().Log(ctx, "build skipped by rule", "repo", e.Repo, "ref", e.Ref)
+		q.ignore(ctx, e, IgnoredRule)
+		return nil
+	}
+
+	githubRepo, err := q.resolveRef(ctx, e)
+	if err != nil {
+		return err
+	}
+
+	targetURL, err := q.buildURLResolver().Resolve(ctx, e)
 	if err != nil {
 		return err
 	}
 
-	return q.statusesRepository().Create(&Status{
-		Repo:        repo,
-		TargetURL:   url,
-		Ref:         sha,
-		State:       state,
-		Description: Statuses[state],
-		Context:     Context,
-	})
+	budget := q.budget(e)
+
+	// Failing to read the commit message shouldn't fail the build, so
+	// it's processed as if there were no directives.
+	var actions map[string]bool
+	if q.Directives != nil && !budget.skip(ctx, StepDirectives) {
+		actions, err = q.Directives.Actions(ctx, githubRepo, e.Ref)
+		if err != nil {
+			q.logger().Log(ctx, "reading commit directives failed", "repo", githubRepo, "ref", e.Ref, "error", err)
+		}
+	}
+	if actions[DirectiveSkip] {
+		q.logger().Log(ctx, "build skipped by commit directive", "repo", e.Repo, "ref", e.Ref)
+		q.ignore(ctx, e, IgnoredDirective)
+		return nil
+	}
+
+	var (
+		image  *Image
+		tagErr error
+	)
+	if e.State == "success" && e.Registry != "" && e.Registry != DefaultRegistry {
+		image = &Image{Registry: e.Registry, Repo: e.Repo, Tags: e.Tags}
+	} else if e.State == "success" && route.Tag && rule.Tag && capabilities.Has(CapabilityTags) && len(e.Tags) > 0 && !actions[DirectiveNoTags] {
+		start := time.Now()
+		image, err = q.LoadImageTags(ctx, e.Tags[0], e.Repo, e.Ref)
+		q.Timelines.Record(e.ID, "tagged", start, err)
+		q.Metrics.RegistryTag(e.Repo, err)
+		q.Dependencies.Observe(DependencyRegistry, err)
+		if err != nil {
+			tagErr = err
+		} else {
+			q.logger().Log(ctx, "image tagged", "repo", e.Repo, "image", image.ID, "tags", image.Tags)
+		}
+
+		if v, ok := ParseSemVer(e.GitTag); ok && q.SemVer != nil && tagErr == nil {
+			apply := q.SemVer.Apply
+			if actions[DirectiveNoFloating] {
+				apply = q.SemVer.ApplyExact
+			}
+			tags, err := apply(ctx, q.tagResolver(), q.tagger(), e.Repo, image.ID, v)
+			image.Tags = append(image.Tags, tags...)
+			if err != nil {
+				tagErr = err
+			} else {
+				q.logger().Log(ctx, "release tagged", "repo", e.Repo, "version", v.String(), "tags", tags)
+			}
+		}
+	}
+
+	state, cancelled := mapping.State, e.Cancelled
+	if cancelled {
+		state = q.cancelledState()
+	}
+
+	// When quayd itself fails to tag the image, the build is reported as
+	// an error rather than a success, so it isn't mistaken for a good
+	// image, or for a failed build. The error is still returned, so the
+	// event is retried.
+	if tagErr != nil {
+		q.logger().Log(ctx, "tagging failed", "repo", e.Repo, "ref", e.Ref, "error", tagErr)
+		state, description, image = "error", ErrorDescription(tagErr), nil
+		cancelled = false
+	}
+	if image != nil && q.LabelPolicy != nil {
+		start := time.Now()
+		err := q.LabelPolicy.Check(ctx, image)
+		q.Timelines.Record(e.ID, "labels-checked", start, err)
+		if missing, ok := err.(*MissingLabelsError); ok {
+			state, description = "failure", missing.Error()
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if state == "success" && image != nil && rule.Tag {
+		q.promote(ctx, e, image, rule.Promote)
+	}
+
+	// Without statuses, a tagging failure is only reported by the error,
+	// so the event is still retried.
+	if !capabilities.Has(CapabilityStatuses) || !rule.Status {
+		if tagErr != nil {
+			return tagErr
+		}
+		reason := IgnoredPolicy
+		if capabilities.Has(CapabilityStatuses) {
+			reason = IgnoredRule
+		}
+		q.ignore(ctx, e, reason)
+		return nil
+	}
+
+	// Report whether the image can be pulled where it's deployed, before
+	// it's reported as ready.
+	var pullAccess *Status
+	if image != nil && q.PullAccess != nil && !budget.skip(ctx, StepPullAccess) {
+		pullAccess = &Status{Repo: githubRepo, TargetURL: targetURL, State: "success", Context: PullAccessContext, Description: "The image can be pulled from every namespace"}
+		start := time.Now()
+		err := q.PullAccess.Check(ctx, image)
+		q.Timelines.Record(e.ID, "pull-access-checked", start, err)
+		if err != nil {
+			pullAccess.State, pullAccess.Description = "failure", err.Error()
+		}
+	}
+
+	refs := []string{e.Ref}
+	if q.AllCommits {
+		refs = append(refs, e.Commits...)
+	}
+
+	seen := make(map[string]bool)
+	for _, ref := range refs {
+		start := time.Now()
+		sha, err := q.commitResolver().Resolve(ctx, githubRepo, ref)
+		q.Timelines.Record(e.ID, "resolved", start, err)
+		q.Dependencies.Observe(DependencyGitHub, err)
+		if err != nil {
+			q.Metrics.GitHubError()
+			return err
+		}
+
+		if seen[sha] {
+			continue
+		}
+		seen[sha] = true
+
+		status := &Status{
+			Repo:        githubRepo,
+			TargetURL:   targetURL,
+			Ref:         sha,
+			State:       state,
+			Description: description,
+			Context:     q.context(e, route),
+			Image:       image,
+			Attempt:     e.Attempt,
+			Branch:      e.Branch,
+			Cancelled:   cancelled,
+		}
+
+		start = time.Now()
+		err = q.statusesRepository().Create(ctx, status)
+		q.Timelines.Record(e.ID, "status-created", start, err)
+		q.Dependencies.Observe(DependencyGitHub, err)
+		if err != nil {
+			q.Metrics.GitHubError()
+			return err
+		}
+		q.logger().Log(ctx, "status created", "repo", githubRepo, "sha", sha, "state", state)
+		if ref == e.Ref {
+			notifiers := route.notifiers(q.Notifiers)
+			if rule.Notify && (len(notifiers) == 0 || !budget.skip(ctx, StepNotify)) {
+				q.notify(ctx, e, status, notifiers)
+			}
+			if state == "success" && image != nil {
+				if rule.Deploy && (q.Environments == nil || !budget.skip(ctx, StepDeploy)) {
+					q.deploy(ctx, e, githubRepo, sha, targetURL)
+				}
+				if rule.GitOps && (q.GitOps == nil || !budget.skip(ctx, StepGitOps)) {
+					q.gitops(ctx, e, image, sha, targetURL)
+				}
+				if rule.Release && (q.ReleaseAssets == nil || e.GitTag == "" || !budget.skip(ctx, StepReleaseAsset)) {
+					q.releaseAsset(ctx, e, image, githubRepo, sha, targetURL)
+				}
+			}
+		}
+
+		if pullAccess != nil {
+			st := *pullAccess
+			st.Ref = sha
+			err := q.statusesRepository().Create(ctx, &st)
+			q.Dependencies.Observe(DependencyGitHub, err)
+			if err != nil {
+				q.Metrics.GitHubError()
+				return err
+			}
+		}
+	}
+
+	return tagErr
+}
+
+// notify sends the status to each of notifiers. Failed notifications are
+// logged, rather than failing the build event.
+func (q *Quayd) notify(ctx context.Context, e *BuildEvent, status *Status, notifiers []Notifier) {
+	if len(notifiers) > 0 && q.ReadOnly {
+		q.logger().Log(ctx, "notification skipped (read-only)", "repo", status.Repo, "sha", status.Ref)
+		return
+	}
+
+	if len(notifiers) == 0 {
+		return
+	}
+
+	start := time.Now()
+	var errs MultiError
+	for _, n := range notifiers {
+		if err := n.Notify(ctx, e, status); err != nil {
+			q.logger().Log(ctx, "notification failed", "repo", status.Repo, "sha", status.Ref, "error", err)
+			errs = append(errs, err)
+		}
+	}
+
+	var err error
+	if len(errs) > 0 {
+		err = errs
+	}
+	q.Timelines.Record(e.ID, "notified", start, err)
+}
+
+// deploy records the image as deployed to the GitHub environments that the
+// build's ref maps to. Failures are logged, rather than failing the build
+// event.
+func (q *Quayd) deploy(ctx context.Context, e *BuildEvent, repo, sha, logURL string) {
+	if q.Environments == nil {
+		return
+	}
+
+	if q.ReadOnly {
+		q.logger().Log(ctx, "deployment skipped (read-only)", "repo", repo, "sha", sha)
+		return
+	}
+
+	environments, err := q.Environments.Deploy(ctx, e, repo, sha, logURL)
+	if err != nil {
+		q.logger().Log(ctx, "deployment failed", "repo", repo, "sha", sha, "error", err)
+	}
+	if len(environments) > 0 {
+		q.logger().Log(ctx, "deployments created", "repo", repo, "sha", sha, "environments", environments)
+	}
 }
 
 // LoadImageTags locates a build from its repo and tag and adds
 // tags for the Image ID as well as the Git SHA since the docker
 // registry does not currently support puling a docker image by its
-// immutable identifier, only by a tag
-func (q *Quayd) LoadImageTags(tag, repo, ref string) error {
-	sha, err := q.commitResolver().Resolve(repo, ref)
+// immutable identifier, only by a tag. The work is done by the stages of the
+// Pipeline.
+func (q *Quayd) LoadImageTags(ctx context.Context, tag, repo, ref string) (*Image, error) {
+	job := &TagJob{Repo: repo, Tag: tag, Ref: ref}
+	if err := q.pipeline().Run(ctx, q, job); err != nil {
+		return nil, err
+	}
+
+	return job.Image, nil
+}
+
+// received records a newly received webhook in the delivery store.
+func (q *Quayd) received(ctx context.Context, d *Delivery) {
+	if q.Deliveries == nil {
+		return
+	}
+
+	// The payload is usually in a pooled buffer, which is reused once the
+	// request has been handled.
+	d.Payload = append([]byte(nil), d.Payload...)
+	if err := q.Deliveries.Save(d); err != nil {
+		q.logger().Log(ctx, "saving delivery failed", "error", err)
+	}
+}
+
+// processed records the outcome of processing a delivery in the delivery
+// store.
+func (q *Quayd) processed(ctx context.Context, id string, err error) {
+	if q.Deliveries == nil || id == "" {
+		return
+	}
+
+	d, findErr := q.Deliveries.Find(id)
+	if findErr != nil {
+		q.logger().Log(ctx, "finding delivery failed", "error", findErr)
+		return
+	}
+
+	d.ProcessedAt = time.Now()
+	d.Error = ""
+	d.Stages = nil
 	if err != nil {
-		return err
+		d.Error = err.Error()
+	}
+	if perr, ok := err.(*PipelineError); ok {
+		d.Stages = perr.Results
+	}
+
+	if err := q.Deliveries.Save(d); err != nil {
+		q.logger().Log(ctx, "saving delivery failed", "error", err)
+	}
+}
+
+// Promote tags the image that was built for ref with tag (e.g. "staging"),
+// so that it can be deployed to the matching environment.
+func (q *Quayd) Promote(ctx context.Context, repo, ref, tag string) (*Image, error) {
+	q = q.forTenant(repo)
+	if !q.Policies.Capabilities(repo).Has(CapabilityPromote) {
+		return nil, fmt.Errorf("promotion is disabled for %s", repo)
 	}
-	// Something that resolves the `tag` into an image id.
-	imageID, err := q.tagResolver().Resolve(repo, tag)
+
+	sha, err := q.resolveCommit(ctx, repo, ref)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// Successful builds are tagged with the git sha.
+	imageID, err := q.tagResolver().Resolve(ctx, repo, sha)
+	if err != nil {
+		return nil, err
+	}
+
+	if q.Quarantines.Quarantined(repo, imageID) {
+		return nil, fmt.Errorf("%s@%s is quarantined", repo, imageID)
+	}
+
+	// Tags that require approval are applied once the pull request
+	// opened for the promotion is approved.
+	if q.Approvals.Required(tag) {
+		p := &Promotion{Repo: repo, Ref: ref, Sha: sha, ImageID: imageID, Tag: tag, RequestedAt: time.Now()}
+		if err := q.requestApproval(ctx, p); err != nil {
+			return nil, err
+		}
+		return nil, &PromotionPendingError{Promotion: p}
+	}
+
+	if err := q.tagger().Tag(ctx, repo, imageID, tag); err != nil {
+		return nil, err
+	}
+	q.bake(ctx, repo, sha, imageID, tag)
+
+	return &Image{
+		Registry: DefaultRegistry,
+		Repo:     repo,
+		ID:       imageID,
+		Tags:     []string{sha, tag},
+	}, nil
+}
+
+// context returns the commit status context for the build event. The
+// context from the webhook takes precedence, then the repo's context, then
+// the route's.
+func (q *Quayd) context(e *BuildEvent, route *Route) string {
+	if e.Context != "" {
+		return e.Context
+	}
+
+	if c, ok := q.Contexts[e.Repo]; ok {
+		return c
 	}
 
-	if err := q.tagger().Tag(repo, imageID, sha); err != nil {
+	return route.context()
+}
+
+// observeFailures records the outcome of the build with FailureIssues, which
+// files issues in the GitHub repo.
+func (q *Quayd) observeFailures(ctx context.Context, e *BuildEvent) error {
+	githubRepo, err := q.githubRepo(e.Repo)
+	if err != nil {
 		return err
 	}
-	return q.tagger().Tag(repo, imageID, imageID)
 
+	ge := *e
+	ge.Repo = githubRepo
+	return q.FailureIssues.Observe(ctx, &ge)
+}
+
+// githubRepo returns the GitHub repo that the Quay repo is built from.
+func (q *Quayd) githubRepo(repo string) (string, error) {
+	if q.RepoMapper == nil {
+		return repo, nil
+	}
+
+	return q.RepoMapper.Map(repo)
+}
+
+// resolveCommit resolves ref to a full sha in the GitHub repo that the Quay
+// repo is built from.
+func (q *Quayd) resolveCommit(ctx context.Context, repo, ref string) (string, error) {
+	githubRepo, err := q.githubRepo(repo)
+	if err != nil {
+		return "", err
+	}
+
+	return q.commitResolver().Resolve(ctx, githubRepo, ref)
+}
+
+func (q *Quayd) buildURLResolver() BuildURLResolver {
+	if q.BuildURLResolver == nil {
+		return DefaultBuildURLResolver
+	}
+
+	return q.BuildURLResolver
+}
+
+func (q *Quayd) cancelledState() string {
+	if q.CancelledState == "" {
+		return "error"
+	}
+
+	return q.CancelledState
+}
+
+func (q *Quayd) logger() Logger {
+	l := q.Logger
+	if l == nil {
+		l = DefaultLogger
+	}
+
+	if q.Tail != nil {
+		return &tailLogger{Logger: l, tail: q.Tail}
+	}
+
+	return l
 }
 
 func (q *Quayd) commitResolver() CommitResolver {
@@ -286,6 +1410,14 @@ func (q *Quayd) commitResolver() CommitResolver {
 }
 
 func (q *Quayd) statusesRepository() StatusesRepository {
+	if q.recorder != nil {
+		return q.recorder
+	}
+
+	if q.ReadOnly {
+		return &ReadOnlyStatusesRepository{Logger: q.logger()}
+	}
+
 	if q.StatusesRepository == nil {
 		return DefaultStatusesRepository
 	}
@@ -294,6 +1426,14 @@ func (q *Quayd) statusesRepository() StatusesRepository {
 }
 
 func (q *Quayd) tagger() Tagger {
+	if q.recorder != nil {
+		return q.recorder
+	}
+
+	if q.ReadOnly {
+		return &ReadOnlyTagger{Logger: q.logger()}
+	}
+
 	if q.Tagger == nil {
 		q.Tagger = DefaultTagger
 	}
@@ -308,3 +1448,23 @@ func (q *Quayd) tagResolver() TagResolver {
 
 	return q.TagResolver
 }
+
+func (q *Quayd) stats() Stats {
+	if q.Stats == nil {
+		return DefaultStats
+	}
+
+	return q.Stats
+}
+
+func (q *Quayd) tagHook() TagHook {
+	if q.recorder != nil {
+		return q.recorder
+	}
+
+	if q.ReadOnly || q.TagHook == nil {
+		return DefaultTagHook
+	}
+
+	return q.TagHook
+}
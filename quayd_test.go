@@ -1,26 +1,38 @@
 package quayd
 
 import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/ejholmes/go-github/github"
+	"github.com/remind101/quayd/vcr"
 )
 
-// TODO Make this work.
-func testGitHubStatusesRepository(t *testing.T) {
+// newGitHubClient returns a github.Client that replays the given fixture.
+func newGitHubClient(fixture string) *github.Client {
+	return github.NewClient(&http.Client{
+		Transport: &vcr.Transport{Path: "test-fixtures/github.com/" + fixture + ".json"},
+	})
+}
+
+func TestGitHubStatusesRepository(t *testing.T) {
 	repo := "ejholmes/docker-statsd"
 
-	g := github.NewClient(nil)
+	g := newGitHubClient("status")
 	r := &GitHubStatusesRepository{RepositoriesService: g.Repositories}
 
 	s := &Status{Repo: repo, Ref: "6607c19", State: "pending", Context: "test"}
 
-	if err := r.Create(s); err != nil {
+	if err := r.Create(context.Background(), s); err != nil {
 		t.Fatal(err)
 	}
 }
 
-// TODO Don't make network requests.
 func TestGitHubCommitResolver(t *testing.T) {
 	repo := "ejholmes/docker-statsd"
 
@@ -32,10 +44,10 @@ func TestGitHubCommitResolver(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		g := github.NewClient(nil)
+		g := newGitHubClient("commit")
 		r := &GitHubCommitResolver{RepositoriesService: g.Repositories}
 
-		sha, err := r.Resolve(repo, tt.in)
+		sha, err := r.Resolve(context.Background(), repo, tt.in)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -45,3 +57,70 @@ func TestGitHubCommitResolver(t *testing.T) {
 		}
 	}
 }
+
+func TestGitHubCommitResolver_FullSha(t *testing.T) {
+	// A nil RepositoriesService would panic if it were used.
+	r := &GitHubCommitResolver{}
+
+	sha, err := r.Resolve(context.Background(), "ejholmes/docker-statsd", "6607c19d3fd492ec53439f4104b39e4c62ece179")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := sha, "6607c19d3fd492ec53439f4104b39e4c62ece179"; got != want {
+		t.Fatalf("Sha => %s; want %s", got, want)
+	}
+}
+
+// recordingTransport is an http.RoundTripper that records requests, and
+// responds with the body for the request's host, or an empty object.
+type recordingTransport struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   map[string]string
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	t.requests = append(t.requests, req)
+	t.mu.Unlock()
+
+	body, ok := t.bodies[req.URL.Host]
+	if !ok {
+		body = "{}"
+	}
+
+	return &http.Response{
+		StatusCode: 200,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+func TestNew_WithTransport(t *testing.T) {
+	rt := &recordingTransport{bodies: map[string]string{"quay.io": `"abcd"`}}
+	q := New("token", "user:pass", WithHTTPClient(&http.Client{Timeout: time.Second}), WithTransport(rt))
+
+	imageID, err := q.TagResolver.Resolve(context.Background(), "remind101/acme-inc", "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := imageID, "abcd"; got != want {
+		t.Fatalf("Image => %s; want %s", got, want)
+	}
+
+	if err := q.StatusesRepository.Create(context.Background(), &Status{Repo: "remind101/acme-inc", Ref: "abcd", State: "success"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(rt.requests), 2; got != want {
+		t.Fatalf("Requests => %d; want %d", got, want)
+	}
+	if got, want := rt.requests[0].URL.Host, "quay.io"; got != want {
+		t.Fatalf("Host => %s; want %s", got, want)
+	}
+	if got, want := rt.requests[1].Header.Get("Authorization"), "Bearer token"; got != want {
+		t.Fatalf("Authorization => %s; want %s", got, want)
+	}
+}
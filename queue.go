@@ -0,0 +1,258 @@
+package quayd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/remind101/quayd/api"
+)
+
+// Defaults for Queue.
+const (
+	DefaultQueueConcurrency = 4
+	DefaultQueueSize        = 100
+)
+
+var (
+	// ErrQueueFull is returned when an event is pushed onto a full Queue.
+	ErrQueueFull = errors.New("queue is full")
+
+	// ErrQueueClosed is returned when an event is pushed onto a Queue
+	// that has been stopped.
+	ErrQueueClosed = errors.New("queue is closed")
+)
+
+// Handler is an interface for handling build events. *Quayd implements it.
+type Handler = api.Handler
+
+// Queue processes build events asynchronously using a pool of workers, so
+// that webhooks can be acknowledged before Quay gives up and retries them.
+type Queue struct {
+	// Concurrency is the number of workers. Defaults to
+	// DefaultQueueConcurrency.
+	Concurrency int
+
+	// Size is the number of events that can be buffered. Defaults to
+	// DefaultQueueSize.
+	Size int
+
+	once sync.Once
+	jobs chan *job
+	wg   sync.WaitGroup
+
+	// ctx is the context that events are handled with. It's cancelled,
+	// and quit is closed, by Cancel.
+	ctx    context.Context
+	cancel context.CancelFunc
+	quit   chan struct{}
+	halt   sync.Once
+
+	mu     sync.Mutex
+	closed bool
+	// leftover are events that workers took off the queue after it was
+	// cancelled, without handling them.
+	leftover []*BuildEvent
+}
+
+// job is an event, and the Handler that should handle it.
+type job struct {
+	handler Handler
+	event   *BuildEvent
+}
+
+// Start starts the workers.
+func (q *Queue) Start() {
+	q.init()
+
+	for i := 0; i < q.concurrency(); i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+}
+
+// Push enqueues the event to be handled by h. It returns ErrQueueFull,
+// without blocking, if the buffer is full, and ErrQueueClosed once the queue
+// has been stopped.
+func (q *Queue) Push(h Handler, e *BuildEvent) error {
+	q.init()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return ErrQueueClosed
+	}
+
+	select {
+	case q.jobs <- &job{handler: h, event: e}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Len returns the number of events waiting to be handled.
+func (q *Queue) Len() int {
+	q.init()
+	return len(q.jobs)
+}
+
+// Cap returns the number of events that can be buffered.
+func (q *Queue) Cap() int {
+	q.init()
+	return cap(q.jobs)
+}
+
+// Stop stops accepting events and waits for the workers to handle the events
+// that are already queued.
+func (q *Queue) Stop() {
+	q.init()
+
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.jobs)
+	}
+	q.mu.Unlock()
+
+	q.wg.Wait()
+}
+
+// Cancel stops the workers from taking more events off the queue, and
+// cancels the context of the events they're handling, so that a Stop or Spill
+// in progress returns promptly. Events that are still queued are left for
+// Spill.
+func (q *Queue) Cancel() {
+	q.init()
+	q.halt.Do(func() {
+		close(q.quit)
+		q.cancel()
+	})
+}
+
+// Spill cancels the workers, waits for them to return, and writes the events
+// that are still queued to the file at path, one JSON encoded event per line.
+// This allows a deployment without persistent storage to restart without
+// losing events; LoadSpill requeues them. No more events can be pushed once
+// the queue has been spilled.
+func (q *Queue) Spill(path string) error {
+	q.init()
+
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+
+	q.Cancel()
+	q.wg.Wait()
+
+	events := q.leftover
+	for len(q.jobs) > 0 {
+		events = append(events, (<-q.jobs).event)
+	}
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+
+	return f.Close()
+}
+
+// LoadSpill pushes the events in the spill file at path onto the queue, to be
+// handled by h, then removes the file. It returns the number of events that
+// were queued. A missing file is not an error.
+func (q *Queue) LoadSpill(h Handler, path string) (int, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	n := 0
+	dec := json.NewDecoder(f)
+	for {
+		var e BuildEvent
+		err := dec.Decode(&e)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, err
+		}
+
+		if err := q.Push(h, &e); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	return n, os.Remove(path)
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+
+	for {
+		select {
+		case <-q.quit:
+			return
+		case j, ok := <-q.jobs:
+			if !ok {
+				return
+			}
+
+			// Once cancelled, leave the event on the queue for Spill.
+			select {
+			case <-q.quit:
+				q.mu.Lock()
+				q.leftover = append(q.leftover, j.event)
+				q.mu.Unlock()
+				return
+			default:
+			}
+
+			if err := j.handler.Handle(q.ctx, j.event); err != nil {
+				handlerLogger(j.handler).Log(q.ctx, "handling queued event failed", "repo", j.event.Repo, "ref", j.event.Ref, "error", err)
+			}
+		}
+	}
+}
+
+func (q *Queue) init() {
+	q.once.Do(func() {
+		size := q.Size
+		if size == 0 {
+			size = DefaultQueueSize
+		}
+		q.jobs = make(chan *job, size)
+		q.quit = make(chan struct{})
+		q.ctx, q.cancel = context.WithCancel(context.Background())
+	})
+}
+
+func (q *Queue) concurrency() int {
+	if q.Concurrency == 0 {
+		return DefaultQueueConcurrency
+	}
+
+	return q.Concurrency
+}
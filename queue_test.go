@@ -0,0 +1,149 @@
+package quayd
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestQueue(t *testing.T) {
+	r := &statusesRepository{}
+	q := &Queue{Concurrency: 2}
+	q.Start()
+
+	h := &Quayd{StatusesRepository: r}
+	for i := 0; i < 10; i++ {
+		if err := q.Push(h, &BuildEvent{Repo: "ejholmes/docker-statsd", Ref: "f1fb3b0", State: "pending"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	q.Stop()
+
+	if got, want := len(r.statuses), 10; got != want {
+		t.Fatalf("Statuses => %d; want %d", got, want)
+	}
+}
+
+func TestQueue_Full(t *testing.T) {
+	q := &Queue{Size: 1}
+
+	if err := q.Push(&Quayd{}, &BuildEvent{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.Push(&Quayd{}, &BuildEvent{}); err != ErrQueueFull {
+		t.Fatalf("err => %v; want %v", err, ErrQueueFull)
+	}
+
+	if got, want := q.Len(), 1; got != want {
+		t.Fatalf("Len => %d; want %d", got, want)
+	}
+}
+
+func TestQueue_Spill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quayd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "spill.jsonl")
+
+	// Nothing handles the events before the queue is spilled.
+	q := &Queue{}
+	for _, ref := range []string{"a5d2c71", "f1fb3b0"} {
+		if err := q.Push(&Quayd{}, &BuildEvent{Repo: "ejholmes/docker-statsd", Ref: ref, State: "pending"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := q.Spill(path); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &statusesRepository{}
+	restarted := &Queue{}
+	n, err := restarted.LoadSpill(&Quayd{StatusesRepository: r}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := n, 2; got != want {
+		t.Fatalf("Loaded => %d; want %d", got, want)
+	}
+
+	restarted.Start()
+	restarted.Stop()
+
+	if got, want := len(r.statuses), 2; got != want {
+		t.Fatalf("Statuses => %d; want %d", got, want)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("Expected the spill file to be removed")
+	}
+}
+
+func TestQueue_Closed(t *testing.T) {
+	q := &Queue{}
+	q.Start()
+	q.Stop()
+
+	if err := q.Push(&Quayd{}, &BuildEvent{}); err != ErrQueueClosed {
+		t.Fatalf("err => %v; want %v", err, ErrQueueClosed)
+	}
+
+	// Stopping twice is fine.
+	q.Stop()
+}
+
+// blockingHandler blocks until the context it handles an event with is
+// cancelled.
+type blockingHandler struct {
+	started chan struct{}
+}
+
+func (h *blockingHandler) Handle(ctx context.Context, e *BuildEvent) error {
+	h.started <- struct{}{}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestQueue_Cancel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quayd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "spill.jsonl")
+
+	h := &blockingHandler{started: make(chan struct{})}
+	q := &Queue{Concurrency: 1}
+	q.Start()
+	for _, ref := range []string{"a5d2c71", "f1fb3b0"} {
+		if err := q.Push(h, &BuildEvent{Repo: "ejholmes/docker-statsd", Ref: ref, State: "pending"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-h.started
+
+	// Spill cancels the event being handled rather than waiting for it.
+	if err := q.Spill(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.Push(h, &BuildEvent{}); err != ErrQueueClosed {
+		t.Fatalf("err => %v; want %v", err, ErrQueueClosed)
+	}
+
+	restarted := &Queue{}
+	n, err := restarted.LoadSpill(&Quayd{StatusesRepository: &statusesRepository{}}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := n, 1; got != want {
+		t.Fatalf("Loaded => %d; want %d", got, want)
+	}
+}
@@ -0,0 +1,297 @@
+package quayd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultRateLimitReserve is the default number of GitHub API requests that
+// are kept in reserve before status creation is delayed.
+const DefaultRateLimitReserve = 100
+
+// Names of the GitHub rate limit metrics.
+const (
+	MetricGitHubRateRemaining = "quayd_github_rate_limit_remaining"
+	MetricGitHubRateLimit     = "quayd_github_rate_limit"
+)
+
+// RateLimit tracks the GitHub API quota from the X-RateLimit headers of the
+// responses that pass through its Transport. A nil *RateLimit is never
+// exhausted.
+type RateLimit struct {
+	// Reserve is the number of remaining requests at which the quota is
+	// considered exhausted. Defaults to DefaultRateLimitReserve.
+	Reserve int
+
+	mu        sync.Mutex
+	observed  bool
+	limit     int
+	remaining int
+	reset     time.Time
+}
+
+// Transport returns an http.RoundTripper that observes the rate limit
+// headers of the responses of t.
+func (l *RateLimit) Transport(t http.RoundTripper) http.RoundTripper {
+	if t == nil {
+		t = http.DefaultTransport
+	}
+	return &rateLimitTransport{limit: l, transport: t}
+}
+
+// Observe records the quota in the rate limit headers of a response.
+func (l *RateLimit) Observe(h http.Header) {
+	remaining, err := strconv.Atoi(h.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	limit, _ := strconv.Atoi(h.Get("X-RateLimit-Limit"))
+	reset, _ := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.observed = true
+	l.limit, l.remaining = limit, remaining
+	l.reset = time.Unix(reset, 0)
+}
+
+// Exhausted returns true if the remaining quota is at or below Reserve, and
+// hasn't been reset yet.
+func (l *RateLimit) Exhausted() bool {
+	if l == nil {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.observed && l.remaining <= l.reserve() && time.Now().Before(l.reset)
+}
+
+// Limited returns true if err is GitHub refusing a request because the quota
+// is used up.
+func (l *RateLimit) Limited(err error) bool {
+	switch statusCode(err) {
+	case 429:
+		return true
+	case 403:
+		return l.Exhausted()
+	}
+	return false
+}
+
+// Reset returns when the quota resets, or the zero time if it hasn't been
+// observed.
+func (l *RateLimit) Reset() time.Time {
+	if l == nil {
+		return time.Time{}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.reset
+}
+
+// writeMetrics writes the remaining quota in the Prometheus text format.
+func (l *RateLimit) writeMetrics(w io.Writer) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.observed {
+		return
+	}
+	writeFamily(w, MetricGitHubRateRemaining, "gauge", "GitHub API requests remaining in the current rate limit window.", map[string]float64{"": float64(l.remaining)})
+	writeFamily(w, MetricGitHubRateLimit, "gauge", "GitHub API requests allowed per rate limit window.", map[string]float64{"": float64(l.limit)})
+}
+
+func (l *RateLimit) reserve() int {
+	if l.Reserve == 0 {
+		return DefaultRateLimitReserve
+	}
+
+	return l.Reserve
+}
+
+type rateLimitTransport struct {
+	limit     *RateLimit
+	transport http.RoundTripper
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.transport.RoundTrip(req)
+	if resp != nil {
+		t.limit.Observe(resp.Header)
+	}
+	return resp, err
+}
+
+// RateLimitedStatusesRepository is a StatusesRepository that, instead of
+// failing, holds statuses while the GitHub quota is exhausted, and creates
+// them once it resets. Only the latest status for each commit and context
+// is kept, and a held status is dropped when a newer one for its commit and
+// context is created. Flush creates the held statuses right away, e.g. at
+// shutdown.
+type RateLimitedStatusesRepository struct {
+	StatusesRepository
+	RateLimit *RateLimit
+
+	// Logger logs the held statuses that fail to be created. Defaults to
+	// DefaultLogger.
+	Logger Logger
+
+	mu       sync.Mutex
+	pending  map[string]*Status
+	order    []string
+	flushing bool
+}
+
+// Create implements StatusesRepository Create.
+func (r *RateLimitedStatusesRepository) Create(ctx context.Context, status *Status) error {
+	if r.RateLimit.Exhausted() {
+		r.hold(status)
+		return nil
+	}
+
+	err := r.StatusesRepository.Create(ctx, status)
+	if err != nil && r.RateLimit.Limited(err) {
+		r.hold(status)
+		return nil
+	}
+	if err == nil {
+		r.evict(statusKey(status))
+	}
+	return err
+}
+
+// Pending returns the number of statuses waiting for the quota to reset.
+func (r *RateLimitedStatusesRepository) Pending() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.order)
+}
+
+// Flush creates the held statuses without waiting for the quota to reset.
+// It returns the first error, after trying every status.
+func (r *RateLimitedStatusesRepository) Flush(ctx context.Context) error {
+	var first error
+	for {
+		status := r.next()
+		if status == nil {
+			return first
+		}
+		if err := r.StatusesRepository.Create(ctx, status); err != nil && first == nil {
+			first = fmt.Errorf("creating held status for %s@%s: %v", status.Repo, status.Ref, err)
+		}
+	}
+}
+
+// hold adds the status to the pending statuses, and starts creating them
+// when the quota resets.
+func (r *RateLimitedStatusesRepository) hold(status *Status) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.pending == nil {
+		r.pending = make(map[string]*Status)
+	}
+	key := statusKey(status)
+	if _, ok := r.pending[key]; !ok {
+		r.order = append(r.order, key)
+	}
+	r.pending[key] = status
+
+	if !r.flushing {
+		r.flushing = true
+		go r.flush()
+	}
+}
+
+// evict drops the held status for key, which a newer status replaced.
+func (r *RateLimitedStatusesRepository) evict(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.pending[key]; !ok {
+		return
+	}
+	delete(r.pending, key)
+	for i, k := range r.order {
+		if k == key {
+			r.order = append(r.order[:i:i], r.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// next removes and returns the oldest held status, or nil.
+func (r *RateLimitedStatusesRepository) next() *Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.order) == 0 {
+		return nil
+	}
+	key := r.order[0]
+	r.order = r.order[1:]
+	status := r.pending[key]
+	delete(r.pending, key)
+	return status
+}
+
+// flush creates the pending statuses once the quota has reset. Statuses are
+// taken one at a time, so that the ones that newer statuses replace in the
+// meantime are dropped. Statuses that are rate limited again are held until
+// the next reset.
+func (r *RateLimitedStatusesRepository) flush() {
+	for {
+		wait := time.Until(r.RateLimit.Reset())
+		if wait < time.Second {
+			wait = time.Second
+		}
+		time.Sleep(wait)
+
+		r.mu.Lock()
+		n := len(r.order)
+		r.mu.Unlock()
+
+		// Statuses that are held again go to the back, so only take
+		// the ones that were held before this reset.
+		for i := 0; i < n; i++ {
+			status := r.next()
+			if status == nil {
+				break
+			}
+			if err := r.Create(context.Background(), status); err != nil {
+				r.logger().Log(context.Background(), "creating held status failed", "repo", status.Repo, "sha", status.Ref, "error", err)
+			}
+		}
+
+		r.mu.Lock()
+		if len(r.order) == 0 {
+			r.flushing = false
+			r.mu.Unlock()
+			return
+		}
+		r.mu.Unlock()
+	}
+}
+
+func (r *RateLimitedStatusesRepository) logger() Logger {
+	if r.Logger == nil {
+		return DefaultLogger
+	}
+
+	return r.Logger
+}
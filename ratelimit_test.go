@@ -0,0 +1,175 @@
+package quayd
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func rateLimitHeaders(remaining int, reset time.Time) http.Header {
+	h := http.Header{}
+	h.Set("X-RateLimit-Limit", "5000")
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	h.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+	return h
+}
+
+func TestRateLimit_Exhausted(t *testing.T) {
+	l := &RateLimit{Reserve: 10}
+	if l.Exhausted() {
+		t.Fatal("Expected an unobserved quota not to be exhausted")
+	}
+
+	l.Observe(rateLimitHeaders(11, time.Now().Add(time.Hour)))
+	if l.Exhausted() {
+		t.Fatal("Expected the quota not to be exhausted")
+	}
+
+	l.Observe(rateLimitHeaders(10, time.Now().Add(time.Hour)))
+	if !l.Exhausted() {
+		t.Fatal("Expected the quota to be exhausted")
+	}
+
+	l.Observe(rateLimitHeaders(0, time.Now().Add(-time.Second)))
+	if l.Exhausted() {
+		t.Fatal("Expected a quota that has reset not to be exhausted")
+	}
+}
+
+func TestRateLimitedStatusesRepository(t *testing.T) {
+	s := &statusesRepository{}
+	l := &RateLimit{Reserve: 10}
+	r := &RateLimitedStatusesRepository{StatusesRepository: s, RateLimit: l}
+	ctx := context.Background()
+
+	l.Observe(rateLimitHeaders(5, time.Now().Add(time.Second)))
+	for _, state := range []string{"pending", "success"} {
+		if err := r.Create(ctx, &Status{Repo: "remind101/acme-inc", Ref: "abcd", Context: "Docker Image", State: state}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := r.Pending(), 1; got != want {
+		t.Fatalf("Pending => %d; want %d", got, want)
+	}
+
+	created := func() []*Status {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return append([]*Status{}, s.statuses...)
+	}
+
+	// Once the quota resets, only the latest status is created.
+	deadline := time.Now().Add(5 * time.Second)
+	for r.Pending() > 0 || len(created()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the held status to be created")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if statuses := created(); len(statuses) != 1 || statuses[0].State != "success" {
+		t.Fatalf("Unexpected statuses %+v", statuses)
+	}
+}
+
+// limitedStatusesRepository is a StatusesRepository that refuses the first
+// status with a 429.
+type limitedStatusesRepository struct {
+	statusesRepository
+	limited bool
+}
+
+func (r *limitedStatusesRepository) Create(ctx context.Context, status *Status) error {
+	r.mu.Lock()
+	limited := r.limited
+	r.limited = true
+	r.mu.Unlock()
+
+	if !limited {
+		return &HTTPError{StatusCode: 429}
+	}
+	return r.statusesRepository.Create(ctx, status)
+}
+
+func TestRateLimitedStatusesRepository_NoRateLimit(t *testing.T) {
+	s := &limitedStatusesRepository{}
+	r := &RateLimitedStatusesRepository{StatusesRepository: s}
+
+	if err := r.Create(context.Background(), &Status{Repo: "remind101/acme-inc", Ref: "abcd", Context: "Docker Image", State: "success"}); err != nil {
+		t.Fatal(err)
+	}
+
+	created := func() int {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.statuses)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for r.Pending() > 0 || created() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the held status to be created")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMetricsHandler_RateLimit(t *testing.T) {
+	l := &RateLimit{}
+	l.Observe(rateLimitHeaders(4321, time.Now().Add(time.Hour)))
+
+	var buf bytes.Buffer
+	l.writeMetrics(&buf)
+	if !strings.Contains(buf.String(), MetricGitHubRateRemaining+" 4321") {
+		t.Fatalf("Unexpected metrics:\n%s", buf.String())
+	}
+}
+
+func TestRateLimitedStatusesRepository_Superseded(t *testing.T) {
+	s := &statusesRepository{}
+	l := &RateLimit{Reserve: 10}
+	r := &RateLimitedStatusesRepository{StatusesRepository: s, RateLimit: l}
+	ctx := context.Background()
+
+	l.Observe(rateLimitHeaders(5, time.Now().Add(time.Hour)))
+	r.Create(ctx, &Status{Repo: "remind101/acme-inc", Ref: "abcd", Context: "Docker Image", State: "pending"})
+
+	// The quota resets, and a newer status is created right away.
+	l.Observe(rateLimitHeaders(5000, time.Now().Add(time.Hour)))
+	if err := r.Create(ctx, &Status{Repo: "remind101/acme-inc", Ref: "abcd", Context: "Docker Image", State: "success"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := r.Pending(), 0; got != want {
+		t.Fatalf("Pending => %d; want %d", got, want)
+	}
+	if err := r.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.statuses) != 1 || s.statuses[0].State != "success" {
+		t.Fatalf("Unexpected statuses %+v", s.statuses)
+	}
+}
+
+func TestServer_Shutdown_FlushesHeldStatuses(t *testing.T) {
+	s := &statusesRepository{}
+	l := &RateLimit{Reserve: 10}
+	r := &RateLimitedStatusesRepository{StatusesRepository: s, RateLimit: l}
+
+	l.Observe(rateLimitHeaders(5, time.Now().Add(time.Hour)))
+	r.Create(context.Background(), &Status{Repo: "remind101/acme-inc", Ref: "abcd", Context: "Docker Image", State: "success"})
+
+	if err := NewServer(&Quayd{StatusesRepository: r}).Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := r.Pending(), 0; got != want {
+		t.Fatalf("Pending => %d; want %d", got, want)
+	}
+	if len(s.statuses) != 1 {
+		t.Fatalf("Expected the held status to be created at shutdown, got %+v", s.statuses)
+	}
+}
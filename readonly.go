@@ -0,0 +1,33 @@
+package quayd
+
+import "context"
+
+// ReadOnlyStatusesRepository is a StatusesRepository that logs the statuses
+// that would have been created, without creating them.
+type ReadOnlyStatusesRepository struct {
+	Logger Logger
+}
+
+// Create implements StatusesRepository Create.
+func (r *ReadOnlyStatusesRepository) Create(ctx context.Context, status *Status) error {
+	r.Logger.Log(ctx, "status skipped (read-only)", "repo", status.Repo, "sha", status.Ref, "state", status.State, "context", status.Context, "description", status.Description)
+	return nil
+}
+
+// ReadOnlyTagger is a Tagger that logs the tags that would have been applied
+// or removed, without changing the registry.
+type ReadOnlyTagger struct {
+	Logger Logger
+}
+
+// Tag implements Tagger Tag.
+func (t *ReadOnlyTagger) Tag(ctx context.Context, repo, imageID, tag string) error {
+	t.Logger.Log(ctx, "tag skipped (read-only)", "repo", repo, "image", imageID, "tag", tag)
+	return nil
+}
+
+// Untag implements Untagger Untag.
+func (t *ReadOnlyTagger) Untag(ctx context.Context, repo, tag string) error {
+	t.Logger.Log(ctx, "untag skipped (read-only)", "repo", repo, "tag", tag)
+	return nil
+}
@@ -0,0 +1,41 @@
+package quayd
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestQuayd_ReadOnly(t *testing.T) {
+	var buf bytes.Buffer
+	r := &statusesRepository{}
+	registry := &MemoryRegistry{}
+	registry.Seed("remind101/acme-inc", "test")
+
+	q := &Quayd{
+		StatusesRepository: r,
+		Tagger:             registry,
+		TagResolver:        registry,
+		Logger:             &JSONLogger{Writer: &buf},
+		ReadOnly:           true,
+	}
+
+	if err := q.Handle(context.Background(), &BuildEvent{Repo: "remind101/acme-inc", Ref: "f1fb3b0", State: "success", Tags: []string{"test"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.statuses) != 0 {
+		t.Fatal("Expected 0 commit statuses")
+	}
+
+	if _, err := registry.Resolve(context.Background(), "remind101/acme-inc", "long-f1fb3b0"); err != ErrTagNotFound {
+		t.Fatal("Expected the image not to be tagged")
+	}
+
+	for _, want := range []string{"status skipped (read-only)", "tag skipped (read-only)"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("Expected %q to be logged", want)
+		}
+	}
+}
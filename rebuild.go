@@ -0,0 +1,223 @@
+package quayd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ejholmes/go-github/github"
+)
+
+// RebuildCommand is the pull request comment that restarts the Quay builds of
+// the pull request's head commit. It can be followed by a Quay repo to only
+// rebuild that repo, e.g. `/rebuild remind101/acme-inc-worker`.
+const RebuildCommand = "/rebuild"
+
+// DefaultRebuildAssociations are the author associations that are allowed to
+// request rebuilds when Rebuilds.Associations is empty.
+var DefaultRebuildAssociations = []string{"OWNER", "MEMBER", "COLLABORATOR"}
+
+// ErrRebuildNotAllowed is returned when the author of a `/rebuild` comment
+// isn't allowed to request rebuilds.
+var ErrRebuildNotAllowed = errors.New("not allowed to request rebuilds")
+
+// ErrRebuildRepo is returned when a `/rebuild` comment names a Quay repo that
+// isn't built from the pull request's GitHub repo.
+var ErrRebuildRepo = errors.New("the Quay repo isn't built from this GitHub repo")
+
+// PullRequestResolver returns the head commit and branch of a pull request.
+type PullRequestResolver interface {
+	Head(ctx context.Context, repo string, number int) (sha, branch string, err error)
+}
+
+// GitHubPullRequestResolver is a PullRequestResolver backed by a
+// github.Client.
+type GitHubPullRequestResolver struct {
+	Client *github.Client
+}
+
+// Head implements PullRequestResolver Head.
+func (r *GitHubPullRequestResolver) Head(ctx context.Context, repo string, number int) (string, string, error) {
+	// The github client's PullRequest doesn't include the head, so it's
+	// decoded here.
+	req, err := r.Client.NewRequest("GET", fmt.Sprintf("repos/%s/pulls/%d", repo, number), nil)
+	if err != nil {
+		return "", "", err
+	}
+
+	var pr struct {
+		Head struct {
+			SHA string `json:"sha"`
+			Ref string `json:"ref"`
+		} `json:"head"`
+	}
+	if _, err := r.Client.Do(req.WithContext(ctx), &pr); err != nil {
+		return "", "", err
+	}
+	return pr.Head.SHA, pr.Head.Ref, nil
+}
+
+// Rebuilds restarts the Quay builds of a pull request when someone with write
+// access comments `/rebuild` on it, so flaky builds can be retried without
+// access to Quay. It requires the Quay API client.
+type Rebuilds struct {
+	// Repos maps a GitHub repo to the Quay repos built from it. GitHub
+	// repos that aren't in it are rebuilt in the Quay repos that map to
+	// them in the RepoMap, or in the Quay repo with the same name.
+	Repos map[string][]string `json:"repos"`
+
+	// Associations are the GitHub author associations (e.g. "MEMBER")
+	// that are allowed to request rebuilds. Defaults to
+	// DefaultRebuildAssociations.
+	Associations []string `json:"associations"`
+
+	// PullRequests resolves the head commit of the pull request.
+	PullRequests PullRequestResolver `json:"-"`
+}
+
+// ParseRebuild returns whether the comment contains the rebuild command, and
+// the Quay repo that it's limited to, if any.
+func ParseRebuild(comment string) (ok bool, repo string) {
+	for _, line := range strings.Split(comment, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] != RebuildCommand {
+			continue
+		}
+		if len(fields) > 1 {
+			repo = fields[1]
+		}
+		return true, repo
+	}
+	return false, ""
+}
+
+// allowed returns true if authors with the association can request rebuilds.
+func (r *Rebuilds) allowed(association string) bool {
+	associations := r.Associations
+	if len(associations) == 0 {
+		associations = DefaultRebuildAssociations
+	}
+
+	for _, a := range associations {
+		if strings.EqualFold(a, association) {
+			return true
+		}
+	}
+	return false
+}
+
+// Rebuild restarts the Quay builds of the head commit of the pull request,
+// and creates a pending status for each of them. If only is set, just that
+// Quay repo is rebuilt; it must be one of the Quay repos built from
+// githubRepo. In read-only mode, no builds are started.
+func (q *Quayd) Rebuild(ctx context.Context, githubRepo string, number int, only string) ([]*QuayBuild, error) {
+	if q.Rebuilds == nil || q.Quay == nil {
+		return nil, errors.New("rebuilds are not enabled")
+	}
+
+	sha, branch, err := q.Rebuilds.PullRequests.Head(ctx, githubRepo, number)
+	if err != nil {
+		return nil, err
+	}
+
+	repos := q.quayRepos(githubRepo, q.Rebuilds.Repos)
+	if only != "" {
+		var found bool
+		for _, repo := range repos {
+			if repo == only {
+				found = true
+			}
+		}
+		if !found {
+			return nil, ErrRebuildRepo
+		}
+		repos = []string{only}
+	}
+
+	if q.ReadOnly {
+		q.logger().Log(ctx, "rebuild skipped (read-only)", "repo", githubRepo, "sha", sha, "pull", number)
+		return nil, nil
+	}
+
+	var builds []*QuayBuild
+	for _, repo := range repos {
+		build, err := q.Quay.StartBuild(ctx, repo, sha)
+		if err != nil {
+			return builds, fmt.Errorf("starting build of %s: %v", repo, err)
+		}
+		builds = append(builds, build)
+		q.logger().Log(ctx, "build restarted", "repo", repo, "sha", sha, "pull", number, "build", build.ID)
+
+		e := &BuildEvent{
+			ID:      build.ID,
+			BuildID: build.ID,
+			Repo:    repo,
+			Ref:     sha,
+			URL:     q.Quay.BuildURL(repo, build.ID),
+			State:   "pending",
+			Branch:  branch,
+		}
+		if err := q.Handle(ctx, e); err != nil {
+			return builds, err
+		}
+	}
+
+	return builds, nil
+}
+
+// GitHubIssueCommentEvent is the payload of a GitHub `issue_comment` webhook.
+type GitHubIssueCommentEvent struct {
+	Action string `json:"action"`
+	Issue  struct {
+		Number      int              `json:"number"`
+		PullRequest *json.RawMessage `json:"pull_request"`
+	} `json:"issue"`
+	Comment struct {
+		Body              string `json:"body"`
+		AuthorAssociation string `json:"author_association"`
+		User              struct {
+			Login string `json:"login"`
+		} `json:"user"`
+	} `json:"comment"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+// issueComment handles `/rebuild` comments on pull requests.
+func (wh *GitHubWebhook) issueComment(w http.ResponseWriter, r *http.Request, body []byte) {
+	var e GitHubIssueCommentEvent
+	if err := json.Unmarshal(body, &e); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	ok, only := ParseRebuild(e.Comment.Body)
+	if !ok || e.Action != "created" || e.Issue.PullRequest == nil {
+		w.WriteHeader(204)
+		return
+	}
+
+	if !wh.Rebuilds.allowed(e.Comment.AuthorAssociation) {
+		wh.logger().Log(r.Context(), "rebuild not allowed", "repo", e.Repository.FullName, "pull", e.Issue.Number, "user", e.Comment.User.Login)
+		http.Error(w, ErrRebuildNotAllowed.Error(), 403)
+		return
+	}
+
+	builds, err := wh.Rebuild(r.Context(), e.Repository.FullName, e.Issue.Number, only)
+	if err == ErrRebuildRepo {
+		http.Error(w, err.Error(), 403)
+		return
+	}
+	if err != nil {
+		wh.errorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(map[string]interface{}{"builds": builds})
+}
@@ -0,0 +1,102 @@
+package quayd
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// pullRequests is a fake PullRequestResolver.
+type pullRequests map[int]string
+
+func (p pullRequests) Head(ctx context.Context, repo string, number int) (string, string, error) {
+	return p[number], "feature", nil
+}
+
+func TestParseRebuild(t *testing.T) {
+	tests := []struct {
+		comment string
+		ok      bool
+		repo    string
+	}{
+		{"/rebuild", true, ""},
+		{"Looks flaky.\n\n/rebuild remind101/acme-inc-worker", true, "remind101/acme-inc-worker"},
+		{"Don't /rebuild this yet", false, ""},
+		{"/rebuilding", false, ""},
+	}
+
+	for _, tt := range tests {
+		if ok, repo := ParseRebuild(tt.comment); ok != tt.ok || repo != tt.repo {
+			t.Fatalf("ParseRebuild(%q) => %v %q; want %v %q", tt.comment, ok, repo, tt.ok, tt.repo)
+		}
+	}
+}
+
+func TestGitHubWebhook_Rebuild(t *testing.T) {
+	api := newQuayAPI(t, "3e1b8fb4-1b7a-4b51-9a4a-8f6a8c6e7d10")
+	defer api.Close()
+
+	r := &statusesRepository{}
+	s := NewServer(&Quayd{
+		StatusesRepository: r,
+		Quay:               &QuayClient{URL: api.URL, Token: "token"},
+		Rebuilds:           &Rebuilds{PullRequests: pullRequests{42: scannedSha}},
+	})
+
+	comment := func(association, body string, pull bool) *httptest.ResponseRecorder {
+		issue := `{"number":42}`
+		if pull {
+			issue = `{"number":42,"pull_request":{}}`
+		}
+		resp := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/github", bytes.NewBufferString(`{"action":"created","issue":`+issue+`,"comment":{"body":"`+body+`","author_association":"`+association+`","user":{"login":"ejholmes"}},"repository":{"full_name":"remind101/acme-inc"}}`))
+		req.Header.Set("X-GitHub-Event", "issue_comment")
+		s.ServeHTTP(resp, req)
+		return resp
+	}
+
+	tests := []struct {
+		association, body string
+		pull              bool
+		code              int
+	}{
+		{"MEMBER", "LGTM", true, 204},
+		{"MEMBER", "/rebuild", false, 204},
+		{"NONE", "/rebuild", true, 403},
+		{"MEMBER", "/rebuild remind101/other", true, 403},
+		{"MEMBER", "/rebuild", true, 201},
+	}
+
+	for _, tt := range tests {
+		if resp := comment(tt.association, tt.body, tt.pull); resp.Code != tt.code {
+			t.Fatalf("%s %q => %d; want %d: %s", tt.association, tt.body, resp.Code, tt.code, resp.Body.String())
+		}
+	}
+
+	if len(r.statuses) != 1 {
+		t.Fatalf("Expected 1 commit status, got %d", len(r.statuses))
+	}
+	if st := r.statuses[0]; st.State != "pending" || st.Ref != "long-"+scannedSha || st.TargetURL != "https://quay.io/repository/remind101/acme-inc/build/3e1b8fb4-1b7a-4b51-9a4a-8f6a8c6e7d10" {
+		t.Fatalf("Unexpected status %+v", st)
+	}
+}
+
+func TestRebuild_ReadOnly(t *testing.T) {
+	r := &statusesRepository{}
+	q := &Quayd{
+		StatusesRepository: r,
+		Quay:               &QuayClient{URL: "http://127.0.0.1:0", Token: "token"},
+		Rebuilds:           &Rebuilds{PullRequests: pullRequests{42: scannedSha}},
+		ReadOnly:           true,
+	}
+
+	builds, err := q.Rebuild(context.Background(), "remind101/acme-inc", 42, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(builds) != 0 || len(r.statuses) != 0 {
+		t.Fatalf("Expected no builds in read-only mode, got %v", builds)
+	}
+}
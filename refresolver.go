@@ -0,0 +1,82 @@
+package quayd
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// TriggerCustomGit is the trigger kind of Quay builds from a custom git
+// remote, rather than from GitHub.
+const TriggerCustomGit = "custom-git"
+
+// RefResolver maps a build from a custom git remote, of which the payload
+// only has the remote's URL and the commit sha, to the repo and ref in the
+// configured SCM backend (GitHub, GitLab, etc.).
+type RefResolver interface {
+	ResolveRef(ctx context.Context, gitURL, sha string) (repo, ref string, err error)
+}
+
+// RefResolverFunc is a function that implements RefResolver.
+type RefResolverFunc func(ctx context.Context, gitURL, sha string) (string, string, error)
+
+// ResolveRef implements RefResolver ResolveRef.
+func (fn RefResolverFunc) ResolveRef(ctx context.Context, gitURL, sha string) (string, string, error) {
+	return fn(ctx, gitURL, sha)
+}
+
+// GitURLRefResolver is a RefResolver for remotes that mirror the SCM
+// backend's repos, which maps a git URL like `git@git.example.com:org/repo.git`
+// to the repo `org/repo`, and resolves the sha to itself.
+type GitURLRefResolver struct {
+	// Repos maps git URLs to repos, for remotes whose path doesn't match the
+	// repo's name.
+	Repos map[string]string
+}
+
+// ResolveRef implements RefResolver ResolveRef.
+func (r *GitURLRefResolver) ResolveRef(ctx context.Context, gitURL, sha string) (string, string, error) {
+	if repo, ok := r.Repos[gitURL]; ok {
+		return repo, sha, nil
+	}
+
+	repo, err := gitURLRepo(gitURL)
+	if err != nil {
+		return "", "", err
+	}
+	return repo, sha, nil
+}
+
+// gitURLRepo returns the `owner/repo` path of a git URL, which is either a URL
+// (https://, ssh://, git://) or an scp-like address (git@host:owner/repo.git).
+func gitURLRepo(gitURL string) (string, error) {
+	path := gitURL
+	if u, err := url.Parse(gitURL); err == nil && u.Scheme != "" && u.Host != "" {
+		path = u.Path
+	} else if i := strings.Index(gitURL, ":"); i >= 0 {
+		path = gitURL[i+1:]
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimSuffix(path, ".git"), "/"), "/")
+	if len(parts) < 2 || parts[len(parts)-2] == "" || parts[len(parts)-1] == "" {
+		return "", fmt.Errorf("can't determine the repo of git remote %q", gitURL)
+	}
+	return parts[len(parts)-2] + "/" + parts[len(parts)-1], nil
+}
+
+// resolveRef returns the repo in the SCM backend for the build event. Builds
+// from custom git remotes are resolved with the RefResolver, which also
+// replaces the event's ref.
+func (q *Quayd) resolveRef(ctx context.Context, e *BuildEvent) (string, error) {
+	if e.GitURL == "" {
+		return q.githubRepo(e.Repo)
+	}
+
+	repo, ref, err := q.RefResolver.ResolveRef(ctx, e.GitURL, e.Ref)
+	if err != nil {
+		return "", fmt.Errorf("resolving %s@%s: %v", e.GitURL, e.Ref, err)
+	}
+	e.Ref = ref
+	return repo, nil
+}
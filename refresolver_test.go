@@ -0,0 +1,65 @@
+package quayd
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGitURLRefResolver(t *testing.T) {
+	r := &GitURLRefResolver{Repos: map[string]string{"https://git.example.com/mirrors/acme.git": "remind101/acme-inc"}}
+
+	tests := []struct {
+		gitURL, repo string
+	}{
+		{"git@git.example.com:remind101/acme-inc.git", "remind101/acme-inc"},
+		{"ssh://git@git.example.com:2222/remind101/acme-inc.git", "remind101/acme-inc"},
+		{"https://git.example.com/scm/remind101/acme-inc", "remind101/acme-inc"},
+		{"https://git.example.com/mirrors/acme.git", "remind101/acme-inc"},
+	}
+
+	for _, tt := range tests {
+		repo, ref, err := r.ResolveRef(context.Background(), tt.gitURL, "abcd")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if repo != tt.repo || ref != "abcd" {
+			t.Fatalf("ResolveRef(%q) => %s@%s; want %s@abcd", tt.gitURL, repo, ref, tt.repo)
+		}
+	}
+
+	if _, _, err := r.ResolveRef(context.Background(), "https://git.example.com/acme", "abcd"); err == nil {
+		t.Fatal("Expected an error")
+	}
+}
+
+func TestWebhook_CustomGit(t *testing.T) {
+	body := `{"repository":"remind101/acme-inc","trigger_kind":"custom-git","build_name":"f1fb3b0","trigger_metadata":{"commit":"` + scannedSha + `","git_url":"git@git.example.com:remind101/acme.git"}}`
+	post := func(q *Quayd) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/quay/pending", bytes.NewBufferString(body))
+		NewServer(q).ServeHTTP(resp, req)
+		return resp
+	}
+
+	// Without a RefResolver, builds from custom git remotes are ignored.
+	r := &statusesRepository{}
+	if post(&Quayd{StatusesRepository: r}); len(r.statuses) != 0 {
+		t.Fatal("Expected no commit status")
+	}
+
+	resolver := RefResolverFunc(func(ctx context.Context, gitURL, sha string) (string, string, error) {
+		return "remind101/acme", sha, nil
+	})
+	if resp := post(&Quayd{StatusesRepository: r, RefResolver: resolver}); resp.Code != 200 {
+		t.Fatalf("Status => %d; want 200: %s", resp.Code, resp.Body.String())
+	}
+	if len(r.statuses) != 1 {
+		t.Fatal("Expected a commit status")
+	}
+	if st := r.statuses[0]; st.Repo != "remind101/acme" || st.Ref != "long-"+scannedSha {
+		t.Fatalf("Unexpected status %+v", st)
+	}
+}
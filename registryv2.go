@@ -0,0 +1,417 @@
+package quayd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Media types of manifests that reference the manifests of each platform.
+const (
+	MediaTypeManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOCIIndex     = "application/vnd.oci.image.index.v1+json"
+)
+
+// manifestMediaTypes are the manifest media types that are accepted from v2
+// registries.
+var manifestMediaTypes = strings.Join([]string{
+	MediaTypeImage,
+	MediaTypeOCIManifest,
+	MediaTypeManifestList,
+	MediaTypeOCIIndex,
+}, ", ")
+
+// ErrNoDigest is returned when a v2 registry doesn't return the digest of a
+// manifest.
+var ErrNoDigest = errors.New("registry did not return a Docker-Content-Digest")
+
+// IsDigest returns true if ref is a content digest, like "sha256:...",
+// rather than a v1 image id.
+func IsDigest(ref string) bool {
+	return strings.HasPrefix(ref, "sha256:")
+}
+
+// DockerRegistryV2TagResolver is an implementation of the TagResolver that
+// resolves an image tag to the digest of its manifest, using the v2 registry
+// api. Unlike v1 image ids, digests can be pulled by, e.g.
+// `docker pull quay.io/remind101/acme-inc@sha256:...`.
+type DockerRegistryV2TagResolver struct {
+	registry string
+
+	// username and password, if set, authenticate the reads, either
+	// directly or to get a bearer token. See registryDo.
+	username string
+	password string
+
+	// Scheme is the URL scheme used to reach the registry. Defaults to
+	// https.
+	Scheme string
+
+	// Client is the http.Client to use. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Resolve implements TagResolver Resolve.
+func (r *DockerRegistryV2TagResolver) Resolve(ctx context.Context, repo, tag string) (string, error) {
+	req, err := http.NewRequest("HEAD", registryURL(r.Scheme, r.registry)+"/v2/"+repo+"/manifests/"+tag, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", manifestMediaTypes)
+	resp, err := registryDo(ctx, r.Client, req, r.username, r.password)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return "", &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", ErrNoDigest
+	}
+	return digest, nil
+}
+
+// Tags implements TagLister Tags. Paginated lists are followed using the
+// Link header.
+func (r *DockerRegistryV2TagResolver) Tags(ctx context.Context, repo string) ([]string, error) {
+	var tags []string
+	for next := "/v2/" + repo + "/tags/list"; next != ""; {
+		u := next
+		if strings.HasPrefix(u, "/") {
+			u = registryURL(r.Scheme, r.registry) + u
+		}
+
+		req, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := registryDo(ctx, r.Client, req, r.username, r.password)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode >= 300 {
+			resp.Body.Close()
+			return nil, &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+		}
+
+		var list struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, list.Tags...)
+
+		next = nextLink(resp.Header.Get("Link"))
+	}
+
+	return tags, nil
+}
+
+// nextLink returns the URL, or path, of the rel="next" link in a Link header, like
+// `</v2/remind101/acme-inc/tags/list?n=100&last=v1.0.0>; rel="next"`.
+func nextLink(link string) string {
+	if !strings.Contains(link, `rel="next"`) {
+		return ""
+	}
+
+	start, end := strings.Index(link, "<"), strings.Index(link, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+
+	return link[start+1 : end]
+}
+
+// PlatformResolver is implemented by TagResolvers that can list the platform
+// specific manifests of a multi-arch manifest list.
+type PlatformResolver interface {
+	// Platforms returns the platforms of the manifest with the digest, or
+	// nil if it isn't a manifest list.
+	Platforms(ctx context.Context, repo, digest string) ([]*Platform, error)
+}
+
+// Platforms implements PlatformResolver Platforms.
+func (r *DockerRegistryV2TagResolver) Platforms(ctx context.Context, repo, digest string) ([]*Platform, error) {
+	req, err := http.NewRequest("GET", registryURL(r.Scheme, r.registry)+"/v2/"+repo+"/manifests/"+digest, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", manifestMediaTypes)
+	resp, err := registryDo(ctx, r.Client, req, r.username, r.password)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return nil, &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	switch resp.Header.Get("Content-Type") {
+	case MediaTypeManifestList, MediaTypeOCIIndex:
+	default:
+		return nil, nil
+	}
+
+	var list struct {
+		Manifests []struct {
+			Digest   string `json:"digest"`
+			Platform struct {
+				OS           string `json:"os"`
+				Architecture string `json:"architecture"`
+				Variant      string `json:"variant"`
+			} `json:"platform"`
+		} `json:"manifests"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+
+	var platforms []*Platform
+	for _, m := range list.Manifests {
+		// Attestation manifests are listed with an unknown platform.
+		if m.Platform.OS == "unknown" {
+			continue
+		}
+		platforms = append(platforms, &Platform{
+			OS:           m.Platform.OS,
+			Architecture: m.Platform.Architecture,
+			Variant:      m.Platform.Variant,
+			Digest:       m.Digest,
+		})
+	}
+	return platforms, nil
+}
+
+// PlatformTag returns the tag for the platform's image of a build of sha, like
+// `<sha>-amd64` or `<sha>-arm64-v8`. The OS is only included when it isn't
+// linux.
+func PlatformTag(sha string, p *Platform) string {
+	parts := []string{sha}
+	if p.OS != "" && p.OS != "linux" {
+		parts = append(parts, p.OS)
+	}
+	parts = append(parts, p.Architecture)
+	if p.Variant != "" {
+		parts = append(parts, p.Variant)
+	}
+	return BranchTag(strings.Join(parts, "-"))
+}
+
+// DockerRegistryV2Tagger is a Tagger implementation that tags the manifest
+// with a digest, using the v2 registry api. The manifest is read by its digest
+// and put back under the new tag, so the tag is pinned to exactly that
+// content.
+type DockerRegistryV2Tagger struct {
+	registry string
+	username string
+	password string
+
+	// Scheme is the URL scheme used to reach the registry. Defaults to
+	// https.
+	Scheme string
+
+	// Client is the http.Client to use. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Tag implements Tagger Tag. digest is the digest of the manifest to tag.
+func (t *DockerRegistryV2Tagger) Tag(ctx context.Context, repo, digest, tag string) error {
+	resp, err := t.do(ctx, "GET", repo, digest, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	manifest, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	resp, err = t.do(ctx, "PUT", repo, tag, manifest, resp.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// Untag implements Untagger Untag, for registries that support deleting a
+// manifest by tag.
+func (t *DockerRegistryV2Tagger) Untag(ctx context.Context, repo, tag string) error {
+	resp, err := t.do(ctx, "DELETE", repo, tag, nil, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// do sends a request for the manifest at reference. Unsuccessful responses
+// are returned as an *HTTPError.
+func (t *DockerRegistryV2Tagger) do(ctx context.Context, method, repo, reference string, body []byte, contentType string) (*http.Response, error) {
+	req, err := http.NewRequest(method, registryURL(t.Scheme, t.registry)+"/v2/"+repo+"/manifests/"+reference, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", manifestMediaTypes)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := registryDo(ctx, t.Client, req, t.username, t.password)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	return resp, nil
+}
+
+// registryDo sends req to a v2 registry. Requests are authenticated with
+// basic auth when there's a username. If the registry challenges for a bearer
+// token instead, with a `WWW-Authenticate: Bearer realm=...` header, a token
+// is requested from the realm, using the same credentials, and the request is
+// retried with it.
+func registryDo(ctx context.Context, c *http.Client, req *http.Request, username, password string) (*http.Response, error) {
+	req = req.WithContext(ctx)
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+
+	resp, err := registryClient(c).Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	if resp.StatusCode != 401 || !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	token, err := registryToken(ctx, c, parseChallenge(challenge[len("bearer "):]), username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	retry := req.Clone(ctx)
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	retry.Header.Set("Authorization", "Bearer "+token)
+
+	return registryClient(c).Do(retry)
+}
+
+// registryToken requests a bearer token from the realm of a challenge.
+func registryToken(ctx context.Context, c *http.Client, challenge map[string]string, username, password string) (string, error) {
+	realm, err := url.Parse(challenge["realm"])
+	if err != nil || challenge["realm"] == "" {
+		return "", fmt.Errorf("registry: invalid bearer realm %q", challenge["realm"])
+	}
+
+	q := realm.Query()
+	for _, k := range []string{"service", "scope"} {
+		if v := challenge[k]; v != "" {
+			q.Set(k, v)
+		}
+	}
+	realm.RawQuery = q.Encode()
+
+	req, err := http.NewRequest("GET", realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+
+	resp, err := registryClient(c).Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return "", &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+
+	if token.Token != "" {
+		return token.Token, nil
+	}
+	if token.AccessToken != "" {
+		return token.AccessToken, nil
+	}
+	return "", errors.New("registry: no token in the token response")
+}
+
+// parseChallenge parses the parameters of a WWW-Authenticate challenge, like
+// `realm="https://quay.io/v2/auth",service="quay.io",scope="repository:remind101/acme-inc:pull"`.
+func parseChallenge(params string) map[string]string {
+	challenge := make(map[string]string)
+	for params != "" {
+		eq := strings.Index(params, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(params[:eq]))
+		params = strings.TrimSpace(params[eq+1:])
+
+		var value string
+		if strings.HasPrefix(params, `"`) {
+			end := strings.Index(params[1:], `"`)
+			if end < 0 {
+				value, params = params[1:], ""
+			} else {
+				value, params = params[1:end+1], params[end+2:]
+			}
+		} else if comma := strings.Index(params, ","); comma >= 0 {
+			value, params = params[:comma], params[comma:]
+		} else {
+			value, params = params, ""
+		}
+		challenge[key] = value
+
+		params = strings.TrimPrefix(strings.TrimSpace(params), ",")
+	}
+	return challenge
+}
+
+// registryClient returns c, or http.DefaultClient if it's nil.
+func registryClient(c *http.Client) *http.Client {
+	if c == nil {
+		return http.DefaultClient
+	}
+
+	return c
+}
@@ -0,0 +1,198 @@
+package quayd
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const manifestDigest = "sha256:44328407448043cf89c1fefb13662de8ce8969ea8de5a25baa0d7700a17d7306"
+
+// v2Registry is a fake v2 registry that stores manifests by tag and digest.
+type v2Registry struct {
+	mu        sync.Mutex
+	manifests map[string]string
+}
+
+func (reg *v2Registry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	ref := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	switch r.Method {
+	case "HEAD", "GET":
+		manifest, ok := reg.manifests[ref]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", MediaTypeImage)
+		w.Header().Set("Docker-Content-Digest", manifestDigest)
+		w.Write([]byte(manifest))
+	case "PUT":
+		if r.Header.Get("Content-Type") != MediaTypeImage {
+			http.Error(w, "unexpected media type", 400)
+			return
+		}
+		raw, _ := ioutil.ReadAll(r.Body)
+		reg.manifests[ref] = string(raw)
+		w.WriteHeader(201)
+	}
+}
+
+func TestDigestTagging(t *testing.T) {
+	reg := &v2Registry{manifests: map[string]string{
+		"test":         `{"schemaVersion":2}`,
+		manifestDigest: `{"schemaVersion":2}`,
+	}}
+	s := httptest.NewServer(reg)
+	defer s.Close()
+
+	q, err := NewFromConfig(&Config{
+		Registry:       strings.TrimPrefix(s.URL, "http://"),
+		RegistryScheme: "http",
+		DigestTagging:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.CommitResolver = DefaultCommitResolver
+
+	image, err := q.LoadImageTags(context.Background(), "test", "remind101/acme-inc", "abcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := image.Digest, manifestDigest; got != want {
+		t.Fatalf("Digest => %s; want %s", got, want)
+	}
+	if got, want := image.Tags, []string{"test", "long-abcd"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Tags => %v; want %v", got, want)
+	}
+	if got, want := reg.manifests["long-abcd"], `{"schemaVersion":2}`; got != want {
+		t.Fatalf("Manifest => %s; want %s", got, want)
+	}
+}
+
+func TestDigestTagging_Platforms(t *testing.T) {
+	const (
+		amd64 = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+		arm64 = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+	)
+	list := `{"schemaVersion":2,"mediaType":"` + MediaTypeManifestList + `","manifests":[` +
+		`{"digest":"` + amd64 + `","platform":{"os":"linux","architecture":"amd64"}},` +
+		`{"digest":"` + arm64 + `","platform":{"os":"linux","architecture":"arm64","variant":"v8"}},` +
+		`{"digest":"sha256:3333","platform":{"os":"unknown","architecture":"unknown"}}]}`
+
+	var tagged []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ref := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		switch {
+		case r.Method == "PUT":
+			tagged = append(tagged, ref)
+			w.WriteHeader(201)
+		case ref == "test" || ref == manifestDigest:
+			w.Header().Set("Content-Type", MediaTypeManifestList)
+			w.Header().Set("Docker-Content-Digest", manifestDigest)
+			w.Write([]byte(list))
+		default:
+			w.Header().Set("Content-Type", MediaTypeImage)
+			w.Write([]byte(`{"schemaVersion":2}`))
+		}
+	}))
+	defer s.Close()
+
+	q, err := NewFromConfig(&Config{
+		Registry:       strings.TrimPrefix(s.URL, "http://"),
+		RegistryScheme: "http",
+		DigestTagging:  true,
+		PlatformTags:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.CommitResolver = DefaultCommitResolver
+
+	image, err := q.LoadImageTags(context.Background(), "test", "remind101/acme-inc", "abcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*Platform{
+		{OS: "linux", Architecture: "amd64", Digest: amd64, Tag: "long-abcd-amd64"},
+		{OS: "linux", Architecture: "arm64", Variant: "v8", Digest: arm64, Tag: "long-abcd-arm64-v8"},
+	}
+	if !reflect.DeepEqual(image.Platforms, want) {
+		t.Fatalf("Platforms => %+v; want %+v", image.Platforms, want)
+	}
+	if got, want := tagged, []string{"long-abcd", "long-abcd-amd64", "long-abcd-arm64-v8"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Tagged => %v; want %v", got, want)
+	}
+}
+
+func TestDockerRegistryV2TagResolver_Tags(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("last") == "" {
+			w.Header().Set("Link", `</v2/remind101/acme-inc/tags/list?n=2&last=1.1.0>; rel="next"`)
+			w.Write([]byte(`{"name":"remind101/acme-inc","tags":["1.0.0","1.1.0"]}`))
+			return
+		}
+		w.Write([]byte(`{"name":"remind101/acme-inc","tags":["1.2.0"]}`))
+	}))
+	defer s.Close()
+
+	r := &DockerRegistryV2TagResolver{registry: strings.TrimPrefix(s.URL, "http://"), Scheme: "http"}
+	tags, err := r.Tags(context.Background(), "remind101/acme-inc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := tags, []string{"1.0.0", "1.1.0", "1.2.0"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Tags => %v; want %v", got, want)
+	}
+}
+
+func TestDockerRegistryV2TagResolver_BearerChallenge(t *testing.T) {
+	var s *httptest.Server
+	s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/auth":
+			if user, pass, _ := r.BasicAuth(); user != "robot" || pass != "secret" {
+				w.WriteHeader(401)
+				return
+			}
+			if got, want := r.URL.Query().Get("scope"), "repository:remind101/acme-inc:pull"; got != want {
+				t.Errorf("scope => %q; want %q", got, want)
+			}
+			w.Write([]byte(`{"token":"abcd"}`))
+		case "/v2/remind101/acme-inc/manifests/latest":
+			if r.Header.Get("Authorization") != "Bearer abcd" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="`+s.URL+`/v2/auth",service="quay.io",scope="repository:remind101/acme-inc:pull"`)
+				w.WriteHeader(401)
+				return
+			}
+			w.Header().Set("Docker-Content-Digest", manifestDigest)
+		case "/v2/remind101/acme-inc/manifests/nodigest":
+		}
+	}))
+	defer s.Close()
+
+	r := &DockerRegistryV2TagResolver{registry: strings.TrimPrefix(s.URL, "http://"), username: "robot", password: "secret", Scheme: "http"}
+	digest, err := r.Resolve(context.Background(), "remind101/acme-inc", "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := digest, manifestDigest; got != want {
+		t.Fatalf("Digest => %s; want %s", got, want)
+	}
+
+	if _, err := r.Resolve(context.Background(), "remind101/acme-inc", "nodigest"); err != ErrNoDigest {
+		t.Fatalf("err => %v; want %v", err, ErrNoDigest)
+	}
+}
@@ -0,0 +1,154 @@
+package quayd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/ejholmes/go-github/github"
+)
+
+// ReleaseAssetService uploads assets to GitHub releases.
+type ReleaseAssetService interface {
+	// Upload attaches content to the release of tag as an asset called
+	// name, replacing an existing asset with the same name. It returns
+	// false if the tag doesn't have a release.
+	Upload(ctx context.Context, repo, tag, name string, content []byte) (bool, error)
+}
+
+// ReleaseImage is the content of the digest file that's attached to a
+// release.
+type ReleaseImage struct {
+	Name     string   `json:"name"`
+	ID       string   `json:"id,omitempty"`
+	Digest   string   `json:"digest,omitempty"`
+	Tags     []string `json:"tags"`
+	Sha      string   `json:"sha"`
+	BuildURL string   `json:"build_url"`
+
+	// Platforms are the platform specific digests of multi-arch images.
+	Platforms []*Platform `json:"platforms,omitempty"`
+}
+
+// ReleaseAssets attaches a digest file describing the image to the GitHub
+// release of a git tag when Quay builds the tag, so that the image a release
+// shipped as can be found from the release page.
+type ReleaseAssets struct {
+	Releases ReleaseAssetService
+}
+
+// AssetName returns the name of the digest file asset for the Quay repo.
+// Repos are named after the image, so that the releases of monorepos can have
+// one for each image.
+func AssetName(repo string) string {
+	return path.Base(repo) + ".digest.json"
+}
+
+// Attach uploads the digest file of the image to the release of the tag.
+func (a *ReleaseAssets) Attach(ctx context.Context, repo, tag, sha, buildURL string, image *Image) (bool, error) {
+	content, err := json.MarshalIndent(&ReleaseImage{
+		Name:      image.Name(),
+		ID:        image.ID,
+		Digest:    image.Digest,
+		Tags:      image.Tags,
+		Sha:       sha,
+		BuildURL:  buildURL,
+		Platforms: image.Platforms,
+	}, "", "  ")
+	if err != nil {
+		return false, err
+	}
+
+	return a.Releases.Upload(ctx, repo, tag, AssetName(image.Repo), content)
+}
+
+// releaseAsset attaches the digest file of the image to the release of the
+// build's git tag. Failures are logged, rather than failing the build event.
+func (q *Quayd) releaseAsset(ctx context.Context, e *BuildEvent, image *Image, repo, sha, buildURL string) {
+	if q.ReleaseAssets == nil || e.GitTag == "" {
+		return
+	}
+
+	if q.recorder != nil {
+		q.recorder.record("release asset %s %s %s", repo, e.GitTag, AssetName(image.Repo))
+		return
+	}
+
+	if q.ReadOnly {
+		q.logger().Log(ctx, "release asset skipped (read-only)", "repo", repo, "tag", e.GitTag)
+		return
+	}
+
+	ok, err := q.ReleaseAssets.Attach(ctx, repo, e.GitTag, sha, buildURL, image)
+	if err != nil {
+		q.logger().Log(ctx, "release asset failed", "repo", repo, "tag", e.GitTag, "error", err)
+		return
+	}
+	if !ok {
+		q.logger().Log(ctx, "release asset skipped (no release)", "repo", repo, "tag", e.GitTag)
+		return
+	}
+	q.logger().Log(ctx, "release asset uploaded", "repo", repo, "tag", e.GitTag, "asset", AssetName(image.Repo))
+}
+
+// GitHubReleaseAssetService is an implementation of the ReleaseAssetService
+// interface backed by a github.Client.
+type GitHubReleaseAssetService struct {
+	Client *github.Client
+}
+
+// Upload implements ReleaseAssetService Upload.
+func (s *GitHubReleaseAssetService) Upload(ctx context.Context, repo, tag, name string, content []byte) (bool, error) {
+	req, err := s.Client.NewRequest("GET", fmt.Sprintf("repos/%s/releases/tags/%s", repo, url.PathEscape(tag)), nil)
+	if err != nil {
+		return false, err
+	}
+
+	var release struct {
+		UploadURL string `json:"upload_url"`
+		Assets    []struct {
+			ID   int    `json:"id"`
+			Name string `json:"name"`
+		} `json:"assets"`
+	}
+	_, err = s.Client.Do(req.WithContext(ctx), &release)
+	if statusCode(err) == 404 {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// Asset names are unique within a release, so the file from an
+	// earlier build of the tag is replaced.
+	for _, asset := range release.Assets {
+		if asset.Name != name {
+			continue
+		}
+		req, err := s.Client.NewRequest("DELETE", fmt.Sprintf("repos/%s/releases/assets/%d", repo, asset.ID), nil)
+		if err != nil {
+			return false, err
+		}
+		if _, err := s.Client.Do(req.WithContext(ctx), nil); err != nil {
+			return false, err
+		}
+	}
+
+	// The upload_url is a URI template, like
+	// `https://uploads.github.com/repos/o/r/releases/1/assets{?name,label}`.
+	uploadURL := release.UploadURL
+	if i := strings.Index(uploadURL, "{"); i >= 0 {
+		uploadURL = uploadURL[:i]
+	}
+
+	req, err = s.Client.NewUploadRequest(uploadURL+"?name="+url.QueryEscape(name), bytes.NewReader(content), int64(len(content)), "application/json")
+	if err != nil {
+		return false, err
+	}
+	_, err = s.Client.Do(req.WithContext(ctx), nil)
+	return err == nil, err
+}
@@ -0,0 +1,63 @@
+package quayd
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/ejholmes/go-github/github"
+)
+
+func TestGitHubReleaseAssetService(t *testing.T) {
+	var requests []string
+	var uploaded ReleaseImage
+
+	var s *httptest.Server
+	s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+
+		switch r.Method + " " + r.URL.Path {
+		case "GET /repos/remind101/acme-inc/releases/tags/v1.2.0":
+			w.Write([]byte(`{"upload_url":"` + s.URL + `/uploads/1/assets{?name,label}","assets":[{"id":7,"name":"acme-inc.digest.json"},{"id":8,"name":"acme-inc.tar.gz"}]}`))
+		case "DELETE /repos/remind101/acme-inc/releases/assets/7":
+			w.WriteHeader(204)
+		case "POST /uploads/1/assets":
+			if got, want := r.URL.Query().Get("name"), "acme-inc.digest.json"; got != want {
+				t.Errorf("name => %q; want %q", got, want)
+			}
+			raw, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(raw, &uploaded)
+			w.WriteHeader(201)
+			w.Write([]byte(`{}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer s.Close()
+
+	g := github.NewClient(nil)
+	g.BaseURL, _ = url.Parse(s.URL + "/")
+	a := &ReleaseAssets{Releases: &GitHubReleaseAssetService{Client: g}}
+	image := &Image{Registry: "quay.io", Repo: "remind101/acme-inc", ID: "abcd", Tags: []string{"v1.2.0"}}
+
+	ok, err := a.Attach(context.Background(), "remind101/acme-inc", "v1.2.0", scannedSha, "https://quay.io/build", image)
+	if err != nil || !ok {
+		t.Fatalf("Attach => %v, %v", ok, err)
+	}
+	if len(requests) != 3 {
+		t.Fatalf("Expected the old asset to be replaced, got %v", requests)
+	}
+	if uploaded.Name != "quay.io/remind101/acme-inc" || uploaded.Sha != scannedSha || uploaded.ID != "abcd" {
+		t.Fatalf("Unexpected digest file %+v", uploaded)
+	}
+
+	// Tags without a release are skipped.
+	ok, err = a.Attach(context.Background(), "remind101/acme-inc", "v1.3.0", scannedSha, "", image)
+	if err != nil || ok {
+		t.Fatalf("Attach => %v, %v; want false, nil", ok, err)
+	}
+}
@@ -0,0 +1,65 @@
+package quayd
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+)
+
+// RepoMapper maps a Quay repo, in the form `namespace/repo`, to the GitHub
+// repo, in the form `owner/repo`, that it's built from.
+type RepoMapper interface {
+	Map(quayRepo string) (githubRepo string, err error)
+}
+
+// RepoMapperFunc is a function that implements the RepoMapper interface.
+type RepoMapperFunc func(quayRepo string) (string, error)
+
+// Map implements RepoMapper Map.
+func (fn RepoMapperFunc) Map(quayRepo string) (string, error) {
+	return fn(quayRepo)
+}
+
+// RepoMap is a RepoMapper that maps Quay repos using a static table. Keys
+// ending in "/*" match every repo in a Quay namespace, and map it to the repo
+// with the same name in the GitHub owner given by the value (e.g.
+// "myorg-images/*": "myorg"). Repos that don't match are assumed to have the
+// same name on GitHub.
+type RepoMap map[string]string
+
+// Map implements RepoMapper Map.
+func (m RepoMap) Map(quayRepo string) (string, error) {
+	if githubRepo, ok := m[quayRepo]; ok {
+		return githubRepo, nil
+	}
+
+	if i := strings.Index(quayRepo, "/"); i > 0 {
+		if owner, ok := m[quayRepo[:i]+"/*"]; ok {
+			return owner + quayRepo[i:], nil
+		}
+	}
+
+	return quayRepo, nil
+}
+
+// DecodeRepoMap decodes a JSON encoded RepoMap from r.
+func DecodeRepoMap(r io.Reader) (RepoMap, error) {
+	var m RepoMap
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// LoadRepoMap loads a JSON encoded RepoMap from the file at path.
+func LoadRepoMap(path string) (RepoMap, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return DecodeRepoMap(f)
+}
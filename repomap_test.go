@@ -0,0 +1,57 @@
+package quayd
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRepoMap(t *testing.T) {
+	m := RepoMap{
+		"myorg/service-img": "myorg/service",
+		"myorg-images/*":    "myorg",
+	}
+
+	tests := []struct {
+		in, out string
+	}{
+		{"myorg/service-img", "myorg/service"},
+		{"myorg-images/worker", "myorg/worker"},
+		{"remind101/acme-inc", "remind101/acme-inc"},
+	}
+
+	for _, tt := range tests {
+		got, err := m.Map(tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != tt.out {
+			t.Fatalf("Map(%s) => %s; want %s", tt.in, got, tt.out)
+		}
+	}
+}
+
+func TestQuayd_RepoMapper(t *testing.T) {
+	r := &statusesRepository{}
+	registry := &MemoryRegistry{}
+	registry.Seed("myorg/service-img", "latest")
+
+	q := &Quayd{
+		StatusesRepository: r,
+		Tagger:             registry,
+		TagResolver:        registry,
+		RepoMapper:         RepoMap{"myorg/service-img": "myorg/service"},
+	}
+
+	if err := q.Handle(context.Background(), &BuildEvent{Repo: "myorg/service-img", Ref: "f1fb3b0", State: "success", Tags: []string{"latest"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := r.statuses[0].Repo, "myorg/service"; got != want {
+		t.Fatalf("Repo => %s; want %s", got, want)
+	}
+
+	if _, err := registry.Resolve(context.Background(), "myorg/service-img", "long-f1fb3b0"); err != nil {
+		t.Fatal("Expected the Quay repo to be tagged")
+	}
+}
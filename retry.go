@@ -0,0 +1,187 @@
+package quayd
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/ejholmes/go-github/github"
+)
+
+// DefaultRetryPolicy is the RetryPolicy used by New.
+var DefaultRetryPolicy = &RetryPolicy{
+	MaxAttempts:          3,
+	Backoff:              500 * time.Millisecond,
+	MaxBackoff:           10 * time.Second,
+	Jitter:               0.2,
+	RetryableStatusCodes: []int{500, 502, 503, 504},
+}
+
+// RetryPolicy retries operations that fail with transient errors, backing off
+// exponentially between attempts.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	MaxAttempts int
+
+	// Backoff is the delay before the first retry. It doubles after each
+	// attempt, up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// Jitter randomizes each delay by up to this fraction of it.
+	Jitter float64
+
+	// RetryableStatusCodes are the HTTP status codes that are considered
+	// transient. Network errors are always retried.
+	RetryableStatusCodes []int
+}
+
+// Do calls fn until it succeeds, returns an error that isn't retryable, the
+// attempts are exhausted, or ctx is done.
+func (p *RetryPolicy) Do(ctx context.Context, fn func() error) error {
+	backoff := p.Backoff
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.MaxAttempts || !p.retryable(err) {
+			return err
+		}
+
+		select {
+		case <-time.After(p.jitter(backoff)):
+		case <-ctx.Done():
+			return err
+		}
+
+		backoff *= 2
+		if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+
+	code := statusCode(err)
+	for _, c := range p.RetryableStatusCodes {
+		if c == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *RetryPolicy) jitter(d time.Duration) time.Duration {
+	if p.Jitter <= 0 {
+		return d
+	}
+
+	delta := p.Jitter * float64(d)
+	return d + time.Duration(delta*(2*rand.Float64()-1))
+}
+
+// statusCode returns the HTTP status code of an error returned by GitHub or a
+// registry, or 0 if it doesn't have one.
+func statusCode(err error) int {
+	switch err := err.(type) {
+	case *HTTPError:
+		return err.StatusCode
+	case *github.ErrorResponse:
+		if err.Response != nil {
+			return err.Response.StatusCode
+		}
+	}
+
+	return 0
+}
+
+// RetryStatusesRepository is a StatusesRepository that retries transient
+// failures of the wrapped StatusesRepository.
+type RetryStatusesRepository struct {
+	StatusesRepository
+	Policy *RetryPolicy
+}
+
+// Create implements StatusesRepository Create.
+func (r *RetryStatusesRepository) Create(ctx context.Context, status *Status) error {
+	return r.Policy.Do(ctx, func() error {
+		return r.StatusesRepository.Create(ctx, status)
+	})
+}
+
+// RetryTagger is a Tagger that retries transient failures of the wrapped
+// Tagger.
+type RetryTagger struct {
+	Tagger
+	Policy *RetryPolicy
+}
+
+// Tag implements Tagger Tag.
+func (t *RetryTagger) Tag(ctx context.Context, repo, imageID, tag string) error {
+	return t.Policy.Do(ctx, func() error {
+		return t.Tagger.Tag(ctx, repo, imageID, tag)
+	})
+}
+
+// Untag implements Untagger Untag, if the wrapped Tagger is an Untagger.
+func (t *RetryTagger) Untag(ctx context.Context, repo, tag string) error {
+	u, ok := t.Tagger.(Untagger)
+	if !ok {
+		return ErrUntagUnsupported
+	}
+
+	return t.Policy.Do(ctx, func() error {
+		return u.Untag(ctx, repo, tag)
+	})
+}
+
+// RetryTagResolver is a TagResolver that retries transient failures of the
+// wrapped TagResolver.
+type RetryTagResolver struct {
+	TagResolver
+	Policy *RetryPolicy
+}
+
+// Resolve implements TagResolver Resolve.
+func (r *RetryTagResolver) Resolve(ctx context.Context, repo, tag string) (imageID string, err error) {
+	err = r.Policy.Do(ctx, func() error {
+		imageID, err = r.TagResolver.Resolve(ctx, repo, tag)
+		return err
+	})
+	return imageID, err
+}
+
+// Tags implements TagLister Tags, when the wrapped TagResolver implements it.
+// Otherwise no tags are returned.
+func (r *RetryTagResolver) Tags(ctx context.Context, repo string) (tags []string, err error) {
+	l, ok := r.TagResolver.(TagLister)
+	if !ok {
+		return nil, nil
+	}
+
+	err = r.Policy.Do(ctx, func() error {
+		tags, err = l.Tags(ctx, repo)
+		return err
+	})
+	return tags, err
+}
+
+// Platforms implements PlatformResolver Platforms, when the wrapped
+// TagResolver implements it. Otherwise no platforms are returned.
+func (r *RetryTagResolver) Platforms(ctx context.Context, repo, digest string) (platforms []*Platform, err error) {
+	p, ok := r.TagResolver.(PlatformResolver)
+	if !ok {
+		return nil, nil
+	}
+
+	err = r.Policy.Do(ctx, func() error {
+		platforms, err = p.Platforms(ctx, repo, digest)
+		return err
+	})
+	return platforms, err
+}
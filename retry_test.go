@@ -0,0 +1,75 @@
+package quayd
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// flakyTagger is a Tagger that fails with err until it has been called n
+// times.
+type flakyTagger struct {
+	err   error
+	n     int
+	calls int
+}
+
+func (t *flakyTagger) Tag(ctx context.Context, repo, imageID, tag string) error {
+	t.calls++
+	if t.calls < t.n {
+		return t.err
+	}
+	return nil
+}
+
+func TestRetryTagger(t *testing.T) {
+	policy := &RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, RetryableStatusCodes: []int{502}}
+
+	tests := []struct {
+		err   error
+		n     int
+		calls int
+		ok    bool
+	}{
+		{&HTTPError{StatusCode: 502}, 3, 3, true},
+		{&HTTPError{StatusCode: 502}, 4, 3, false},
+		{&HTTPError{StatusCode: 401}, 2, 1, false},
+		{errors.New("boom"), 2, 1, false},
+	}
+
+	for _, tt := range tests {
+		f := &flakyTagger{err: tt.err, n: tt.n}
+		r := &RetryTagger{Tagger: f, Policy: policy}
+
+		err := r.Tag(context.Background(), "ejholmes/docker-statsd", "1234", "latest")
+		if got, want := err == nil, tt.ok; got != want {
+			t.Fatalf("Success => %v; want %v (%v)", got, want, err)
+		}
+
+		if got, want := f.calls, tt.calls; got != want {
+			t.Fatalf("Calls => %d; want %d", got, want)
+		}
+	}
+}
+
+func TestRetryPolicy_Canceled(t *testing.T) {
+	p := &RetryPolicy{MaxAttempts: 5, Backoff: time.Hour, RetryableStatusCodes: []int{503}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	calls := 0
+	err := p.Do(ctx, func() error {
+		calls++
+		return &HTTPError{StatusCode: 503}
+	})
+
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+
+	if got, want := calls, 1; got != want {
+		t.Fatalf("Calls => %d; want %d", got, want)
+	}
+}
@@ -0,0 +1,78 @@
+package quayd
+
+// Media types for the kinds of artifacts that can be pushed to a Quay
+// namespace.
+const (
+	MediaTypeImage       = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeChart       = "application/vnd.cncf.helm.config.v1+json"
+	MediaTypeAttestation = "application/vnd.in-toto+json"
+)
+
+// DefaultRoute is the Route used for artifacts that don't match a configured
+// media type.
+var DefaultRoute = &Route{Tag: true}
+
+// Route describes how builds of a particular artifact type are handled.
+type Route struct {
+	// Context is the commit status context to use. Defaults to Context.
+	Context string `json:"context"`
+
+	// Tag controls whether the artifact is tagged with the git sha once the
+	// build succeeds.
+	Tag bool `json:"tag"`
+
+	// Capabilities, if set, limits the parts of the pipeline that run for
+	// this artifact type, on top of the repo's policy.
+	Capabilities *Capabilities `json:"capabilities,omitempty"`
+
+	// SlackWebhookURL, if set, posts build results for this artifact type
+	// to this Slack incoming webhook, instead of through the default
+	// notifiers.
+	SlackWebhookURL string `json:"slack_webhook_url,omitempty"`
+
+	// Notifiers, if set, are notified instead of Quayd's Notifiers for
+	// this artifact type.
+	Notifiers []Notifier `json:"-"`
+}
+
+// context returns the commit status context for this route.
+func (r *Route) context() string {
+	if r.Context == "" {
+		return Context
+	}
+
+	return r.Context
+}
+
+// capabilities returns the capabilities that the repo's policy, c, allows
+// for this route.
+func (r *Route) capabilities(c Capabilities) Capabilities {
+	if r.Capabilities == nil {
+		return c
+	}
+
+	return c & *r.Capabilities
+}
+
+// notifiers returns the notifiers for this route, falling back to
+// defaults.
+func (r *Route) notifiers(defaults []Notifier) []Notifier {
+	if r.Notifiers == nil {
+		return defaults
+	}
+
+	return r.Notifiers
+}
+
+// Routes maps a media type to the Route that should handle it.
+type Routes map[string]*Route
+
+// Route returns the Route for the given media type, falling back to
+// DefaultRoute.
+func (r Routes) Route(mediaType string) *Route {
+	if route, ok := r[mediaType]; ok {
+		return route
+	}
+
+	return DefaultRoute
+}
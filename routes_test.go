@@ -0,0 +1,65 @@
+package quayd
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRoutes(t *testing.T) {
+	chart := &Route{Context: "Helm Chart"}
+	routes := Routes{MediaTypeChart: chart}
+
+	tests := []struct {
+		mediaType string
+		route     *Route
+		context   string
+	}{
+		{MediaTypeChart, chart, "Helm Chart"},
+		{MediaTypeImage, DefaultRoute, "Docker Image"},
+		{"", DefaultRoute, "Docker Image"},
+	}
+
+	for _, tt := range tests {
+		r := routes.Route(tt.mediaType)
+
+		if got, want := r, tt.route; got != want {
+			t.Fatalf("Route(%q) => %v; want %v", tt.mediaType, got, want)
+		}
+
+		if got, want := r.context(), tt.context; got != want {
+			t.Fatalf("Context => %s; want %s", got, want)
+		}
+	}
+}
+
+func TestHandle_Routes(t *testing.T) {
+	r := &statusesRepository{}
+	registry := &MemoryRegistry{}
+	registry.Seed("remind101/acme-inc", "test")
+	images, charts := &notifier{}, &notifier{}
+	statuses := CapabilityStatuses
+	q := &Quayd{
+		StatusesRepository: r,
+		TagResolver:        registry,
+		Tagger:             registry,
+		Notifiers:          []Notifier{images},
+		Routes: Routes{
+			MediaTypeChart: &Route{Context: "Helm Chart", Tag: true, Capabilities: &statuses, Notifiers: []Notifier{charts}},
+		},
+	}
+
+	e := &BuildEvent{Repo: "remind101/acme-inc", Ref: "abcd", State: "success", Tags: []string{"test"}, MediaType: MediaTypeChart}
+	if err := q.Handle(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.statuses) != 1 || r.statuses[0].Image != nil {
+		t.Fatalf("Expected a commit status without an image, got %+v", r.statuses)
+	}
+	if got, want := len(charts.statuses), 1; got != want {
+		t.Fatalf("Chart notifications => %d; want %d", got, want)
+	}
+	if got, want := len(images.statuses), 0; got != want {
+		t.Fatalf("Image notifications => %d; want %d", got, want)
+	}
+}
@@ -0,0 +1,532 @@
+package quayd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The actions that an EventRule can take.
+const (
+	ActionStatus  = "status"
+	ActionTag     = "tag"
+	ActionNotify  = "notify"
+	ActionDeploy  = "deploy"
+	ActionGitOps  = "gitops"
+	ActionRelease = "release"
+	ActionPromote = "promote"
+	ActionSkip    = "skip"
+)
+
+// EventActions are the actions to take for a build event.
+type EventActions struct {
+	// Status creates commit statuses.
+	Status bool
+
+	// Tag tags the image of successful builds with the git sha.
+	Tag bool
+
+	// Notify, Deploy, GitOps and Release enable the Notifiers,
+	// Environments, GitOps and ReleaseAssets for the event.
+	Notify  bool
+	Deploy  bool
+	GitOps  bool
+	Release bool
+
+	// Promote are the tags that the image of a successful build is
+	// promoted to.
+	Promote []string
+
+	// Skip ignores the event.
+	Skip bool
+}
+
+// DefaultEventActions are the actions for events that no rule matches, which
+// is everything that's configured.
+var DefaultEventActions = &EventActions{
+	Status:  true,
+	Tag:     true,
+	Notify:  true,
+	Deploy:  true,
+	GitOps:  true,
+	Release: true,
+}
+
+// EventRule decides what to do with the build events that match its
+// condition. Rules are written like:
+//
+//	repo.startsWith("infra/") && branch == "main" -> [status, tag, promote(staging)]
+//
+// The condition can compare the fields repo, branch, default_branch, ref,
+// git_tag, state and media_type with == and !=, call startsWith, endsWith,
+// contains or matches (a path.Match glob) on them, and combine the results
+// with &&, || and !. The actions are status, tag, notify, deploy, gitops,
+// release, promote(<tag>), or skip to ignore the event.
+type EventRule struct {
+	// Source is the rule as it was written.
+	Source string
+
+	cond    condition
+	actions *EventActions
+}
+
+// ParseEventRule parses a rule.
+func ParseEventRule(source string) (*EventRule, error) {
+	p := &ruleParser{source: source}
+	if err := p.lex(); err != nil {
+		return nil, p.errorf("%v", err)
+	}
+
+	cond, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.accept("->") {
+		return nil, p.errorf("expected ->")
+	}
+	actions, err := p.parseActions()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok != "" {
+		return nil, p.errorf("unexpected %q", tok)
+	}
+
+	return &EventRule{Source: source, cond: cond, actions: actions}, nil
+}
+
+// Matches reports whether the build event matches the rule's condition.
+func (r *EventRule) Matches(e *BuildEvent) bool {
+	return r.cond.eval(e)
+}
+
+// MarshalJSON encodes the rule as its source.
+func (r *EventRule) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.Source)
+}
+
+// UnmarshalJSON parses a rule from a JSON string.
+func (r *EventRule) UnmarshalJSON(raw []byte) error {
+	var source string
+	if err := json.Unmarshal(raw, &source); err != nil {
+		return err
+	}
+
+	parsed, err := ParseEventRule(source)
+	if err != nil {
+		return err
+	}
+	*r = *parsed
+	return nil
+}
+
+// EventRules are evaluated in order, and the first rule that matches a build
+// event decides its actions.
+type EventRules []*EventRule
+
+// Actions returns the actions of the first rule that matches the build event,
+// or DefaultEventActions.
+func (rules EventRules) Actions(e *BuildEvent) *EventActions {
+	for _, r := range rules {
+		if r.Matches(e) {
+			return r.actions
+		}
+	}
+	return DefaultEventActions
+}
+
+// promote promotes the image of a successful build to the tags of a rule's
+// promote actions. Failures are logged, rather than failing the build event.
+func (q *Quayd) promote(ctx context.Context, e *BuildEvent, image *Image, tags []string) {
+	if len(tags) == 0 {
+		return
+	}
+
+	if q.recorder != nil {
+		for _, tag := range tags {
+			if q.Approvals.Required(tag) {
+				q.recorder.record("approval %s@%s %s", e.Repo, image.ID, tag)
+			} else {
+				q.recorder.record("promote %s@%s %s", e.Repo, image.ID, tag)
+			}
+		}
+		return
+	}
+
+	if q.ReadOnly {
+		q.logger().Log(ctx, "promotion skipped (read-only)", "repo", e.Repo, "tags", tags)
+		return
+	}
+
+	if !q.Policies.Capabilities(e.Repo).Has(CapabilityPromote) {
+		q.logger().Log(ctx, "promotion skipped (disabled by policy)", "repo", e.Repo, "tags", tags)
+		return
+	}
+
+	for _, tag := range tags {
+		if q.Approvals.Required(tag) {
+			p := &Promotion{Repo: e.Repo, Ref: e.Ref, Sha: e.Ref, ImageID: image.ID, Tag: tag, RequestedAt: time.Now()}
+			if err := q.requestApproval(ctx, p); err != nil {
+				q.logger().Log(ctx, "requesting promotion approval failed", "repo", e.Repo, "tag", tag, "error", err)
+			} else {
+				q.logger().Log(ctx, "promotion awaits approval", "repo", e.Repo, "tag", tag, "url", p.URL)
+			}
+			continue
+		}
+
+		if err := q.tagger().Tag(ctx, e.Repo, image.ID, tag); err != nil {
+			q.logger().Log(ctx, "promotion failed", "repo", e.Repo, "tag", tag, "error", err)
+			continue
+		}
+		image.Tags = append(image.Tags, tag)
+		q.logger().Log(ctx, "image promoted", "repo", e.Repo, "image", image.ID, "tag", tag)
+		q.bake(ctx, e.Repo, e.Ref, image.ID, tag)
+	}
+}
+
+// condition is a parsed rule condition.
+type condition interface {
+	eval(e *BuildEvent) bool
+}
+
+type andCondition struct{ left, right condition }
+
+func (c *andCondition) eval(e *BuildEvent) bool { return c.left.eval(e) && c.right.eval(e) }
+
+type orCondition struct{ left, right condition }
+
+func (c *orCondition) eval(e *BuildEvent) bool { return c.left.eval(e) || c.right.eval(e) }
+
+type notCondition struct{ c condition }
+
+func (c *notCondition) eval(e *BuildEvent) bool { return !c.c.eval(e) }
+
+type boolCondition bool
+
+func (c boolCondition) eval(e *BuildEvent) bool { return bool(c) }
+
+// compareCondition compares two operands with == or !=.
+type compareCondition struct {
+	left, right operand
+	equal       bool
+}
+
+func (c *compareCondition) eval(e *BuildEvent) bool {
+	return (c.left.value(e) == c.right.value(e)) == c.equal
+}
+
+// methodCondition calls a string method on an operand.
+type methodCondition struct {
+	operand operand
+	method  func(s, arg string) bool
+	arg     string
+}
+
+func (c *methodCondition) eval(e *BuildEvent) bool {
+	return c.method(c.operand.value(e), c.arg)
+}
+
+// ruleMethods are the methods that can be called on operands.
+var ruleMethods = map[string]func(s, arg string) bool{
+	"startsWith": strings.HasPrefix,
+	"endsWith":   strings.HasSuffix,
+	"contains":   strings.Contains,
+	"matches": func(s, pattern string) bool {
+		ok, _ := path.Match(pattern, s)
+		return ok
+	},
+}
+
+// ruleFields are the fields of a build event that rules can refer to.
+var ruleFields = map[string]func(e *BuildEvent) string{
+	"repo":           func(e *BuildEvent) string { return e.Repo },
+	"branch":         func(e *BuildEvent) string { return e.Branch },
+	"default_branch": func(e *BuildEvent) string { return e.DefaultBranch },
+	"ref":            func(e *BuildEvent) string { return e.Ref },
+	"git_tag":        func(e *BuildEvent) string { return e.GitTag },
+	"state":          func(e *BuildEvent) string { return e.State },
+	"media_type":     func(e *BuildEvent) string { return e.MediaType },
+}
+
+// operand is a field of the build event, or a string literal.
+type operand struct {
+	field   func(e *BuildEvent) string
+	literal string
+}
+
+func (o operand) value(e *BuildEvent) string {
+	if o.field != nil {
+		return o.field(e)
+	}
+	return o.literal
+}
+
+// ruleParser is a recursive descent parser for rules.
+type ruleParser struct {
+	source string
+	tokens []string
+	pos    int
+}
+
+// lex splits the source into tokens. String literals keep their quotes, so
+// they can be told apart from identifiers.
+func (p *ruleParser) lex() error {
+	s := p.source
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case strings.HasPrefix(s[i:], "&&"), strings.HasPrefix(s[i:], "||"),
+			strings.HasPrefix(s[i:], "=="), strings.HasPrefix(s[i:], "!="),
+			strings.HasPrefix(s[i:], "->"):
+			p.tokens = append(p.tokens, s[i:i+2])
+			i += 2
+		case strings.ContainsRune("()[],.!", rune(c)):
+			p.tokens = append(p.tokens, string(c))
+			i++
+		case c == '"':
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				return fmt.Errorf("unterminated string")
+			}
+			p.tokens = append(p.tokens, s[i:j+1])
+			i = j + 1
+		case isIdentRune(rune(c)):
+			j := i
+			for j < len(s) && (isIdentRune(rune(s[j])) || (s[j] == '-' && !strings.HasPrefix(s[j:], "->"))) {
+				j++
+			}
+			p.tokens = append(p.tokens, s[i:j])
+			i = j
+		default:
+			return fmt.Errorf("unexpected %q", c)
+		}
+	}
+	return nil
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func (p *ruleParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *ruleParser) next() string {
+	tok := p.peek()
+	if tok != "" {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *ruleParser) accept(tok string) bool {
+	if p.peek() == tok {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *ruleParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid rule %q: %s", p.source, fmt.Sprintf(format, args...))
+}
+
+func (p *ruleParser) parseOr() (condition, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orCondition{left, right}
+	}
+	return left, nil
+}
+
+func (p *ruleParser) parseAnd() (condition, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andCondition{left, right}
+	}
+	return left, nil
+}
+
+func (p *ruleParser) parseUnary() (condition, error) {
+	if p.accept("!") {
+		c, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notCondition{c}, nil
+	}
+
+	if p.accept("(") {
+		c, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, p.errorf("expected )")
+		}
+		return c, nil
+	}
+
+	switch p.peek() {
+	case "true":
+		p.next()
+		return boolCondition(true), nil
+	case "false":
+		p.next()
+		return boolCondition(false), nil
+	}
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok := p.next(); tok {
+	case "==", "!=":
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return &compareCondition{left: left, right: right, equal: tok == "=="}, nil
+	case ".":
+		name := p.next()
+		method, ok := ruleMethods[name]
+		if !ok {
+			return nil, p.errorf("unknown method %q", name)
+		}
+		if !p.accept("(") {
+			return nil, p.errorf("expected ( after %s", name)
+		}
+		arg, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, p.errorf("expected ) after the argument of %s", name)
+		}
+		return &methodCondition{operand: left, method: method, arg: arg}, nil
+	default:
+		return nil, p.errorf("expected ==, != or a method call, got %q", tok)
+	}
+}
+
+func (p *ruleParser) parseOperand() (operand, error) {
+	tok := p.peek()
+	if strings.HasPrefix(tok, `"`) {
+		s, err := p.parseString()
+		return operand{literal: s}, err
+	}
+
+	field, ok := ruleFields[tok]
+	if !ok {
+		return operand{}, p.errorf("unknown field %q", tok)
+	}
+	p.next()
+	return operand{field: field}, nil
+}
+
+func (p *ruleParser) parseString() (string, error) {
+	tok := p.next()
+	if !strings.HasPrefix(tok, `"`) {
+		return "", p.errorf("expected a string, got %q", tok)
+	}
+
+	s, err := strconv.Unquote(tok)
+	if err != nil {
+		return "", p.errorf("invalid string %s", tok)
+	}
+	return s, nil
+}
+
+func (p *ruleParser) parseActions() (*EventActions, error) {
+	if !p.accept("[") {
+		return nil, p.errorf("expected [ after ->")
+	}
+
+	actions := &EventActions{}
+	if p.accept("]") {
+		return actions, nil
+	}
+	for {
+		if err := p.parseAction(actions); err != nil {
+			return nil, err
+		}
+		if p.accept("]") {
+			return actions, nil
+		}
+		if !p.accept(",") {
+			return nil, p.errorf("expected , or ]")
+		}
+	}
+}
+
+func (p *ruleParser) parseAction(actions *EventActions) error {
+	switch name := p.next(); name {
+	case ActionStatus:
+		actions.Status = true
+	case ActionTag:
+		actions.Tag = true
+	case ActionNotify:
+		actions.Notify = true
+	case ActionDeploy:
+		actions.Deploy = true
+	case ActionGitOps:
+		actions.GitOps = true
+	case ActionRelease:
+		actions.Release = true
+	case ActionSkip:
+		actions.Skip = true
+	case ActionPromote:
+		if !p.accept("(") {
+			return p.errorf("expected ( after promote")
+		}
+		tag := p.next()
+		if strings.HasPrefix(tag, `"`) {
+			unquoted, err := strconv.Unquote(tag)
+			if err != nil {
+				return p.errorf("invalid string %s", tag)
+			}
+			tag = unquoted
+		}
+		if tag == "" || tag == ")" {
+			return p.errorf("promote requires a tag")
+		}
+		if !p.accept(")") {
+			return p.errorf("expected ) after the promote tag")
+		}
+		actions.Promote = append(actions.Promote, tag)
+	default:
+		return p.errorf("unknown action %q", name)
+	}
+	return nil
+}
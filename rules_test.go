@@ -0,0 +1,156 @@
+package quayd
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseEventRule(t *testing.T) {
+	r, err := ParseEventRule(`repo.startsWith("infra/") && branch == "main" -> [status, tag, promote(staging)]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &EventActions{Status: true, Tag: true, Promote: []string{"staging"}}
+	if !reflect.DeepEqual(r.actions, want) {
+		t.Fatalf("Actions => %+v; want %+v", r.actions, want)
+	}
+
+	tests := []struct {
+		event *BuildEvent
+		match bool
+	}{
+		{&BuildEvent{Repo: "infra/terraform", Branch: "main"}, true},
+		{&BuildEvent{Repo: "infra/terraform", Branch: "feature"}, false},
+		{&BuildEvent{Repo: "remind101/acme-inc", Branch: "main"}, false},
+	}
+
+	for _, tt := range tests {
+		if got := r.Matches(tt.event); got != tt.match {
+			t.Fatalf("Matches(%+v) => %v; want %v", tt.event, got, tt.match)
+		}
+	}
+}
+
+func TestParseEventRule_Conditions(t *testing.T) {
+	e := &BuildEvent{Repo: "remind101/acme-inc", Branch: "feature/login", DefaultBranch: "master", State: "success"}
+
+	tests := []struct {
+		source string
+		match  bool
+	}{
+		{`true -> [skip]`, true},
+		{`branch == default_branch -> [skip]`, false},
+		{`branch != default_branch -> [skip]`, true},
+		{`!(state == "success") -> [skip]`, false},
+		{`repo.matches("remind101/*") && (branch.endsWith("login") || false) -> [skip]`, true},
+		{`branch.contains("/") && !repo.startsWith("infra/") -> [skip]`, true},
+	}
+
+	for _, tt := range tests {
+		r, err := ParseEventRule(tt.source)
+		if err != nil {
+			t.Fatalf("ParseEventRule(%q) => %v", tt.source, err)
+		}
+		if got := r.Matches(e); got != tt.match {
+			t.Fatalf("Matches(%q) => %v; want %v", tt.source, got, tt.match)
+		}
+	}
+}
+
+func TestParseEventRule_Invalid(t *testing.T) {
+	for _, source := range []string{
+		`owner == "remind101" -> [status]`,
+		`repo == "remind101/acme-inc" -> [status, launch]`,
+		`repo == "remind101/acme-inc" [status]`,
+		`repo == "remind101/acme-inc" -> [promote()]`,
+		`repo.endsWith(branch) -> [status]`,
+		`repo == "remind101/acme-inc -> [status]`,
+	} {
+		if _, err := ParseEventRule(source); err == nil {
+			t.Fatalf("Expected %q to be invalid", source)
+		}
+	}
+}
+
+func TestEventRules_JSON(t *testing.T) {
+	var rules EventRules
+	if err := json.Unmarshal([]byte(`["branch == \"main\" -> [status, tag]", "true -> [skip]"]`), &rules); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := rules.Actions(&BuildEvent{Branch: "main"}); !got.Tag || got.Skip {
+		t.Fatalf("Actions => %+v", got)
+	}
+	if got := rules.Actions(&BuildEvent{Branch: "feature"}); !got.Skip {
+		t.Fatalf("Actions => %+v; want skip", got)
+	}
+	if got := EventRules(nil).Actions(&BuildEvent{}); got != DefaultEventActions {
+		t.Fatalf("Actions => %+v; want the defaults", got)
+	}
+
+	raw, err := json.Marshal(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sources []string
+	if err := json.Unmarshal(raw, &sources); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{`branch == "main" -> [status, tag]`, `true -> [skip]`}; !reflect.DeepEqual(sources, want) {
+		t.Fatalf("JSON => %v; want %v", sources, want)
+	}
+}
+
+func TestHandle_EventRules(t *testing.T) {
+	r := &statusesRepository{}
+	registry := &MemoryRegistry{}
+	registry.Seed("infra/terraform", "test")
+	q := &Quayd{
+		StatusesRepository: r,
+		TagResolver:        registry,
+		Tagger:             registry,
+	}
+	for _, source := range []string{
+		`branch == "skip" -> [skip]`,
+		`branch == "notag" -> [status]`,
+		`repo.startsWith("infra/") && branch == "main" -> [status, tag, promote(staging)]`,
+	} {
+		rule, err := ParseEventRule(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q.EventRules = append(q.EventRules, rule)
+	}
+	ctx := context.Background()
+
+	if err := q.Handle(ctx, &BuildEvent{Repo: "infra/terraform", Ref: "a", Branch: "skip", State: "success", Tags: []string{"test"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.statuses) != 0 {
+		t.Fatal("Expected no commit status for a skipped build")
+	}
+
+	if err := q.Handle(ctx, &BuildEvent{Repo: "infra/terraform", Ref: "b", Branch: "notag", State: "success", Tags: []string{"test"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.statuses) != 1 || r.statuses[0].Image != nil {
+		t.Fatalf("Expected a commit status without an image, got %+v", r.statuses)
+	}
+	if _, err := registry.Resolve(ctx, "infra/terraform", "long-b"); err != ErrTagNotFound {
+		t.Fatal("Expected the image not to be tagged")
+	}
+
+	if err := q.Handle(ctx, &BuildEvent{Repo: "infra/terraform", Ref: "c", Branch: "main", State: "success", Tags: []string{"test"}}); err != nil {
+		t.Fatal(err)
+	}
+	built, err := registry.Resolve(ctx, "infra/terraform", "long-c")
+	if err != nil {
+		t.Fatal("Expected the image to be tagged")
+	}
+	if staging, _ := registry.Resolve(ctx, "infra/terraform", "staging"); staging != built {
+		t.Fatal("Expected the image to be promoted to staging")
+	}
+}
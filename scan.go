@@ -0,0 +1,209 @@
+package quayd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// SecurityScanContext is the commit status context for security scan results.
+const SecurityScanContext = "Image Security Scan"
+
+// Severities are the severities that Clair assigns to vulnerabilities, from
+// least to most severe.
+var Severities = []string{"Unknown", "Negligible", "Low", "Medium", "High", "Critical", "Defcon1"}
+
+// severity returns the rank of the severity in Severities, ignoring case.
+// Unrecognized severities rank as Unknown.
+func severity(s string) int {
+	for i, sev := range Severities {
+		if strings.EqualFold(s, sev) {
+			return i
+		}
+	}
+	return 0
+}
+
+// Vulnerability is a vulnerability that Clair found in an image.
+type Vulnerability struct {
+	ID          string `json:"id"`
+	Description string `json:"description"`
+	Link        string `json:"link"`
+	Priority    string `json:"priority"`
+	HasFix      bool   `json:"has_fix"`
+}
+
+// SecurityScanForm is the payload of a Quay security scan notification. Quay
+// sends a `vulnerability_found` notification for each vulnerability; a
+// notification with Vulnerabilities is the complete result of a scan, which
+// replaces what was found before.
+type SecurityScanForm struct {
+	Repository string   `json:"repository"`
+	Homepage   string   `json:"homepage"`
+	Tags       []string `json:"tags"`
+
+	Vulnerability   *Vulnerability   `json:"vulnerability"`
+	Vulnerabilities []*Vulnerability `json:"vulnerabilities"`
+}
+
+// SecurityScans collects the vulnerabilities found in images, and reports
+// them as an "Image Security Scan" commit status on the commit that the image
+// was built from.
+type SecurityScans struct {
+	// FailSeverity, if set, fails the status when more than Threshold
+	// vulnerabilities of this severity or higher are found (e.g. "High").
+	FailSeverity string `json:"fail_severity"`
+	Threshold    int    `json:"threshold"`
+
+	mu     sync.Mutex
+	images map[string]map[string]*Vulnerability
+}
+
+// record adds the vulnerabilities found in the image to what was found
+// before, or replaces it when the scan is complete, and returns all of them.
+func (s *SecurityScans) record(image string, vulns []*Vulnerability, complete bool) []*Vulnerability {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.images == nil {
+		s.images = make(map[string]map[string]*Vulnerability)
+	}
+	if s.images[image] == nil || complete {
+		s.images[image] = make(map[string]*Vulnerability)
+	}
+	for _, v := range vulns {
+		s.images[image][v.ID] = v
+	}
+
+	all := make([]*Vulnerability, 0, len(s.images[image]))
+	for _, v := range s.images[image] {
+		all = append(all, v)
+	}
+	return all
+}
+
+// Status returns the state and description of the status for the
+// vulnerabilities, like "1 Critical, 2 High (3 total)".
+func (s *SecurityScans) Status(vulns []*Vulnerability) (state, description string) {
+	if len(vulns) == 0 {
+		return "success", "No vulnerabilities found"
+	}
+
+	counts := make([]int, len(Severities))
+	var failing int
+	for _, v := range vulns {
+		sev := severity(v.Priority)
+		counts[sev]++
+		if s.FailSeverity != "" && sev >= severity(s.FailSeverity) {
+			failing++
+		}
+	}
+
+	var parts []string
+	for i := len(Severities) - 1; i >= 0; i-- {
+		if counts[i] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[i], Severities[i]))
+		}
+	}
+
+	state = "success"
+	if s.FailSeverity != "" && failing > s.Threshold {
+		state = "failure"
+	}
+	return state, fmt.Sprintf("%s (%d total)", strings.Join(parts, ", "), len(vulns))
+}
+
+// ReportScan records the vulnerabilities in the notification, and creates the
+// security scan status on the commit that the scanned image was built from.
+// Images that weren't tagged with a commit sha are ignored.
+func (q *Quayd) ReportScan(ctx context.Context, form *SecurityScanForm) (*Status, error) {
+	if q.SecurityScans == nil {
+		return nil, errors.New("security scan reporting is not enabled")
+	}
+
+	var sha string
+	for _, tag := range form.Tags {
+		if fullSha.MatchString(tag) {
+			sha = tag
+		}
+	}
+	if sha == "" {
+		return nil, nil
+	}
+
+	vulns, complete := form.Vulnerabilities, form.Vulnerabilities != nil
+	if form.Vulnerability != nil {
+		vulns = append(vulns, form.Vulnerability)
+	}
+	vulns = q.SecurityScans.record(form.Repository+"@"+sha, vulns, complete)
+
+	q = q.forTenant(form.Repository)
+	githubRepo, err := q.githubRepo(form.Repository)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &Status{
+		Repo:      githubRepo,
+		Ref:       sha,
+		Context:   SecurityScanContext,
+		TargetURL: form.Homepage,
+	}
+	status.State, status.Description = q.SecurityScans.Status(vulns)
+
+	if err := q.statusesRepository().Create(ctx, status); err != nil {
+		return nil, err
+	}
+	q.logger().Log(ctx, "security scan reported", "repo", githubRepo, "sha", sha, "state", status.State, "vulnerabilities", len(vulns))
+
+	return status, nil
+}
+
+// SecurityScanHandler is an http.Handler that handles Quay security scan
+// notifications.
+type SecurityScanHandler struct {
+	*Quayd
+}
+
+func (h *SecurityScanHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p, err := h.readPayload(r, nil)
+	if err != nil {
+		payloadError(w, err)
+		return
+	}
+	defer p.release()
+	body := p.Bytes()
+
+	if err := h.WebhookValidators.Validate("scan", r, body); err != nil {
+		http.Error(w, err.Error(), 401)
+		return
+	}
+
+	if h.SecurityScans == nil {
+		http.Error(w, "security scan reporting is not enabled", 404)
+		return
+	}
+
+	var form SecurityScanForm
+	if err := json.Unmarshal(body, &form); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	status, err := h.ReportScan(r.Context(), &form)
+	if err != nil {
+		h.errorResponse(w, r, err)
+		return
+	}
+	if status == nil {
+		w.WriteHeader(204)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,72 @@
+package quayd
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const scannedSha = "f1fb3b0c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f80"
+
+func TestSecurityScanHandler(t *testing.T) {
+	r := &statusesRepository{}
+	q := &Quayd{
+		StatusesRepository: r,
+		SecurityScans:      &SecurityScans{FailSeverity: "High", Threshold: 1},
+	}
+	s := NewServer(q)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/quay/scan", bytes.NewBufferString(body))
+		s.ServeHTTP(resp, req)
+		return resp
+	}
+
+	found := func(id, priority string) string {
+		return `{"repository":"remind101/acme-inc","homepage":"https://quay.io/repository/remind101/acme-inc","tags":["latest","` + scannedSha + `"],"vulnerability":{"id":"` + id + `","priority":"` + priority + `"}}`
+	}
+
+	tests := []struct {
+		body, state, description string
+	}{
+		{found("CVE-2021-44228", "Critical"), "success", "1 Critical (1 total)"},
+		{found("CVE-2020-0001", "Low"), "success", "1 Critical, 1 Low (2 total)"},
+		{found("CVE-2020-0002", "High"), "failure", "1 Critical, 1 High, 1 Low (3 total)"},
+
+		// A complete scan replaces the vulnerabilities found before.
+		{`{"repository":"remind101/acme-inc","tags":["` + scannedSha + `"],"vulnerabilities":[]}`, "success", "No vulnerabilities found"},
+	}
+
+	for i, tt := range tests {
+		if resp := post(tt.body); resp.Code != 200 {
+			t.Fatalf("Status => %d; want 200: %s", resp.Code, resp.Body.String())
+		}
+
+		st := r.statuses[i]
+		if st.Context != SecurityScanContext || st.Ref != scannedSha {
+			t.Fatalf("Unexpected status %+v", st)
+		}
+		if st.State != tt.state || st.Description != tt.description {
+			t.Fatalf("Status => %s %q; want %s %q", st.State, st.Description, tt.state, tt.description)
+		}
+	}
+
+	// Images that weren't built from a commit are ignored.
+	if resp := post(`{"repository":"remind101/acme-inc","tags":["latest"]}`); resp.Code != 204 {
+		t.Fatalf("Status => %d; want 204", resp.Code)
+	}
+}
+
+func TestSecurityScanHandler_Disabled(t *testing.T) {
+	s := NewServer(&Quayd{StatusesRepository: &statusesRepository{}})
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/quay/scan", bytes.NewBufferString(`{}`))
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 404; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+}
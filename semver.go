@@ -0,0 +1,271 @@
+package quayd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+	"text/template"
+)
+
+// DefaultSemVerRules are the floating tags applied for a release: the major
+// version, the minor version, and latest-stable.
+var DefaultSemVerRules = []string{"{{.Major}}", "{{.Major}}.{{.Minor}}", "latest-stable"}
+
+// ErrImmutableTag is returned when a version tag already points at a
+// different image. Version tags are never repointed.
+var ErrImmutableTag = errors.New("version tag already points at a different image")
+
+var semverRegexp = regexp.MustCompile(`^v?(\d+)\.(\d+)\.(\d+)(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+// SemVer is a semantic version.
+type SemVer struct {
+	Major, Minor, Patch int
+	Prerelease          string
+}
+
+// ParseSemVer parses a semantic version, like v1.2.3 or 1.2.3-rc.1.
+func ParseSemVer(s string) (*SemVer, bool) {
+	m := semverRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return nil, false
+	}
+
+	v := &SemVer{Prerelease: m[4]}
+	v.Major, _ = strconv.Atoi(m[1])
+	v.Minor, _ = strconv.Atoi(m[2])
+	v.Patch, _ = strconv.Atoi(m[3])
+	return v, true
+}
+
+// String returns the version without a leading "v".
+func (v *SemVer) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	return s
+}
+
+// Less reports whether v is an earlier release than o. Prerelease ordering
+// is compared lexically.
+func (v *SemVer) Less(o *SemVer) bool {
+	switch {
+	case v.Major != o.Major:
+		return v.Major < o.Major
+	case v.Minor != o.Minor:
+		return v.Minor < o.Minor
+	case v.Patch != o.Patch:
+		return v.Patch < o.Patch
+	case v.Prerelease == o.Prerelease:
+		return false
+	case v.Prerelease == "":
+		return false
+	case o.Prerelease == "":
+		return true
+	}
+	return v.Prerelease < o.Prerelease
+}
+
+// TagStrategy derives the floating tags, besides the exact version, that point
+// at the latest release of a repo. Teams can implement it to customize the
+// tags their deploy tooling relies on.
+type TagStrategy interface {
+	FloatingTags(repo string, v *SemVer) ([]string, error)
+}
+
+// TagStrategyFunc is a function that implements the TagStrategy interface.
+type TagStrategyFunc func(repo string, v *SemVer) ([]string, error)
+
+// FloatingTags implements TagStrategy FloatingTags.
+func (fn TagStrategyFunc) FloatingTags(repo string, v *SemVer) ([]string, error) {
+	return fn(repo, v)
+}
+
+// TemplateTagStrategy is a TagStrategy that renders each template with the
+// SemVer, e.g. `{{.Major}}.{{.Minor}}`.
+type TemplateTagStrategy []string
+
+// FloatingTags implements TagStrategy FloatingTags.
+func (s TemplateTagStrategy) FloatingTags(repo string, v *SemVer) ([]string, error) {
+	var tags []string
+	for _, rule := range s {
+		tmpl, err := template.New("tag").Parse(rule)
+		if err != nil {
+			return nil, err
+		}
+
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, v); err != nil {
+			return nil, err
+		}
+		tags = append(tags, buf.String())
+	}
+	return tags, nil
+}
+
+// LatestTagStrategy points the minor version, major version and `latest`
+// tags (1.4, 1, latest) at each release.
+var LatestTagStrategy = TemplateTagStrategy{"{{.Major}}.{{.Minor}}", "{{.Major}}", "latest"}
+
+// TagLister is implemented by TagResolvers that can list the tags of a repo.
+type TagLister interface {
+	// Tags returns the tags of repo.
+	Tags(ctx context.Context, repo string) ([]string, error)
+}
+
+// SemVerTags applies floating tags to images built from semver git tags. The
+// exact version tag is immutable, and floating tags only move forward: a
+// patch release of an older minor version doesn't repoint the major version
+// tag. Prereleases only get the exact version tag.
+//
+// When the TagResolver is a TagLister, the version that a floating tag points
+// at is worked out from the version tags in the registry, so it's right
+// across restarts and between instances. Otherwise it's remembered in memory.
+type SemVerTags struct {
+	// Strategy derives the floating tags to apply. Defaults to a
+	// TemplateTagStrategy of Rules.
+	Strategy TagStrategy
+
+	// Rules are templates, rendered with the SemVer, for the floating tags
+	// to apply when there's no Strategy. Defaults to DefaultSemVerRules.
+	Rules []string
+
+	mu sync.Mutex
+	// current is the version that each floating tag points at, when the
+	// registry's tags can't be listed.
+	current map[string]*SemVer
+}
+
+// Apply tags the image with the exact version, then repoints the floating
+// tags. Nothing is tagged if the exact version tag already points at a
+// different image. It returns the tags that were applied.
+func (s *SemVerTags) Apply(ctx context.Context, resolver TagResolver, tagger Tagger, repo, imageID string, v *SemVer) ([]string, error) {
+	return s.apply(ctx, resolver, tagger, repo, imageID, v, true)
+}
+
+// ApplyExact tags the image with the exact version only, leaving the floating
+// tags where they are.
+func (s *SemVerTags) ApplyExact(ctx context.Context, resolver TagResolver, tagger Tagger, repo, imageID string, v *SemVer) ([]string, error) {
+	return s.apply(ctx, resolver, tagger, repo, imageID, v, false)
+}
+
+func (s *SemVerTags) apply(ctx context.Context, resolver TagResolver, tagger Tagger, repo, imageID string, v *SemVer, float bool) ([]string, error) {
+	var floating []string
+	if float && v.Prerelease == "" {
+		var err error
+		if floating, err = s.strategy().FloatingTags(repo, v); err != nil {
+			return nil, err
+		}
+	}
+
+	exact := v.String()
+	existing, err := resolver.Resolve(ctx, repo, exact)
+	if err != nil && !tagNotFound(err) {
+		return nil, err
+	}
+	if err == nil && existing != "" && existing != imageID {
+		return nil, ErrImmutableTag
+	}
+
+	if err := tagger.Tag(ctx, repo, imageID, exact); err != nil {
+		return nil, err
+	}
+	applied := []string{exact}
+
+	current, err := s.released(ctx, resolver, repo, floating)
+	if err != nil {
+		return applied, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.current == nil {
+		s.current = make(map[string]*SemVer)
+	}
+
+	for _, tag := range floating {
+		key := repo + ":" + tag
+		latest, ok := current[tag]
+		if current == nil {
+			latest, ok = s.current[key]
+		}
+		if ok && v.Less(latest) {
+			continue
+		}
+
+		if err := tagger.Tag(ctx, repo, imageID, tag); err != nil {
+			return applied, err
+		}
+		s.current[key] = v
+		applied = append(applied, tag)
+	}
+
+	return applied, nil
+}
+
+// released returns the latest released version, according to the version
+// tags of repo in the registry, that each of the floating tags should point
+// at. It returns nil if the resolver can't list tags.
+func (s *SemVerTags) released(ctx context.Context, resolver TagResolver, repo string, floating []string) (map[string]*SemVer, error) {
+	l, ok := resolver.(TagLister)
+	if !ok || len(floating) == 0 {
+		return nil, nil
+	}
+
+	tags, err := l.Tags(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	want := make(map[string]bool)
+	for _, tag := range floating {
+		want[tag] = true
+	}
+
+	current := make(map[string]*SemVer)
+	for _, t := range tags {
+		v, ok := ParseSemVer(t)
+		if !ok || v.Prerelease != "" || t != v.String() {
+			continue
+		}
+
+		vtags, err := s.strategy().FloatingTags(repo, v)
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range vtags {
+			if latest, ok := current[tag]; want[tag] && (!ok || latest.Less(v)) {
+				current[tag] = v
+			}
+		}
+	}
+
+	return current, nil
+}
+
+func (s *SemVerTags) strategy() TagStrategy {
+	if s.Strategy != nil {
+		return s.Strategy
+	}
+
+	if len(s.Rules) == 0 {
+		return TemplateTagStrategy(DefaultSemVerRules)
+	}
+
+	return TemplateTagStrategy(s.Rules)
+}
+
+// tagNotFound reports whether err means that a tag doesn't exist.
+func tagNotFound(err error) bool {
+	if err == ErrTagNotFound {
+		return true
+	}
+
+	e, ok := err.(*HTTPError)
+	return ok && e.StatusCode == 404
+}
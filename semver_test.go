@@ -0,0 +1,130 @@
+package quayd
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestParseSemVer(t *testing.T) {
+	tests := []struct {
+		in   string
+		want *SemVer
+	}{
+		{"v1.2.3", &SemVer{Major: 1, Minor: 2, Patch: 3}},
+		{"1.2.3-rc.1", &SemVer{Major: 1, Minor: 2, Patch: 3, Prerelease: "rc.1"}},
+		{"1.2", nil},
+		{"master", nil},
+	}
+
+	for _, tt := range tests {
+		got, _ := ParseSemVer(tt.in)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("ParseSemVer(%q) => %+v; want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSemVerTags(t *testing.T) {
+	r := &MemoryRegistry{}
+	s := &SemVerTags{}
+	ctx := context.Background()
+
+	apply := func(version, imageID string) ([]string, error) {
+		v, _ := ParseSemVer(version)
+		return s.Apply(ctx, r, r, "remind101/acme-inc", imageID, v)
+	}
+
+	tags, err := apply("v1.2.0", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tags, []string{"1.2.0", "1", "1.2", "latest-stable"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Tags => %v; want %v", got, want)
+	}
+
+	// A patch release of an older minor version only moves its own minor
+	// version tag.
+	tags, err = apply("v1.1.5", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tags, []string{"1.1.5", "1.1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Tags => %v; want %v", got, want)
+	}
+
+	if got, _ := r.Resolve(ctx, "remind101/acme-inc", "1"); got != "a" {
+		t.Fatalf("Expected 1 to still point at a, got %s", got)
+	}
+
+	// Version tags are immutable.
+	if _, err := apply("v1.2.0", "c"); err != ErrImmutableTag {
+		t.Fatalf("err => %v; want %v", err, ErrImmutableTag)
+	}
+
+	// Prereleases only get the version tag.
+	tags, err = apply("v2.0.0-rc.1", "d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tags, []string{"2.0.0-rc.1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Tags => %v; want %v", got, want)
+	}
+}
+
+func TestSemVerTags_Restart(t *testing.T) {
+	r := &MemoryRegistry{}
+	ctx := context.Background()
+
+	apply := func(s *SemVerTags, version, imageID string) ([]string, error) {
+		v, _ := ParseSemVer(version)
+		return s.Apply(ctx, r, r, "remind101/acme-inc", imageID, v)
+	}
+
+	if _, err := apply(&SemVerTags{}, "v1.2.0", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new instance works out that 1 points at 1.2.0 from the registry.
+	tags, err := apply(&SemVerTags{}, "v1.1.5", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tags, []string{"1.1.5", "1.1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Tags => %v; want %v", got, want)
+	}
+
+	if got, _ := r.Resolve(ctx, "remind101/acme-inc", "1"); got != "a" {
+		t.Fatalf("Expected 1 to still point at a, got %s", got)
+	}
+}
+
+func TestSemVerTags_Strategy(t *testing.T) {
+	r := &MemoryRegistry{}
+	ctx := context.Background()
+	v, _ := ParseSemVer("v1.4.2")
+
+	s := &SemVerTags{Strategy: LatestTagStrategy}
+	tags, err := s.Apply(ctx, r, r, "remind101/acme-inc", "a", v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tags, []string{"1.4.2", "1.4", "1", "latest"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Tags => %v; want %v", got, want)
+	}
+
+	// Strategies can derive tags per repo.
+	s = &SemVerTags{Strategy: TagStrategyFunc(func(repo string, v *SemVer) ([]string, error) {
+		if repo == "remind101/acme-inc" {
+			return []string{"stable"}, nil
+		}
+		return nil, nil
+	})}
+	tags, err = s.Apply(ctx, r, r, "remind101/acme-inc", "a", v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tags, []string{"1.4.2", "stable"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Tags => %v; want %v", got, want)
+	}
+}
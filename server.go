@@ -1,9 +1,15 @@
 package quayd
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/codegangsta/negroni"
 	"github.com/gorilla/mux"
@@ -11,23 +17,220 @@ import (
 
 var validStatuses = []string{"pending", "success", "error", "failure"}
 
+// QuayEvents maps the `event` field of a Quay build notification to the
+// GitHub commit status state that it reports.
+var QuayEvents = map[string]string{
+	"build_queued":    "pending",
+	"build_start":     "pending",
+	"build_success":   "success",
+	"build_failure":   "failure",
+	"build_cancelled": "error",
+}
+
 type Server struct {
 	http.Handler
+
+	// router holds the http.Handler for the current Quayd instance.
+	router atomic.Value
+
+	// quayd holds the current Quayd instance.
+	quayd atomic.Value
+
+	mu         sync.Mutex
+	middleware []func(http.Handler) http.Handler
+	draining   bool
+	inflight   sync.WaitGroup
+	closing    chan struct{}
+	drained    chan struct{}
 }
 
+// DefaultQuayPath is the path of the Quay webhook routes when
+// Quayd.QuayPath isn't set.
+const DefaultQuayPath = "/quay"
+
 func NewServer(q *Quayd) *Server {
+	s := &Server{closing: make(chan struct{}), drained: make(chan struct{})}
+	s.Reload(q)
+
+	n := negroni.Classic()
+	n.UseHandler(http.HandlerFunc(s.route))
+	s.Handler = n
+
+	return s
+}
+
+// Quayd returns the Quayd instance that handles new requests.
+func (s *Server) Quayd() *Quayd {
+	return s.quayd.Load().(*Quayd)
+}
+
+// Reload atomically replaces the Quayd instance that handles new requests.
+// Requests that are in flight finish with the instance they started with.
+func (s *Server) Reload(q *Quayd) {
 	if q == nil {
 		q = Default
 	}
 
-	m := mux.NewRouter()
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	m.Handle("/quay/{status}", &Webhook{q}).Methods("POST")
+	s.router.Store(s.wrap(NewRouter(q)))
+	s.quayd.Store(q)
+}
 
-	n := negroni.Classic()
-	n.UseHandler(m)
+// Use adds middleware, like authentication or tracing, that wraps every
+// route. Middleware runs in the order that it was added, after the server's
+// logging and recovery, and keeps applying when the Quayd is reloaded.
+func (s *Server) Use(middleware ...func(http.Handler) http.Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	return &Server{n}
+	s.middleware = append(s.middleware, middleware...)
+	s.router.Store(s.wrap(NewRouter(s.Quayd())))
+}
+
+// wrap wraps h in the middleware, so that the first middleware added is the
+// outermost. The result is always an http.HandlerFunc, since every value
+// stored in router must have the same type.
+func (s *Server) wrap(h http.Handler) http.HandlerFunc {
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		h = s.middleware[i](h)
+	}
+	return h.ServeHTTP
+}
+
+// NewRouter returns an http.Handler that routes requests to the handlers of
+// q, under q.BasePath. Unlike a Server, it has no middleware, and can't be
+// reloaded or shut down, so it can be mounted in another server's routes.
+func NewRouter(q *Quayd) http.Handler {
+	if q == nil {
+		q = Default
+	}
+
+	root := mux.NewRouter()
+	m := root
+	if base := strings.TrimSuffix(q.BasePath, "/"); base != "" {
+		m = root.PathPrefix(base).Subrouter()
+	}
+
+	// The admin endpoints require AdminToken.
+	admin := q.AdminAuth
+
+	// Only Quay's webhooks are limited by the IP allowlist, since the
+	// other webhooks come from other networks.
+	quay := q.quayPath()
+	m.Handle(quay, q.IPAllowlist.Handler(&Webhook{q})).Methods("POST")
+	m.Handle(quay+"/scan", q.IPAllowlist.Handler(&SecurityScanHandler{q})).Methods("POST")
+	m.Handle(quay+"/{status}", q.IPAllowlist.Handler(&Webhook{q})).Methods("POST")
+	m.Handle("/dockerhub", &DockerHubWebhook{q}).Methods("POST")
+	m.Handle("/github", &GitHubWebhook{q}).Methods("POST")
+	m.Handle("/trigger/{namespace}/{name}", admin(&TriggerHandler{q})).Methods("POST")
+	m.Handle("/metrics", &MetricsHandler{q}).Methods("GET")
+	m.Handle("/statusz", &StatuszHandler{q}).Methods("GET")
+	m.Handle("/events/stream", admin(&StreamHandler{q})).Methods("GET")
+	m.Handle("/admin/deliveries", admin(&DeliveriesHandler{q})).Methods("GET")
+	m.Handle("/admin/deliveries/{id}/timeline", admin(&TimelineHandler{q})).Methods("GET")
+	m.Handle("/admin/attempts/{namespace}/{name}/{ref}", admin(&AttemptsHandler{q})).Methods("GET")
+	m.Handle("/admin/replay/{id}", admin(&ReplayHandler{q})).Methods("POST")
+	m.Handle("/admin/quarantine", admin(&QuarantineHandler{q})).Methods("GET", "POST")
+	m.Handle("/admin/cleanup", admin(&CleanupHandler{q})).Methods("POST")
+	m.Handle("/admin/ignored", admin(&IgnoredHandler{q})).Methods("GET")
+	m.Handle("/admin/pauses", admin(&PausesHandler{q})).Methods("GET")
+	m.Handle("/admin/pauses/{namespace}/{name}", admin(&PauseHandler{q})).Methods("POST", "DELETE")
+	m.Handle("/admin/canary", admin(&CanaryHandler{q})).Methods("GET")
+	m.Handle("/admin/sla", admin(&SLAHandler{q})).Methods("GET")
+	m.Handle("/admin/promotions", admin(&PromotionsHandler{q})).Methods("GET", "POST")
+	m.Handle("/admin/costs", admin(&CostsHandler{q})).Methods("GET")
+	m.Handle("/admin/socket", admin(&AdminSocketHandler{q})).Methods("GET")
+	m.Handle("/slack/actions", &SlackHandler{q}).Methods("POST")
+	m.Handle("/supply-chain/{owner}/{repo}/{sha}/{step}", &SupplyChainHandler{q}).Methods("POST")
+
+	return root
+}
+
+// Shutdown stops accepting new requests, waits for the requests that are in
+// flight, then drains the queue, waiting for the events that are already
+// queued to be handled. Long lived streams are closed. If ctx expires first,
+// the events being handled are cancelled, Shutdown returns its error, and the
+// remaining events are left on the queue (where Queue.Spill can save them).
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.draining {
+		s.draining = true
+		close(s.closing)
+		go s.drain()
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-s.drained:
+		return nil
+	case <-ctx.Done():
+		if q := s.Quayd(); q.Queue != nil {
+			q.Queue.Cancel()
+		}
+		return ctx.Err()
+	}
+}
+
+// drain waits for in flight requests, then for the queue, then creates the
+// statuses that are held (e.g. until the GitHub quota resets), and opens the
+// GitOps pull requests that are still batched.
+func (s *Server) drain() {
+	s.inflight.Wait()
+	q := s.Quayd()
+	if q.Queue != nil {
+		q.Queue.Stop()
+	}
+	if f, ok := q.StatusesRepository.(statusFlusher); ok {
+		if err := f.Flush(context.Background()); err != nil {
+			q.logger().Log(context.Background(), "flushing held statuses failed", "error", err)
+		}
+	}
+	if err := q.GitOps.Flush(context.Background()); err != nil {
+		q.logger().Log(context.Background(), "flushing gitops pull requests failed", "error", err)
+	}
+	close(s.drained)
+}
+
+// statusFlusher is implemented by StatusesRepositories that hold statuses,
+// like RateLimitedStatusesRepository.
+type statusFlusher interface {
+	Flush(ctx context.Context) error
+}
+
+func (s *Server) route(w http.ResponseWriter, r *http.Request) {
+	if !s.begin() {
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, "Server is shutting down", 503)
+		return
+	}
+	defer s.inflight.Done()
+
+	r = r.WithContext(context.WithValue(r.Context(), closingKey{}, s.closing))
+	s.router.Load().(http.Handler).ServeHTTP(w, r)
+}
+
+// begin tracks a new request, unless the server is shutting down.
+func (s *Server) begin() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.draining {
+		return false
+	}
+	s.inflight.Add(1)
+	return true
+}
+
+type closingKey struct{}
+
+// closing returns a channel that's closed when the server that's handling
+// the request shuts down. Handlers that stream indefinitely should return
+// when it's closed, so they don't hold up the shutdown.
+func closing(ctx context.Context) <-chan struct{} {
+	c, _ := ctx.Value(closingKey{}).(chan struct{})
+	return c
 }
 
 type Webhook struct {
@@ -35,48 +238,269 @@ type Webhook struct {
 }
 
 type WebhookForm struct {
+	Event       string   `json:"event"`
+	BuildID     string   `json:"build_id"`
 	Repository  string   `json:"repository"`
 	TriggerKind string   `json:"trigger_kind"`
 	IsManual    bool     `json:"is_manual"`
 	DockerTags  []string `json:"docker_tags"`
 	BuildName   string   `json:"build_name"`
 	BuildURL    string   `json:"homepage"`
+	MediaType   string   `json:"media_type"`
+	StartedAt   int64    `json:"started_at"`
+	CompletedAt int64    `json:"completed_at"`
+
+	// ErrorMessage is set on build_failure notifications.
+	ErrorMessage string `json:"error_message"`
+
+	TriggerMetadata struct {
+		Commits       []string `json:"commits"`
+		Ref           string   `json:"ref"`
+		DefaultBranch string   `json:"default_branch"`
+
+		// Commit and GitURL are set by custom git triggers.
+		Commit string `json:"commit"`
+		GitURL string `json:"git_url"`
+	} `json:"trigger_metadata"`
+}
+
+// Validate returns a *PayloadError if the payload is missing fields that
+// quayd needs, or has fields with invalid values.
+func (f *WebhookForm) Validate() error {
+	var fields []*FieldError
+	invalid := func(field, message string) {
+		fields = append(fields, &FieldError{Field: field, Message: message})
+	}
+
+	if parts := strings.Split(f.Repository, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		invalid("repository", "must be a repository, like namespace/name")
+	}
+	if f.TriggerKind == "github" && f.BuildName == "" {
+		invalid("build_name", "is required for builds triggered from GitHub")
+	}
+	if f.TriggerKind == TriggerCustomGit && f.TriggerMetadata.GitURL == "" {
+		invalid("trigger_metadata.git_url", "is required for builds triggered from a custom git remote")
+	}
+	for _, tag := range f.DockerTags {
+		if tag == "" {
+			invalid("docker_tags", "must not contain empty tags")
+			break
+		}
+	}
+	if f.StartedAt < 0 {
+		invalid("started_at", "must not be negative")
+	}
+	if f.CompletedAt < 0 {
+		invalid("completed_at", "must not be negative")
+	}
+	if f.BuildURL != "" {
+		if u, err := url.Parse(f.BuildURL); err != nil || !u.IsAbs() {
+			invalid("homepage", "must be an absolute URL")
+		}
+	}
+
+	if len(fields) > 0 {
+		return &PayloadError{Code: "invalid_payload", Message: "the payload is invalid", Fields: fields}
+	}
+	return nil
 }
 
 func (wh *Webhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	status := vars["status"]
-	if !validStatus(status) {
-		http.Error(w, "Invalid status: "+status, 400)
+	id, received := newID(), time.Now()
+	w.Header().Set("X-Delivery-ID", id)
+
+	start := time.Now()
+	var form WebhookForm
+	p, err := wh.readPayload(r, &form)
+	if err != nil {
+		payloadError(w, err)
 		return
 	}
+	defer p.release()
+	body := p.Bytes()
 
-	var form WebhookForm
+	// Nothing is recorded about a webhook until it's been validated, so
+	// unauthenticated requests can't fill the timelines or ignored events.
+	// When the state isn't part of the path, it's determined by the event
+	// in the notification.
+	status := mux.Vars(r)["status"]
+	endpoint := status
+	if endpoint == "" {
+		endpoint, _ = formStatus(form.Event)
+	}
+	if err := wh.WebhookValidators.Validate(endpoint, r, body); err != nil {
+		http.Error(w, err.Error(), 401)
+		return
+	}
 
-	if err := json.NewDecoder(r.Body).Decode(&form); err != nil {
-		errorResponse(w, err)
+	wh.Timelines.Record(id, "received", received, nil)
+	wh.Timelines.Record(id, "parsed", start, nil)
+
+	if _, ok := wh.States[status]; status != "" && !ok && !validStatus(status) {
+		wh.ignore(r.Context(), &BuildEvent{ID: id, State: status}, IgnoredUnknownState)
+		payloadError(w, &PayloadError{Code: "invalid_status", Message: "Invalid status: " + status})
 		return
 	}
 
-	// We don't want to process manually triggered builds.
-	if !(!form.IsManual && form.TriggerKind == "github") {
+	if status == "" {
+		status, err = formStatus(form.Event)
+		if err != nil {
+			wh.ignore(r.Context(), &BuildEvent{ID: id, Repo: form.Repository, Ref: form.BuildName, State: form.Event}, IgnoredUnknownState)
+			payloadError(w, &PayloadError{Code: "unknown_event", Message: err.Error()})
+			return
+		}
+	}
+
+	e, err := wh.accept(r.Context(), id, status, r.URL.Query().Get("context"), &form, body, received)
+	if err != nil {
+		payloadError(w, err)
+		return
+	}
+	if e == nil {
 		w.WriteHeader(204)
 		return
 	}
 
-	if status == "success" {
-		if err := wh.Quayd.LoadImageTags(form.DockerTags[0], form.Repository, form.BuildName); err != nil {
-			errorResponse(w, err)
+	if wh.Queue != nil {
+		err := wh.Queue.Push(wh.Quayd, e)
+		wh.Dependencies.Observe(DependencyQueue, err)
+		if err != nil {
+			http.Error(w, err.Error(), 503)
 			return
 		}
+
+		w.WriteHeader(202)
+		return
 	}
-	if err := wh.Quayd.Handle(form.Repository, form.BuildName, form.BuildURL, status); err != nil {
-		errorResponse(w, err)
+
+	if err := wh.Quayd.Handle(r.Context(), e); err != nil {
+		wh.errorResponse(w, r, err)
 		return
 	}
 
 }
 
+// accept validates a decoded Quay notification, records its delivery, and
+// returns the BuildEvent to process. Webhooks and notifications from a
+// message queue are both accepted through here. It returns a nil BuildEvent,
+// after recording why, for builds that are ignored.
+func (q *Quayd) accept(ctx context.Context, id, status, statusContext string, form *WebhookForm, body []byte, received time.Time) (*BuildEvent, error) {
+	if err := form.Validate(); err != nil {
+		return nil, err
+	}
+
+	q.received(ctx, &Delivery{ID: id, Status: status, Context: statusContext, Payload: body, ReceivedAt: received})
+
+	// We don't want to process manually triggered builds.
+	e := buildEvent(id, status, form, q.Quay.Triggered)
+	if e == nil {
+		reason := IgnoredNotGitHub
+		if form.TriggerKind == "github" {
+			reason = IgnoredManualBuild
+		}
+		q.ignore(ctx, &BuildEvent{ID: id, Repo: form.Repository, Ref: form.BuildName, State: status}, reason)
+		return nil, nil
+	}
+	e.Context = statusContext
+	e.ReceivedAt = received
+
+	return e, nil
+}
+
+// newBuildEvent parses a Quay webhook payload into a BuildEvent. It returns a
+// nil BuildEvent for builds that shouldn't be processed. Manual builds are
+// only processed if triggered reports that quayd started them.
+func newBuildEvent(id, status string, body []byte, triggered func(buildID string) bool) (*BuildEvent, error) {
+	var form WebhookForm
+	if err := json.Unmarshal(body, &form); err != nil {
+		return nil, err
+	}
+
+	return buildEvent(id, status, &form, triggered), nil
+}
+
+// buildEvent returns the BuildEvent for a decoded Quay webhook payload, or
+// nil if the build shouldn't be processed.
+func buildEvent(id, status string, form *WebhookForm, triggered func(buildID string) bool) *BuildEvent {
+	custom := form.TriggerKind == TriggerCustomGit
+	if (form.TriggerKind != "github" && !custom) || (form.IsManual && !triggered(form.BuildID)) {
+		return nil
+	}
+
+	e := &BuildEvent{
+		ID:        id,
+		Repo:      form.Repository,
+		Ref:       form.BuildName,
+		URL:       form.BuildURL,
+		BuildID:   form.BuildID,
+		State:     status,
+		Error:     form.ErrorMessage,
+		Cancelled: form.Event == "build_cancelled",
+		Tags:      form.DockerTags,
+		MediaType: form.MediaType,
+		Commits:   form.TriggerMetadata.Commits,
+
+		DefaultBranch: form.TriggerMetadata.DefaultBranch,
+	}
+	if custom {
+		e.GitURL = form.TriggerMetadata.GitURL
+		if form.TriggerMetadata.Commit != "" {
+			e.Ref = form.TriggerMetadata.Commit
+		}
+	}
+	if ref := form.TriggerMetadata.Ref; strings.HasPrefix(ref, "refs/tags/") {
+		e.GitTag = strings.TrimPrefix(ref, "refs/tags/")
+	} else {
+		e.Branch = strings.TrimPrefix(ref, "refs/heads/")
+	}
+	if form.StartedAt > 0 {
+		e.StartedAt = time.Unix(form.StartedAt, 0)
+	}
+	if form.CompletedAt > 0 {
+		e.CompletedAt = time.Unix(form.CompletedAt, 0)
+	}
+
+	return e
+}
+
+// eventStatus returns the commit status state for the event in a Quay
+// notification.
+func eventStatus(body []byte) (string, error) {
+	var form struct {
+		Event string `json:"event"`
+	}
+	if err := json.Unmarshal(body, &form); err != nil {
+		return "", err
+	}
+
+	return formStatus(form.Event)
+}
+
+// formStatus returns the commit status state for a Quay notification event.
+func formStatus(event string) (string, error) {
+	status, ok := QuayEvents[event]
+	if !ok {
+		return "", fmt.Errorf("Unknown event: %s", event)
+	}
+
+	return status, nil
+}
+
+// WebhookPath returns the full path, including BasePath, that Quay should
+// POST webhooks for status to.
+func (q *Quayd) WebhookPath(status string) string {
+	return strings.TrimSuffix(q.BasePath, "/") + q.quayPath() + "/" + status
+}
+
+// quayPath returns the path of the Quay webhook routes, relative to BasePath.
+func (q *Quayd) quayPath() string {
+	if q.QuayPath == "" {
+		return DefaultQuayPath
+	}
+
+	return "/" + strings.Trim(q.QuayPath, "/")
+}
+
 func validStatus(a string) bool {
 	for _, b := range validStatuses {
 		if b == a {
@@ -86,7 +510,7 @@ func validStatus(a string) bool {
 	return false
 }
 
-func errorResponse(w http.ResponseWriter, err error) {
-	fmt.Println(err)
+func (q *Quayd) errorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	q.logger().Log(r.Context(), "request failed", "method", r.Method, "path", r.URL.Path, "error", err)
 	http.Error(w, err.Error(), 500)
 }
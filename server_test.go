@@ -2,11 +2,13 @@ package quayd
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -29,8 +31,8 @@ func TestWebhook(t *testing.T) {
 		fixture  string
 		expected Status
 	}{
-		{"pending", "pending_build", Status{Repo: "ejholmes/docker-statsd", Ref: "long-f1fb3b0", State: "pending", Context: "Docker Image", TargetURL: "https://quay.io/repository/ejholmes/docker-statsd/build?current=077f3664-35d3-48e6-9da7-889f9be73070", Description: "The Docker image is building"}},
-		{"success", "pending_build", Status{Repo: "ejholmes/docker-statsd", Ref: "long-f1fb3b0", State: "success", Context: "Docker Image", TargetURL: "https://quay.io/repository/ejholmes/docker-statsd/build?current=077f3664-35d3-48e6-9da7-889f9be73070", Description: "The Docker image was built"}},
+		{"pending", "pending_build", Status{Repo: "ejholmes/docker-statsd", Ref: "long-f1fb3b0", State: "pending", Context: "Docker Image", TargetURL: "https://quay.io/repository/ejholmes/docker-statsd/build/077f3664-35d3-48e6-9da7-889f9be73070", Description: "The Docker image is building"}},
+		{"success", "pending_build", Status{Repo: "ejholmes/docker-statsd", Ref: "long-f1fb3b0", State: "success", Context: "Docker Image", TargetURL: "https://quay.io/repository/ejholmes/docker-statsd/build/077f3664-35d3-48e6-9da7-889f9be73070", Description: "The Docker image was built", Image: &Image{Registry: "quay.io", Repo: "ejholmes/docker-statsd", Tags: []string{"test", "long-f1fb3b0", ""}}}},
 	}
 
 	for _, tt := range tests {
@@ -46,7 +48,7 @@ func TestWebhook(t *testing.T) {
 		}
 
 		if got, want := r.statuses[0], &tt.expected; !reflect.DeepEqual(got, want) {
-			t.Fatalf("Status => %q; want %q", got, want)
+			t.Fatalf("Status => %+v; want %+v", got, want)
 		}
 	}
 }
@@ -87,3 +89,335 @@ func TestWebhook_TagsImageID(t *testing.T) {
 
 	s.ServeHTTP(resp, req)
 }
+
+func TestWebhook_AllCommits(t *testing.T) {
+	r := &statusesRepository{}
+	s := NewServer(&Quayd{StatusesRepository: r, AllCommits: true})
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/quay/pending", loadFixture("merge_build", t))
+
+	s.ServeHTTP(resp, req)
+
+	var refs []string
+	for _, s := range r.statuses {
+		refs = append(refs, s.Ref)
+	}
+
+	if got, want := refs, []string{"long-a5d2c71", "long-f1fb3b0", "long-e2b9c44"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Refs => %v; want %v", got, want)
+	}
+}
+
+func TestServer_Reload(t *testing.T) {
+	a, b := &statusesRepository{}, &statusesRepository{}
+	s := NewServer(&Quayd{StatusesRepository: a})
+	s.Reload(&Quayd{StatusesRepository: b})
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/quay/pending", loadFixture("pending_build", t))
+
+	s.ServeHTTP(resp, req)
+
+	if len(a.statuses) != 0 || len(b.statuses) != 1 {
+		t.Fatal("Expected the reloaded Quayd to handle the request")
+	}
+}
+
+func TestServer_Use(t *testing.T) {
+	a, b := &statusesRepository{}, &statusesRepository{}
+	s := NewServer(&Quayd{StatusesRepository: a})
+
+	var order []string
+	trace := func(name string) func(http.Handler) http.Handler {
+		return func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				h.ServeHTTP(w, r)
+			})
+		}
+	}
+	auth := func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer shh" {
+				http.Error(w, "Unauthorized", 401)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+	s.Use(trace("first"), auth)
+	s.Use(trace("second"))
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/quay/pending", loadFixture("pending_build", t))
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 401; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+	if len(a.statuses) != 0 {
+		t.Fatal("Expected the middleware to reject the request")
+	}
+
+	// Middleware applies to reloaded instances too.
+	s.Reload(&Quayd{StatusesRepository: b})
+	order = nil
+
+	resp = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/quay/pending", loadFixture("pending_build", t))
+	req.Header.Set("Authorization", "Bearer shh")
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 200; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+	if got, want := len(b.statuses), 1; got != want {
+		t.Fatalf("Statuses => %d; want %d", got, want)
+	}
+	if got, want := strings.Join(order, ","), "first,second"; got != want {
+		t.Fatalf("Order => %s; want %s", got, want)
+	}
+}
+
+func TestWebhook_Canceled(t *testing.T) {
+	r := &statusesRepository{}
+	s := NewServer(&Quayd{StatusesRepository: r, CommitResolver: &GitHubCommitResolver{}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/quay/pending", loadFixture("pending_build", t))
+
+	s.ServeHTTP(resp, req.WithContext(ctx))
+
+	if got, want := resp.Code, 500; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+
+	if len(r.statuses) != 0 {
+		t.Fatal("Expected 0 commit statuses")
+	}
+}
+
+func TestWebhook_Queue(t *testing.T) {
+	r := &statusesRepository{}
+	queue := &Queue{}
+	s := NewServer(&Quayd{StatusesRepository: r, Queue: queue})
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/quay/pending", loadFixture("pending_build", t))
+
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 202; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+
+	queue.Start()
+	queue.Stop()
+
+	if len(r.statuses) != 1 {
+		t.Fatal("Expected 1 commit status")
+	}
+}
+
+func TestWebhook_Event(t *testing.T) {
+	r := &statusesRepository{}
+	s := NewServer(&Quayd{StatusesRepository: r})
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/quay", loadFixture("build_success", t))
+
+	s.ServeHTTP(resp, req)
+
+	if len(r.statuses) != 1 {
+		t.Fatal("Expected 1 commit status")
+	}
+
+	if got, want := r.statuses[0].State, "success"; got != want {
+		t.Fatalf("State => %s; want %s", got, want)
+	}
+}
+
+func TestWebhook_Cancelled(t *testing.T) {
+	tests := []struct {
+		cancelledState, state string
+	}{
+		{"", "error"},
+		{"failure", "failure"},
+	}
+
+	for _, tt := range tests {
+		r := &statusesRepository{}
+		s := NewServer(&Quayd{StatusesRepository: r, CancelledState: tt.cancelledState})
+
+		resp := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/quay", loadFixture("build_cancelled", t))
+
+		s.ServeHTTP(resp, req)
+
+		if len(r.statuses) != 1 {
+			t.Fatal("Expected 1 commit status")
+		}
+
+		st := r.statuses[0]
+		if st.State != tt.state || st.Description != "Build was cancelled" {
+			t.Fatalf("Status => %s %q; want %s %q", st.State, st.Description, tt.state, "Build was cancelled")
+		}
+	}
+}
+
+func TestWebhook_UnknownEvent(t *testing.T) {
+	r := &statusesRepository{}
+	s := NewServer(&Quayd{StatusesRepository: r})
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/quay", bytes.NewBufferString(`{"event":"repo_push"}`))
+
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 400; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+
+	if len(r.statuses) != 0 {
+		t.Fatal("Expected 0 commit statuses")
+	}
+}
+
+func TestWebhook_Context(t *testing.T) {
+	r := &statusesRepository{}
+	s := NewServer(&Quayd{
+		StatusesRepository: r,
+		Contexts:           map[string]string{"ejholmes/docker-statsd": "Docker Image (statsd)"},
+	})
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"/quay/pending", "Docker Image (statsd)"},
+		{"/quay/pending?context=Docker+Image+(worker)", "Docker Image (worker)"},
+	}
+
+	for _, tt := range tests {
+		r.Reset()
+
+		resp := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", tt.url, loadFixture("pending_build", t))
+		s.ServeHTTP(resp, req)
+
+		if len(r.statuses) != 1 {
+			t.Fatal("Expected 1 commit status")
+		}
+
+		if got := r.statuses[0].Context; got != tt.want {
+			t.Fatalf("Context => %s; want %s", got, tt.want)
+		}
+	}
+}
+
+// blockingStatusesRepository is a StatusesRepository that blocks until
+// released.
+type blockingStatusesRepository struct {
+	statusesRepository
+	started, release chan struct{}
+}
+
+func (r *blockingStatusesRepository) Create(ctx context.Context, status *Status) error {
+	r.started <- struct{}{}
+	<-r.release
+	return r.statusesRepository.Create(ctx, status)
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	r := &statusesRepository{}
+	queue := &Queue{}
+	queue.Start()
+	s := NewServer(&Quayd{StatusesRepository: r, Queue: queue})
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/quay/pending", loadFixture("pending_build", t))
+	s.ServeHTTP(resp, req)
+	if got, want := resp.Code, 202; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The queued event was handled before Shutdown returned.
+	if got, want := len(r.statuses), 1; got != want {
+		t.Fatalf("Statuses => %d; want %d", got, want)
+	}
+
+	resp = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/quay/pending", loadFixture("pending_build", t))
+	s.ServeHTTP(resp, req)
+	if got, want := resp.Code, 503; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+}
+
+func TestServer_Shutdown_Deadline(t *testing.T) {
+	r := &blockingStatusesRepository{started: make(chan struct{}), release: make(chan struct{})}
+	s := NewServer(&Quayd{StatusesRepository: r})
+
+	go func() {
+		resp := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/quay/pending", loadFixture("pending_build", t))
+		s.ServeHTTP(resp, req)
+	}()
+	<-r.started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.Shutdown(ctx); err != context.Canceled {
+		t.Fatalf("err => %v; want %v", err, context.Canceled)
+	}
+
+	// Once the request finishes, the server drains.
+	close(r.release)
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(r.statuses), 1; got != want {
+		t.Fatalf("Statuses => %d; want %d", got, want)
+	}
+}
+
+func TestNewRouter_BasePath(t *testing.T) {
+	r := &statusesRepository{}
+	q := &Quayd{StatusesRepository: r, BasePath: "/hooks/quayd/", QuayPath: "builds"}
+	h := NewRouter(q)
+
+	if got, want := q.WebhookPath("pending"), "/hooks/quayd/builds/pending"; got != want {
+		t.Fatalf("WebhookPath => %s; want %s", got, want)
+	}
+
+	tests := []struct {
+		path string
+		code int
+	}{
+		{"/hooks/quayd/builds/pending", 200},
+		{"/quay/pending", 404},
+		{"/hooks/quayd/quay/pending", 404},
+	}
+
+	for _, tt := range tests {
+		resp := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", tt.path, loadFixture("pending_build", t))
+		h.ServeHTTP(resp, req)
+
+		if got, want := resp.Code, tt.code; got != want {
+			t.Fatalf("POST %s => %d; want %d", tt.path, got, want)
+		}
+	}
+
+	if got, want := len(r.statuses), 1; got != want {
+		t.Fatalf("Statuses => %d; want %d", got, want)
+	}
+}
@@ -0,0 +1,189 @@
+package quayd
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CommitSigner signs git commits, so that commits quayd creates pass branch
+// protections that require signed commits, and can be verified as coming
+// from automation.
+type CommitSigner interface {
+	// SignCommit returns the armored signature of the raw commit object.
+	SignCommit(payload []byte) (string, error)
+}
+
+// sshsigNamespace is the namespace git uses for SSH commit signatures.
+const sshsigNamespace = "git"
+
+// SSHCommitSigner signs commits with an ed25519 SSH key, producing the same
+// signatures as `git commit -S` with `gpg.format=ssh`.
+type SSHCommitSigner struct {
+	Key ed25519.PrivateKey
+}
+
+// ParseSSHCommitSigner returns an SSHCommitSigner for an unencrypted ed25519
+// private key in the OpenSSH format, as generated by `ssh-keygen -t ed25519`.
+func ParseSSHCommitSigner(raw []byte) (*SSHCommitSigner, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil || block.Type != "OPENSSH PRIVATE KEY" {
+		return nil, errors.New("ssh: not an OpenSSH private key")
+	}
+
+	r := &sshReader{b: block.Bytes}
+	if !bytes.HasPrefix(r.b, []byte("openssh-key-v1\x00")) {
+		return nil, errors.New("ssh: invalid private key")
+	}
+	r.b = r.b[len("openssh-key-v1\x00"):]
+
+	cipher, kdf := r.string(), r.string()
+	r.string() // kdf options
+	if cipher != "none" || kdf != "none" {
+		return nil, errors.New("ssh: encrypted private keys aren't supported")
+	}
+	if n := r.uint32(); n != 1 {
+		return nil, fmt.Errorf("ssh: expected 1 key, found %d", n)
+	}
+	r.string() // public key
+
+	priv := &sshReader{b: []byte(r.string())}
+	if priv.uint32() != priv.uint32() {
+		return nil, errors.New("ssh: invalid private key")
+	}
+	if typ := priv.string(); typ != "ssh-ed25519" {
+		return nil, fmt.Errorf("ssh: unsupported key type %s", typ)
+	}
+	priv.string() // public key
+	key := []byte(priv.string())
+
+	if r.err != nil || priv.err != nil || len(key) != ed25519.PrivateKeySize {
+		return nil, errors.New("ssh: invalid private key")
+	}
+
+	return &SSHCommitSigner{Key: ed25519.PrivateKey(key)}, nil
+}
+
+// SignCommit implements CommitSigner SignCommit. The signature is in the
+// SSHSIG format (see PROTOCOL.sshsig in OpenSSH).
+func (s *SSHCommitSigner) SignCommit(payload []byte) (string, error) {
+	if len(s.Key) != ed25519.PrivateKeySize {
+		return "", errors.New("ssh: invalid ed25519 key")
+	}
+
+	pub := s.publicKey()
+	sig := sshString(nil, "ssh-ed25519")
+	sig = sshString(sig, string(ed25519.Sign(s.Key, sshsigSignedData(payload))))
+
+	var blob []byte
+	blob = append(blob, "SSHSIG"...)
+	blob = binary.BigEndian.AppendUint32(blob, 1)
+	blob = sshString(blob, string(pub))
+	blob = sshString(blob, sshsigNamespace)
+	blob = sshString(blob, "")
+	blob = sshString(blob, "sha512")
+	blob = sshString(blob, string(sig))
+
+	var b strings.Builder
+	b.WriteString("-----BEGIN SSH SIGNATURE-----\n")
+	enc := base64.StdEncoding.EncodeToString(blob)
+	for len(enc) > 70 {
+		b.WriteString(enc[:70] + "\n")
+		enc = enc[70:]
+	}
+	b.WriteString(enc + "\n")
+	b.WriteString("-----END SSH SIGNATURE-----\n")
+	return b.String(), nil
+}
+
+// AuthorizedKey returns the public key in the authorized_keys format, which
+// is what's added to the GitHub account that commits are attributed to.
+func (s *SSHCommitSigner) AuthorizedKey() string {
+	return "ssh-ed25519 " + base64.StdEncoding.EncodeToString(s.publicKey())
+}
+
+// publicKey returns the wire encoding of the public key.
+func (s *SSHCommitSigner) publicKey() []byte {
+	pub := sshString(nil, "ssh-ed25519")
+	return sshString(pub, string(s.Key.Public().(ed25519.PublicKey)))
+}
+
+// sshsigSignedData returns the data that's signed for message.
+func sshsigSignedData(message []byte) []byte {
+	h := sha512.Sum512(message)
+
+	data := []byte("SSHSIG")
+	data = sshString(data, sshsigNamespace)
+	data = sshString(data, "")
+	data = sshString(data, "sha512")
+	return sshString(data, string(h[:]))
+}
+
+// sshString appends s to b as an SSH wire format string.
+func sshString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+// sshReader reads SSH wire format values, recording the first error.
+type sshReader struct {
+	b   []byte
+	err error
+}
+
+func (r *sshReader) uint32() uint32 {
+	if len(r.b) < 4 {
+		r.err = errors.New("ssh: short read")
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.b)
+	r.b = r.b[4:]
+	return v
+}
+
+func (r *sshReader) string() string {
+	n := r.uint32()
+	if uint32(len(r.b)) < n {
+		r.err = errors.New("ssh: short read")
+		return ""
+	}
+	s := string(r.b[:n])
+	r.b = r.b[n:]
+	return s
+}
+
+// GitCommit is a commit to be created through the GitHub API.
+type GitCommit struct {
+	Message string
+	Tree    string
+	Parents []string
+
+	// Author is also used as the committer.
+	AuthorName  string
+	AuthorEmail string
+	Date        time.Time
+}
+
+// Payload returns the raw git commit object, which is what's signed. It must
+// match the commit that GitHub creates byte for byte for the signature to
+// verify.
+func (c *GitCommit) Payload() []byte {
+	ident := fmt.Sprintf("%s <%s> %d +0000", c.AuthorName, c.AuthorEmail, c.Date.Unix())
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "tree %s\n", c.Tree)
+	for _, p := range c.Parents {
+		fmt.Fprintf(&b, "parent %s\n", p)
+	}
+	fmt.Fprintf(&b, "author %s\n", ident)
+	fmt.Fprintf(&b, "committer %s\n", ident)
+	fmt.Fprintf(&b, "\n%s", c.Message)
+	return b.Bytes()
+}
@@ -0,0 +1,89 @@
+package quayd
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSSHCommitSigner(t *testing.T) {
+	raw, err := ioutil.ReadFile("test-fixtures/ssh/id_ed25519")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ioutil.ReadFile("test-fixtures/ssh/id_ed25519.pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := ParseSSHCommitSigner(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.AuthorizedKey(), strings.Join(strings.Fields(string(pub))[:2], " "); got != want {
+		t.Fatalf("AuthorizedKey => %s; want %s", got, want)
+	}
+
+	c := &GitCommit{
+		Message:     "Bump remind101/acme-inc to abcd\n",
+		Tree:        "4b825dc642cb6eb9a060e54bf8d69288fbee4904",
+		Parents:     []string{"827e1a0b1ed0e8b4e3d0b7aa5e2a1b73a5d6e4f3"},
+		AuthorName:  "quayd",
+		AuthorEmail: "quayd@example.com",
+		Date:        time.Unix(1500000000, 0),
+	}
+	sig, err := s.SignCommit(c.Payload())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	armor := strings.Split(strings.TrimSpace(sig), "\n")
+	if armor[0] != "-----BEGIN SSH SIGNATURE-----" || armor[len(armor)-1] != "-----END SSH SIGNATURE-----" {
+		t.Fatalf("Signature => %s", sig)
+	}
+	blob, err := base64.StdEncoding.DecodeString(strings.Join(armor[1:len(armor)-1], ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &sshReader{b: blob[len("SSHSIG")+4:]}
+	r.string() // public key
+	if ns := r.string(); ns != "git" {
+		t.Fatalf("Namespace => %s; want git", ns)
+	}
+	r.string() // reserved
+	r.string() // hash algorithm
+	inner := &sshReader{b: []byte(r.string())}
+	inner.string() // key type
+	signature := []byte(inner.string())
+
+	key := s.Key.Public().(ed25519.PublicKey)
+	if !ed25519.Verify(key, sshsigSignedData(c.Payload()), signature) {
+		t.Fatal("Expected the signature to verify")
+	}
+}
+
+func TestGitCommit_Payload(t *testing.T) {
+	c := &GitCommit{
+		Message:     "Bump\n",
+		Tree:        "tree-sha",
+		Parents:     []string{"parent-sha"},
+		AuthorName:  "quayd",
+		AuthorEmail: "quayd@example.com",
+		Date:        time.Unix(1500000000, 0),
+	}
+
+	want := "tree tree-sha\nparent parent-sha\nauthor quayd <quayd@example.com> 1500000000 +0000\ncommitter quayd <quayd@example.com> 1500000000 +0000\n\nBump\n"
+	if got := string(c.Payload()); got != want {
+		t.Fatalf("Payload => %q; want %q", got, want)
+	}
+}
+
+func TestParseSSHCommitSigner_Invalid(t *testing.T) {
+	if _, err := ParseSSHCommitSigner([]byte("not a key")); err == nil {
+		t.Fatal("Expected an error")
+	}
+}
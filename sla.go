@@ -0,0 +1,150 @@
+package quayd
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultSLATarget is the default time, from receiving a webhook, within
+// which its commit status should be posted.
+const DefaultSLATarget = time.Minute
+
+// SLA tracks compliance with per repo delivery targets: the time between
+// quayd receiving a webhook and posting its commit status. A nil *SLA tracks
+// nothing.
+type SLA struct {
+	// Target is the target for repos that aren't in Repos. Defaults to
+	// DefaultSLATarget.
+	Target time.Duration
+
+	// Repos maps a repo, in the form `owner/repo`, to its target.
+	Repos map[string]time.Duration
+
+	// Alert is called when a target is breached. The default logs the
+	// breach.
+	Alert func(repo string, took, target time.Duration)
+
+	mu         sync.Mutex
+	compliance map[string]*SLACompliance
+}
+
+// SLACompliance summarizes how often a repo's target was met.
+type SLACompliance struct {
+	Repo     string        `json:"repo"`
+	Target   time.Duration `json:"target"`
+	Met      int           `json:"met"`
+	Breached int           `json:"breached"`
+}
+
+// Percent returns the percentage of deliveries that met the target.
+func (c *SLACompliance) Percent() float64 {
+	total := c.Met + c.Breached
+	if total == 0 {
+		return 100
+	}
+
+	return float64(c.Met) / float64(total) * 100
+}
+
+// Observe records that handling a delivery for repo took the given time,
+// failing with err if the status couldn't be posted. It returns true if the
+// target was breached.
+func (s *SLA) Observe(repo string, took time.Duration, err error) bool {
+	if s == nil {
+		return false
+	}
+
+	target := s.target(repo)
+	breached := err != nil || took > target
+
+	s.mu.Lock()
+	if s.compliance == nil {
+		s.compliance = make(map[string]*SLACompliance)
+	}
+	c, ok := s.compliance[repo]
+	if !ok {
+		c = &SLACompliance{Repo: repo, Target: target}
+		s.compliance[repo] = c
+	}
+	if breached {
+		c.Breached++
+	} else {
+		c.Met++
+	}
+	s.mu.Unlock()
+
+	if breached {
+		s.alert(repo, took, target)
+	}
+
+	return breached
+}
+
+// Compliance returns a copy of the compliance for each repo, sorted by repo.
+func (s *SLA) Compliance() []*SLACompliance {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var compliance []*SLACompliance
+	for _, c := range s.compliance {
+		cc := *c
+		compliance = append(compliance, &cc)
+	}
+	sort.Sort(byRepo(compliance))
+	return compliance
+}
+
+func (s *SLA) target(repo string) time.Duration {
+	if t, ok := s.Repos[repo]; ok {
+		return t
+	}
+
+	if s.Target == 0 {
+		return DefaultSLATarget
+	}
+
+	return s.Target
+}
+
+func (s *SLA) alert(repo string, took, target time.Duration) {
+	if s.Alert == nil {
+		log.Printf("sla for %s breached: took %s (target %s)", repo, took, target)
+		return
+	}
+
+	s.Alert(repo, took, target)
+}
+
+type byRepo []*SLACompliance
+
+func (s byRepo) Len() int           { return len(s) }
+func (s byRepo) Less(i, j int) bool { return s[i].Repo < s[j].Repo }
+func (s byRepo) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// SLAHandler is an http.Handler that returns SLA compliance for each repo.
+type SLAHandler struct {
+	*Quayd
+}
+
+func (h *SLAHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	type compliance struct {
+		*SLACompliance
+		Percent float64 `json:"percent"`
+	}
+
+	resp := []compliance{}
+	for _, c := range h.SLA.Compliance() {
+		resp = append(resp, compliance{c, c.Percent()})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,56 @@
+package quayd
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSLA(t *testing.T) {
+	var breaches []string
+	s := &SLA{
+		Target: time.Minute,
+		Repos:  map[string]time.Duration{"remind101/acme-inc": 10 * time.Second},
+		Alert: func(repo string, took, target time.Duration) {
+			breaches = append(breaches, repo)
+		},
+	}
+
+	s.Observe("remind101/acme-inc", 5*time.Second, nil)
+	s.Observe("remind101/acme-inc", 30*time.Second, nil)
+	s.Observe("remind101/r101-api", 30*time.Second, nil)
+	s.Observe("remind101/r101-api", time.Second, errors.New("github is down"))
+
+	want := []*SLACompliance{
+		{Repo: "remind101/acme-inc", Target: 10 * time.Second, Met: 1, Breached: 1},
+		{Repo: "remind101/r101-api", Target: time.Minute, Met: 1, Breached: 1},
+	}
+
+	got := s.Compliance()
+	if len(got) != len(want) {
+		t.Fatalf("Compliance => %v; want %v", got, want)
+	}
+	for i := range want {
+		if *got[i] != *want[i] {
+			t.Fatalf("Compliance => %+v; want %+v", got[i], want[i])
+		}
+	}
+
+	if len(breaches) != 2 {
+		t.Fatalf("Breaches => %v; want 2", breaches)
+	}
+}
+
+func TestQuayd_SLA(t *testing.T) {
+	s := &SLA{Target: time.Minute, Alert: func(string, time.Duration, time.Duration) {}}
+	q := &Quayd{StatusesRepository: &statusesRepository{}, SLA: s}
+
+	if err := q.Handle(context.Background(), &BuildEvent{Repo: "remind101/acme-inc", Ref: "f1fb3b0", State: "pending", ReceivedAt: time.Now().Add(-2 * time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if c := s.Compliance(); len(c) != 1 || c[0].Breached != 1 {
+		t.Fatalf("Expected a breach, got %+v", c)
+	}
+}
@@ -0,0 +1,218 @@
+package quayd
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Slack action ids for the buttons that quayd includes in Slack messages.
+const (
+	SlackActionRetry   = "retry"
+	SlackActionPromote = "promote"
+)
+
+// DefaultSlackPromoteTag is the tag that the "Promote" button promotes images
+// to.
+const DefaultSlackPromoteTag = "staging"
+
+// SlackRequestMaxAge is how old a signed Slack request can be before it's
+// rejected, to prevent replay attacks.
+var SlackRequestMaxAge = 5 * time.Minute
+
+// SlackValidator is a WebhookValidator that verifies Slack's request
+// signature, as described in https://api.slack.com/authentication/verifying-requests-from-slack.
+type SlackValidator struct {
+	SigningSecret string
+}
+
+// Validate implements WebhookValidator Validate.
+func (v *SlackValidator) Validate(r *http.Request, body []byte) error {
+	ts := r.Header.Get("X-Slack-Request-Timestamp")
+	sig := r.Header.Get("X-Slack-Signature")
+	if ts == "" || sig == "" {
+		return ErrWebhookUnauthorized
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrWebhookUnauthorized
+	}
+	if age := time.Since(time.Unix(unix, 0)); age > SlackRequestMaxAge || age < -SlackRequestMaxAge {
+		return ErrWebhookUnauthorized
+	}
+
+	if !hmac.Equal([]byte(sig), []byte(SlackSign(v.SigningSecret, ts, body))) {
+		return ErrWebhookUnauthorized
+	}
+
+	return nil
+}
+
+// SlackSign returns the Slack signature for a request body sent at the given
+// unix timestamp.
+func SlackSign(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + ts + ":"))
+	mac.Write(body)
+	return "v0=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SlackButton is an interactive button in a Slack message.
+type SlackButton struct {
+	Type     string     `json:"type"`
+	Text     *SlackText `json:"text"`
+	ActionID string     `json:"action_id"`
+	Value    string     `json:"value"`
+}
+
+// SlackText is a Slack text object.
+type SlackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// SlackActionsBlock is a Slack block containing interactive buttons.
+type SlackActionsBlock struct {
+	Type     string         `json:"type"`
+	Elements []*SlackButton `json:"elements"`
+}
+
+// SlackActionValue is the value attached to quayd's Slack buttons,
+// identifying the build that the action applies to.
+type SlackActionValue struct {
+	DeliveryID string `json:"delivery_id,omitempty"`
+	Repo       string `json:"repo"`
+	Ref        string `json:"ref"`
+}
+
+// NewSlackActionsBlock returns the buttons to include in a Slack notification
+// about the build event.
+func NewSlackActionsBlock(e *BuildEvent) *SlackActionsBlock {
+	raw, _ := json.Marshal(&SlackActionValue{DeliveryID: e.ID, Repo: e.Repo, Ref: e.Ref})
+
+	block := &SlackActionsBlock{Type: "actions"}
+	if e.State == "success" {
+		block.Elements = append(block.Elements, &SlackButton{
+			Type:     "button",
+			Text:     &SlackText{Type: "plain_text", Text: "Promote to " + DefaultSlackPromoteTag},
+			ActionID: SlackActionPromote,
+			Value:    string(raw),
+		})
+	} else {
+		block.Elements = append(block.Elements, &SlackButton{
+			Type:     "button",
+			Text:     &SlackText{Type: "plain_text", Text: "Retry"},
+			ActionID: SlackActionRetry,
+			Value:    string(raw),
+		})
+	}
+
+	return block
+}
+
+// SlackHandler is an http.Handler that handles Slack interactivity requests
+// for the buttons in quayd's Slack messages. "Retry" replays the delivery and
+// "Promote" tags the built image with DefaultSlackPromoteTag.
+type SlackHandler struct {
+	*Quayd
+}
+
+// slackInteraction is the subset of a Slack block_actions payload that quayd
+// uses.
+type slackInteraction struct {
+	User struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+	} `json:"user"`
+
+	Actions []struct {
+		ActionID string `json:"action_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+}
+
+func (h *SlackHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.SlackSigningSecret == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	p, err := h.readPayload(r, nil)
+	if err != nil {
+		payloadError(w, err)
+		return
+	}
+	defer p.release()
+	body := p.Bytes()
+
+	v := &SlackValidator{SigningSecret: h.SlackSigningSecret}
+	if err := v.Validate(r, body); err != nil {
+		http.Error(w, err.Error(), 401)
+		return
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	var interaction slackInteraction
+	if err := json.Unmarshal([]byte(form.Get("payload")), &interaction); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	var messages []string
+	for _, action := range interaction.Actions {
+		var v SlackActionValue
+		if err := json.Unmarshal([]byte(action.Value), &v); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+
+		message, err := h.slackAction(r.Context(), action.ActionID, &v)
+		if err != nil {
+			message = fmt.Sprintf("%s failed: %s", action.ActionID, err)
+		}
+
+		// Attribute every action to the Slack user that took it.
+		log.Printf("slack: @%s (%s) %s: %s", interaction.User.Username, interaction.User.ID, action.ActionID, message)
+		messages = append(messages, fmt.Sprintf("<@%s> %s", interaction.User.ID, message))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"response_type":    "in_channel",
+		"replace_original": false,
+		"text":             strings.Join(messages, "\n"),
+	})
+}
+
+func (h *SlackHandler) slackAction(ctx context.Context, actionID string, v *SlackActionValue) (string, error) {
+	switch actionID {
+	case SlackActionRetry:
+		if err := h.Replay(ctx, v.DeliveryID); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("retried %s@%s", v.Repo, v.Ref), nil
+	case SlackActionPromote:
+		image, err := h.Promote(ctx, v.Repo, v.Ref, DefaultSlackPromoteTag)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("promoted %s@%s to %s", image.Name(), image.ID, DefaultSlackPromoteTag), nil
+	default:
+		return "", fmt.Errorf("unknown action: %s", actionID)
+	}
+}
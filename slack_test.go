@@ -0,0 +1,86 @@
+package quayd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newSlackRequest(secret string, value *SlackActionValue, actionID string) *http.Request {
+	raw, _ := json.Marshal(value)
+	payload, _ := json.Marshal(map[string]interface{}{
+		"user":    map[string]string{"id": "U123", "username": "ejholmes"},
+		"actions": []map[string]string{{"action_id": actionID, "value": string(raw)}},
+	})
+	body := url.Values{"payload": {string(payload)}}.Encode()
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req, _ := http.NewRequest("POST", "/slack/actions", strings.NewReader(body))
+	req.Header.Set("X-Slack-Request-Timestamp", ts)
+	req.Header.Set("X-Slack-Signature", SlackSign(secret, ts, []byte(body)))
+	return req
+}
+
+func TestSlackHandler_Promote(t *testing.T) {
+	registry := &MemoryRegistry{}
+	registry.Seed("remind101/acme-inc", "long-f1fb3b0")
+	q := &Quayd{Tagger: registry, TagResolver: registry, SlackSigningSecret: "shh"}
+	s := NewServer(q)
+
+	resp := httptest.NewRecorder()
+	s.ServeHTTP(resp, newSlackRequest("shh", &SlackActionValue{Repo: "remind101/acme-inc", Ref: "f1fb3b0"}, SlackActionPromote))
+
+	if got, want := resp.Code, 200; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+
+	var message struct {
+		Text string `json:"text"`
+	}
+	json.NewDecoder(resp.Body).Decode(&message)
+	if !strings.HasPrefix(message.Text, "<@U123> promoted") {
+		t.Fatalf("Expected the action to be attributed, got %s", message.Text)
+	}
+
+	staging, _ := registry.Resolve(context.Background(), "remind101/acme-inc", "staging")
+	built, _ := registry.Resolve(context.Background(), "remind101/acme-inc", "long-f1fb3b0")
+	if staging == "" || staging != built {
+		t.Fatal("Expected the image to be tagged staging")
+	}
+}
+
+func TestSlackHandler_InvalidSignature(t *testing.T) {
+	s := NewServer(&Quayd{SlackSigningSecret: "shh"})
+
+	resp := httptest.NewRecorder()
+	s.ServeHTTP(resp, newSlackRequest("wrong", &SlackActionValue{}, SlackActionRetry))
+
+	if got, want := resp.Code, 401; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+}
+
+func TestSlackHandler_PayloadTooLarge(t *testing.T) {
+	s := NewServer(&Quayd{SlackSigningSecret: "shh", MaxPayloadSize: 64})
+
+	resp := httptest.NewRecorder()
+	s.ServeHTTP(resp, newSlackRequest("shh", &SlackActionValue{Repo: "remind101/acme-inc", Ref: "f1fb3b0"}, SlackActionPromote))
+
+	if got, want := resp.Code, 413; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+}
+
+func TestNewSlackActionsBlock(t *testing.T) {
+	block := NewSlackActionsBlock(&BuildEvent{ID: "1", Repo: "remind101/acme-inc", Ref: "f1fb3b0", State: "failure"})
+
+	if got, want := block.Elements[0].ActionID, SlackActionRetry; got != want {
+		t.Fatalf("ActionID => %s; want %s", got, want)
+	}
+}
@@ -0,0 +1,146 @@
+package quayd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// StateMapping is how a build state is reported as a commit status.
+type StateMapping struct {
+	// State is the GitHub commit status state: pending, success, failure
+	// or error.
+	State string `json:"state"`
+
+	// Description is the description of the commit status.
+	Description string `json:"description"`
+}
+
+// StateMappings maps build states, which are the states of the webhook URL
+// (`/quay/{state}`) or of the Quay event, to how they're reported. Custom
+// states can be added, e.g. to report `/quay/flaky` builds as errors.
+type StateMappings map[string]*StateMapping
+
+// UnknownStateError is returned for a build state that isn't mapped.
+type UnknownStateError struct {
+	State string
+	Known []string
+}
+
+// Error implements the error interface.
+func (e *UnknownStateError) Error() string {
+	return fmt.Sprintf("unknown build state %q (expected one of %s)", e.State, strings.Join(e.Known, ", "))
+}
+
+// Validate returns an error if a mapping reports a state that GitHub doesn't
+// have.
+func (m StateMappings) Validate() error {
+	for state, mapping := range m {
+		if _, ok := GitHubStates[mapping.State]; !ok {
+			return fmt.Errorf("state %q is mapped to %q, which isn't a GitHub commit status state", state, mapping.State)
+		}
+	}
+	return nil
+}
+
+// mapState returns how the build state is reported. States that aren't in
+// States are reported as themselves, with the description in Statuses.
+func (q *Quayd) mapState(state string) (*StateMapping, error) {
+	if m, ok := q.States[state]; ok {
+		return m, nil
+	}
+
+	if validStatus(state) {
+		return &StateMapping{State: state, Description: Statuses[state]}, nil
+	}
+
+	known := append([]string{}, validStatuses...)
+	for s := range q.States {
+		known = append(known, s)
+	}
+	sort.Strings(known)
+	return nil, &UnknownStateError{State: state, Known: known}
+}
+
+// MaxDescriptionLength is the longest commit status description that GitHub
+// accepts.
+const MaxDescriptionLength = 140
+
+// ErrorDescription returns the description of an `error` status for a build
+// that quayd failed to process, e.g. because the registry was unreachable.
+// It's truncated to MaxDescriptionLength.
+func ErrorDescription(err error) string {
+	return truncateDescription("quayd failed to tag the image: " + err.Error())
+}
+
+// truncateDescription truncates a commit status description to
+// MaxDescriptionLength.
+func truncateDescription(description string) string {
+	if r := []rune(description); len(r) > MaxDescriptionLength {
+		description = string(r[:MaxDescriptionLength-3]) + "..."
+	}
+	return description
+}
+
+// StateMap translates quayd's internal build states (pending, success,
+// failure, error) into the state vocabulary of a status backend. Backends
+// with a state for cancelled builds map CancelledBackendState to it.
+type StateMap map[string]string
+
+// CancelledBackendState is the StateMap key of the backend state that
+// cancelled builds are reported with, instead of Quayd.CancelledState.
+const CancelledBackendState = "cancelled"
+
+// State vocabularies for the supported status backends.
+var (
+	GitHubStates = StateMap{
+		"pending": "pending",
+		"success": "success",
+		"failure": "failure",
+		"error":   "error",
+	}
+
+	GitLabStates = StateMap{
+		"pending": "running",
+		"success": "success",
+		"failure": "failed",
+		"error":   "failed",
+
+		// Cancelled builds are reported as GitLab's own state.
+		CancelledBackendState: "canceled",
+	}
+)
+
+// Translate returns the backend state for the given internal state.
+func (m StateMap) Translate(state string) (string, error) {
+	s, ok := m[state]
+	if !ok {
+		return "", fmt.Errorf("no backend state for %q", state)
+	}
+
+	return s, nil
+}
+
+// MappedStatusesRepository is a StatusesRepository that translates the state
+// of each status using States before passing it on to the wrapped
+// StatusesRepository.
+type MappedStatusesRepository struct {
+	StatusesRepository
+	States StateMap
+}
+
+// Create implements StatusesRepository Create.
+func (r *MappedStatusesRepository) Create(ctx context.Context, status *Status) error {
+	state, err := r.States.Translate(status.State)
+	if err != nil {
+		return err
+	}
+	if cancelled, ok := r.States[CancelledBackendState]; ok && status.Cancelled {
+		state = cancelled
+	}
+
+	s := *status
+	s.State = state
+	return r.StatusesRepository.Create(ctx, &s)
+}
@@ -0,0 +1,115 @@
+package quayd
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMappedStatusesRepository(t *testing.T) {
+	tests := []struct {
+		states StateMap
+		in     string
+		out    string
+	}{
+		{GitHubStates, "failure", "failure"},
+		{GitLabStates, "pending", "running"},
+		{GitLabStates, "failure", "failed"},
+	}
+
+	for _, tt := range tests {
+		s := &statusesRepository{}
+		r := &MappedStatusesRepository{StatusesRepository: s, States: tt.states}
+
+		if err := r.Create(context.Background(), &Status{State: tt.in}); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := s.statuses[0].State, tt.out; got != want {
+			t.Fatalf("State => %s; want %s", got, want)
+		}
+	}
+}
+
+func TestMappedStatusesRepository_Unknown(t *testing.T) {
+	r := &MappedStatusesRepository{StatusesRepository: &statusesRepository{}, States: GitLabStates}
+
+	if err := r.Create(context.Background(), &Status{State: "exploded"}); err == nil {
+		t.Fatal("Expected an error")
+	}
+}
+
+func TestHandle_StateMappings(t *testing.T) {
+	r := &statusesRepository{}
+	q := &Quayd{
+		StatusesRepository: r,
+		States: StateMappings{
+			"failure": {State: "failure", Description: "Image build failed, see the build logs"},
+			"flaky":   {State: "error", Description: "The Docker image build timed out"},
+		},
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		in, state, description string
+	}{
+		{"failure", "failure", "Image build failed, see the build logs"},
+		{"flaky", "error", "The Docker image build timed out"},
+		{"error", "error", Statuses["error"]},
+	}
+
+	for i, tt := range tests {
+		if err := q.Handle(ctx, &BuildEvent{Repo: "remind101/acme-inc", Ref: tt.in, State: tt.in}); err != nil {
+			t.Fatal(err)
+		}
+
+		st := r.statuses[i]
+		if st.State != tt.state || st.Description != tt.description {
+			t.Fatalf("Status => %s %q; want %s %q", st.State, st.Description, tt.state, tt.description)
+		}
+	}
+
+	err := q.Handle(ctx, &BuildEvent{Repo: "remind101/acme-inc", Ref: "exploded", State: "exploded"})
+	if _, ok := err.(*UnknownStateError); !ok {
+		t.Fatalf("Expected an UnknownStateError, got %v", err)
+	}
+}
+
+func TestStateMappings_Validate(t *testing.T) {
+	if err := (StateMappings{"flaky": {State: "error"}}).Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := (StateMappings{"flaky": {State: "cancelled"}}).Validate(); err == nil {
+		t.Fatal("Expected an error")
+	}
+}
+
+func TestHandle_TagError(t *testing.T) {
+	r := &statusesRepository{}
+	q := &Quayd{
+		StatusesRepository: r,
+		TagResolver:        &MemoryRegistry{},
+		Tagger:             &MemoryRegistry{},
+	}
+
+	err := q.Handle(context.Background(), &BuildEvent{Repo: "remind101/acme-inc", Ref: "abcd", State: "success", Tags: []string{"missing"}})
+	if err == nil {
+		t.Fatal("Expected the tagging error to be returned")
+	}
+
+	st := r.statuses[0]
+	if st.State != "error" || st.Image != nil {
+		t.Fatalf("Unexpected status %+v", st)
+	}
+	if got, want := st.Description, ErrorDescription(err); got != want {
+		t.Fatalf("Description => %q; want %q", got, want)
+	}
+}
+
+func TestErrorDescription(t *testing.T) {
+	d := ErrorDescription(errors.New(strings.Repeat("unreachable ", 20)))
+	if n := len([]rune(d)); n != MaxDescriptionLength {
+		t.Fatalf("Description is %d characters; want %d", n, MaxDescriptionLength)
+	}
+}
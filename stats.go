@@ -0,0 +1,74 @@
+package quayd
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// DefaultStats is the default Stats to use.
+var DefaultStats = &stats{}
+
+// Stats is an interface for recording operational statistics.
+type Stats interface {
+	// DeliveryLag records the time between a build completing and quayd
+	// processing the notification for it.
+	DeliveryLag(repo string, lag time.Duration)
+}
+
+// stats is a fake implementation of the Stats interface.
+type stats struct{}
+
+// DeliveryLag implements Stats DeliveryLag.
+func (s *stats) DeliveryLag(repo string, lag time.Duration) {}
+
+// LagStats is a Stats implementation that keeps track of the most recent
+// delivery lag for each repo and alerts when it exceeds a threshold, which
+// helps to tell quayd slowness apart from Quay slowness.
+type LagStats struct {
+	// Threshold is the lag above which Alert is called. Zero disables
+	// alerting.
+	Threshold time.Duration
+
+	// Alert is called when the delivery lag exceeds Threshold. The default
+	// logs the lag.
+	Alert func(repo string, lag time.Duration)
+
+	mu   sync.Mutex
+	lags map[string]time.Duration
+}
+
+// DeliveryLag implements Stats DeliveryLag.
+func (s *LagStats) DeliveryLag(repo string, lag time.Duration) {
+	s.mu.Lock()
+	if s.lags == nil {
+		s.lags = make(map[string]time.Duration)
+	}
+	s.lags[repo] = lag
+	s.mu.Unlock()
+
+	if s.Threshold > 0 && lag > s.Threshold {
+		s.alert(repo, lag)
+	}
+}
+
+// Lags returns a copy of the most recent delivery lag for each repo.
+func (s *LagStats) Lags() map[string]time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lags := make(map[string]time.Duration, len(s.lags))
+	for repo, lag := range s.lags {
+		lags[repo] = lag
+	}
+	return lags
+}
+
+func (s *LagStats) alert(repo string, lag time.Duration) {
+	if s.Alert == nil {
+		log.Printf("quay delivery lag for %s is %s (threshold %s)", repo, lag, s.Threshold)
+		return
+	}
+
+	s.Alert(repo, lag)
+}
@@ -0,0 +1,31 @@
+package quayd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLagStats(t *testing.T) {
+	var alerted []string
+	s := &LagStats{
+		Threshold: time.Minute,
+		Alert: func(repo string, lag time.Duration) {
+			alerted = append(alerted, repo)
+		},
+	}
+
+	s.DeliveryLag("remind101/r101-api", 5*time.Second)
+	s.DeliveryLag("remind101/acme-inc", 5*time.Minute)
+
+	if got, want := s.Lags()["remind101/acme-inc"], 5*time.Minute; got != want {
+		t.Fatalf("Lag => %s; want %s", got, want)
+	}
+
+	if got, want := len(alerted), 1; got != want {
+		t.Fatalf("Alerts => %d; want %d", got, want)
+	}
+
+	if got, want := alerted[0], "remind101/acme-inc"; got != want {
+		t.Fatalf("Alerted => %s; want %s", got, want)
+	}
+}
@@ -0,0 +1,122 @@
+package quayd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// DefaultStreamBuffer is the number of events that are buffered for each
+// subscriber before new events are dropped for it.
+const DefaultStreamBuffer = 16
+
+// EventStream fans processed build events out to subscribers, such as
+// dashboards and bots connected to the /events/stream endpoint. Subscribers
+// that fall behind miss events rather than slowing down processing. A nil
+// *EventStream publishes nothing.
+type EventStream struct {
+	// Buffer is the number of events buffered for each subscriber.
+	// Defaults to DefaultStreamBuffer.
+	Buffer int
+
+	mu   sync.Mutex
+	subs map[chan *Envelope]struct{}
+}
+
+// Publish sends the build event to every subscriber.
+func (s *EventStream) Publish(e *BuildEvent) {
+	if s == nil {
+		return
+	}
+
+	env := NewEnvelope(e)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for ch := range s.subs {
+		select {
+		case ch <- env:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel that receives published events, and a function
+// that must be called to unsubscribe.
+func (s *EventStream) Subscribe() (<-chan *Envelope, func()) {
+	buffer := s.Buffer
+	if buffer == 0 {
+		buffer = DefaultStreamBuffer
+	}
+	ch := make(chan *Envelope, buffer)
+
+	s.mu.Lock()
+	if s.subs == nil {
+		s.subs = make(map[chan *Envelope]struct{})
+	}
+	s.subs[ch] = struct{}{}
+	s.mu.Unlock()
+
+	return ch, func() {
+		s.mu.Lock()
+		delete(s.subs, ch)
+		s.mu.Unlock()
+	}
+}
+
+// StreamHandler is an http.Handler that streams processed build events as
+// Server-Sent Events. Events can be limited to particular repos with one or
+// more `repo` query parameters. It requires the admin token, which
+// EventSource clients can pass as the `token` query parameter.
+type StreamHandler struct {
+	*Quayd
+}
+
+func (h *StreamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.Events == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", 500)
+		return
+	}
+
+	repos := make(map[string]bool)
+	for _, repo := range r.URL.Query()["repo"] {
+		repos[repo] = true
+	}
+
+	events, unsubscribe := h.Events.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-closing(r.Context()):
+			return
+		case env := <-events:
+			if len(repos) > 0 && !repos[env.Data.Repo] {
+				continue
+			}
+
+			raw, err := json.Marshal(env)
+			if err != nil {
+				continue
+			}
+
+			fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", env.Data.ID, env.Type, raw)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package quayd
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStreamHandler(t *testing.T) {
+	events := &EventStream{}
+	q := &Quayd{AdminToken: testAdminToken, StatusesRepository: &statusesRepository{}, Events: events}
+	s := httptest.NewServer(NewServer(q))
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "/events/stream?repo=ejholmes/docker-statsd&token=" + testAdminToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got, want := resp.Header.Get("Content-Type"), "text/event-stream"; got != want {
+		t.Fatalf("Content-Type => %s; want %s", got, want)
+	}
+
+	r := bufio.NewReader(resp.Body)
+	if line, _ := r.ReadString('\n'); line != ": connected\n" {
+		t.Fatalf("Expected the stream to be connected, got %q", line)
+	}
+	r.ReadString('\n')
+
+	ctx := context.Background()
+	q.Handle(ctx, &BuildEvent{ID: "1", Repo: "ejholmes/other", Ref: "abcd", State: "pending"})
+	q.Handle(ctx, &BuildEvent{ID: "2", Repo: "ejholmes/docker-statsd", Ref: "abcd", State: "pending"})
+
+	var lines []string
+	for len(lines) < 3 {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, strings.TrimSpace(line))
+	}
+
+	if got, want := lines[0], "id: 2"; got != want {
+		t.Fatalf("Line => %s; want %s", got, want)
+	}
+
+	if got, want := lines[1], "event: "+BuildEventType; got != want {
+		t.Fatalf("Line => %s; want %s", got, want)
+	}
+
+	if !strings.HasPrefix(lines[2], "data: ") || !strings.Contains(lines[2], `"repo":"ejholmes/docker-statsd"`) {
+		t.Fatalf("Unexpected data: %s", lines[2])
+	}
+}
+
+func TestStreamHandler_Unauthorized(t *testing.T) {
+	s := NewServer(&Quayd{AdminToken: testAdminToken, Events: &EventStream{}})
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/events/stream", nil)
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 401; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+}
+
+func TestStreamHandler_Disabled(t *testing.T) {
+	s := NewServer(&Quayd{})
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/events/stream", nil)
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 404; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+}
+
+func TestEventStream_SlowSubscriber(t *testing.T) {
+	s := &EventStream{Buffer: 1}
+	events, unsubscribe := s.Subscribe()
+	defer unsubscribe()
+
+	s.Publish(&BuildEvent{ID: "1"})
+	s.Publish(&BuildEvent{ID: "2"})
+
+	if got, want := (<-events).Data.ID, "1"; got != want {
+		t.Fatalf("ID => %s; want %s", got, want)
+	}
+
+	select {
+	case env := <-events:
+		t.Fatalf("Expected the second event to be dropped, got %s", env.Data.ID)
+	default:
+	}
+}
@@ -0,0 +1,301 @@
+package quayd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// SupplyChainContext is the name of the summary Check Run.
+const SupplyChainContext = "Supply Chain"
+
+// SupplyChainSteps are the steps that make up the supply chain summary.
+var SupplyChainSteps = []string{"signing", "sbom", "provenance", "scan"}
+
+// DefaultSupplyChainAnnotationPath is the file that supply chain step
+// annotations are attached to when SupplyChain.AnnotationPath is empty.
+const DefaultSupplyChainAnnotationPath = "Dockerfile"
+
+// DefaultMaxSupplyChainCommits is the default number of commits that
+// SupplyChain keeps the step results of.
+const DefaultMaxSupplyChainCommits = 1000
+
+// CheckRunsService is an interface for creating and updating GitHub Check
+// Runs. GitHubChecksRepository implements it.
+type CheckRunsService interface {
+	CreateCheckRun(ctx context.Context, repo string, check *CheckRun) (int, error)
+	UpdateCheckRun(ctx context.Context, repo string, id int, check *CheckRun) error
+}
+
+// SupplyChainStep is the result of a single supply chain step.
+type SupplyChainStep struct {
+	// State is one of pending, success or failure.
+	State string `json:"state"`
+
+	// Summary is a short description of the result.
+	Summary string `json:"summary"`
+
+	// Vulnerabilities are the ids of the vulnerabilities found by the scan
+	// step. When set, the summary of the scan step is the change relative
+	// to the previous build of the branch.
+	Vulnerabilities []string `json:"vulnerabilities,omitempty"`
+
+	// Branch is the branch that was built. Scans are only compared against
+	// builds of the same branch.
+	Branch string `json:"branch,omitempty"`
+}
+
+// SupplyChain aggregates the asynchronous supply chain steps for an image
+// (signing, SBOM generation, provenance and scanning) into a single summary
+// Check Run, which is updated as each step completes.
+type SupplyChain struct {
+	Checks CheckRunsService
+
+	// AnnotationPath is the file that each step's annotation is attached
+	// to. The zero value is DefaultSupplyChainAnnotationPath.
+	AnnotationPath string
+
+	// Max is the number of commits to keep the step results of. Defaults
+	// to DefaultMaxSupplyChainCommits.
+	Max int
+
+	mu      sync.Mutex
+	order   []string
+	commits map[string]*supplyChainCommit
+	scans   map[string]*branchScan
+}
+
+type supplyChainCommit struct {
+	// mu serializes updates to the commit, so that the Check Run is only
+	// created once, without holding SupplyChain.mu across GitHub calls.
+	mu sync.Mutex
+
+	id    int
+	steps map[string]*SupplyChainStep
+	diff  *VulnDiff
+}
+
+// Update records the result of a step for the commit, and creates or updates
+// the summary Check Run.
+func (s *SupplyChain) Update(ctx context.Context, repo, sha, step string, result *SupplyChainStep) error {
+	if !validSupplyChainStep(step) {
+		return fmt.Errorf("unknown supply chain step: %s", step)
+	}
+
+	s.mu.Lock()
+	if s.commits == nil {
+		s.commits = make(map[string]*supplyChainCommit)
+	}
+
+	key := repo + "@" + sha
+	c, ok := s.commits[key]
+	if !ok {
+		c = &supplyChainCommit{steps: make(map[string]*SupplyChainStep)}
+		s.commits[key] = c
+		s.order = append(s.order, key)
+	}
+
+	max := s.Max
+	if max == 0 {
+		max = DefaultMaxSupplyChainCommits
+	}
+	for len(s.order) > max {
+		delete(s.commits, s.order[0])
+		s.order = s.order[1:]
+	}
+
+	var diff *VulnDiff
+	if step == "scan" && result.Vulnerabilities != nil {
+		if diff = s.diffScan(repo, sha, result); diff != nil {
+			result.Summary = diff.String()
+		}
+	}
+	s.mu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.steps[step] = result
+	if diff != nil {
+		c.diff = diff
+	}
+
+	check := c.checkRun(sha, s.annotationPath())
+	if c.id == 0 {
+		id, err := s.Checks.CreateCheckRun(ctx, repo, check)
+		if err != nil {
+			return err
+		}
+		c.id = id
+		return nil
+	}
+
+	return s.Checks.UpdateCheckRun(ctx, repo, c.id, check)
+}
+
+func (s *SupplyChain) annotationPath() string {
+	if s.AnnotationPath == "" {
+		return DefaultSupplyChainAnnotationPath
+	}
+	return s.AnnotationPath
+}
+
+// diffScan records the scan for the branch, and returns the change relative
+// to the previous build of the branch, or nil if there isn't one.
+func (s *SupplyChain) diffScan(repo, sha string, result *SupplyChainStep) *VulnDiff {
+	if result.Branch == "" {
+		return nil
+	}
+
+	if s.scans == nil {
+		s.scans = make(map[string]*branchScan)
+	}
+
+	key := repo + "#" + result.Branch
+	last, ok := s.scans[key]
+	scan := &branchScan{sha: sha, vulns: result.Vulnerabilities}
+	switch {
+	case ok && last.sha == sha:
+		// A rescan of the same build is compared against the same
+		// previous build.
+		scan.previous, scan.hasPrev = last.previous, last.hasPrev
+	case ok:
+		scan.previous, scan.hasPrev = last.vulns, true
+	}
+	s.scans[key] = scan
+
+	if !scan.hasPrev {
+		return nil
+	}
+
+	return DiffVulnerabilities(scan.previous, scan.vulns)
+}
+
+// checkRun returns the summary Check Run for the commit, with an annotation
+// on path for each completed step.
+func (c *supplyChainCommit) checkRun(sha, path string) *CheckRun {
+	var (
+		lines       = []string{"| Step | State | Summary |", "| --- | --- | --- |"}
+		annotations []*CheckRunAnnotation
+		completed   = 0
+		failed      = false
+	)
+
+	for _, name := range SupplyChainSteps {
+		step, ok := c.steps[name]
+		if !ok {
+			step = &SupplyChainStep{State: "pending"}
+		}
+
+		var level string
+		switch step.State {
+		case "success":
+			completed++
+			level = "notice"
+		case "failure":
+			completed++
+			failed = true
+			level = "failure"
+		}
+		if level != "" {
+			message := step.Summary
+			if message == "" {
+				message = step.State
+			}
+			annotations = append(annotations, &CheckRunAnnotation{
+				Path:      path,
+				StartLine: 1,
+				EndLine:   1,
+				Level:     level,
+				Title:     name,
+				Message:   message,
+			})
+		}
+
+		lines = append(lines, fmt.Sprintf("| %s | %s | %s |", name, step.State, step.Summary))
+	}
+
+	check := &CheckRun{
+		Name:    SupplyChainContext,
+		HeadSHA: sha,
+		Status:  "in_progress",
+		Output: &CheckRunOutput{
+			Title:       fmt.Sprintf("%d of %d supply chain steps completed", completed, len(SupplyChainSteps)),
+			Summary:     strings.Join(lines, "\n"),
+			Annotations: annotations,
+		},
+	}
+	if c.diff != nil {
+		check.Output.Text = c.diff.Markdown()
+	}
+
+	if failed || completed == len(SupplyChainSteps) {
+		check.Status = "completed"
+		check.Conclusion = "success"
+		if failed {
+			check.Conclusion = "failure"
+		}
+	}
+
+	return check
+}
+
+func validSupplyChainStep(step string) bool {
+	for _, s := range SupplyChainSteps {
+		if s == step {
+			return true
+		}
+	}
+	return false
+}
+
+// SupplyChainHandler is an http.Handler that records the result of a supply
+// chain step, posted as a JSON SupplyChainStep. Requests are validated with
+// the "supply-chain" WebhookValidator.
+type SupplyChainHandler struct {
+	*Quayd
+}
+
+func (h *SupplyChainHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p, err := h.readPayload(r, nil)
+	if err != nil {
+		payloadError(w, err)
+		return
+	}
+	defer p.release()
+	body := p.Bytes()
+
+	if err := h.WebhookValidators.Validate("supply-chain", r, body); err != nil {
+		http.Error(w, err.Error(), 401)
+		return
+	}
+
+	if h.SupplyChain == nil {
+		http.Error(w, "Supply chain reporting is not enabled", 404)
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	var step SupplyChainStep
+	if err := json.Unmarshal(body, &step); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	repo := vars["owner"] + "/" + vars["repo"]
+	if h.ReadOnly {
+		h.logger().Log(r.Context(), "supply chain update skipped (read-only)", "repo", repo, "sha", vars["sha"], "step", vars["step"])
+		return
+	}
+
+	if err := h.SupplyChain.Update(r.Context(), repo, vars["sha"], vars["step"], &step); err != nil {
+		h.errorResponse(w, r, err)
+		return
+	}
+}
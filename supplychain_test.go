@@ -0,0 +1,171 @@
+package quayd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// checkRunsService is a fake implementation of the CheckRunsService
+// interface.
+type checkRunsService struct {
+	created []*CheckRun
+	updated []*CheckRun
+}
+
+func (s *checkRunsService) CreateCheckRun(ctx context.Context, repo string, check *CheckRun) (int, error) {
+	s.created = append(s.created, check)
+	return len(s.created), nil
+}
+
+func (s *checkRunsService) UpdateCheckRun(ctx context.Context, repo string, id int, check *CheckRun) error {
+	s.updated = append(s.updated, check)
+	return nil
+}
+
+func TestSupplyChain(t *testing.T) {
+	checks := &checkRunsService{}
+	s := &SupplyChain{Checks: checks}
+	ctx := context.Background()
+
+	for _, step := range []string{"signing", "sbom", "provenance"} {
+		if err := s.Update(ctx, "ejholmes/docker-statsd", "abcd", step, &SupplyChainStep{State: "success"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, want := len(checks.created), 1; got != want {
+		t.Fatalf("Created => %d; want %d", got, want)
+	}
+
+	last := checks.updated[len(checks.updated)-1]
+	if got, want := last.Status, "in_progress"; got != want {
+		t.Fatalf("Status => %s; want %s", got, want)
+	}
+
+	if err := s.Update(ctx, "ejholmes/docker-statsd", "abcd", "scan", &SupplyChainStep{State: "failure", Summary: "2 critical vulnerabilities"}); err != nil {
+		t.Fatal(err)
+	}
+
+	last = checks.updated[len(checks.updated)-1]
+	if got, want := last.Conclusion, "failure"; got != want {
+		t.Fatalf("Conclusion => %s; want %s", got, want)
+	}
+
+	if got, want := last.Output.Title, "4 of 4 supply chain steps completed"; got != want {
+		t.Fatalf("Title => %s; want %s", got, want)
+	}
+
+	annotations := last.Output.Annotations
+	if got, want := len(annotations), 4; got != want {
+		t.Fatalf("Annotations => %d; want %d", got, want)
+	}
+	scan := annotations[3]
+	if scan.Path != DefaultSupplyChainAnnotationPath || scan.Level != "failure" || scan.Title != "scan" || scan.Message != "2 critical vulnerabilities" {
+		t.Fatalf("Annotation => %+v", scan)
+	}
+	if got, want := annotations[0].Level, "notice"; got != want {
+		t.Fatalf("Level => %s; want %s", got, want)
+	}
+}
+
+func TestSupplyChain_Max(t *testing.T) {
+	s := &SupplyChain{Checks: &checkRunsService{}, Max: 2}
+	ctx := context.Background()
+
+	for _, sha := range []string{"a", "b", "c"} {
+		if err := s.Update(ctx, "ejholmes/docker-statsd", sha, "sbom", &SupplyChainStep{State: "success"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, want := len(s.commits), 2; got != want {
+		t.Fatalf("Commits => %d; want %d", got, want)
+	}
+	if _, ok := s.commits["ejholmes/docker-statsd@a"]; ok {
+		t.Fatal("Expected the oldest commit to be evicted")
+	}
+}
+
+func TestSupplyChain_UnknownStep(t *testing.T) {
+	s := &SupplyChain{Checks: &checkRunsService{}}
+
+	if err := s.Update(context.Background(), "ejholmes/docker-statsd", "abcd", "lint", &SupplyChainStep{}); err == nil {
+		t.Fatal("Expected an error")
+	}
+}
+
+func TestSupplyChainHandler(t *testing.T) {
+	checks := &checkRunsService{}
+	s := NewServer(&Quayd{SupplyChain: &SupplyChain{Checks: checks}})
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/supply-chain/ejholmes/docker-statsd/abcd/sbom", strings.NewReader(`{"state":"success"}`))
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 200; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+
+	if got, want := len(checks.created), 1; got != want {
+		t.Fatalf("Created => %d; want %d", got, want)
+	}
+}
+
+func TestSupplyChainHandler_Unauthorized(t *testing.T) {
+	checks := &checkRunsService{}
+	s := NewServer(&Quayd{
+		SupplyChain:       &SupplyChain{Checks: checks},
+		WebhookValidators: WebhookValidators{"supply-chain": &HMACValidator{Secret: "secret"}},
+	})
+
+	body := `{"state":"success"}`
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/supply-chain/ejholmes/docker-statsd/abcd/sbom", strings.NewReader(body))
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 401; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+	if len(checks.created) != 0 {
+		t.Fatal("Expected no Check Run for an unsigned request")
+	}
+
+	resp = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/supply-chain/ejholmes/docker-statsd/abcd/sbom", strings.NewReader(body))
+	req.Header.Set(SignatureHeader, Sign([]byte("secret"), []byte(body)))
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 200; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+}
+
+func TestSupplyChain_VulnerabilityDiff(t *testing.T) {
+	checks := &checkRunsService{}
+	s := &SupplyChain{Checks: checks}
+	ctx := context.Background()
+
+	if err := s.Update(ctx, "ejholmes/docker-statsd", "abcd", "scan", &SupplyChainStep{State: "success", Branch: "master", Vulnerabilities: []string{"CVE-1", "CVE-2"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := checks.created[0].Output.Text; got != "" {
+		t.Fatalf("Expected no diff for the first build, got %s", got)
+	}
+
+	if err := s.Update(ctx, "ejholmes/docker-statsd", "efgh", "scan", &SupplyChainStep{State: "success", Branch: "master", Vulnerabilities: []string{"CVE-2", "CVE-3"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	check := checks.created[1]
+	if !strings.Contains(check.Output.Summary, "| scan | success | 1 new (CVE-3), 1 fixed, 2 total |") {
+		t.Fatalf("Unexpected summary: %s", check.Output.Summary)
+	}
+
+	if !strings.Contains(check.Output.Text, "| CVE-1 | fixed |") {
+		t.Fatalf("Unexpected text: %s", check.Output.Text)
+	}
+}
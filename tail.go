@@ -0,0 +1,103 @@
+package quayd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// LogLine is a log line that was written while processing a delivery.
+type LogLine struct {
+	Time      time.Time              `json:"time"`
+	RequestID string                 `json:"request_id"`
+	Msg       string                 `json:"msg"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+}
+
+// LogTail lets operators follow the log lines for a delivery as it's
+// processed. A nil *LogTail publishes nothing.
+type LogTail struct {
+	// Buffer is the number of lines buffered for each subscriber.
+	// Defaults to DefaultStreamBuffer.
+	Buffer int
+
+	mu   sync.Mutex
+	subs map[chan *LogLine]string
+}
+
+// Subscribe returns a channel that receives the log lines for the delivery
+// with the given id, and a function that must be called to unsubscribe.
+func (t *LogTail) Subscribe(id string) (<-chan *LogLine, func()) {
+	buffer := t.Buffer
+	if buffer == 0 {
+		buffer = DefaultStreamBuffer
+	}
+	ch := make(chan *LogLine, buffer)
+
+	t.mu.Lock()
+	if t.subs == nil {
+		t.subs = make(map[chan *LogLine]string)
+	}
+	t.subs[ch] = id
+	t.mu.Unlock()
+
+	return ch, func() {
+		t.mu.Lock()
+		delete(t.subs, ch)
+		t.mu.Unlock()
+	}
+}
+
+// publish sends a log line to the subscribers of the request id in ctx.
+func (t *LogTail) publish(ctx context.Context, msg string, keyvals ...interface{}) {
+	if t == nil {
+		return
+	}
+
+	id := RequestID(ctx)
+	if id == "" {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var line *LogLine
+	for ch, sub := range t.subs {
+		if sub != id {
+			continue
+		}
+
+		if line == nil {
+			line = &LogLine{Time: time.Now().UTC(), RequestID: id, Msg: msg}
+			for i := 0; i+1 < len(keyvals); i += 2 {
+				if line.Fields == nil {
+					line.Fields = make(map[string]interface{})
+				}
+				v := keyvals[i+1]
+				if err, ok := v.(error); ok {
+					v = err.Error()
+				}
+				line.Fields[fmt.Sprint(keyvals[i])] = v
+			}
+		}
+
+		select {
+		case ch <- line:
+		default:
+		}
+	}
+}
+
+// tailLogger is a Logger that also publishes each line to a LogTail.
+type tailLogger struct {
+	Logger
+	tail *LogTail
+}
+
+// Log implements Logger Log.
+func (l *tailLogger) Log(ctx context.Context, msg string, keyvals ...interface{}) {
+	l.Logger.Log(ctx, msg, keyvals...)
+	l.tail.publish(ctx, msg, keyvals...)
+}
@@ -0,0 +1,293 @@
+package quayd
+
+import (
+	"context"
+	"strings"
+
+	"github.com/ejholmes/go-github/github"
+)
+
+// Tenant holds the clients, and so the credentials, used for the repos of an
+// organization.
+type Tenant struct {
+	StatusesRepository
+	CommitResolver
+	TagResolver
+	Tagger
+
+	// The clients below are used by the optional features. Nil clients
+	// fall back to those of the feature.
+	Issues       IssuesService
+	Deployments  DeploymentsService
+	GitOps       GitOpsService
+	Messages     CommitMessageResolver
+	Releases     ReleaseAssetService
+	Approvals    ApprovalService
+	PullRequests PullRequestResolver
+
+	// Checks creates the supply chain Check Runs, when the Tenant creates
+	// Check Runs instead of commit statuses.
+	Checks CheckRunsService
+}
+
+// NewTenant returns a Tenant backed by GitHub implementations, authenticated
+// with token, that tags images in registry with registryAuth.
+func NewTenant(token, registryAuth, registry string, opts ...Option) *Tenant {
+	q := newQuayd(token, registryAuth, registry, opts...)
+	client := NewGitHubClient(token)
+	t := &Tenant{
+		StatusesRepository: q.StatusesRepository,
+		CommitResolver:     q.CommitResolver,
+		TagResolver:        q.TagResolver,
+		Tagger:             q.Tagger,
+		Issues:             client.Issues,
+		Deployments:        &GitHubDeploymentsService{Client: client},
+		GitOps:             &GitHubGitOpsService{Client: client},
+		Messages:           &GitHubCommitMessageResolver{client.Repositories},
+		Releases:           &GitHubReleaseAssetService{Client: client},
+		Approvals:          &GitHubApprovalService{Client: client},
+		PullRequests:       &GitHubPullRequestResolver{Client: client},
+	}
+	if q.SupplyChain != nil {
+		t.Checks = q.SupplyChain.Checks
+	}
+	return t
+}
+
+// Tenants maps a GitHub owner, or an `owner/repo`, to the Tenant whose
+// credentials are used for it. This allows a single quayd to serve several
+// organizations that don't share a token.
+type Tenants map[string]*Tenant
+
+// Tenant returns the Tenant for the GitHub repo, preferring an exact match
+// over a match on the owner. It returns nil if there's no match.
+func (t Tenants) Tenant(repo string) *Tenant {
+	if tenant, ok := t[repo]; ok {
+		return tenant
+	}
+
+	if i := strings.Index(repo, "/"); i > 0 {
+		return t[repo[:i]]
+	}
+
+	return nil
+}
+
+// forTenant returns a copy of q that uses the clients of the Tenant for the
+// Quay repo, or q itself if there isn't one. The optional features keep state
+// across repos, so they're shared rather than copied, and their clients are
+// routed to the Tenant by routeTenants instead.
+func (q *Quayd) forTenant(repo string) *Quayd {
+	if len(q.Tenants) == 0 {
+		return q
+	}
+
+	githubRepo, err := q.githubRepo(repo)
+	if err != nil {
+		return q
+	}
+
+	tenant := q.Tenants.Tenant(githubRepo)
+	if tenant == nil {
+		return q
+	}
+
+	t := *q
+	t.StatusesRepository = tenant.StatusesRepository
+	t.CommitResolver = tenant.CommitResolver
+	t.TagResolver = tenant.TagResolver
+	t.Tagger = tenant.Tagger
+	return &t
+}
+
+// routeTenants replaces the clients of the optional features with ones that
+// use the client of the Tenant for the GitHub repo of each call, falling back
+// to the feature's own client.
+func (q *Quayd) routeTenants() {
+	if len(q.Tenants) == 0 {
+		return
+	}
+
+	if q.FailureIssues != nil {
+		q.FailureIssues.Issues = &tenantIssues{q.Tenants, q.FailureIssues.Issues}
+	}
+	if q.Environments != nil {
+		q.Environments.Deployments = &tenantDeployments{q.Tenants, q.Environments.Deployments}
+	}
+	if q.GitOps != nil {
+		// Tenants sign their commits with the same key.
+		if service, ok := q.GitOps.Service.(*GitHubGitOpsService); ok && service.Signer != nil {
+			for _, t := range q.Tenants {
+				if s, ok := t.GitOps.(*GitHubGitOpsService); ok && s.Signer == nil {
+					s.Signer = service.Signer
+				}
+			}
+		}
+		q.GitOps.Service = &tenantGitOps{q.Tenants, q.GitOps.Service}
+	}
+	if q.Directives != nil {
+		q.Directives.Messages = &tenantMessages{q.Tenants, q.Directives.Messages}
+	}
+	if q.ReleaseAssets != nil {
+		q.ReleaseAssets.Releases = &tenantReleases{q.Tenants, q.ReleaseAssets.Releases}
+	}
+	if q.Approvals != nil {
+		q.Approvals.Service = &tenantApprovals{q.Tenants, q.Approvals.Service}
+	}
+	if q.Rebuilds != nil {
+		q.Rebuilds.PullRequests = &tenantPullRequests{q.Tenants, q.Rebuilds.PullRequests}
+	}
+	if q.SupplyChain != nil {
+		q.SupplyChain.Checks = &tenantCheckRuns{q.Tenants, q.SupplyChain.Checks}
+	}
+}
+
+// tenantIssues is an IssuesService that uses the Tenant's client.
+type tenantIssues struct {
+	tenants Tenants
+	IssuesService
+}
+
+func (s *tenantIssues) service(owner, repo string) IssuesService {
+	if t := s.tenants.Tenant(owner + "/" + repo); t != nil && t.Issues != nil {
+		return t.Issues
+	}
+	return s.IssuesService
+}
+
+func (s *tenantIssues) Create(owner, repo string, issue *github.IssueRequest) (*github.Issue, *github.Response, error) {
+	return s.service(owner, repo).Create(owner, repo, issue)
+}
+
+func (s *tenantIssues) Edit(owner, repo string, number int, issue *github.IssueRequest) (*github.Issue, *github.Response, error) {
+	return s.service(owner, repo).Edit(owner, repo, number, issue)
+}
+
+func (s *tenantIssues) CreateComment(owner, repo string, number int, comment *github.IssueComment) (*github.IssueComment, *github.Response, error) {
+	return s.service(owner, repo).CreateComment(owner, repo, number, comment)
+}
+
+func (s *tenantIssues) ListByRepo(owner, repo string, opt *github.IssueListByRepoOptions) ([]github.Issue, *github.Response, error) {
+	return s.service(owner, repo).ListByRepo(owner, repo, opt)
+}
+
+// tenantDeployments is a DeploymentsService that uses the Tenant's client.
+type tenantDeployments struct {
+	tenants Tenants
+	DeploymentsService
+}
+
+func (s *tenantDeployments) service(repo string) DeploymentsService {
+	if t := s.tenants.Tenant(repo); t != nil && t.Deployments != nil {
+		return t.Deployments
+	}
+	return s.DeploymentsService
+}
+
+func (s *tenantDeployments) CreateEnvironment(ctx context.Context, repo, name string) error {
+	return s.service(repo).CreateEnvironment(ctx, repo, name)
+}
+
+func (s *tenantDeployments) CreateDeployment(ctx context.Context, repo, sha, environment string) (int, error) {
+	return s.service(repo).CreateDeployment(ctx, repo, sha, environment)
+}
+
+func (s *tenantDeployments) CreateDeploymentStatus(ctx context.Context, repo string, id int, state, environmentURL, logURL string) error {
+	return s.service(repo).CreateDeploymentStatus(ctx, repo, id, state, environmentURL, logURL)
+}
+
+// tenantGitOps is a GitOpsService that uses the Tenant's client for the
+// GitOps repo.
+type tenantGitOps struct {
+	tenants Tenants
+	GitOpsService
+}
+
+func (s *tenantGitOps) OpenPullRequest(ctx context.Context, pr *GitOpsPullRequest) (string, error) {
+	if t := s.tenants.Tenant(pr.Repo); t != nil && t.GitOps != nil {
+		return t.GitOps.OpenPullRequest(ctx, pr)
+	}
+	return s.GitOpsService.OpenPullRequest(ctx, pr)
+}
+
+// tenantMessages is a CommitMessageResolver that uses the Tenant's client.
+type tenantMessages struct {
+	tenants Tenants
+	CommitMessageResolver
+}
+
+func (s *tenantMessages) Message(ctx context.Context, repo, ref string) (string, error) {
+	if t := s.tenants.Tenant(repo); t != nil && t.Messages != nil {
+		return t.Messages.Message(ctx, repo, ref)
+	}
+	return s.CommitMessageResolver.Message(ctx, repo, ref)
+}
+
+// tenantReleases is a ReleaseAssetService that uses the Tenant's client.
+type tenantReleases struct {
+	tenants Tenants
+	ReleaseAssetService
+}
+
+func (s *tenantReleases) Upload(ctx context.Context, repo, tag, name string, content []byte) (bool, error) {
+	if t := s.tenants.Tenant(repo); t != nil && t.Releases != nil {
+		return t.Releases.Upload(ctx, repo, tag, name, content)
+	}
+	return s.ReleaseAssetService.Upload(ctx, repo, tag, name, content)
+}
+
+// tenantApprovals is an ApprovalService that uses the Tenant's client for the
+// deploys repo.
+type tenantApprovals struct {
+	tenants Tenants
+	ApprovalService
+}
+
+func (s *tenantApprovals) service(repo string) ApprovalService {
+	if t := s.tenants.Tenant(repo); t != nil && t.Approvals != nil {
+		return t.Approvals
+	}
+	return s.ApprovalService
+}
+
+func (s *tenantApprovals) OpenPullRequest(ctx context.Context, pr *ApprovalPullRequest) (int, string, error) {
+	return s.service(pr.Repo).OpenPullRequest(ctx, pr)
+}
+
+func (s *tenantApprovals) PullRequestState(ctx context.Context, repo string, number int) (*ApprovalState, error) {
+	return s.service(repo).PullRequestState(ctx, repo, number)
+}
+
+// tenantPullRequests is a PullRequestResolver that uses the Tenant's client.
+type tenantPullRequests struct {
+	tenants Tenants
+	PullRequestResolver
+}
+
+func (s *tenantPullRequests) Head(ctx context.Context, repo string, number int) (string, string, error) {
+	if t := s.tenants.Tenant(repo); t != nil && t.PullRequests != nil {
+		return t.PullRequests.Head(ctx, repo, number)
+	}
+	return s.PullRequestResolver.Head(ctx, repo, number)
+}
+
+// tenantCheckRuns is a CheckRunsService that uses the Tenant's client.
+type tenantCheckRuns struct {
+	tenants Tenants
+	CheckRunsService
+}
+
+func (s *tenantCheckRuns) service(repo string) CheckRunsService {
+	if t := s.tenants.Tenant(repo); t != nil && t.Checks != nil {
+		return t.Checks
+	}
+	return s.CheckRunsService
+}
+
+func (s *tenantCheckRuns) CreateCheckRun(ctx context.Context, repo string, check *CheckRun) (int, error) {
+	return s.service(repo).CreateCheckRun(ctx, repo, check)
+}
+
+func (s *tenantCheckRuns) UpdateCheckRun(ctx context.Context, repo string, id int, check *CheckRun) error {
+	return s.service(repo).UpdateCheckRun(ctx, repo, id, check)
+}
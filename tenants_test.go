@@ -0,0 +1,91 @@
+package quayd
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTenants_Tenant(t *testing.T) {
+	acme, widgets := &Tenant{}, &Tenant{}
+	tenants := Tenants{"acme": acme, "remind101/widgets": widgets}
+
+	tests := []struct {
+		repo string
+		want *Tenant
+	}{
+		{"acme/api", acme},
+		{"remind101/widgets", widgets},
+		{"remind101/api", nil},
+	}
+
+	for _, tt := range tests {
+		if got := tenants.Tenant(tt.repo); got != tt.want {
+			t.Fatalf("Tenant(%q) => %v; want %v", tt.repo, got, tt.want)
+		}
+	}
+}
+
+func TestQuayd_Tenants(t *testing.T) {
+	primary, acme := &statusesRepository{}, &statusesRepository{}
+	q := &Quayd{
+		StatusesRepository: primary,
+		RepoMapper:         RepoMap{"quay/*": "acme"},
+		Tenants:            Tenants{"acme": &Tenant{StatusesRepository: acme}},
+	}
+
+	ctx := context.Background()
+	if err := q.Handle(ctx, &BuildEvent{Repo: "quay/api", Ref: "abcd", State: "pending"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Handle(ctx, &BuildEvent{Repo: "remind101/api", Ref: "abcd", State: "pending"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(acme.statuses) != 1 || acme.statuses[0].Repo != "acme/api" {
+		t.Fatalf("Expected the tenant to create a status for acme/api, got %v", acme.statuses)
+	}
+
+	if len(primary.statuses) != 1 || primary.statuses[0].Repo != "remind101/api" {
+		t.Fatalf("Expected the default client to create a status for remind101/api, got %v", primary.statuses)
+	}
+}
+
+func TestQuayd_routeTenants(t *testing.T) {
+	primary, acme := &releaseAssetService{}, &releaseAssetService{}
+	checks := &checkRunsService{}
+	q := &Quayd{
+		ReleaseAssets: &ReleaseAssets{Releases: primary},
+		Rebuilds:      &Rebuilds{PullRequests: pullRequests{42: "abcd"}},
+		SupplyChain:   &SupplyChain{Checks: &checkRunsService{}},
+		Tenants: Tenants{"acme": &Tenant{
+			Releases:     acme,
+			PullRequests: pullRequests{42: "efgh"},
+		}},
+	}
+	q.Tenants["remind101"] = &Tenant{Checks: checks}
+	q.routeTenants()
+
+	ctx := context.Background()
+	for _, repo := range []string{"acme/api", "remind101/api"} {
+		if _, err := q.ReleaseAssets.Releases.Upload(ctx, repo, "v1.0.0", "image.json", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if acme.uploads != 1 || primary.uploads != 1 {
+		t.Fatalf("Uploads => %d, %d; want 1, 1", acme.uploads, primary.uploads)
+	}
+
+	if sha, _, _ := q.Rebuilds.PullRequests.Head(ctx, "acme/api", 42); sha != "efgh" {
+		t.Fatalf("Head => %s; want the tenant's", sha)
+	}
+	if sha, _, _ := q.Rebuilds.PullRequests.Head(ctx, "other/api", 42); sha != "abcd" {
+		t.Fatalf("Head => %s; want the default", sha)
+	}
+
+	if _, err := q.SupplyChain.Checks.CreateCheckRun(ctx, "remind101/api", &CheckRun{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(checks.created) != 1 {
+		t.Fatal("Expected the tenant's checks to create the Check Run")
+	}
+}
@@ -0,0 +1,106 @@
+package quayd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// DefaultMaxTimelines is the default number of timelines that Timelines
+// keeps.
+const DefaultMaxTimelines = 1000
+
+// Step is a single step in the processing of a delivery.
+type Step struct {
+	Name     string        `json:"name"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Timeline is the sequence of steps that were taken to process a delivery.
+type Timeline struct {
+	ID    string  `json:"id"`
+	Steps []*Step `json:"steps"`
+}
+
+// Timelines keeps the processing timelines for the most recent deliveries, so
+// that support requests can be diagnosed after the fact. A nil *Timelines
+// records nothing.
+type Timelines struct {
+	// Max is the number of timelines to keep. Defaults to
+	// DefaultMaxTimelines.
+	Max int
+
+	mu        sync.Mutex
+	order     []string
+	timelines map[string]*Timeline
+}
+
+// Record adds a step, which started at start, to the timeline for the
+// delivery with the given id.
+func (t *Timelines) Record(id, name string, start time.Time, err error) {
+	if t == nil || id == "" {
+		return
+	}
+
+	step := &Step{Name: name, Start: start, Duration: time.Since(start)}
+	if err != nil {
+		step.Error = err.Error()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.timelines == nil {
+		t.timelines = make(map[string]*Timeline)
+	}
+
+	tl, ok := t.timelines[id]
+	if !ok {
+		tl = &Timeline{ID: id}
+		t.timelines[id] = tl
+		t.order = append(t.order, id)
+	}
+	tl.Steps = append(tl.Steps, step)
+
+	for len(t.order) > t.max() {
+		delete(t.timelines, t.order[0])
+		t.order = t.order[1:]
+	}
+}
+
+// Get returns a copy of the timeline for the delivery with the given id.
+func (t *Timelines) Get(id string) (*Timeline, bool) {
+	if t == nil {
+		return nil, false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tl, ok := t.timelines[id]
+	if !ok {
+		return nil, false
+	}
+
+	return &Timeline{ID: tl.ID, Steps: append([]*Step(nil), tl.Steps...)}, true
+}
+
+func (t *Timelines) max() int {
+	if t.Max == 0 {
+		return DefaultMaxTimelines
+	}
+
+	return t.Max
+}
+
+// newID returns a random identifier for a delivery.
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
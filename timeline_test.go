@@ -0,0 +1,39 @@
+package quayd
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTimelines(t *testing.T) {
+	tl := &Timelines{Max: 2}
+
+	tl.Record("a", "received", time.Now(), nil)
+	tl.Record("a", "resolved", time.Now(), errors.New("boom"))
+	tl.Record("b", "received", time.Now(), nil)
+	tl.Record("c", "received", time.Now(), nil)
+
+	if _, ok := tl.Get("a"); ok {
+		t.Fatal("Expected the oldest timeline to be evicted")
+	}
+
+	tl.Record("b", "resolved", time.Now(), errors.New("boom"))
+	b, ok := tl.Get("b")
+	if !ok {
+		t.Fatal("Expected a timeline")
+	}
+
+	if got, want := b.Steps[1].Error, "boom"; got != want {
+		t.Fatalf("Error => %s; want %s", got, want)
+	}
+}
+
+func TestTimelines_Nil(t *testing.T) {
+	var tl *Timelines
+	tl.Record("a", "received", time.Now(), nil)
+
+	if _, ok := tl.Get("a"); ok {
+		t.Fatal("Expected no timeline")
+	}
+}
@@ -0,0 +1,223 @@
+package quayd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenSource returns the GitHub token to authenticate a request with, so
+// that credentials can be rotated without restarting quayd.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// StaticTokenSource is a TokenSource that always returns the same token.
+type StaticTokenSource string
+
+// Token implements TokenSource Token.
+func (s StaticTokenSource) Token(ctx context.Context) (string, error) {
+	return string(s), nil
+}
+
+// EnvTokenSource is a TokenSource that reads the token from an environment
+// variable on every request, for processes that refresh their environment.
+type EnvTokenSource string
+
+// Token implements TokenSource Token.
+func (s EnvTokenSource) Token(ctx context.Context) (string, error) {
+	token := os.Getenv(string(s))
+	if token == "" {
+		return "", fmt.Errorf("token: $%s is not set", string(s))
+	}
+	return token, nil
+}
+
+// FileTokenSource is a TokenSource that reads the token from a file, like a
+// mounted Kubernetes secret, and rereads it when the file changes.
+type FileTokenSource struct {
+	Path string
+
+	mu      sync.Mutex
+	token   string
+	modTime time.Time
+}
+
+// Token implements TokenSource Token.
+func (s *FileTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fi, err := os.Stat(s.Path)
+	if err != nil {
+		return "", err
+	}
+	if s.token != "" && fi.ModTime().Equal(s.modTime) {
+		return s.token, nil
+	}
+
+	raw, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(raw))
+	if token == "" {
+		return "", fmt.Errorf("token: %s is empty", s.Path)
+	}
+
+	s.token, s.modTime = token, fi.ModTime()
+	return s.token, nil
+}
+
+// OIDCTokenSource is a TokenSource that exchanges an OIDC identity token, like
+// a cloud workload identity token, for a GitHub token at ExchangeURL. The
+// identity token is sent as a bearer token, and the response is JSON like a
+// GitHub App installation token:
+//
+//	{"token": "ghs_...", "expires_at": "2016-07-11T22:14:10Z"}
+//
+// The GitHub token is cached until shortly before it expires.
+type OIDCTokenSource struct {
+	// IDToken is the source of the identity token, which is usually a
+	// FileTokenSource, since workload identity tokens are projected into
+	// files.
+	IDToken TokenSource
+
+	ExchangeURL string
+
+	// Client is the http.Client to use. Defaults to http.DefaultClient.
+	Client *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// oidcExpiryMargin is how long before it expires that an exchanged token is
+// replaced.
+const oidcExpiryMargin = time.Minute
+
+// Token implements TokenSource Token.
+func (s *OIDCTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && time.Now().Add(oidcExpiryMargin).Before(s.expires) {
+		return s.token, nil
+	}
+
+	id, err := s.IDToken.Token(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest("POST", s.ExchangeURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+id)
+	req.Header.Set("Accept", "application/json")
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("token: exchanging identity token: %v", &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status})
+	}
+
+	var exchanged struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&exchanged); err != nil {
+		return "", err
+	}
+	if exchanged.Token == "" {
+		return "", errors.New("token: exchange returned no token")
+	}
+
+	s.token, s.expires = exchanged.Token, exchanged.ExpiresAt
+	return s.token, nil
+}
+
+// ParseTokenSource returns the TokenSource for a GitHub token flag or config
+// value, which is either a token, or one of:
+//
+//	env:NAME                                   read from $NAME
+//	file:/path/to/token                        read from a file
+//	oidc:https://host/exchange?id_token_file=/path/to/id-token
+//
+// For oidc, the id_token_file parameter is the file that the identity token
+// is read from, and it's removed from the exchange URL.
+func ParseTokenSource(spec string) (TokenSource, error) {
+	switch {
+	case strings.HasPrefix(spec, "env:"):
+		return EnvTokenSource(strings.TrimPrefix(spec, "env:")), nil
+	case strings.HasPrefix(spec, "file:"):
+		return &FileTokenSource{Path: strings.TrimPrefix(spec, "file:")}, nil
+	case strings.HasPrefix(spec, "oidc:"):
+		u, err := url.Parse(strings.TrimPrefix(spec, "oidc:"))
+		if err != nil {
+			return nil, err
+		}
+		query := u.Query()
+		path := query.Get("id_token_file")
+		if path == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid oidc token source %q: expected oidc:https://host/path?id_token_file=/path", spec)
+		}
+		query.Del("id_token_file")
+		u.RawQuery = query.Encode()
+		return &OIDCTokenSource{IDToken: &FileTokenSource{Path: path}, ExchangeURL: u.String()}, nil
+	}
+
+	return StaticTokenSource(spec), nil
+}
+
+// invalidTokenSource fails every request with the error from parsing the
+// token source.
+type invalidTokenSource struct {
+	err error
+}
+
+func (s invalidTokenSource) Token(ctx context.Context) (string, error) {
+	return "", s.err
+}
+
+// tokenTransport authenticates requests with a token from Source.
+type tokenTransport struct {
+	Source    TokenSource
+	Transport http.RoundTripper
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.Source.Token(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	// RoundTrippers mustn't modify the request.
+	r := req.Clone(req.Context())
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return transport.RoundTrip(r)
+}
@@ -0,0 +1,86 @@
+package quayd
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFileTokenSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(path, []byte("abcd\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s := &FileTokenSource{Path: path}
+	ctx := context.Background()
+
+	if token, err := s.Token(ctx); err != nil || token != "abcd" {
+		t.Fatalf("Token => %q, %v; want abcd", token, err)
+	}
+
+	// The token is reread when the file is rotated.
+	if err := ioutil.WriteFile(path, []byte("efgh"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if token, err := s.Token(ctx); err != nil || token != "efgh" {
+		t.Fatalf("Token => %q, %v; want efgh", token, err)
+	}
+}
+
+func TestOIDCTokenSource(t *testing.T) {
+	var exchanges int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Header.Get("Authorization"), "Bearer id-token"; got != want {
+			t.Errorf("Authorization => %q; want %q", got, want)
+		}
+		exchanges++
+		w.Write([]byte(`{"token":"ghs_1234","expires_at":"` + time.Now().Add(time.Hour).Format(time.RFC3339) + `"}`))
+	}))
+	defer s.Close()
+
+	source := &OIDCTokenSource{IDToken: StaticTokenSource("id-token"), ExchangeURL: s.URL}
+	for i := 0; i < 2; i++ {
+		if token, err := source.Token(context.Background()); err != nil || token != "ghs_1234" {
+			t.Fatalf("Token => %q, %v; want ghs_1234", token, err)
+		}
+	}
+	if exchanges != 1 {
+		t.Fatalf("Expected the exchanged token to be cached, got %d exchanges", exchanges)
+	}
+}
+
+func TestParseTokenSource(t *testing.T) {
+	tests := []struct {
+		spec   string
+		source TokenSource
+	}{
+		{"1234", StaticTokenSource("1234")},
+		{"env:QUAYD_GITHUB_TOKEN", EnvTokenSource("QUAYD_GITHUB_TOKEN")},
+		{"file:/var/run/secrets/github", &FileTokenSource{Path: "/var/run/secrets/github"}},
+		{"oidc:https://sts.example.com/exchange?id_token_file=/var/run/secrets/oidc&scope=acme", &OIDCTokenSource{IDToken: &FileTokenSource{Path: "/var/run/secrets/oidc"}, ExchangeURL: "https://sts.example.com/exchange?scope=acme"}},
+	}
+
+	for _, tt := range tests {
+		source, err := ParseTokenSource(tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := source, tt.source; !reflect.DeepEqual(got, want) {
+			t.Fatalf("ParseTokenSource(%q) => %#v; want %#v", tt.spec, got, want)
+		}
+	}
+
+	if _, err := ParseTokenSource("oidc:https://sts.example.com/exchange"); err == nil {
+		t.Fatal("Expected an error without id_token_file")
+	}
+}
@@ -0,0 +1,86 @@
+package quayd
+
+import (
+	"crypto/hmac"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+)
+
+// GitHubSignatureHeader is the header that GitHub signs webhooks in, with the
+// webhook's secret.
+const GitHubSignatureHeader = "X-Hub-Signature-256"
+
+// ErrWebhookUnauthorized is returned by a WebhookValidator when a webhook is
+// unsigned or the signature doesn't match.
+var ErrWebhookUnauthorized = errors.New("webhook signature is missing or invalid")
+
+// WebhookValidator is an interface for verifying the authenticity of an
+// incoming webhook before it is processed.
+type WebhookValidator interface {
+	// Validate returns an error if the request, with the given body, is not
+	// authentic.
+	Validate(r *http.Request, body []byte) error
+}
+
+// HMACValidator is a WebhookValidator that verifies that the request is
+// signed with an HMAC-SHA256 signature of the body, in the form
+// `sha256=<hex>`.
+type HMACValidator struct {
+	Secret string
+
+	// Header is the header containing the signature. Defaults to
+	// X-Quayd-Signature.
+	Header string
+}
+
+// Validate implements WebhookValidator Validate.
+func (v *HMACValidator) Validate(r *http.Request, body []byte) error {
+	header := v.Header
+	if header == "" {
+		header = SignatureHeader
+	}
+
+	sig := r.Header.Get(header)
+	if sig == "" || !hmac.Equal([]byte(sig), []byte(Sign([]byte(v.Secret), body))) {
+		return ErrWebhookUnauthorized
+	}
+
+	return nil
+}
+
+// SharedSecretValidator is a WebhookValidator that verifies that the request
+// includes a shared secret in the `secret` query parameter. This is useful
+// with Quay, which can't sign its notifications but lets you choose the URL.
+type SharedSecretValidator struct {
+	Secret string
+}
+
+// Validate implements WebhookValidator Validate.
+func (v *SharedSecretValidator) Validate(r *http.Request, body []byte) error {
+	secret := r.URL.Query().Get("secret")
+	if secret == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(v.Secret)) != 1 {
+		return ErrWebhookUnauthorized
+	}
+
+	return nil
+}
+
+// WebhookValidators maps a webhook endpoint (e.g. "success") to the
+// WebhookValidator for it. The "*" key applies to endpoints without their own
+// validator.
+type WebhookValidators map[string]WebhookValidator
+
+// Validate validates the request against the WebhookValidator for endpoint.
+// Endpoints without a validator are not validated.
+func (v WebhookValidators) Validate(endpoint string, r *http.Request, body []byte) error {
+	validator, ok := v[endpoint]
+	if !ok {
+		validator, ok = v["*"]
+	}
+	if !ok {
+		return nil
+	}
+
+	return validator.Validate(r, body)
+}
@@ -0,0 +1,88 @@
+package quayd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhookValidators(t *testing.T) {
+	body := []byte(`{}`)
+
+	signed, _ := http.NewRequest("POST", "/quay/success", nil)
+	signed.Header.Set(SignatureHeader, Sign([]byte("secret"), body))
+	unsigned, _ := http.NewRequest("POST", "/quay/success", nil)
+	shared, _ := http.NewRequest("POST", "/quay/pending?secret=shh", nil)
+
+	v := WebhookValidators{
+		"success": &HMACValidator{Secret: "secret"},
+		"*":       &SharedSecretValidator{Secret: "shh"},
+	}
+
+	tests := []struct {
+		endpoint string
+		req      *http.Request
+		err      error
+	}{
+		{"success", signed, nil},
+		{"success", unsigned, ErrWebhookUnauthorized},
+		{"pending", shared, nil},
+		{"pending", unsigned, ErrWebhookUnauthorized},
+	}
+
+	for _, tt := range tests {
+		if got, want := v.Validate(tt.endpoint, tt.req, body), tt.err; got != want {
+			t.Fatalf("Validate(%s) => %v; want %v", tt.endpoint, got, want)
+		}
+	}
+
+	if err := (WebhookValidators{}).Validate("success", unsigned, body); err != nil {
+		t.Fatalf("Expected no validation without validators, got %v", err)
+	}
+}
+
+func TestWebhook_Unauthorized(t *testing.T) {
+	r := &statusesRepository{}
+	s := NewServer(&Quayd{
+		StatusesRepository: r,
+		WebhookValidators:  WebhookValidators{"*": &SharedSecretValidator{Secret: "shh"}},
+	})
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/quay/pending?secret=wrong", loadFixture("pending_build", t))
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 401; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+
+	if len(r.statuses) != 0 {
+		t.Fatal("Expected 0 commit statuses")
+	}
+}
+
+func TestWebhook_Unauthorized_NothingRecorded(t *testing.T) {
+	timelines, ignored := &Timelines{}, &IgnoredEvents{}
+	s := NewServer(&Quayd{
+		StatusesRepository: &statusesRepository{},
+		Timelines:          timelines,
+		Ignored:            ignored,
+		WebhookValidators:  WebhookValidators{"*": &SharedSecretValidator{Secret: "shh"}},
+	})
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/quay/unknown?secret=wrong", loadFixture("pending_build", t))
+	s.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, 401; got != want {
+		t.Fatalf("Status => %d; want %d", got, want)
+	}
+
+	if _, ok := timelines.Get(resp.Header().Get("X-Delivery-ID")); ok {
+		t.Fatal("Expected no timeline for an unauthorized webhook")
+	}
+
+	if events := ignored.List("", ""); len(events) != 0 {
+		t.Fatalf("Expected no ignored events, got %+v", events)
+	}
+}
@@ -0,0 +1,234 @@
+// Package vcr provides an http.RoundTripper that records HTTP interactions
+// with downstream APIs (GitHub, docker registries) into fixture files, and
+// replays them in tests without needing live credentials.
+package vcr
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+)
+
+// Mode controls whether a Transport records or replays interactions.
+type Mode int
+
+const (
+	// Replay serves responses from the cassette, never touching the network.
+	Replay Mode = iota
+
+	// Record performs real requests and records them into the cassette.
+	Record
+)
+
+// SensitiveParams are query parameters that are stripped from recorded URLs.
+var SensitiveParams = []string{"access_token", "client_secret", "token"}
+
+// SensitiveHeaders are response headers that are stripped from recorded
+// responses.
+var SensitiveHeaders = []string{"Set-Cookie", "Authorization"}
+
+// ErrNoInteraction is returned when replaying a request that wasn't recorded.
+var ErrNoInteraction = errors.New("vcr: no recorded interaction")
+
+// Request is a recorded HTTP request.
+type Request struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Body   string `json:"body,omitempty"`
+}
+
+// Response is a recorded HTTP response.
+type Response struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body"`
+}
+
+// Interaction is a request and the response that was returned for it.
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Transport is an http.RoundTripper that records or replays interactions
+// to/from a JSON fixture at Path.
+type Transport struct {
+	Mode Mode
+
+	// Path is the location of the fixture.
+	Path string
+
+	// Transport is the http.RoundTripper used when recording. Defaults to
+	// http.DefaultTransport.
+	Transport http.RoundTripper
+
+	// Sanitize, if set, is called on each interaction before it is recorded,
+	// in addition to the default sanitization.
+	Sanitize func(*Interaction)
+
+	mu           sync.Mutex
+	loaded       bool
+	interactions []*Interaction
+	used         map[int]bool
+}
+
+// RoundTrip implements http.RoundTripper RoundTrip.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	r := Request{Method: req.Method, URL: sanitizeURL(req.URL), Body: string(body)}
+
+	if t.Mode == Record {
+		return t.record(req, r)
+	}
+
+	return t.replay(req, r)
+}
+
+// Save writes the recorded interactions to Path.
+func (t *Transport) Save() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	raw, err := json.MarshalIndent(t.interactions, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(t.Path, raw, 0644)
+}
+
+func (t *Transport) record(req *http.Request, r Request) (*http.Response, error) {
+	resp, err := t.transport().RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	i := &Interaction{
+		Request: r,
+		Response: Response{
+			StatusCode: resp.StatusCode,
+			Header:     resp.Header,
+			Body:       string(body),
+		},
+	}
+	sanitize(i)
+	if t.Sanitize != nil {
+		t.Sanitize(i)
+	}
+
+	t.mu.Lock()
+	t.interactions = append(t.interactions, i)
+	t.mu.Unlock()
+
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+func (t *Transport) replay(req *http.Request, r Request) (*http.Response, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.load(); err != nil {
+		return nil, err
+	}
+
+	for n, i := range t.interactions {
+		if t.used[n] || i.Request != r {
+			continue
+		}
+		t.used[n] = true
+
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", i.Response.StatusCode, http.StatusText(i.Response.StatusCode)),
+			StatusCode:    i.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        i.Response.Header,
+			Body:          ioutil.NopCloser(bytes.NewReader([]byte(i.Response.Body))),
+			ContentLength: int64(len(i.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%s: %s %s", ErrNoInteraction, r.Method, r.URL)
+}
+
+// load reads the fixture from Path, if it hasn't been already.
+func (t *Transport) load() error {
+	if t.loaded {
+		return nil
+	}
+
+	raw, err := ioutil.ReadFile(t.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(raw, &t.interactions); err != nil {
+			return err
+		}
+	}
+
+	t.used = make(map[int]bool)
+	t.loaded = true
+	return nil
+}
+
+func (t *Transport) transport() http.RoundTripper {
+	if t.Transport == nil {
+		return http.DefaultTransport
+	}
+
+	return t.Transport
+}
+
+// sanitize removes credentials from the recorded response. The headers are
+// copied first, since they're shared with the live response that's returned
+// to the caller.
+func sanitize(i *Interaction) {
+	h := make(http.Header, len(i.Response.Header))
+	for k, v := range i.Response.Header {
+		h[k] = append([]string(nil), v...)
+	}
+	for _, k := range SensitiveHeaders {
+		h.Del(k)
+	}
+	i.Response.Header = h
+}
+
+// sanitizeURL returns the url with any credentials removed.
+func sanitizeURL(u *url.URL) string {
+	c := *u
+	c.User = nil
+
+	q := c.Query()
+	for _, p := range SensitiveParams {
+		q.Del(p)
+	}
+	c.RawQuery = q.Encode()
+
+	return c.String()
+}
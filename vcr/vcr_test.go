@@ -0,0 +1,71 @@
+package vcr
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTransport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vcr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "fixture.json")
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret"})
+		w.Write([]byte(`"abcd"`))
+	}))
+
+	rec := &Transport{Mode: Record, Path: path}
+	req, _ := http.NewRequest("GET", s.URL+"/v1/repositories/foo/bar/tags/latest?access_token=secret", nil)
+	resp, err := rec.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// The caller still gets the credentials in the live response.
+	if resp.Header.Get("Set-Cookie") == "" {
+		t.Fatal("Expected the live response to keep its Set-Cookie header")
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	raw, _ := ioutil.ReadFile(path)
+	for _, secret := range []string{"access_token", "session"} {
+		if strings.Contains(string(raw), secret) {
+			t.Fatalf("Fixture contains %q", secret)
+		}
+	}
+
+	rep := &Transport{Mode: Replay, Path: path}
+	if got, want := get(t, rep, s.URL+"/v1/repositories/foo/bar/tags/latest?access_token=secret"), `"abcd"`; got != want {
+		t.Fatalf("Body => %s; want %s", got, want)
+	}
+
+	c := &http.Client{Transport: rep}
+	if _, err := c.Get(s.URL + "/v1/repositories/foo/bar/tags/latest"); err == nil {
+		t.Fatal("Expected an error when replaying an interaction twice")
+	}
+}
+
+func get(t testing.TB, tr http.RoundTripper, url string) string {
+	c := &http.Client{Transport: tr}
+	resp, err := c.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	return string(body)
+}
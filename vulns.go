@@ -0,0 +1,88 @@
+package quayd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// VulnDiff is the change in vulnerabilities between two scans of a branch.
+type VulnDiff struct {
+	// Introduced are the vulnerabilities that weren't in the previous scan.
+	Introduced []string `json:"introduced"`
+
+	// Fixed are the vulnerabilities that are no longer present.
+	Fixed []string `json:"fixed"`
+
+	// Total is the number of vulnerabilities in the current scan.
+	Total int `json:"total"`
+}
+
+// DiffVulnerabilities compares the vulnerability ids (e.g. CVE-2021-44228)
+// found in the current scan against those found in the previous scan.
+func DiffVulnerabilities(previous, current []string) *VulnDiff {
+	prev := make(map[string]bool)
+	for _, id := range previous {
+		prev[id] = true
+	}
+
+	cur := make(map[string]bool)
+	for _, id := range current {
+		cur[id] = true
+	}
+
+	d := &VulnDiff{Total: len(cur)}
+	for id := range cur {
+		if !prev[id] {
+			d.Introduced = append(d.Introduced, id)
+		}
+	}
+	for id := range prev {
+		if !cur[id] {
+			d.Fixed = append(d.Fixed, id)
+		}
+	}
+	sort.Strings(d.Introduced)
+	sort.Strings(d.Fixed)
+
+	return d
+}
+
+// String returns a one line summary of the diff, like
+// "1 new (CVE-2021-44228), 2 fixed, 5 total".
+func (d *VulnDiff) String() string {
+	introduced := fmt.Sprintf("%d new", len(d.Introduced))
+	if len(d.Introduced) > 0 {
+		introduced += " (" + strings.Join(d.Introduced, ", ") + ")"
+	}
+
+	return fmt.Sprintf("%s, %d fixed, %d total", introduced, len(d.Fixed), d.Total)
+}
+
+// Markdown renders the diff for a Check Run or pull request comment.
+func (d *VulnDiff) Markdown() string {
+	lines := []string{"### Vulnerabilities", ""}
+	if len(d.Introduced) == 0 && len(d.Fixed) == 0 {
+		lines = append(lines, fmt.Sprintf("No change since the previous build (%d total).", d.Total))
+		return strings.Join(lines, "\n")
+	}
+
+	lines = append(lines, "| Vulnerability | Change |", "| --- | --- |")
+	for _, id := range d.Introduced {
+		lines = append(lines, fmt.Sprintf("| %s | introduced |", id))
+	}
+	for _, id := range d.Fixed {
+		lines = append(lines, fmt.Sprintf("| %s | fixed |", id))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// branchScan is the most recent scan of a branch.
+type branchScan struct {
+	sha   string
+	vulns []string
+
+	// previous is the scan of the build before sha, if any.
+	previous []string
+	hasPrev  bool
+}
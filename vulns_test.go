@@ -0,0 +1,22 @@
+package quayd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffVulnerabilities(t *testing.T) {
+	d := DiffVulnerabilities([]string{"CVE-1", "CVE-2"}, []string{"CVE-3", "CVE-2", "CVE-4"})
+
+	if got, want := d.Introduced, []string{"CVE-3", "CVE-4"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Introduced => %v; want %v", got, want)
+	}
+
+	if got, want := d.Fixed, []string{"CVE-1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Fixed => %v; want %v", got, want)
+	}
+
+	if got, want := d.String(), "2 new (CVE-3, CVE-4), 1 fixed, 3 total"; got != want {
+		t.Fatalf("String => %s; want %s", got, want)
+	}
+}